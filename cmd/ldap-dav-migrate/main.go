@@ -0,0 +1,327 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/rs/zerolog"
+	"github.com/sonroyaalmerol/ldap-dav/internal/config"
+	"github.com/sonroyaalmerol/ldap-dav/internal/dav/caldav"
+	"github.com/sonroyaalmerol/ldap-dav/internal/logging"
+	"github.com/sonroyaalmerol/ldap-dav/internal/storage"
+	"github.com/sonroyaalmerol/ldap-dav/internal/storage/postgres"
+	"github.com/sonroyaalmerol/ldap-dav/internal/storage/sqlite"
+)
+
+// errUsage is returned by run when the required flags are missing.
+var errUsage = errors.New("usage: ldap-dav-migrate -from <postgres|sqlite> -from-url <url|path> -to <postgres|sqlite> -to-url <url|path>")
+
+func main() {
+	if err := run(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		if errors.Is(err, errUsage) {
+			os.Exit(2)
+		}
+		os.Exit(1)
+	}
+}
+
+// run copies everything from the source store into the destination. It can
+// be repeated: collections that already exist are reused and objects whose
+// ETag already matches are skipped.
+func run() error {
+	var (
+		fromType string
+		fromURL  string
+		toType   string
+		toURL    string
+	)
+	flag.StringVar(&fromType, "from", "", "Source storage type: postgres|sqlite (required)")
+	flag.StringVar(&fromURL, "from-url", "", "Source PostgreSQL URL or SQLite path (required)")
+	flag.StringVar(&toType, "to", "", "Destination storage type: postgres|sqlite (required)")
+	flag.StringVar(&toURL, "to-url", "", "Destination PostgreSQL URL or SQLite path (required)")
+	flag.Parse()
+
+	if fromType == "" || fromURL == "" || toType == "" || toURL == "" {
+		return errUsage
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+
+	logger := logging.New(cfg.LogLevel)
+	logger = logger.With().Str("component", "migrate").Logger()
+
+	src, err := openStore(fromType, fromURL, logger)
+	if err != nil {
+		return fmt.Errorf("source storage init: %w", err)
+	}
+	defer src.Close()
+
+	dst, err := openStore(toType, toURL, logger)
+	if err != nil {
+		return fmt.Errorf("destination storage init: %w", err)
+	}
+	defer dst.Close()
+
+	ctx := context.Background()
+
+	// ids maps source collection IDs to their destination IDs, which differ
+	// when the destination already held a collection with the same URI.
+	ids := make(map[string]string)
+
+	nCal, nObj, err := migrateCalendars(ctx, src, dst, ids, logger)
+	if err != nil {
+		return fmt.Errorf("migrate calendars: %w", err)
+	}
+	nAB, nContact, err := migrateAddressbooks(ctx, src, dst, ids, logger)
+	if err != nil {
+		return fmt.Errorf("migrate addressbooks: %w", err)
+	}
+	nProp, err := migrateDeadProperties(ctx, src, dst, ids)
+	if err != nil {
+		return fmt.Errorf("migrate properties: %w", err)
+	}
+
+	fmt.Printf("Migrated %d calendars (%d objects), %d addressbooks (%d contacts) and %d properties\n", nCal, nObj, nAB, nContact, nProp)
+	return nil
+}
+
+func openStore(typ, url string, logger zerolog.Logger) (storage.Store, error) {
+	switch typ {
+	case "postgres":
+		return postgres.New(url, logger)
+	case "sqlite":
+		return sqlite.New(url, logger)
+	default:
+		return nil, fmt.Errorf("unknown storage type: %s", typ)
+	}
+}
+
+// migrateCalendars copies every calendar and its objects, keeping IDs, ETags,
+// schedule tags and timestamps. The destination change log gets one entry
+// per copied object, so clients resync once against the new tokens. It
+// returns the number of calendars and of objects copied.
+func migrateCalendars(ctx context.Context, src, dst storage.Store, ids map[string]string, logger zerolog.Logger) (int, int, error) {
+	cals, err := src.ListAllCalendars(ctx)
+	if err != nil {
+		return 0, 0, fmt.Errorf("list calendars: %w", err)
+	}
+
+	nObj := 0
+	for _, cal := range cals {
+		dstID, err := ensureCalendar(ctx, dst, cal)
+		if err != nil {
+			return 0, 0, err
+		}
+		ids[cal.ID] = dstID
+
+		objs, err := src.ListObjects(ctx, cal.ID, nil, nil)
+		if err != nil {
+			return 0, 0, fmt.Errorf("list objects %s/%s: %w", cal.OwnerUserID, cal.URI, err)
+		}
+		copied := 0
+		for _, o := range objs {
+			existing, err := dst.GetObject(ctx, dstID, o.UID)
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				return 0, 0, fmt.Errorf("get object %s in %s/%s: %w", o.UID, cal.OwnerUserID, cal.URI, err)
+			}
+			if existing != nil && existing.ETag == o.ETag {
+				continue
+			}
+			if existing != nil {
+				// Replaced rather than updated, so the source timestamp
+				// is kept.
+				if err := dst.DeleteObject(ctx, dstID, o.UID, ""); err != nil {
+					return 0, 0, fmt.Errorf("replace object %s in %s/%s: %w", o.UID, cal.OwnerUserID, cal.URI, err)
+				}
+			}
+			obj := *o
+			obj.CalendarID = dstID
+			if err := dst.PutObject(ctx, &obj); err != nil {
+				return 0, 0, fmt.Errorf("put object %s in %s/%s: %w", o.UID, cal.OwnerUserID, cal.URI, err)
+			}
+			if _, _, err := dst.RecordChange(ctx, dstID, o.UID, false); err != nil {
+				return 0, 0, fmt.Errorf("record change %s in %s/%s: %w", o.UID, cal.OwnerUserID, cal.URI, err)
+			}
+			copied++
+		}
+		nObj += copied
+
+		logger.Info().
+			Str("owner", cal.OwnerUserID).
+			Str("uri", cal.URI).
+			Int("objects", copied).
+			Int("unchanged", len(objs)-copied).
+			Msg("calendar migrated")
+	}
+	return len(cals), nObj, nil
+}
+
+// ensureCalendar creates cal in dst, or brings the calendar its owner
+// already holds under the same URI up to date, and returns the destination
+// ID.
+func ensureCalendar(ctx context.Context, dst storage.Store, cal *storage.Calendar) (string, error) {
+	owned, err := dst.ListCalendarsByOwnerUser(ctx, cal.OwnerUserID)
+	if err != nil {
+		return "", fmt.Errorf("list calendars of %s: %w", cal.OwnerUserID, err)
+	}
+	var cur *storage.Calendar
+	for _, c := range owned {
+		if c.URI == cal.URI {
+			cur = c
+			break
+		}
+	}
+	if cur == nil {
+		if err := dst.CreateCalendar(*cal, cal.OwnerGroup, cal.Description); err != nil {
+			return "", fmt.Errorf("create calendar %s/%s: %w", cal.OwnerUserID, cal.URI, err)
+		}
+		return cal.ID, nil
+	}
+
+	if cur.DisplayName != cal.DisplayName {
+		name := cal.DisplayName
+		if err := dst.UpdateCalendarDisplayName(ctx, cal.OwnerUserID, cal.URI, &name); err != nil {
+			return "", fmt.Errorf("set display name %s/%s: %w", cal.OwnerUserID, cal.URI, err)
+		}
+	}
+	if cur.Color != cal.Color && cal.Color != "" {
+		if err := dst.UpdateCalendarColor(ctx, cal.OwnerUserID, cal.URI, cal.Color); err != nil {
+			return "", fmt.Errorf("set color %s/%s: %w", cal.OwnerUserID, cal.URI, err)
+		}
+	}
+	if cur.DefaultAlarmVEventDateTime != cal.DefaultAlarmVEventDateTime {
+		if err := dst.UpdateCalendarDefaultAlarm(ctx, cal.OwnerUserID, cal.URI, false, cal.DefaultAlarmVEventDateTime); err != nil {
+			return "", fmt.Errorf("set default alarm %s/%s: %w", cal.OwnerUserID, cal.URI, err)
+		}
+	}
+	if cur.DefaultAlarmVEventDate != cal.DefaultAlarmVEventDate {
+		if err := dst.UpdateCalendarDefaultAlarm(ctx, cal.OwnerUserID, cal.URI, true, cal.DefaultAlarmVEventDate); err != nil {
+			return "", fmt.Errorf("set default alarm %s/%s: %w", cal.OwnerUserID, cal.URI, err)
+		}
+	}
+	return cur.ID, nil
+}
+
+// migrateAddressbooks copies every address book and its contacts the same
+// way migrateCalendars copies calendars.
+func migrateAddressbooks(ctx context.Context, src, dst storage.Store, ids map[string]string, logger zerolog.Logger) (int, int, error) {
+	abs, err := src.ListAllAddressbooks(ctx)
+	if err != nil {
+		return 0, 0, fmt.Errorf("list addressbooks: %w", err)
+	}
+
+	nContact := 0
+	for _, ab := range abs {
+		dstID, err := ensureAddressbook(ctx, dst, ab)
+		if err != nil {
+			return 0, 0, err
+		}
+		ids[ab.ID] = dstID
+
+		contacts, err := src.ListContacts(ctx, ab.ID)
+		if err != nil {
+			return 0, 0, fmt.Errorf("list contacts %s/%s: %w", ab.OwnerUserID, ab.URI, err)
+		}
+		copied := 0
+		for _, c := range contacts {
+			existing, err := dst.GetContact(ctx, dstID, c.UID)
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				return 0, 0, fmt.Errorf("get contact %s in %s/%s: %w", c.UID, ab.OwnerUserID, ab.URI, err)
+			}
+			if existing != nil && existing.ETag == c.ETag {
+				continue
+			}
+			if existing != nil {
+				if err := dst.DeleteContact(ctx, dstID, c.UID, ""); err != nil {
+					return 0, 0, fmt.Errorf("replace contact %s in %s/%s: %w", c.UID, ab.OwnerUserID, ab.URI, err)
+				}
+			}
+			contact := *c
+			contact.AddressbookID = dstID
+			if err := dst.PutContact(ctx, &contact); err != nil {
+				return 0, 0, fmt.Errorf("put contact %s in %s/%s: %w", c.UID, ab.OwnerUserID, ab.URI, err)
+			}
+			if _, _, err := dst.RecordAddressbookChange(ctx, dstID, c.UID, false); err != nil {
+				return 0, 0, fmt.Errorf("record change %s in %s/%s: %w", c.UID, ab.OwnerUserID, ab.URI, err)
+			}
+			copied++
+		}
+		nContact += copied
+
+		logger.Info().
+			Str("owner", ab.OwnerUserID).
+			Str("uri", ab.URI).
+			Int("contacts", copied).
+			Int("unchanged", len(contacts)-copied).
+			Msg("addressbook migrated")
+	}
+	return len(abs), nContact, nil
+}
+
+// ensureAddressbook creates ab in dst unless its owner already holds an
+// address book under the same URI, and returns the destination ID.
+func ensureAddressbook(ctx context.Context, dst storage.Store, ab *storage.Addressbook) (string, error) {
+	owned, err := dst.ListAddressbooksByOwnerUser(ctx, ab.OwnerUserID)
+	if err != nil {
+		return "", fmt.Errorf("list addressbooks of %s: %w", ab.OwnerUserID, err)
+	}
+	var cur *storage.Addressbook
+	for _, a := range owned {
+		if a.URI == ab.URI {
+			cur = a
+			break
+		}
+	}
+	if cur == nil {
+		if err := dst.CreateAddressbook(*ab, ab.OwnerGroup, ab.Description); err != nil {
+			return "", fmt.Errorf("create addressbook %s/%s: %w", ab.OwnerUserID, ab.URI, err)
+		}
+		return ab.ID, nil
+	}
+	if cur.DisplayName != ab.DisplayName {
+		name := ab.DisplayName
+		if err := dst.UpdateAddressbookDisplayName(ctx, ab.OwnerUserID, ab.URI, &name); err != nil {
+			return "", fmt.Errorf("set display name %s/%s: %w", ab.OwnerUserID, ab.URI, err)
+		}
+	}
+	return cur.ID, nil
+}
+
+// migrateDeadProperties copies the dead properties of every resource:
+// collections, calendar and address book homes, principals and shared
+// mounts. Keys naming a collection follow it to its destination ID.
+func migrateDeadProperties(ctx context.Context, src, dst storage.Store, ids map[string]string) (int, error) {
+	props, err := src.ListAllDeadProperties(ctx)
+	if err != nil {
+		return 0, err
+	}
+	for _, p := range props {
+		p.ResourceID = remapResourceID(p.ResourceID, ids)
+		if err := dst.SetDeadProperty(ctx, p); err != nil {
+			return 0, fmt.Errorf("set %s {%s}%s: %w", p.ResourceID, p.Space, p.Local, err)
+		}
+	}
+	return len(props), nil
+}
+
+// remapResourceID rewrites a dead property key that names a collection to
+// the collection's destination ID.
+func remapResourceID(id string, ids map[string]string) string {
+	if to, ok := ids[id]; ok {
+		return to
+	}
+	if sharee, calendarID, ok := caldav.ParseMountResourceID(id); ok {
+		if to, ok := ids[calendarID]; ok {
+			return caldav.MountResourceID(sharee, to)
+		}
+	}
+	return id
+}
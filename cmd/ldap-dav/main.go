@@ -2,7 +2,9 @@ package main
 
 import (
 	"context"
+	"errors"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
@@ -27,13 +29,22 @@ func main() {
 	defer cleanup()
 
 	go func() {
-		if err := srv.Start(); err != nil {
+		if err := srv.Start(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			logger.Fatal().Err(err).Msg("server stopped with error")
 		}
 	}()
 
 	logger.Info().Msgf("listening on %s", cfg.HTTP.Addr)
 
+	// SIGHUP toggles read-only maintenance mode
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			logger.Warn().Bool("read_only", srv.ToggleMaintenance()).Msg("maintenance mode toggled")
+		}
+	}()
+
 	// graceful shutdown
 	ch := make(chan os.Signal, 1)
 	signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
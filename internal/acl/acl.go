@@ -2,6 +2,7 @@ package acl
 
 import (
 	"context"
+	"strings"
 
 	"github.com/sonroyaalmerol/ldap-dav/internal/directory"
 )
@@ -11,6 +12,8 @@ type Provider interface {
 	Effective(ctx context.Context, user *directory.User, calendarID string) (Effective, error)
 	// List calendars the user can at least read
 	VisibleCalendars(ctx context.Context, user *directory.User) (map[string]Effective, error)
+	// Compute effective privileges for user on owner's addressbook with the given URI from LDAP group ACLs
+	EffectiveAddressbook(ctx context.Context, user *directory.User, owner, addressbookURI string) (Effective, error)
 }
 
 type LDAPACL struct {
@@ -28,35 +31,11 @@ func (p *LDAPACL) Effective(ctx context.Context, user *directory.User, calendarI
 	}
 
 	e := Effective{}
-
 	for _, a := range acls {
-		if a.CalendarID != calendarID {
+		if a.CalendarID == "" || a.CalendarID != calendarID {
 			continue
 		}
-		if a.Read {
-			e.Read = true
-		}
-		if a.WriteProps {
-			e.WriteProps = true
-		}
-		if a.WriteContent {
-			e.WriteContent = true
-		}
-		if a.Bind {
-			e.Bind = true
-		}
-		if a.Unbind {
-			e.Unbind = true
-		}
-		if a.ReadACL {
-			e.ReadACL = true
-		}
-		if a.ReadCurrentUserPrivilegeSet {
-			e.ReadCurrentUserPrivilegeSet = true
-		}
-		if a.Unlock {
-			e.Unlock = true
-		}
+		e.grant(a)
 	}
 	return e, nil
 }
@@ -69,33 +48,60 @@ func (p *LDAPACL) VisibleCalendars(ctx context.Context, user *directory.User) (m
 
 	m := map[string]Effective{}
 	for _, a := range acls {
-		e := m[a.CalendarID]
-
-		if a.Read {
-			e.Read = true
-		}
-		if a.WriteProps {
-			e.WriteProps = true
-		}
-		if a.WriteContent {
-			e.WriteContent = true
-		}
-		if a.Bind {
-			e.Bind = true
-		}
-		if a.Unbind {
-			e.Unbind = true
-		}
-		if a.ReadACL {
-			e.ReadACL = true
-		}
-		if a.ReadCurrentUserPrivilegeSet {
-			e.ReadCurrentUserPrivilegeSet = true
-		}
-		if a.Unlock {
-			e.Unlock = true
+		if a.CalendarID == "" {
+			continue
 		}
+		e := m[a.CalendarID]
+		e.grant(a)
 		m[a.CalendarID] = e
 	}
 	return m, nil
 }
+
+// EffectiveAddressbook matches addressbook-id bindings of the form
+// <owner>/<uri>, so a grant reaches one user's address book only. Bindings
+// without an owner match nothing.
+func (p *LDAPACL) EffectiveAddressbook(ctx context.Context, user *directory.User, owner, addressbookURI string) (Effective, error) {
+	acls, err := p.Dir.UserGroupsACL(ctx, user)
+	if err != nil {
+		return Effective{}, err
+	}
+
+	e := Effective{}
+	for _, a := range acls {
+		bindOwner, bindURI, ok := strings.Cut(a.AddressbookID, "/")
+		if !ok || bindOwner == "" || bindURI == "" || bindOwner != owner || bindURI != addressbookURI {
+			continue
+		}
+		e.grant(a)
+	}
+	return e, nil
+}
+
+// grant adds the privileges of a group binding to e.
+func (e *Effective) grant(a directory.GroupACL) {
+	if a.Read {
+		e.Read = true
+	}
+	if a.WriteProps {
+		e.WriteProps = true
+	}
+	if a.WriteContent {
+		e.WriteContent = true
+	}
+	if a.Bind {
+		e.Bind = true
+	}
+	if a.Unbind {
+		e.Unbind = true
+	}
+	if a.ReadACL {
+		e.ReadACL = true
+	}
+	if a.ReadCurrentUserPrivilegeSet {
+		e.ReadCurrentUserPrivilegeSet = true
+	}
+	if a.Unlock {
+		e.Unlock = true
+	}
+}
@@ -0,0 +1,62 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+)
+
+type lruEntry[K comparable, V any] struct {
+	key  K
+	val  V
+	cost int64
+}
+
+// LRU holds values up to a total cost, evicting the least recently used
+// ones first. Values costing more than the whole budget are not kept.
+type LRU[K comparable, V any] struct {
+	mu    sync.Mutex
+	max   int64
+	used  int64
+	cost  func(V) int64
+	order *list.List
+	items map[K]*list.Element
+}
+
+func NewLRU[K comparable, V any](max int64, cost func(V) int64) *LRU[K, V] {
+	return &LRU[K, V]{max: max, cost: cost, order: list.New(), items: make(map[K]*list.Element)}
+}
+
+func (c *LRU[K, V]) Get(k K) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[k]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	c.order.MoveToFront(el)
+	return el.Value.(*lruEntry[K, V]).val, true
+}
+
+func (c *LRU[K, V]) Set(k K, v V) {
+	cost := c.cost(v)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[k]; ok {
+		c.remove(el)
+	}
+	if cost > c.max {
+		return
+	}
+	c.items[k] = c.order.PushFront(&lruEntry[K, V]{key: k, val: v, cost: cost})
+	c.used += cost
+	for c.used > c.max {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *LRU[K, V]) remove(el *list.Element) {
+	e := c.order.Remove(el).(*lruEntry[K, V])
+	delete(c.items, e.key)
+	c.used -= e.cost
+}
@@ -0,0 +1,28 @@
+package cache
+
+import "testing"
+
+func TestLRUEvictsLeastRecentlyUsed(t *testing.T) {
+	c := NewLRU[string](10, func(v string) int64 { return int64(len(v)) })
+	c.Set("a", "aaaa")
+	c.Set("b", "bbbb")
+	c.Get("a")
+	c.Set("c", "cccc")
+
+	if _, ok := c.Get("b"); ok {
+		t.Error("least recently used entry was kept over budget")
+	}
+	for _, k := range []string{"a", "c"} {
+		if _, ok := c.Get(k); !ok {
+			t.Errorf("entry %q was evicted", k)
+		}
+	}
+
+	c.Set("big", "01234567890")
+	if _, ok := c.Get("big"); ok {
+		t.Error("entry larger than the budget was kept")
+	}
+	if _, ok := c.Get("a"); !ok {
+		t.Error("oversized entry evicted the others")
+	}
+}
@@ -7,6 +7,9 @@ type ICSConfig struct {
 	ProductName string
 	Version     string
 	Language    string
+
+	ProdIDPolicy   string
+	EnforceVersion bool
 }
 
 func (cfg *ICSConfig) BuildProdID() string {
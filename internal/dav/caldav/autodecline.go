@@ -0,0 +1,212 @@
+package caldav
+
+import (
+	"context"
+	"encoding/xml"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/sonroyaalmerol/ldap-dav/internal/dav/common"
+	"github.com/sonroyaalmerol/ldap-dav/internal/directory"
+	"github.com/sonroyaalmerol/ldap-dav/internal/storage"
+	"github.com/sonroyaalmerol/ldap-dav/pkg/ical"
+)
+
+// autoDeclineHorizon bounds how far ahead recurring invitations are checked
+// for conflicts.
+const autoDeclineHorizon = 365 * 24 * time.Hour
+
+// autoScheduleModeProp is the calendarserver principal property users set
+// to opt in to, or out of, auto-decline. "decline-if-busy" turns it on and
+// any other value turns it off, overriding CALDAV_AUTO_DECLINE.
+var autoScheduleModeProp = xml.Name{Space: common.NSCS, Local: "auto-schedule-mode"}
+
+func (h *Handlers) autoDeclineEnabled(ctx context.Context, owner string) bool {
+	if mode, ok := common.StoredPropertyText(ctx, h.store, common.PrincipalResourceID(owner), autoScheduleModeProp); ok {
+		return mode == "decline-if-busy"
+	}
+	users := h.cfg.CalDAV.AutoDeclineUsers
+	return slices.Contains(users, "*") || slices.Contains(users, owner)
+}
+
+// autoDecline declines the owner's pending participation in an invitation
+// that overlaps busy time in their own calendars. It returns the declined
+// data together with the attendee who declined, whose reply the caller
+// sends with replyToOrganizer once the attendee's copy is stored. The data
+// is returned unchanged, with no attendee, when auto-decline is off for the
+// owner, the client sent Schedule-Reply: F, or there is no conflict.
+func (h *Handlers) autoDecline(r *http.Request, owner, uid string, data []byte) ([]byte, *directory.User) {
+	if r.Header.Get("Schedule-Reply") == "F" || !h.autoDeclineEnabled(r.Context(), owner) {
+		return data, nil
+	}
+	if !ical.IsSchedulingObject(data) {
+		return data, nil
+	}
+
+	ctx := r.Context()
+	u, err := h.dir.LookupUserByAttr(ctx, h.cfg.LDAP.TokenUserAttr, owner)
+	if err != nil || u == nil {
+		h.logger.Debug().Ctx(ctx).Err(err).Str("owner", owner).Msg("auto-decline skipped - owner lookup failed")
+		return data, nil
+	}
+
+	instances := h.upcomingInstances(ctx, data)
+	if len(instances) == 0 {
+		return data, nil
+	}
+
+	cals, err := h.store.ListCalendarsByOwnerUser(ctx, owner)
+	if err != nil {
+		h.logger.Error().Ctx(ctx).Err(err).Str("owner", owner).Msg("failed to list calendars for auto-decline")
+		return data, nil
+	}
+	if !h.conflictsWithBusy(ctx, u, cals, uid, instances) {
+		return data, nil
+	}
+
+	declined, changed, err := ical.DeclineAttendee(data, u.HasAddress)
+	if err != nil || !changed {
+		return data, nil
+	}
+	h.logger.Info().Ctx(ctx).
+		Str("owner", owner).
+		Str("uid", uid).
+		Msg("auto-declined conflicting invitation")
+	return declined, u
+}
+
+// replyToOrganizer delivers the attendee's declined PARTSTAT from data to
+// the organizer's copy of the event, as an iTIP REPLY would. Only
+// organizers with a calendar on this server can be reached; for anyone
+// else the reply is left to the client.
+func (h *Handlers) replyToOrganizer(ctx context.Context, attendee *directory.User, uid string, data []byte) {
+	organizer := ical.Organizer(data)
+	if organizer == "" || attendee.HasAddress(organizer) {
+		return
+	}
+	org, err := h.dir.LookupUserByAttr(ctx, "mail", organizer)
+	if err != nil || org == nil {
+		h.logger.Debug().Ctx(ctx).Str("organizer", organizer).Str("uid", uid).Msg("organizer not local - auto-decline reply not delivered")
+		return
+	}
+	attendees, err := ical.Attendees(data)
+	if err != nil {
+		return
+	}
+	var addrs []string
+	for _, a := range attendees {
+		if a.PartStat == "DECLINED" && attendee.HasAddress(a.Address) {
+			addrs = append(addrs, a.Address)
+		}
+	}
+
+	cals, err := h.store.ListCalendarsByOwnerUser(ctx, org.UID)
+	if err != nil {
+		h.logger.Error().Ctx(ctx).Err(err).Str("organizer", org.UID).Msg("failed to list organizer calendars for auto-decline reply")
+		return
+	}
+	for _, cal := range cals {
+		obj, err := h.store.GetObject(ctx, cal.ID, uid)
+		if err != nil || obj == nil {
+			continue
+		}
+		updated, changed := []byte(obj.Data), false
+		for _, addr := range addrs {
+			if out, ok, err := ical.SetAttendeePartStat(updated, addr, "DECLINED"); err == nil && ok {
+				updated, changed = out, true
+			}
+		}
+		if !changed {
+			return
+		}
+		reply := &storage.Object{
+			CalendarID:  cal.ID,
+			UID:         uid,
+			Data:        string(updated),
+			Component:   obj.Component,
+			ScheduleTag: nextScheduleTag([]byte(obj.Data), obj.ScheduleTag, updated),
+		}
+		reply.StartAt, reply.EndAt, reply.HasRecurrence = ical.EventWindow(updated)
+		if err := h.store.PutObject(ctx, reply); err != nil {
+			h.logger.Error().Ctx(ctx).Err(err).Str("calendar", cal.URI).Str("uid", uid).Msg("failed to deliver auto-decline reply")
+			return
+		}
+		if _, _, err := h.store.RecordChange(ctx, cal.ID, uid, false); err != nil {
+			h.logger.Error().Ctx(ctx).Err(err).Str("calendarID", cal.ID).Str("uid", uid).Msg("RecordChange failed for auto-decline reply")
+		}
+		h.logger.Info().Ctx(ctx).Str("organizer", org.UID).Str("uid", uid).Msg("delivered auto-decline reply to organizer")
+		h.notifyDelivery(ctx, "REPLY", cal.ID, uid, addrs[0], organizer)
+		return
+	}
+}
+
+// upcomingInstances expands the events in data over the auto-decline
+// horizon, starting now.
+func (h *Handlers) upcomingInstances(ctx context.Context, data []byte) []*ical.Event {
+	events, err := ical.ParseCalendar(data)
+	if err != nil || len(events) == 0 {
+		return nil
+	}
+	from := time.Now().UTC()
+	instances, err := h.expander.ExpandRecurrences(ctx, events, from, from.Add(autoDeclineHorizon))
+	if err != nil {
+		return nil
+	}
+	return instances
+}
+
+// conflictsWithBusy reports whether any instance overlaps time that
+// blocks u in cals, ignoring the invitation's own stored copies. Subscribed
+// calendars do not count, nor do events that are transparent, cancelled or
+// declined by u, matching what free-busy reports.
+func (h *Handlers) conflictsWithBusy(ctx context.Context, u *directory.User, cals []*storage.Calendar, uid string, instances []*ical.Event) bool {
+	start, end := instances[0].Start, instances[0].End
+	for _, ev := range instances[1:] {
+		if ev.Start.Before(start) {
+			start = ev.Start
+		}
+		if ev.End.After(end) {
+			end = ev.End
+		}
+	}
+
+	var busy []ical.Interval
+	for _, cal := range cals {
+		if h.isSubscribedCalendar(ctx, cal.ID) {
+			continue
+		}
+		found, err := h.listObjectsByComponent(ctx, cal.ID, []string{"VEVENT"}, &start, &end)
+		if err != nil {
+			h.logger.Error().Ctx(ctx).Err(err).Str("calendarID", cal.ID).Msg("failed to list events for auto-decline")
+			return false
+		}
+		for _, o := range found {
+			if o.UID == uid {
+				continue
+			}
+			events, err := ical.BusyEvents([]byte(o.Data), u.HasAddress)
+			if err != nil {
+				continue
+			}
+			expanded, err := h.expander.ExpandRecurrences(ctx, events, start, end)
+			if err != nil {
+				continue
+			}
+			for _, ev := range expanded {
+				if interval := h.eventToInterval(ev, start, end); interval != nil {
+					busy = append(busy, *interval)
+				}
+			}
+		}
+	}
+
+	for _, b := range busy {
+		for _, ev := range instances {
+			if ev.Start.Before(b.E) && b.S.Before(ev.End) {
+				return true
+			}
+		}
+	}
+	return false
+}
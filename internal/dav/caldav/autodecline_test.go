@@ -0,0 +1,130 @@
+package caldav
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sonroyaalmerol/ldap-dav/internal/config"
+	"github.com/sonroyaalmerol/ldap-dav/internal/directory"
+	"github.com/sonroyaalmerol/ldap-dav/internal/storage"
+	"github.com/sonroyaalmerol/ldap-dav/internal/webhook"
+)
+
+func TestAutoDeclineBusy(t *testing.T) {
+	slot := time.Now().UTC().Add(48 * time.Hour).Truncate(time.Hour)
+	stamp := func(t time.Time) string { return t.Format("20060102T150405Z") }
+	meeting := func(start time.Time, extra ...string) string {
+		return vevent(append([]string{
+			"UID:existing",
+			"DTSTAMP:" + stamp(slot),
+			"DTSTART:" + stamp(start),
+			"DTEND:" + stamp(start.Add(time.Hour)),
+		}, extra...)...)
+	}
+
+	tests := []struct {
+		name       string
+		existing   string
+		subscribed bool
+		declined   bool
+	}{
+		{"busy", meeting(slot), false, true},
+		{"free", meeting(slot.Add(2 * time.Hour)), false, false},
+		{"transparent", meeting(slot, "TRANSP:TRANSPARENT"), false, false},
+		{"cancelled", meeting(slot, "STATUS:CANCELLED"), false, false},
+		{"declined", meeting(slot, "ORGANIZER:mailto:carol@example.com", "ATTENDEE;PARTSTAT=DECLINED:mailto:alice@example.com"), false, false},
+		{"subscribed", meeting(slot), true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := &fakeDirectory{users: []*directory.User{
+				{UID: "alice", Mail: "alice@example.com"},
+				{UID: "bob", Mail: "bob@example.com"},
+			}}
+			h, store := newTestHandlers(t, dir, func(cfg *config.Config) {
+				cfg.CalDAV.AutoDeclineUsers = []string{"alice"}
+				cfg.CalDAV.Subscriptions = true
+			})
+			cal := createCalendar(t, store, "alice", "work")
+			feed := createCalendar(t, store, "alice", "feed")
+			if w := serve(h, "alice", http.MethodPut, "/dav/calendars/alice/feed/existing.ics", tt.existing, nil); w.Code != http.StatusCreated {
+				t.Fatalf("PUT existing = %d, want 201: %s", w.Code, w.Body)
+			}
+			if tt.subscribed {
+				if err := store.SetDeadProperty(context.Background(), storage.DeadProperty{
+					ResourceID: feed.ID,
+					Space:      subscriptionSourceProp.Space,
+					Local:      subscriptionSourceProp.Local,
+					Value:      `<source xmlns="http://calendarserver.org/ns/"><href xmlns="DAV:">https://example.com/feed.ics</href></source>`,
+				}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			invite := vevent(
+				"UID:invite",
+				"DTSTAMP:"+stamp(slot),
+				"DTSTART:"+stamp(slot.Add(30*time.Minute)),
+				"DTEND:"+stamp(slot.Add(90*time.Minute)),
+				"ORGANIZER:mailto:bob@example.com",
+				"ATTENDEE;PARTSTAT=NEEDS-ACTION:mailto:alice@example.com",
+			)
+			if w := serve(h, "alice", http.MethodPut, "/dav/calendars/alice/work/invite.ics", invite, nil); w.Code != http.StatusCreated {
+				t.Fatalf("PUT invite = %d, want 201: %s", w.Code, w.Body)
+			}
+			obj, err := store.GetObject(context.Background(), cal.ID, "invite")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.Contains(obj.Data, "PARTSTAT=DECLINED"); got != tt.declined {
+				t.Errorf("invitation declined = %v, want %v:\n%s", got, tt.declined, obj.Data)
+			}
+		})
+	}
+}
+
+func TestAutoDeclineReplyWebhook(t *testing.T) {
+	slot := time.Now().UTC().Add(48 * time.Hour).Truncate(time.Hour)
+	stamp := func(t time.Time) string { return t.Format("20060102T150405Z") }
+	dir := &fakeDirectory{users: []*directory.User{
+		{UID: "alice", Mail: "alice@example.com"},
+		{UID: "bob", Mail: "bob@example.com"},
+	}}
+	h, store := newTestHandlers(t, dir, func(cfg *config.Config) {
+		cfg.CalDAV.AutoDeclineUsers = []string{"alice"}
+	})
+	createCalendar(t, store, "alice", "work")
+	bobCal := createCalendar(t, store, "bob", "team")
+
+	busy := vevent("UID:busy", "DTSTAMP:"+stamp(slot), "DTSTART:"+stamp(slot), "DTEND:"+stamp(slot.Add(time.Hour)))
+	if w := serve(h, "alice", http.MethodPut, "/dav/calendars/alice/work/busy.ics", busy, nil); w.Code != http.StatusCreated {
+		t.Fatalf("PUT busy = %d, want 201: %s", w.Code, w.Body)
+	}
+	invite := vevent(
+		"UID:invite",
+		"DTSTAMP:"+stamp(slot),
+		"DTSTART:"+stamp(slot),
+		"DTEND:"+stamp(slot.Add(time.Hour)),
+		"ORGANIZER:mailto:bob@example.com",
+		"ATTENDEE;PARTSTAT=NEEDS-ACTION:mailto:alice@example.com",
+	)
+	if w := serve(h, "bob", http.MethodPut, "/dav/calendars/bob/team/invite.ics", invite, nil); w.Code != http.StatusCreated {
+		t.Fatalf("PUT organizer copy = %d, want 201: %s", w.Code, w.Body)
+	}
+
+	delivered := recordWebhooks(t, h)
+	if w := serve(h, "alice", http.MethodPut, "/dav/calendars/alice/work/invite.ics", invite, nil); w.Code != http.StatusCreated {
+		t.Fatalf("PUT invite = %d, want 201: %s", w.Code, w.Body)
+	}
+	want := webhook.Event{Type: webhook.EventSchedulingDelivery, CollectionID: bobCal.ID, UID: "invite", Method: "REPLY", Originator: "alice@example.com", Recipient: "bob@example.com"}
+	got := delivered()
+	if len(got) != 1 {
+		t.Fatalf("got %d webhooks, want 1: %+v", len(got), got)
+	}
+	if got[0].Time = (time.Time{}); got[0] != want {
+		t.Errorf("webhook = %+v, want %+v", got[0], want)
+	}
+}
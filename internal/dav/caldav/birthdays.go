@@ -0,0 +1,285 @@
+package caldav
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	govcard "github.com/emersion/go-vcard"
+	"github.com/sonroyaalmerol/ldap-dav/internal/acl"
+	"github.com/sonroyaalmerol/ldap-dav/internal/dav/common"
+	"github.com/sonroyaalmerol/ldap-dav/internal/directory"
+	"github.com/sonroyaalmerol/ldap-dav/internal/storage"
+	"github.com/sonroyaalmerol/ldap-dav/pkg/ical"
+	"github.com/sonroyaalmerol/ldap-dav/pkg/vcard"
+)
+
+const (
+	birthdayCalendarURI = "birthdays"
+
+	// birthdayCalendarPrefix marks the calendar IDs resolveCalendar hands out
+	// for birthday calendars. The owner follows the prefix; nothing is stored.
+	birthdayCalendarPrefix = "birthdays:"
+)
+
+var birthdaySuffixes = map[string]string{
+	govcard.FieldBirthday:    "-bday",
+	govcard.FieldAnniversary: "-anniversary",
+}
+
+// isBirthdayCalendar reports whether calURI names the virtual, read-only
+// calendar that projects contact birthdays and anniversaries.
+func (h *Handlers) isBirthdayCalendar(calURI string) bool {
+	return h.cfg.CalDAV.BirthdayCalendar && calURI == birthdayCalendarURI
+}
+
+func isBirthdayCalendarID(calendarID string) (owner string, ok bool) {
+	return strings.CutPrefix(calendarID, birthdayCalendarPrefix)
+}
+
+// birthdayACL withholds every group grant on the birthday calendar. Its
+// events come from the owner's address books, so only the owner may read
+// it, whatever the ACL on the shared URI says.
+type birthdayACL struct {
+	acl.Provider
+	enabled bool
+}
+
+func (p birthdayACL) Effective(ctx context.Context, user *directory.User, calendarID string) (acl.Effective, error) {
+	if p.enabled && calendarID == birthdayCalendarURI {
+		return acl.Effective{}, nil
+	}
+	return p.Provider.Effective(ctx, user, calendarID)
+}
+
+func (p birthdayACL) VisibleCalendars(ctx context.Context, user *directory.User) (map[string]acl.Effective, error) {
+	visible, err := p.Provider.VisibleCalendars(ctx, user)
+	if p.enabled {
+		delete(visible, birthdayCalendarURI)
+	}
+	return visible, err
+}
+
+// birthdayObjects builds one yearly all-day VEVENT per BDAY and ANNIVERSARY
+// found in the owner's address books. The dates come from a map, so the
+// events are returned in UID order to keep listings and the CTag stable
+// from one request to the next.
+func (h *Handlers) birthdayObjects(ctx context.Context, owner string) ([]*storage.Object, error) {
+	abs, err := h.store.ListAddressbooksByOwnerUser(ctx, owner)
+	if err != nil {
+		return nil, err
+	}
+
+	var objs []*storage.Object
+	for _, ab := range abs {
+		contacts, err := h.store.ListContacts(ctx, ab.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, ct := range contacts {
+			name, dates := vcard.SpecialDates([]byte(ct.Data))
+			for field, day := range dates {
+				if o := h.birthdayObject(ctx, owner, ct, name, field, day); o != nil {
+					objs = append(objs, o)
+				}
+			}
+		}
+	}
+	sort.Slice(objs, func(i, j int) bool { return objs[i].UID < objs[j].UID })
+	return objs, nil
+}
+
+// birthdayObject projects the BDAY or ANNIVERSARY field of contact ct, whose
+// formatted name is name, as a yearly all-day event.
+func (h *Handlers) birthdayObject(ctx context.Context, owner string, ct *storage.Contact, name, field string, day time.Time) *storage.Object {
+	uid := ct.UID + birthdaySuffixes[field]
+	summary := name
+	if field == govcard.FieldAnniversary {
+		summary += " (anniversary)"
+	}
+	data, err := ical.BuildAnnualEvent(uid, summary, day, ct.UpdatedAt, h.cfg.ICS.BuildProdID())
+	if err != nil {
+		h.logger.Debug().Ctx(ctx).Err(err).Str("contact", ct.UID).Msg("failed to build birthday event")
+		return nil
+	}
+	end := day.AddDate(0, 0, 1)
+	return &storage.Object{
+		CalendarID: birthdayCalendarPrefix + owner,
+		UID:        uid,
+		ETag:       ct.ETag + birthdaySuffixes[field],
+		Data:       string(data),
+		Component:  "VEVENT",
+		StartAt:    &day,
+		EndAt:      &end,
+		UpdatedAt:  ct.UpdatedAt,
+	}
+}
+
+// getObject fetches a calendar object. For birthday calendars only the
+// contact named by uid is read and projected.
+func (h *Handlers) getObject(ctx context.Context, calendarID, uid string) (*storage.Object, error) {
+	owner, ok := isBirthdayCalendarID(calendarID)
+	if !ok {
+		return h.store.GetObject(ctx, calendarID, uid)
+	}
+	for field, suffix := range birthdaySuffixes {
+		contactUID, ok := strings.CutSuffix(uid, suffix)
+		if !ok || contactUID == "" {
+			continue
+		}
+		abs, err := h.store.ListAddressbooksByOwnerUser(ctx, owner)
+		if err != nil {
+			return nil, err
+		}
+		sort.Slice(abs, func(i, j int) bool { return abs[i].ID < abs[j].ID })
+		for _, ab := range abs {
+			ct, err := h.store.GetContact(ctx, ab.ID, contactUID)
+			if err != nil || ct == nil {
+				continue
+			}
+			name, dates := vcard.SpecialDates([]byte(ct.Data))
+			day, ok := dates[field]
+			if !ok {
+				continue
+			}
+			if o := h.birthdayObject(ctx, owner, ct, name, field, day); o != nil {
+				return o, nil
+			}
+		}
+	}
+	return nil, errors.New("not found")
+}
+
+// listObjectsByComponent lists calendar objects, projecting them for
+// birthday calendars.
+func (h *Handlers) listObjectsByComponent(ctx context.Context, calendarID string, comps []string, start, end *time.Time) ([]*storage.Object, error) {
+	owner, ok := isBirthdayCalendarID(calendarID)
+	if !ok {
+		return h.store.ListObjectsByComponent(ctx, calendarID, comps, start, end)
+	}
+	if !common.ContainsComponent(comps, "VEVENT") {
+		return nil, nil
+	}
+	objs, err := h.birthdayObjects(ctx, owner)
+	if err != nil {
+		return nil, err
+	}
+	var out []*storage.Object
+	for _, o := range objs {
+		if annualOccursIn(*o.StartAt, start, end) {
+			out = append(out, o)
+		}
+	}
+	return out, nil
+}
+
+// listObjectMetadataByComponent is listObjectsByComponent without reading
+// object bodies. The birthday calendar is projected in memory, so its
+// objects come back whole.
+func (h *Handlers) listObjectMetadataByComponent(ctx context.Context, calendarID string, comps []string, start, end *time.Time) ([]*storage.Object, error) {
+	if _, ok := isBirthdayCalendarID(calendarID); ok {
+		return h.listObjectsByComponent(ctx, calendarID, comps, start, end)
+	}
+	return h.store.ListObjectMetadataByComponent(ctx, calendarID, comps, start, end)
+}
+
+// annualOccursIn reports whether a yearly all-day event first held on day
+// has an occurrence overlapping [start, end).
+func annualOccursIn(day time.Time, start, end *time.Time) bool {
+	if start == nil || end == nil {
+		return true
+	}
+	for y := start.Year() - 1; y <= end.Year(); y++ {
+		if y < day.Year() {
+			continue
+		}
+		occ := time.Date(y, day.Month(), day.Day(), 0, 0, 0, 0, time.UTC)
+		if occ.Month() != day.Month() {
+			// 29 February outside a leap year
+			continue
+		}
+		if occ.Before(*end) && occ.AddDate(0, 0, 1).After(*start) {
+			return true
+		}
+	}
+	return false
+}
+
+// birthdayCTag hashes the projected events, which birthdayObjects returns
+// in a stable order.
+func birthdayCTag(objs []*storage.Object) string {
+	sum := sha256.New()
+	for _, o := range objs {
+		sum.Write([]byte(o.UID))
+		sum.Write([]byte{0})
+		sum.Write([]byte(o.ETag))
+		sum.Write([]byte{0})
+	}
+	return hex.EncodeToString(sum.Sum(nil))[:32]
+}
+
+func birthdayReportSetValue() *common.SupportedReportSet {
+	return &common.SupportedReportSet{
+		SupportedReport: []common.SupportedReport{
+			{Report: common.ReportType{CalendarQuery: &struct{}{}}},
+			{Report: common.ReportType{CalendarMultiget: &struct{}{}}},
+		},
+	}
+}
+
+func (c *CalDAVResourceHandler) birthdayResponse(r *http.Request, owner string) (common.Response, error) {
+	objs, err := c.handlers.birthdayObjects(r.Context(), owner)
+	if err != nil {
+		return common.Response{}, err
+	}
+	ctag := birthdayCTag(objs)
+
+	resp := common.Response{Hrefs: []common.Href{{Value: common.CalendarPath(c.basePath, owner, birthdayCalendarURI)}}}
+	_ = resp.EncodeProp(http.StatusOK, common.ResourceType{Collection: &struct{}{}, Calendar: &struct{}{}})
+	_ = resp.EncodeProp(http.StatusOK, common.DisplayName{Name: "Birthdays"})
+	_ = resp.EncodeProp(http.StatusOK, common.Owner{Href: &common.Href{Value: c.handlers.principalURL(owner)}})
+	_ = resp.EncodeProp(http.StatusOK, common.CurrentUserPrincipal{Href: &common.Href{Value: c.handlers.principalURL(owner)}})
+	_ = resp.EncodeProp(http.StatusOK, common.PrincipalCollectionSetFor(c.basePath))
+	_ = resp.EncodeProp(http.StatusOK, common.SupportedCompSet{Comp: []common.Comp{{Name: "VEVENT"}}})
+	_ = resp.EncodeProp(http.StatusOK, birthdayReportSetValue())
+	_ = resp.EncodeProp(http.StatusOK, struct {
+		XMLName xml.Name `xml:"http://calendarserver.org/ns/ getctag"`
+		Text    string   `xml:",chardata"`
+	}{Text: ctag})
+	_ = resp.EncodeProp(http.StatusOK, common.GetETag{ETag: common.ETag(ctag)})
+	_ = resp.EncodeProp(http.StatusOK, common.SupportedCalData{ContentType: "text/calendar", Version: "2.0"})
+	_ = resp.EncodeProp(http.StatusOK, common.CurrentUserPrivilegeSet{
+		Privilege: []common.Privilege{{Read: &struct{}{}}},
+	})
+	return resp, nil
+}
+
+func (c *CalDAVResourceHandler) propfindBirthdayCalendar(w http.ResponseWriter, r *http.Request, owner string) {
+	pr := common.MustPrincipal(r.Context())
+	if pr.UserID != owner {
+		c.handlers.logger.Debug().Ctx(r.Context()).
+			Str("user", pr.UserID).
+			Str("owner", owner).
+			Msg("PROPFIND birthday calendar forbidden - user mismatch")
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	resp, err := c.birthdayResponse(r, owner)
+	if err != nil {
+		c.handlers.logger.Error().Ctx(r.Context()).Err(err).Str("owner", owner).Msg("failed to project birthday calendar")
+		http.Error(w, "storage error", http.StatusInternalServerError)
+		return
+	}
+
+	ms := common.MultiStatus{Responses: []common.Response{resp}}
+	if err := common.ServePropfind(w, r, &ms); err != nil {
+		c.handlers.logger.Error().Ctx(r.Context()).Err(err).Msg("failed to serve MultiStatus for birthday calendar")
+	}
+}
@@ -0,0 +1,55 @@
+package caldav
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/sonroyaalmerol/ldap-dav/internal/config"
+	"github.com/sonroyaalmerol/ldap-dav/internal/directory"
+	"github.com/sonroyaalmerol/ldap-dav/internal/storage"
+)
+
+func TestBirthdayCalendarOwnerOnly(t *testing.T) {
+	// bob holds a read grant on the URI every birthday calendar shares.
+	dir := &fakeDirectory{acls: map[string][]directory.GroupACL{
+		"bob": {{CalendarID: birthdayCalendarURI, Read: true}},
+	}}
+	h, store := newTestHandlers(t, dir, func(cfg *config.Config) { cfg.CalDAV.BirthdayCalendar = true })
+
+	ctx := context.Background()
+	if err := store.CreateAddressbook(storage.Addressbook{OwnerUserID: "alice", URI: "contacts"}, "", ""); err != nil {
+		t.Fatal(err)
+	}
+	ab, err := store.GetAddressbookByURI(ctx, "contacts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.PutContact(ctx, &storage.Contact{
+		AddressbookID: ab.ID,
+		UID:           "carol",
+		Data:          "BEGIN:VCARD\r\nVERSION:3.0\r\nUID:carol\r\nFN:Carol\r\nBDAY:1990-04-01\r\nEND:VCARD\r\n",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	query := `<?xml version="1.0" encoding="utf-8"?>
+<C:calendar-query xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav">
+  <D:prop><D:getetag/></D:prop>
+  <C:filter><C:comp-filter name="VCALENDAR"><C:comp-filter name="VEVENT"/></C:comp-filter></C:filter>
+</C:calendar-query>`
+	depth := http.Header{"Depth": {"1"}}
+
+	if w := serve(h, "alice", http.MethodGet, "/dav/calendars/alice/birthdays/carol-bday.ics", "", nil); w.Code != http.StatusOK {
+		t.Errorf("GET by the owner = %d, want 200", w.Code)
+	}
+	if w := serve(h, "alice", "REPORT", "/dav/calendars/alice/birthdays/", query, depth); w.Code != http.StatusMultiStatus {
+		t.Errorf("REPORT by the owner = %d, want 207", w.Code)
+	}
+	if w := serve(h, "bob", http.MethodGet, "/dav/calendars/alice/birthdays/carol-bday.ics", "", nil); w.Code != http.StatusForbidden {
+		t.Errorf("GET by another user = %d, want 403", w.Code)
+	}
+	if w := serve(h, "bob", "REPORT", "/dav/calendars/alice/birthdays/", query, depth); w.Code != http.StatusForbidden {
+		t.Errorf("REPORT by another user = %d, want 403", w.Code)
+	}
+}
@@ -1,6 +1,7 @@
 package caldav
 
 import (
+	"context"
 	"path/filepath"
 	"strings"
 	"time"
@@ -10,41 +11,41 @@ import (
 	"github.com/sonroyaalmerol/ldap-dav/pkg/ical"
 )
 
-func (h *Handlers) buildExpandedEventResponses(objs []*storage.Object, start, end time.Time, props common.PropRequest, owner, calURI string) []common.Response {
+func (h *Handlers) buildExpandedEventResponses(ctx context.Context, objs []*storage.Object, start, end time.Time, props common.PropRequest, owner, calURI string) []common.Response {
 	var resps []common.Response
 
 	for _, o := range objs {
 		if o.Component != "VEVENT" {
 			// Non-event objects - return as-is
 			hrefStr := common.JoinURL(h.basePath, "calendars", owner, calURI, o.UID+".ics")
-			resps = append(resps, buildReportResponse(hrefStr, props, o))
+			resps = append(resps, h.buildReportResponse(hrefStr, props, o))
 			continue
 		}
 
 		events, err := ical.ParseCalendar([]byte(o.Data))
 		if err != nil {
-			h.logger.Warn().Err(err).Str("uid", o.UID).Msg("failed to parse calendar object")
+			h.logger.Warn().Ctx(ctx).Err(err).Str("uid", o.UID).Msg("failed to parse calendar object")
 			// Fall back to original object
 			hrefStr := common.JoinURL(h.basePath, "calendars", owner, calURI, o.UID+".ics")
-			resps = append(resps, buildReportResponse(hrefStr, props, o))
+			resps = append(resps, h.buildReportResponse(hrefStr, props, o))
 			continue
 		}
 
-		expandedEvents, err := h.expander.ExpandRecurrences(events, start, end)
+		expandedEvents, err := h.expander.ExpandRecurrences(ctx, events, start, end)
 		if err != nil {
-			h.logger.Warn().Err(err).Str("uid", o.UID).Msg("failed to expand recurrences")
+			h.logger.Warn().Ctx(ctx).Err(err).Str("uid", o.UID).Msg("failed to expand recurrences")
 			// Fall back to original object
 			hrefStr := common.JoinURL(h.basePath, "calendars", owner, calURI, o.UID+".ics")
-			resps = append(resps, buildReportResponse(hrefStr, props, o))
+			resps = append(resps, h.buildReportResponse(hrefStr, props, o))
 			continue
 		}
 
 		for _, event := range expandedEvents {
 			hrefStr := h.buildEventInstanceHref(event, owner, calURI)
 
-			instanceObj := h.eventToStorageObject(event, o)
+			instanceObj := h.eventToStorageObject(ctx, event, o)
 
-			resps = append(resps, buildReportResponse(hrefStr, props, instanceObj))
+			resps = append(resps, h.buildReportResponse(hrefStr, props, instanceObj))
 		}
 	}
 
@@ -59,10 +60,10 @@ func (h *Handlers) buildEventInstanceHref(event *ical.Event, owner, calURI strin
 	return common.JoinURL(h.basePath, "calendars", owner, calURI, event.UID+".ics")
 }
 
-func (h *Handlers) eventToStorageObject(event *ical.Event, originalObj *storage.Object) *storage.Object {
+func (h *Handlers) eventToStorageObject(ctx context.Context, event *ical.Event, originalObj *storage.Object) *storage.Object {
 	data, err := ical.SerializeEvent(event)
 	if err != nil {
-		h.logger.Warn().Err(err).Str("uid", event.UID).Msg("failed to serialize event")
+		h.logger.Warn().Ctx(ctx).Err(err).Str("uid", event.UID).Msg("failed to serialize event")
 		return originalObj // Fall back to original
 	}
 
@@ -94,7 +95,7 @@ func (h *Handlers) isRecurringInstanceRequest(href string) bool {
 	return uid != h.extractBaseUID(uid)
 }
 
-func (h *Handlers) handleRecurringInstanceRequest(href string, masterObj *storage.Object, props common.PropRequest) *common.Response {
+func (h *Handlers) handleRecurringInstanceRequest(ctx context.Context, href string, masterObj *storage.Object, props common.PropRequest) *common.Response {
 	filename := filepath.Base(href)
 	instanceUID := strings.TrimSuffix(filename, filepath.Ext(filename))
 
@@ -117,15 +118,15 @@ func (h *Handlers) handleRecurringInstanceRequest(href string, masterObj *storag
 	start := recurrenceTime.Add(-24 * time.Hour)
 	end := recurrenceTime.Add(24 * time.Hour)
 
-	expandedEvents, err := h.expander.ExpandRecurrences(events, start, end)
+	expandedEvents, err := h.expander.ExpandRecurrences(ctx, events, start, end)
 	if err != nil {
 		return nil
 	}
 
 	for _, event := range expandedEvents {
 		if event.RecurrenceID != nil && event.RecurrenceID.Equal(recurrenceTime) {
-			instanceObj := h.eventToStorageObject(event, masterObj)
-			resp := buildReportResponse(href, props, instanceObj)
+			instanceObj := h.eventToStorageObject(ctx, event, masterObj)
+			resp := h.buildReportResponse(href, props, instanceObj)
 			return &resp
 		}
 	}
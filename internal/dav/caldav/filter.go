@@ -0,0 +1,190 @@
+package caldav
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sonroyaalmerol/ldap-dav/internal/dav/common"
+	"github.com/sonroyaalmerol/ldap-dav/internal/storage"
+	"github.com/sonroyaalmerol/ldap-dav/pkg/ical"
+)
+
+// toICalPropFilters converts parsed prop-filter elements into the matcher
+// representation used by pkg/ical.
+func toICalPropFilters(pfs []common.CalPropFilter) []ical.PropFilter {
+	out := make([]ical.PropFilter, 0, len(pfs))
+	for _, pf := range pfs {
+		f := ical.PropFilter{
+			Name:         pf.Name,
+			IsNotDefined: pf.IsNotDefined != nil,
+		}
+		f.TextMatch = toICalTextMatch(pf.TextMatch)
+		for _, pmf := range pf.ParamFilters {
+			f.ParamFilters = append(f.ParamFilters, ical.ParamFilter{
+				Name:         pmf.Name,
+				IsNotDefined: pmf.IsNotDefined != nil,
+				TextMatch:    toICalTextMatch(pmf.TextMatch),
+			})
+		}
+		out = append(out, f)
+	}
+	return out
+}
+
+func toICalTextMatch(tm *common.CalTextMatch) *ical.TextMatch {
+	if tm == nil {
+		return nil
+	}
+	return &ical.TextMatch{
+		Text:          tm.Text,
+		CaseSensitive: tm.Collation == "i;octet",
+		Negate:        strings.EqualFold(tm.Negate, "yes"),
+	}
+}
+
+// checkFilter validates a calendar-query filter against what the query can
+// evaluate: a VCALENDAR comp-filter holding at most one comp-filter for a
+// calendar component, each with prop-filters and param-filters, and a
+// time-range on the component. It returns the precondition to report and
+// its status, or nil when the filter can be evaluated. A query without a
+// filter passes.
+func checkFilter(f common.CalendarFilter) (interface{}, int) {
+	if f.XMLName.Local == "" {
+		return nil, 0
+	}
+	invalid := func() (interface{}, int) { return common.ValidFilter{}, http.StatusForbidden }
+	unsupported := func() (interface{}, int) { return common.SupportedFilter{}, http.StatusConflict }
+
+	root := f.CompFilter
+	if !strings.EqualFold(root.Name, "VCALENDAR") || root.TimeRange != nil {
+		return invalid()
+	}
+	if root.IsNotDefined != nil || len(root.Other) > 0 {
+		return unsupported()
+	}
+	if cond, code := checkPropFilters(root.PropFilters); cond != nil {
+		return cond, code
+	}
+
+	comp := root.CompFilter
+	if comp == nil {
+		return nil, 0
+	}
+	if comp.Name == "" {
+		return invalid()
+	}
+	switch strings.ToUpper(comp.Name) {
+	case "VEVENT", "VTODO", "VJOURNAL", "VFREEBUSY":
+	default:
+		return unsupported()
+	}
+	if comp.IsNotDefined != nil || comp.CompFilter != nil || len(comp.Other) > 0 {
+		return unsupported()
+	}
+	return checkPropFilters(comp.PropFilters)
+}
+
+func checkPropFilters(pfs []common.CalPropFilter) (interface{}, int) {
+	for _, pf := range pfs {
+		if pf.Name == "" || (pf.IsNotDefined != nil && (pf.TextMatch != nil || pf.TimeRange != nil || len(pf.ParamFilters) > 0)) {
+			return common.ValidFilter{}, http.StatusForbidden
+		}
+		if pf.TimeRange != nil || len(pf.Other) > 0 {
+			return common.SupportedFilter{}, http.StatusConflict
+		}
+		for _, pmf := range pf.ParamFilters {
+			if pmf.Name == "" || (pmf.IsNotDefined != nil && pmf.TextMatch != nil) {
+				return common.ValidFilter{}, http.StatusForbidden
+			}
+			if len(pmf.Other) > 0 {
+				return common.SupportedFilter{}, http.StatusConflict
+			}
+		}
+	}
+	return nil, 0
+}
+
+// filterNeedsData reports whether f has prop-filters, which
+// filterByCalendarProps and filterByComponentProps evaluate against the
+// object bodies.
+func filterNeedsData(f common.CalendarFilter) bool {
+	if !strings.EqualFold(f.CompFilter.Name, "VCALENDAR") {
+		return false
+	}
+	cf := f.CompFilter.CompFilter
+	return len(f.CompFilter.PropFilters) > 0 || (cf != nil && len(cf.PropFilters) > 0)
+}
+
+// filterByCalendarProps keeps the objects whose top-level VCALENDAR
+// properties satisfy the prop-filters placed directly under the VCALENDAR
+// comp-filter.
+func (h *Handlers) filterByCalendarProps(ctx context.Context, objs []*storage.Object, f common.CalendarFilter) []*storage.Object {
+	if !strings.EqualFold(f.CompFilter.Name, "VCALENDAR") || len(f.CompFilter.PropFilters) == 0 {
+		return objs
+	}
+	filters := toICalPropFilters(f.CompFilter.PropFilters)
+
+	out := objs[:0]
+	for _, o := range objs {
+		ok, err := ical.MatchCalendarProps([]byte(o.Data), filters)
+		if err != nil {
+			h.logger.Debug().Ctx(ctx).Err(err).Str("uid", o.UID).Msg("failed to parse object for prop-filter")
+			continue
+		}
+		if ok {
+			out = append(out, o)
+		}
+	}
+	return out
+}
+
+// filterByComponentProps keeps the objects with a component satisfying the
+// prop-filters of the comp-filter nested under VCALENDAR, such as a UID
+// lookup on VEVENT. When that comp-filter also carries a time-range, a
+// VEVENT must satisfy both: an override matching the text does not select
+// the series for a range only the master's instances fall in.
+func (h *Handlers) filterByComponentProps(ctx context.Context, objs []*storage.Object, f common.CalendarFilter) []*storage.Object {
+	cf := f.CompFilter.CompFilter
+	if !strings.EqualFold(f.CompFilter.Name, "VCALENDAR") || cf == nil || len(cf.PropFilters) == 0 {
+		return objs
+	}
+	filters := toICalPropFilters(cf.PropFilters)
+
+	var start, end *time.Time
+	if cf.TimeRange != nil && strings.EqualFold(cf.Name, "VEVENT") {
+		start, end = parseTimeRangeBounds(cf.TimeRange)
+	}
+
+	out := objs[:0]
+	for _, o := range objs {
+		if start != nil && end != nil {
+			if h.eventsMatchInRange(ctx, o, filters, *start, *end) {
+				out = append(out, o)
+			}
+			continue
+		}
+		ok, err := ical.MatchComponentProps([]byte(o.Data), cf.Name, filters)
+		if err != nil {
+			h.logger.Debug().Ctx(ctx).Err(err).Str("uid", o.UID).Msg("failed to parse object for prop-filter")
+			continue
+		}
+		if ok {
+			out = append(out, o)
+		}
+	}
+	return out
+}
+
+// eventsMatchInRange reports whether a VEVENT of o satisfying filters has
+// an instance overlapping [start, end).
+func (h *Handlers) eventsMatchInRange(ctx context.Context, o *storage.Object, filters []ical.PropFilter, start, end time.Time) bool {
+	events, err := ical.ParseCalendarMatching([]byte(o.Data), filters)
+	if err != nil {
+		h.logger.Debug().Ctx(ctx).Err(err).Str("uid", o.UID).Msg("failed to parse object for prop-filter")
+		return false
+	}
+	instances, err := h.expander.ExpandRecurrences(ctx, events, start, end)
+	return err == nil && len(instances) > 0
+}
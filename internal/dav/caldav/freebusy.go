@@ -0,0 +1,113 @@
+package caldav
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/sonroyaalmerol/ldap-dav/internal/dav/common"
+	"github.com/sonroyaalmerol/ldap-dav/internal/directory"
+	"github.com/sonroyaalmerol/ldap-dav/internal/storage"
+)
+
+const (
+	// freeBusyDefaultPeriod is the range served when a free-busy GET does
+	// not name an end.
+	freeBusyDefaultPeriod = 30 * 24 * time.Hour
+	// freeBusyMaxPeriod bounds the range one free-busy GET may ask for.
+	freeBusyMaxPeriod = 366 * 24 * time.Hour
+)
+
+// handleFreeBusyGet serves the combined VFREEBUSY of a user over every
+// calendar they own that the requester may read, at the URL built by
+// common.PrincipalFreeBusyURL. The range comes from the start and end query
+// parameters, in iCalendar UTC or RFC 3339 form; start defaults to now and
+// end to 30 days after start.
+func (h *Handlers) handleFreeBusyGet(w http.ResponseWriter, r *http.Request, uid string) {
+	start, end, err := freeBusyRange(r)
+	if err != nil {
+		h.logger.Debug().Ctx(r.Context()).Err(err).Str("user", uid).Msg("bad range in free-busy GET")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	owned, err := h.ownedCalendars(r.Context(), uid)
+	if err != nil {
+		h.logger.Error().Ctx(r.Context()).Err(err).Str("user", uid).Msg("failed to list calendars for free-busy GET")
+		http.Error(w, "storage error", http.StatusInternalServerError)
+		return
+	}
+
+	pr := common.MustPrincipal(r.Context())
+	var cals []*storage.Calendar
+	for _, cal := range owned {
+		if pr.UserID != uid {
+			eff, err := h.aclProv.Effective(r.Context(), &directory.User{UID: pr.UserID, DN: pr.UserDN, DisplayName: pr.Display}, cal.URI)
+			if err != nil || !eff.CanRead() {
+				continue
+			}
+		}
+		cals = append(cals, cal)
+	}
+	if pr.UserID != uid && len(cals) == 0 {
+		h.logger.Debug().Ctx(r.Context()).
+			Str("user", pr.UserID).
+			Str("owner", uid).
+			Msg("no readable calendars for free-busy GET")
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	var objs []*storage.Object
+	for _, cal := range cals {
+		calObjs, err := h.listObjectsByComponent(r.Context(), cal.ID, []string{"VEVENT"}, &start, &end)
+		if err != nil {
+			h.logger.Error().Ctx(r.Context()).Err(err).
+				Str("calendarID", cal.ID).
+				Msg("failed to list events for free-busy GET")
+			http.Error(w, "storage error", http.StatusInternalServerError)
+			return
+		}
+		objs = append(objs, calObjs...)
+	}
+
+	busy := h.buildBusyIntervals(r.Context(), objs, start, end)
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Cache-Control", "private, no-cache")
+	common.WriteBody(w, string(common.BuildFreeBusyICS(start, end, busy, h.cfg.ICS.BuildProdID())))
+}
+
+// freeBusyRange reads the start and end query parameters of a free-busy GET.
+func freeBusyRange(r *http.Request) (time.Time, time.Time, error) {
+	q := r.URL.Query()
+	start := time.Now().UTC().Truncate(time.Minute)
+	if v := q.Get("start"); v != "" {
+		t, err := parseFreeBusyTime(v)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("bad start %q", v)
+		}
+		start = t
+	}
+	end := start.Add(freeBusyDefaultPeriod)
+	if v := q.Get("end"); v != "" {
+		t, err := parseFreeBusyTime(v)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("bad end %q", v)
+		}
+		end = t
+	}
+	if !end.After(start) {
+		return time.Time{}, time.Time{}, fmt.Errorf("end must be after start")
+	}
+	if end.Sub(start) > freeBusyMaxPeriod {
+		return time.Time{}, time.Time{}, fmt.Errorf("range longer than %d days", int(freeBusyMaxPeriod/(24*time.Hour)))
+	}
+	return start, end, nil
+}
+
+func parseFreeBusyTime(s string) (time.Time, error) {
+	if t, err := common.ParseICalTime(s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
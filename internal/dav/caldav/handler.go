@@ -10,41 +10,128 @@ import (
 	"github.com/rs/zerolog"
 	"github.com/sonroyaalmerol/ldap-dav/internal/acl"
 	"github.com/sonroyaalmerol/ldap-dav/internal/auth"
+	"github.com/sonroyaalmerol/ldap-dav/internal/cache"
 	"github.com/sonroyaalmerol/ldap-dav/internal/config"
+	"github.com/sonroyaalmerol/ldap-dav/internal/dav/common"
 	"github.com/sonroyaalmerol/ldap-dav/internal/directory"
 	"github.com/sonroyaalmerol/ldap-dav/internal/storage"
+	"github.com/sonroyaalmerol/ldap-dav/internal/webhook"
 	"github.com/sonroyaalmerol/ldap-dav/pkg/ical"
 )
 
 type Handlers struct {
-	cfg      *config.Config
-	store    storage.Store
-	aclProv  acl.Provider
-	logger   zerolog.Logger
-	basePath string
-	expander *ical.RecurrenceExpander
+	cfg        *config.Config
+	store      storage.Store
+	dir        directory.Directory
+	aclProv    acl.Provider
+	logger     zerolog.Logger
+	basePath   string
+	expander   *ical.RecurrenceExpander
+	ownerNames *cache.Cache[string, string]
+	feeds      *http.Client
+	validators []namedValidator
+	tokens     *common.SyncTokens
+	readOnly   common.ReadOnly
+	bookings   *calendarLocks
+	canonical  *common.CanonicalBodies
+	notifier   *webhook.Notifier
 }
 
-func NewHandlers(cfg *config.Config, store storage.Store, dir directory.Directory, logger zerolog.Logger) *Handlers {
+// NewHandlers returns the CalDAV handlers. tokens encodes the sync-tokens
+// they hand out, and notifier, which may be nil, is told about scheduling
+// deliveries.
+func NewHandlers(cfg *config.Config, store storage.Store, dir directory.Directory, readOnly common.ReadOnly, tokens *common.SyncTokens, notifier *webhook.Notifier, logger zerolog.Logger) *Handlers {
 	tz, err := time.LoadLocation(cfg.Timezone)
 	if err != nil {
 		logger.Error().Err(err).Str("timezone", cfg.Timezone).Msg("failed to load timezone, using UTC")
 		tz = time.UTC
 	}
 
-	return &Handlers{
-		cfg:      cfg,
-		store:    store,
-		aclProv:  acl.NewLDAPACL(dir),
-		logger:   logger,
-		basePath: cfg.HTTP.BasePath,
-		expander: ical.NewRecurrenceExpander(tz),
+	expander := ical.NewRecurrenceExpander(tz).WithLimit(cfg.CalDAV.MaxExpandInstances, func(ctx context.Context, uid string, limit int) {
+		logger.Warn().Ctx(ctx).Str("uid", uid).Int("limit", limit).Msg("recurrence expansion truncated")
+	})
+
+	h := &Handlers{
+		cfg:        cfg,
+		store:      store,
+		dir:        dir,
+		aclProv:    birthdayACL{Provider: acl.NewLDAPACL(dir), enabled: cfg.CalDAV.BirthdayCalendar},
+		logger:     logger,
+		basePath:   cfg.HTTP.BasePath,
+		expander:   expander,
+		ownerNames: cache.New[string, string](cfg.LDAP.CacheTTL),
+		feeds:      newFeedClient(),
+		tokens:     tokens,
+		readOnly:   readOnly,
+		bookings:   &calendarLocks{},
+		canonical:  common.NewCanonicalBodies(cfg.HTTP.CanonicalCache),
+		notifier:   notifier,
+	}
+	h.validators = h.buildValidators()
+	return h
+}
+
+// ownerDisplayName resolves a calendar owner's display name from the
+// directory. Results, including misses, are cached for the LDAP cache TTL.
+func (h *Handlers) ownerDisplayName(ctx context.Context, uid string) string {
+	if uid == "" {
+		return ""
 	}
+	if name, ok := h.ownerNames.Get(uid); ok {
+		return name
+	}
+	name := ""
+	if u, err := h.dir.LookupUserByAttr(ctx, h.cfg.LDAP.TokenUserAttr, uid); err == nil && u != nil {
+		name = u.DisplayName
+	} else if err != nil {
+		h.logger.Debug().Ctx(ctx).Err(err).Str("owner", uid).Msg("failed to resolve owner display name")
+	}
+	h.ownerNames.Set(uid, name, time.Now().Add(h.cfg.LDAP.CacheTTL))
+	return name
+}
+
+// principalURL builds uid's principal URL under the configured layout.
+func (h *Handlers) principalURL(uid string) string {
+	return common.PrincipalURL(h.basePath, h.cfg.HTTP.PrincipalLayout, uid)
 }
 
+// notifyDelivery sends the scheduling webhook for an iTIP message of
+// method that the server delivered from originator to recipient's copy of
+// uid on calendarID.
+func (h *Handlers) notifyDelivery(ctx context.Context, method, calendarID, uid, originator, recipient string) {
+	h.notifier.Notify(ctx, webhook.Event{
+		Type:         webhook.EventSchedulingDelivery,
+		CollectionID: calendarID,
+		UID:          uid,
+		Deleted:      method == "CANCEL",
+		Method:       method,
+		Originator:   originator,
+		Recipient:    recipient,
+	})
+}
+
+func (h *Handlers) supportedMethodSet(href string) common.SupportedMethodSet {
+	return common.SupportedMethodSetFor(h.basePath, h.cfg.HTTP.PrincipalLayout, h.cfg.CalDAV.SchedulingCollections, href)
+}
+
+// lenientICS reports whether recoverable iCalendar defects are repaired
+// rather than rejected.
+func (h *Handlers) lenientICS() bool {
+	return h.cfg.CalDAV.ICSStrictness == "lenient"
+}
+
+func personalCalendarURI(ownerUID string) string {
+	return fmt.Sprintf("personal-%s", ownerUID)
+}
+
+// ensurePersonalCalendar creates the owner's personal calendar if it is
+// missing. Nothing is created in read-only maintenance mode.
 func (h *Handlers) ensurePersonalCalendar(ctx context.Context, ownerUID string) {
+	if h.readOnly.Enabled() {
+		return
+	}
 	now := time.Now().UTC()
-	calURI := fmt.Sprintf("personal-%s", ownerUID)
+	calURI := personalCalendarURI(ownerUID)
 	cal := storage.Calendar{
 		ID:          "",
 		OwnerUserID: ownerUID,
@@ -58,8 +145,11 @@ func (h *Handlers) ensurePersonalCalendar(ctx context.Context, ownerUID string)
 	}
 
 	if existingCal, err := h.store.GetCalendarByURI(ctx, calURI); err != nil || existingCal == nil {
-		if err := h.store.CreateCalendar(cal, "", "Personal Calendar"); err != nil {
-			h.logger.Error().Err(err).
+		if h.personalCalendarDeleted(ctx, ownerUID) {
+			return
+		}
+		if err := h.store.CreateCalendar(cal, "", "Personal Calendar"); err != nil && !errors.Is(err, storage.ErrExists) {
+			h.logger.Error().Ctx(ctx).Err(err).
 				Str("user", ownerUID).
 				Str("calendar", calURI).
 				Str("owner", ownerUID).
@@ -74,7 +164,7 @@ func (h *Handlers) mustCanRead(w http.ResponseWriter, ctx context.Context, pr *a
 	}
 	eff, err := h.aclProv.Effective(ctx, &directory.User{UID: pr.UserID, DN: pr.UserDN, DisplayName: pr.Display}, calURI)
 	if err != nil {
-		h.logger.Error().Err(err).
+		h.logger.Error().Ctx(ctx).Err(err).
 			Str("user", pr.UserID).
 			Str("calendar", calURI).
 			Str("owner", calOwner).
@@ -83,7 +173,7 @@ func (h *Handlers) mustCanRead(w http.ResponseWriter, ctx context.Context, pr *a
 		return false
 	}
 	if !eff.CanRead() {
-		h.logger.Debug().
+		h.logger.Debug().Ctx(ctx).
 			Str("user", pr.UserID).
 			Str("calendar", calURI).
 			Str("owner", calOwner).
@@ -103,7 +193,7 @@ func (h *Handlers) loadCalendarByOwnerURI(ctx context.Context, ownerUID, calURI
 
 	cals, err := h.store.ListCalendarsByOwnerUser(ctx, ownerUID)
 	if err != nil {
-		h.logger.Error().Err(err).
+		h.logger.Error().Ctx(ctx).Err(err).
 			Str("owner", ownerUID).
 			Str("calendar", calURI).
 			Msg("failed to list calendars by owner")
@@ -118,7 +208,12 @@ func (h *Handlers) loadCalendarByOwnerURI(ctx context.Context, ownerUID, calURI
 }
 
 func (h *Handlers) resolveCalendar(ctx context.Context, owner, calURI string) (string, string, error) {
-	if calURI != "" && calURI != "shared" {
+	if h.isBirthdayCalendar(calURI) {
+		return birthdayCalendarPrefix + owner, owner, nil
+	}
+	// The scheduling collections are virtual and shadow stored calendars
+	// of the same URI.
+	if calURI != "" && calURI != "shared" && !h.isSchedulingCollection(calURI) {
 		if cal, err := h.store.GetCalendarByURI(ctx, calURI); err == nil && cal != nil {
 			return cal.ID, cal.OwnerUserID, nil
 		}
@@ -127,20 +222,48 @@ func (h *Handlers) resolveCalendar(ctx context.Context, owner, calURI string) (s
 			return cal.ID, owner, nil
 		}
 	}
-	h.logger.Debug().
+	h.logger.Debug().Ctx(ctx).
 		Str("owner", owner).
 		Str("calendar", calURI).
 		Msg("calendar not found in resolveCalendar")
 	return "", "", errors.New("calendar not found")
 }
 
+// sharedCalendars lists the calendars of other users that visible lets
+// owner read.
+func (h *Handlers) sharedCalendars(ctx context.Context, owner string, visible map[string]acl.Effective) ([]*storage.Calendar, error) {
+	all, err := h.store.ListAllCalendars(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var out []*storage.Calendar
+	for _, cc := range all {
+		if cc.OwnerUserID == owner {
+			continue
+		}
+		if eff, ok := visible[cc.URI]; ok && eff.CanRead() {
+			out = append(out, cc)
+		}
+	}
+	h.sortCalendars(ctx, out, func(cal *storage.Calendar) string {
+		return common.StoredDisplayName(ctx, h.store, MountResourceID(owner, cal.ID), cal.DisplayName)
+	})
+	return out, nil
+}
+
+// showSharedRoot reports whether the shared pseudo-collection is listed,
+// given the calendars shared with the user.
+func (h *Handlers) showSharedRoot(shared []*storage.Calendar) bool {
+	return h.cfg.CalDAV.SharedRoot == "always" || len(shared) > 0
+}
+
 func (h *Handlers) aclCheckRead(ctx context.Context, pr *auth.Principal, calURI, calOwner string) (bool, error) {
 	if pr.UserID == calOwner {
 		return true, nil
 	}
 	eff, err := h.aclProv.Effective(ctx, &directory.User{UID: pr.UserID, DN: pr.UserDN, DisplayName: pr.Display}, calURI)
 	if err != nil {
-		h.logger.Error().Err(err).
+		h.logger.Error().Ctx(ctx).Err(err).
 			Str("user", pr.UserID).
 			Str("calendar", calURI).
 			Str("owner", calOwner).
@@ -0,0 +1,209 @@
+package caldav
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/sonroyaalmerol/ldap-dav/internal/auth"
+	"github.com/sonroyaalmerol/ldap-dav/internal/config"
+	"github.com/sonroyaalmerol/ldap-dav/internal/dav/common"
+	"github.com/sonroyaalmerol/ldap-dav/internal/directory"
+	"github.com/sonroyaalmerol/ldap-dav/internal/storage"
+	"github.com/sonroyaalmerol/ldap-dav/internal/storage/sqlite"
+	"github.com/sonroyaalmerol/ldap-dav/internal/webhook"
+)
+
+// staticReadOnly is a maintenance switch fixed at construction.
+type staticReadOnly bool
+
+func (s staticReadOnly) Enabled() bool { return bool(s) }
+
+// fakeDirectory serves users and their group ACLs from memory.
+type fakeDirectory struct {
+	users []*directory.User
+	acls  map[string][]directory.GroupACL
+}
+
+func (d *fakeDirectory) Close() {}
+
+func (d *fakeDirectory) BindUser(ctx context.Context, username, password string) (*directory.User, error) {
+	return nil, nil
+}
+
+// LookupUserByAttr matches value against the UID or, for "mail", the
+// addresses of the known users.
+func (d *fakeDirectory) LookupUserByAttr(ctx context.Context, attr, value string) (*directory.User, error) {
+	for _, u := range d.users {
+		if (attr == "mail" && u.HasAddress(value)) || (attr != "mail" && u.UID == value) {
+			return u, nil
+		}
+	}
+	return nil, nil
+}
+
+func (d *fakeDirectory) UserGroupsACL(ctx context.Context, user *directory.User) ([]directory.GroupACL, error) {
+	return d.acls[user.UID], nil
+}
+
+func (d *fakeDirectory) IntrospectToken(ctx context.Context, token, url, authHeader string) (bool, string, error) {
+	return false, "", nil
+}
+
+// newTestHandlers returns handlers over a fresh SQLite store with the
+// default configuration, adjusted by configure when it is not nil.
+func newTestHandlers(t *testing.T, dir *fakeDirectory, configure func(*config.Config)) (*Handlers, *sqlite.Store) {
+	t.Helper()
+	store, err := sqlite.New(filepath.Join(t.TempDir(), "dav.db"), zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(store.Close)
+
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.HTTP.BasePath = "/dav"
+	cfg.Timezone = "UTC"
+	if configure != nil {
+		configure(cfg)
+	}
+	if dir == nil {
+		dir = &fakeDirectory{}
+	}
+	return NewHandlers(cfg, store, dir, staticReadOnly(false), common.NewSyncTokens(cfg.HTTP.SyncTokenFormat, cfg.HTTP.SyncTokenSecret), nil, zerolog.Nop()), store
+}
+
+// createCalendar stores a calendar for owner and returns it.
+func createCalendar(t *testing.T, store storage.Store, owner, uri string) *storage.Calendar {
+	t.Helper()
+	if err := store.CreateCalendar(storage.Calendar{OwnerUserID: owner, URI: uri}, "", ""); err != nil {
+		t.Fatal(err)
+	}
+	cal, err := store.GetCalendarByURI(context.Background(), uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cal
+}
+
+// recordWebhooks points h's notifier at a test endpoint. The returned
+// function drains the notifier and returns the events it delivered, in order.
+func recordWebhooks(t *testing.T, h *Handlers) func() []webhook.Event {
+	t.Helper()
+	var mu sync.Mutex
+	var events []webhook.Event
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ev webhook.Event
+		if err := json.NewDecoder(r.Body).Decode(&ev); err != nil {
+			t.Error(err)
+			return
+		}
+		mu.Lock()
+		events = append(events, ev)
+		mu.Unlock()
+	}))
+	t.Cleanup(srv.Close)
+	h.notifier = webhook.New(config.WebhookConfig{URLs: []string{srv.URL}, Timeout: time.Second, Workers: 1, QueueSize: 10}, zerolog.Nop())
+	return func() []webhook.Event {
+		if err := h.notifier.Close(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		return events
+	}
+}
+
+// serve runs a request as user through the handler for its method.
+func serve(h *Handlers, user, method, path, body string, header http.Header) *httptest.ResponseRecorder {
+	var rd io.Reader
+	if body != "" {
+		rd = strings.NewReader(body)
+	}
+	r := httptest.NewRequest(method, path, rd)
+	for k, v := range header {
+		r.Header[k] = v
+	}
+	r = r.WithContext(auth.WithPrincipal(r.Context(), &auth.Principal{UserID: user}))
+	w := httptest.NewRecorder()
+	switch method {
+	case http.MethodGet:
+		h.HandleGet(w, r)
+	case http.MethodPut:
+		h.HandlePut(w, r)
+	case http.MethodPost:
+		h.HandlePost(w, r)
+	case http.MethodDelete:
+		h.HandleDelete(w, r)
+	case "PROPPATCH":
+		h.HandleProppatch(w, r)
+	case "REPORT":
+		h.HandleReport(w, r)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+	return w
+}
+
+// vevent wraps the given VEVENT properties, CRLF-separated, in a calendar.
+func vevent(props ...string) string {
+	return "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//test//EN\r\nBEGIN:VEVENT\r\n" +
+		strings.Join(props, "\r\n") + "\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"
+}
+
+func TestPutIfMatchAny(t *testing.T) {
+	h, store := newTestHandlers(t, nil, nil)
+	createCalendar(t, store, "alice", "work")
+	ifMatchAny := http.Header{"If-Match": {"*"}}
+	body := vevent("UID:standup", "DTSTAMP:20260101T090000Z", "DTSTART:20260105T100000Z", "DTEND:20260105T103000Z", "SUMMARY:Standup")
+
+	if w := serve(h, "alice", http.MethodPut, "/dav/calendars/alice/work/standup.ics", body, ifMatchAny); w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("PUT If-Match: * on a missing object = %d, want 412", w.Code)
+	}
+	if w := serve(h, "alice", http.MethodPut, "/dav/calendars/alice/work/standup.ics", body, nil); w.Code != http.StatusCreated {
+		t.Fatalf("PUT = %d, want 201: %s", w.Code, w.Body)
+	}
+
+	h.cfg.HTTP.RequireIfMatch = true
+	changed := strings.Replace(body, "SUMMARY:Standup", "SUMMARY:Daily standup", 1)
+	if w := serve(h, "alice", http.MethodPut, "/dav/calendars/alice/work/standup.ics", changed, nil); w.Code != http.StatusConflict {
+		t.Fatalf("blind PUT with HTTP_REQUIRE_IF_MATCH = %d, want 409", w.Code)
+	}
+	if w := serve(h, "alice", http.MethodPut, "/dav/calendars/alice/work/standup.ics", changed, ifMatchAny); w.Code != http.StatusNoContent {
+		t.Fatalf("PUT If-Match: * on an existing object = %d, want 204: %s", w.Code, w.Body)
+	}
+}
+
+func TestPostUIDConflictNeedsBind(t *testing.T) {
+	dir := &fakeDirectory{acls: map[string][]directory.GroupACL{
+		"bob":   {{CalendarID: "work", Read: true, Bind: true, WriteContent: true}},
+		"carol": {{CalendarID: "work", Read: true}},
+	}}
+	h, store := newTestHandlers(t, dir, nil)
+	createCalendar(t, store, "alice", "work")
+	body := vevent("UID:standup", "DTSTAMP:20260101T090000Z", "DTSTART:20260105T100000Z", "DTEND:20260105T103000Z")
+	if w := serve(h, "alice", http.MethodPut, "/dav/calendars/alice/work/standup.ics", body, nil); w.Code != http.StatusCreated {
+		t.Fatalf("PUT = %d, want 201: %s", w.Code, w.Body)
+	}
+
+	w := serve(h, "bob", http.MethodPost, "/dav/calendars/alice/work/", body, nil)
+	if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "no-uid-conflict") {
+		t.Errorf("POST by a writer = %d %q, want 403 with no-uid-conflict", w.Code, w.Body)
+	}
+	for _, uid := range []string{"standup", "missing"} {
+		w := serve(h, "carol", http.MethodPost, "/dav/calendars/alice/work/", strings.Replace(body, "UID:standup", "UID:"+uid, 1), nil)
+		if w.Code != http.StatusForbidden || strings.Contains(w.Body.String(), "no-uid-conflict") {
+			t.Errorf("POST of %s by a reader = %d %q, want a plain 403", uid, w.Code, w.Body)
+		}
+	}
+}
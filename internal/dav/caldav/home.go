@@ -0,0 +1,243 @@
+package caldav
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sonroyaalmerol/ldap-dav/internal/dav/common"
+	"github.com/sonroyaalmerol/ldap-dav/internal/storage"
+)
+
+// homePropertyPrefix keys the dead properties of a calendar home, which has
+// no stored collection of its own.
+const homePropertyPrefix = "calendar-home:"
+
+func homeResourceID(owner string) string {
+	return homePropertyPrefix + owner
+}
+
+// mountPropertyPrefix keys the properties a sharee keeps on their mount of
+// someone else's calendar, such as a display name of their own choosing.
+const mountPropertyPrefix = "calendar-mount:"
+
+// MountResourceID returns the key of the properties sharee keeps on their
+// mount of the calendar calendarID.
+func MountResourceID(sharee, calendarID string) string {
+	return mountPropertyPrefix + sharee + ":" + calendarID
+}
+
+// ParseMountResourceID splits a key built by MountResourceID into the
+// sharee and the calendar ID. It reports false for any other key.
+func ParseMountResourceID(id string) (sharee, calendarID string, ok bool) {
+	rest, ok := strings.CutPrefix(id, mountPropertyPrefix)
+	if !ok {
+		return "", "", false
+	}
+	i := strings.LastIndex(rest, ":")
+	if i < 0 {
+		return "", "", false
+	}
+	return rest[:i], rest[i+1:], true
+}
+
+// personalDeletedPrefix keys the marker left when an owner deletes their
+// personal calendar under CALDAV_PERSONAL_DELETE=allow, so it is not
+// provisioned again on the next home PROPFIND.
+const personalDeletedPrefix = "personal-calendar-deleted:"
+
+const personalDeletedSpace = "urn:ldap-dav:internal"
+
+// personalCalendarDeleted reports whether the owner deleted their personal
+// calendar and asked, through the allow policy, not to get it back.
+func (h *Handlers) personalCalendarDeleted(ctx context.Context, owner string) bool {
+	if h.cfg.CalDAV.PersonalDelete != "allow" {
+		return false
+	}
+	props, err := h.store.ListDeadProperties(ctx, personalDeletedPrefix+owner)
+	return err == nil && len(props) > 0
+}
+
+// markPersonalCalendarDeleted records that the owner's personal calendar
+// was deleted on purpose.
+func (h *Handlers) markPersonalCalendarDeleted(ctx context.Context, owner string) {
+	err := h.store.SetDeadProperty(ctx, storage.DeadProperty{
+		ResourceID: personalDeletedPrefix + owner,
+		Space:      personalDeletedSpace,
+		Local:      "deleted",
+		Value:      time.Now().UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		h.logger.Error().Ctx(ctx).Err(err).
+			Str("owner", owner).
+			Msg("failed to record deletion of personal calendar")
+	}
+}
+
+// sortCalendars puts calendars in the order a home lists them. With
+// CALDAV_CALENDAR_ORDER=order, calendars carrying Apple's calendar-order
+// property come first, lowest first; the rest follow by display name, as
+// every calendar does with name. The URI breaks ties, so the order holds
+// from one request to the next whatever order storage returned. nameOf
+// gives the display name a calendar is listed under.
+func (h *Handlers) sortCalendars(ctx context.Context, cals []*storage.Calendar, nameOf func(*storage.Calendar) string) {
+	type key struct {
+		order    int
+		hasOrder bool
+		name     string
+	}
+	keys := make(map[*storage.Calendar]key, len(cals))
+	for _, cal := range cals {
+		k := key{name: strings.ToLower(nameOf(cal))}
+		if h.cfg.CalDAV.CalendarOrder == "order" {
+			k.order, k.hasOrder = h.calendarOrder(ctx, cal.ID)
+		}
+		keys[cal] = k
+	}
+	sort.SliceStable(cals, func(i, j int) bool {
+		a, b := keys[cals[i]], keys[cals[j]]
+		if a.hasOrder != b.hasOrder {
+			return a.hasOrder
+		}
+		if a.hasOrder && a.order != b.order {
+			return a.order < b.order
+		}
+		if a.name != b.name {
+			return a.name < b.name
+		}
+		return cals[i].URI < cals[j].URI
+	})
+}
+
+// calendarOrder returns the calendar-order dead property of a calendar.
+func (h *Handlers) calendarOrder(ctx context.Context, calendarID string) (int, bool) {
+	props, err := h.store.ListDeadProperties(ctx, calendarID)
+	if err != nil {
+		return 0, false
+	}
+	for _, p := range props {
+		if p.Space != common.NSApple || p.Local != "calendar-order" {
+			continue
+		}
+		var v struct {
+			Text string `xml:",chardata"`
+		}
+		if err := xml.Unmarshal([]byte(p.Value), &v); err != nil {
+			return 0, false
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(v.Text))
+		return n, err == nil
+	}
+	return 0, false
+}
+
+// encodeMemberValidators emits getetag and getlastmodified for a
+// collection whose only state is the set of calendars below it. The ETag
+// hashes each member's URI and CTag, so adding, removing or changing a
+// calendar changes it.
+func encodeMemberValidators(resp *common.Response, members []*storage.Calendar) {
+	keys := make([]string, 0, len(members))
+	var modified time.Time
+	for _, cc := range members {
+		keys = append(keys, cc.OwnerUserID+"/"+cc.URI+"\x00"+cc.CTag)
+		if cc.UpdatedAt.After(modified) {
+			modified = cc.UpdatedAt
+		}
+	}
+	sort.Strings(keys)
+	sum := sha256.New()
+	for _, k := range keys {
+		sum.Write([]byte(k))
+		sum.Write([]byte{'\n'})
+	}
+	_ = resp.EncodeProp(http.StatusOK, common.GetETag{ETag: common.ETag(hex.EncodeToString(sum.Sum(nil)[:16]))})
+	if !modified.IsZero() {
+		_ = resp.EncodeProp(http.StatusOK, common.GetLastModified{LastModified: common.TimeText(modified.UTC())})
+	}
+}
+
+// proppatchStored stores properties set on a resource without a stored row,
+// such as the calendar home or a principal. DAV:displayname is settable
+// there; anything else outside the DAV: namespace is kept as a dead
+// property.
+func (h *Handlers) proppatchStored(w http.ResponseWriter, r *http.Request, owner, resourceID string) {
+	pr := common.MustPrincipal(r.Context())
+	if pr.UserID != owner {
+		h.logger.Debug().Ctx(r.Context()).
+			Str("user", pr.UserID).
+			Str("owner", owner).
+			Msg("PROPPATCH forbidden - user mismatch")
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	maxBody := h.cfg.HTTP.MaxProppatchBytes
+	if common.ExceedsLimit(r, maxBody) {
+		common.ServeTooLarge(w, maxBody)
+		return
+	}
+	body, err := common.ReadBody(r, maxBody)
+	if errors.Is(err, common.ErrBodyTooLarge) {
+		common.ServeTooLarge(w, maxBody)
+		return
+	}
+	if err != nil {
+		h.logger.Error().Ctx(r.Context()).Err(err).Msg("failed to read PROPPATCH body")
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	type setRemove struct {
+		Prop common.Prop `xml:"DAV: prop"`
+	}
+	var req struct {
+		XMLName xml.Name   `xml:"DAV: propertyupdate"`
+		Set     *setRemove `xml:"DAV: set"`
+		Remove  *setRemove `xml:"DAV: remove"`
+	}
+	if err := xml.Unmarshal(body, &req); err != nil {
+		h.logger.Debug().Ctx(r.Context()).Err(err).Msg("failed to unmarshal PROPPATCH XML")
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	resp := common.Response{Hrefs: []common.Href{{Value: r.URL.Path}}}
+
+	var set, remove []common.RawXMLValue
+	if req.Set != nil {
+		set = req.Set.Prop.Raw
+	}
+	if req.Remove != nil {
+		remove = req.Remove.Prop.Raw
+	}
+
+	isDisplayName := func(name xml.Name) bool { return name == common.DisplayNameProp }
+	patchName := func(props []common.RawXMLValue, removing bool) {
+		for _, raw := range props {
+			if name, ok := raw.Name(); !ok || !isDisplayName(name) {
+				continue
+			}
+			if err := common.PatchDisplayName(r.Context(), h.store, resourceID, raw, removing, &resp); err != nil {
+				h.logger.Error().Ctx(r.Context()).Err(err).Str("owner", owner).Msg("failed to update display name")
+			}
+		}
+	}
+	patchName(set, false)
+	patchName(remove, true)
+
+	if err := common.PatchDeadProperties(r.Context(), h.store, resourceID, set, remove, isDisplayName, &resp); err != nil {
+		h.logger.Error().Ctx(r.Context()).Err(err).Str("owner", owner).Msg("failed to update dead properties")
+	}
+
+	ms := common.MultiStatus{Responses: []common.Response{resp}}
+	if err := common.ServeMultiStatus(w, &ms); err != nil {
+		h.logger.Error().Ctx(r.Context()).Err(err).Msg("failed to serve MultiStatus for PROPPATCH")
+	}
+}
@@ -1,8 +1,10 @@
 package caldav
 
 import (
+	"bytes"
 	"context"
 	"encoding/xml"
+	"errors"
 	"io"
 	"net/http"
 	"path/filepath"
@@ -20,14 +22,18 @@ func (h *Handlers) GetCapabilities() string {
 }
 
 func (h *Handlers) HandleHead(w http.ResponseWriter, r *http.Request) {
-	hrw := &headResponseWriter{ResponseWriter: w}
+	hrw := &common.HeadResponseWriter{ResponseWriter: w}
 	h.HandleGet(hrw, r)
 }
 
 func (h *Handlers) HandleGet(w http.ResponseWriter, r *http.Request) {
+	if uid, ok := common.ParsePrincipalFreeBusyPath(h.basePath, h.cfg.HTTP.PrincipalLayout, r.URL.Path); ok {
+		h.handleFreeBusyGet(w, r, uid)
+		return
+	}
 	owner, calURI, rest := splitResourcePath(r.URL.Path, h.basePath)
 	if owner == "" || len(rest) == 0 {
-		h.logger.Debug().Str("path", r.URL.Path).Msg("GET request with invalid path")
+		h.logger.Debug().Ctx(r.Context()).Str("path", r.URL.Path).Msg("GET request with invalid path")
 		http.NotFound(w, r)
 		return
 	}
@@ -35,7 +41,7 @@ func (h *Handlers) HandleGet(w http.ResponseWriter, r *http.Request) {
 	uid := strings.TrimSuffix(filename, filepath.Ext(filename))
 
 	if !common.SafeSegment(calURI) || !common.SafeSegment(uid) {
-		h.logger.Error().
+		h.logger.Error().Ctx(r.Context()).
 			Str("calendar", calURI).
 			Str("uid", uid).
 			Msg("GET request with unsafe path segments")
@@ -45,7 +51,7 @@ func (h *Handlers) HandleGet(w http.ResponseWriter, r *http.Request) {
 
 	calendarID, calOwner, err := h.resolveCalendar(r.Context(), owner, calURI)
 	if err != nil {
-		h.logger.Error().Err(err).
+		h.logger.Error().Ctx(r.Context()).Err(err).
 			Str("owner", owner).
 			Str("calendar", calURI).
 			Msg("failed to resolve calendar in GET")
@@ -57,7 +63,7 @@ func (h *Handlers) HandleGet(w http.ResponseWriter, r *http.Request) {
 	if pr.UserID != calOwner {
 		eff, err := h.aclProv.Effective(r.Context(), &directory.User{UID: pr.UserID, DN: pr.UserDN, DisplayName: pr.Display}, calURI)
 		if err != nil {
-			h.logger.Error().Err(err).
+			h.logger.Error().Ctx(r.Context()).Err(err).
 				Str("user", pr.UserID).
 				Str("calendar", calURI).
 				Msg("ACL check failed in GET")
@@ -65,7 +71,7 @@ func (h *Handlers) HandleGet(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		if !eff.Read {
-			h.logger.Debug().
+			h.logger.Debug().Ctx(r.Context()).
 				Str("user", pr.UserID).
 				Str("calendar", calURI).
 				Msg("insufficient DAV:read privileges for GET")
@@ -74,9 +80,9 @@ func (h *Handlers) HandleGet(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	obj, err := h.store.GetObject(r.Context(), calendarID, uid)
+	obj, err := h.getObject(r.Context(), calendarID, uid)
 	if err != nil {
-		h.logger.Error().Err(err).
+		h.logger.Error().Ctx(r.Context()).Err(err).
 			Str("calendarID", calendarID).
 			Str("uid", uid).
 			Msg("failed to get object in GET")
@@ -92,29 +98,66 @@ func (h *Handlers) HandleGet(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
 	w.Header().Set("ETag", `"`+obj.ETag+`"`)
+	if obj.ScheduleTag != "" {
+		w.Header().Set("Schedule-Tag", `"`+obj.ScheduleTag+`"`)
+	}
 	if !obj.UpdatedAt.IsZero() {
 		w.Header().Set("Last-Modified", obj.UpdatedAt.UTC().Format(time.RFC1123))
 	}
-	_, _ = io.WriteString(w, obj.Data)
+	common.WriteBody(w, h.canonicalObject(obj))
+}
+
+// stampCalendar applies ICS_PRODID_POLICY and ICS_ENFORCE_VERSION to an
+// object about to be stored, reporting whether that changed it.
+func (h *Handlers) stampCalendar(ics []byte) ([]byte, bool) {
+	var prodID string
+	switch h.cfg.ICS.ProdIDPolicy {
+	case "add", "replace":
+		prodID = h.cfg.ICS.BuildProdID()
+	default:
+		if !h.cfg.ICS.EnforceVersion {
+			return ics, false
+		}
+	}
+	return ical.StampCalendar(ics, prodID, h.cfg.ICS.ProdIDPolicy == "replace", h.cfg.ICS.EnforceVersion)
+}
+
+// canonicalData serves calendar data as a single VCALENDAR with CRLF-folded
+// lines, whatever form it was stored in, unless HTTP_CANONICAL_GET is off.
+// GET and REPORT both go through it so they return the same bytes.
+func (h *Handlers) canonicalData(data string) string {
+	if !h.cfg.HTTP.CanonicalGET {
+		return data
+	}
+	return common.FoldContentLines(string(ical.SingleCalendar([]byte(data), h.cfg.ICS.BuildProdID())))
+}
+
+// canonicalObject is canonicalData for a whole stored object, reused until
+// the object's ETag changes.
+func (h *Handlers) canonicalObject(o *storage.Object) string {
+	if !h.cfg.HTTP.CanonicalGET {
+		return o.Data
+	}
+	return h.canonical.Get(o.CalendarID+"/"+o.UID, o.ETag, func() string { return h.canonicalData(o.Data) })
 }
 
 func (h *Handlers) HandlePut(w http.ResponseWriter, r *http.Request) {
 	owner, calURI, rest := splitResourcePath(r.URL.Path, h.basePath)
 	if owner == "" || len(rest) == 0 {
-		h.logger.Debug().Str("path", r.URL.Path).Msg("PUT request with invalid path")
+		h.logger.Debug().Ctx(r.Context()).Str("path", r.URL.Path).Msg("PUT request with invalid path")
 		http.NotFound(w, r)
 		return
 	}
 	filename := rest[len(rest)-1]
 	if !strings.HasSuffix(strings.ToLower(filename), ".ics") {
-		h.logger.Error().Str("filename", filename).Msg("PUT request with invalid filename")
+		h.logger.Error().Ctx(r.Context()).Str("filename", filename).Msg("PUT request with invalid filename")
 		http.Error(w, "bad object name", http.StatusBadRequest)
 		return
 	}
 	uid := strings.TrimSuffix(filename, filepath.Ext(filename))
 
 	if !common.SafeSegment(calURI) || !common.SafeSegment(uid) {
-		h.logger.Error().
+		h.logger.Error().Ctx(r.Context()).
 			Str("calendar", calURI).
 			Str("uid", uid).
 			Msg("PUT request with unsafe path segments")
@@ -122,13 +165,26 @@ func (h *Handlers) HandlePut(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if h.isBirthdayCalendar(calURI) {
+		h.logger.Debug().Ctx(r.Context()).Str("calendar", calURI).Msg("PUT into read-only birthday calendar")
+		http.Error(w, "calendar is read-only", http.StatusForbidden)
+		return
+	}
+
 	calendarID, calOwner, err := h.resolveCalendar(r.Context(), owner, calURI)
 	if err != nil {
-		h.logger.Error().Err(err).
+		h.logger.Error().Ctx(r.Context()).Err(err).
 			Str("owner", owner).
 			Str("calendar", calURI).
 			Msg("failed to resolve calendar in PUT")
-		http.NotFound(w, r)
+		// RFC 4918 9.7.1: a PUT whose parent collection does not exist is a conflict
+		http.Error(w, "conflict: parent collection does not exist", http.StatusConflict)
+		return
+	}
+
+	if h.isSubscribedCalendar(r.Context(), calendarID) {
+		h.logger.Debug().Ctx(r.Context()).Str("calendar", calURI).Msg("PUT into read-only subscribed calendar")
+		http.Error(w, "calendar is read-only", http.StatusForbidden)
 		return
 	}
 
@@ -139,7 +195,7 @@ func (h *Handlers) HandlePut(w http.ResponseWriter, r *http.Request) {
 	if pr.UserID != calOwner {
 		eff, err := h.aclProv.Effective(r.Context(), &directory.User{UID: pr.UserID, DN: pr.UserDN, DisplayName: pr.Display}, calURI)
 		if err != nil {
-			h.logger.Error().Err(err).
+			h.logger.Error().Ctx(r.Context()).Err(err).
 				Str("user", pr.UserID).
 				Str("calendar", calURI).
 				Msg("ACL check failed in PUT")
@@ -149,7 +205,7 @@ func (h *Handlers) HandlePut(w http.ResponseWriter, r *http.Request) {
 
 		if existing == nil {
 			if !eff.Bind {
-				h.logger.Debug().
+				h.logger.Debug().Ctx(r.Context()).
 					Str("user", pr.UserID).
 					Str("calendar", calURI).
 					Msg("insufficient DAV:bind privileges for creating new resource")
@@ -158,7 +214,7 @@ func (h *Handlers) HandlePut(w http.ResponseWriter, r *http.Request) {
 			}
 		} else {
 			if !eff.WriteContent {
-				h.logger.Debug().
+				h.logger.Debug().Ctx(r.Context()).
 					Str("user", pr.UserID).
 					Str("calendar", calURI).
 					Msg("insufficient DAV:write-content privileges for modifying existing resource")
@@ -171,19 +227,19 @@ func (h *Handlers) HandlePut(w http.ResponseWriter, r *http.Request) {
 	maxICS := h.cfg.HTTP.MaxICSBytes
 	raw, err := io.ReadAll(io.LimitReader(r.Body, maxICS+1))
 	if err != nil {
-		h.logger.Error().Err(err).Msg("failed to read PUT body")
+		h.logger.Error().Ctx(r.Context()).Err(err).Msg("failed to read PUT body")
 		http.Error(w, "bad request", http.StatusBadRequest)
 		return
 	}
 	_ = r.Body.Close()
 	if len(raw) == 0 {
-		h.logger.Error().Msg("empty body in PUT request")
+		h.logger.Error().Ctx(r.Context()).Msg("empty body in PUT request")
 		http.Error(w, "empty body", http.StatusBadRequest)
 		return
 	}
 
 	if maxICS > 0 && int64(len(raw)) > maxICS {
-		h.logger.Error().
+		h.logger.Error().Ctx(r.Context()).
 			Int("size", len(raw)).
 			Int64("max", maxICS).
 			Msg("payload too large in PUT")
@@ -191,10 +247,32 @@ func (h *Handlers) HandlePut(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	raw, err = common.ToUTF8(raw, r.Header.Get("Content-Type"))
+	if err != nil {
+		h.logger.Debug().Ctx(r.Context()).Err(err).Msg("unsupported charset in PUT")
+		http.Error(w, "unsupported charset", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	if h.lenientICS() {
+		if fixed, repaired := ical.RepairICS(raw, h.cfg.ICS.BuildProdID()); repaired {
+			h.logger.Debug().Ctx(r.Context()).Str("uid", uid).Msg("repaired malformed iCalendar in PUT")
+			raw = fixed
+		}
+	}
+
 	compType, err := ical.DetectICSComponent(raw)
+	if errors.Is(err, ical.ErrUnsupportedComponent) {
+		h.logger.Debug().Ctx(r.Context()).Str("uid", uid).Msg("unsupported calendar component in PUT")
+		_ = common.ServeError(w, http.StatusForbidden, common.CalendarComponentUnsupported{})
+		return
+	}
 	if err != nil {
-		h.logger.Error().Err(err).Msg("unsupported calendar component in PUT")
-		http.Error(w, "unsupported calendar component", http.StatusUnsupportedMediaType)
+		h.logger.Debug().Ctx(r.Context()).Err(err).
+			Str("uid", uid).
+			Str("content_type", r.Header.Get("Content-Type")).
+			Msg("body is not iCalendar data in PUT")
+		_ = common.ServeError(w, http.StatusUnsupportedMediaType, common.CalendarDataUnsupported{})
 		return
 	}
 
@@ -204,21 +282,65 @@ func (h *Handlers) HandlePut(w http.ResponseWriter, r *http.Request) {
 
 	ics, err := ical.NormalizeICS(raw)
 	if err != nil {
-		h.logger.Error().Err(err).Bytes("raw_ics", raw).Msg("normalize ics failed")
+		h.logger.Error().Ctx(r.Context()).Err(err).Bytes("raw_ics", raw).Msg("normalize ics failed")
 		http.Error(w, "invalid ical", http.StatusBadRequest)
 		return
 	}
+	// RFC 4791 section 5.3.4: no ETag is returned when the stored object
+	// differs from what the client sent, so it refetches the server copy.
+	ics, rewritten := h.stampCalendar(ics)
 
-	wantNew := r.Header.Get("If-None-Match") == "*"
+	if limit := h.cfg.CalDAV.MaxAttendees; ical.MaxAttendees(ics) > limit {
+		h.logger.Debug().Ctx(r.Context()).Str("uid", uid).Int("max", limit).Msg("too many attendees in PUT")
+		_ = common.ServeError(w, http.StatusForbidden, common.MaxAttendeesExceeded{})
+		return
+	}
+	if limit := h.cfg.CalDAV.MaxInstances; ical.CountInstances(ics, limit) > limit {
+		h.logger.Debug().Ctx(r.Context()).Str("uid", uid).Int("max", limit).Msg("too many instances in PUT")
+		_ = common.ServeError(w, http.StatusForbidden, common.MaxInstancesExceeded{})
+		return
+	}
+	if name, err := h.validate(ics); err != nil {
+		h.logger.Debug().Ctx(r.Context()).Err(err).Str("uid", uid).Str("validator", name).Msg("object rejected by validator in PUT")
+		_ = common.ServeError(w, http.StatusForbidden, common.ValidCalendarObjectResource{},
+			common.ValidationFailed{Validator: name, Reason: err.Error()})
+		return
+	}
+
+	wantNew := common.NoOverwrite(r)
 	match := common.TrimQuotes(r.Header.Get("If-Match"))
+	scheduleMatch := common.TrimQuotes(r.Header.Get("If-Schedule-Tag-Match"))
 
 	if wantNew && existing != nil {
-		h.logger.Debug().Str("uid", uid).Msg("precondition failed - object exists")
+		h.logger.Debug().Ctx(r.Context()).Str("uid", uid).Msg("precondition failed - object exists")
 		http.Error(w, "precondition failed", http.StatusPreconditionFailed)
 		return
 	}
-	if match != "" && existing != nil && existing.ETag != match {
-		h.logger.Debug().
+	// For scheduling objects If-Schedule-Tag-Match takes precedence over
+	// If-Match, so attendee replies don't fail on unrelated ETag changes. A
+	// missing object or one without a schedule tag never matches (RFC 6638
+	// section 3.2.10).
+	if scheduleMatch != "" {
+		actual := ""
+		if existing != nil {
+			actual = existing.ScheduleTag
+		}
+		if actual != scheduleMatch {
+			h.logger.Debug().Ctx(r.Context()).
+				Str("uid", uid).
+				Str("expected_schedule_tag", scheduleMatch).
+				Str("actual_schedule_tag", actual).
+				Msg("precondition failed - schedule-tag mismatch")
+			http.Error(w, "precondition failed", http.StatusPreconditionFailed)
+			return
+		}
+	} else if match == "*" && existing == nil {
+		// If-Match: * only asks that the object exists (RFC 9110 section 13.1.1).
+		h.logger.Debug().Ctx(r.Context()).Str("uid", uid).Msg("precondition failed - object does not exist")
+		http.Error(w, "precondition failed", http.StatusPreconditionFailed)
+		return
+	} else if match != "" && match != "*" && existing != nil && existing.ETag != match {
+		h.logger.Debug().Ctx(r.Context()).
 			Str("uid", uid).
 			Str("expected_etag", match).
 			Str("actual_etag", existing.ETag).
@@ -227,14 +349,50 @@ func (h *Handlers) HandlePut(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if h.cfg.HTTP.RequireIfMatch && existing != nil && existing.Data != string(ics) && common.BlindOverwrite(r) {
+		h.logger.Debug().Ctx(r.Context()).Str("uid", uid).Msg("conflict - blind overwrite of a different object")
+		http.Error(w, "conflict: If-Match required to replace this object", http.StatusConflict)
+		return
+	}
+
+	// Replies to the organizer and resource bookings are only delivered
+	// once this object is stored, so a failed PUT leaves no trace elsewhere.
+	var decliner *directory.User
+	var bookings *resourceBookings
+	if compType == "VEVENT" {
+		var declined []byte
+		declined, decliner = h.autoDecline(r, calOwner, uid, ics)
+		rewritten = rewritten || !bytes.Equal(declined, ics)
+		ics = declined
+		var old []byte
+		if existing != nil {
+			old = []byte(existing.Data)
+		}
+		var booked []byte
+		booked, bookings = h.bookResources(r, calOwner, uid, old, ics)
+		defer bookings.release()
+		rewritten = rewritten || !bytes.Equal(booked, ics)
+		ics = booked
+	}
+
 	obj := &storage.Object{
 		CalendarID: calendarID,
 		UID:        uid,
 		Data:       string(ics),
 		Component:  compType,
 	}
+	if compType == "VEVENT" {
+		obj.StartAt, obj.EndAt, obj.HasRecurrence = ical.EventWindow(ics)
+	}
+	if ical.IsSchedulingObject(ics) {
+		if existing != nil {
+			obj.ScheduleTag = nextScheduleTag([]byte(existing.Data), existing.ScheduleTag, ics)
+		} else {
+			obj.ScheduleTag = nextScheduleTag(nil, "", ics)
+		}
+	}
 	if err := h.store.PutObject(r.Context(), obj); err != nil {
-		h.logger.Error().Err(err).
+		h.logger.Error().Ctx(r.Context()).Err(err).
 			Str("calendarID", calendarID).
 			Str("uid", uid).
 			Msg("PutObject failed")
@@ -243,13 +401,24 @@ func (h *Handlers) HandlePut(w http.ResponseWriter, r *http.Request) {
 	}
 	_, _, err = h.store.RecordChange(r.Context(), calendarID, uid, false)
 	if err != nil {
-		h.logger.Error().Err(err).
+		h.logger.Error().Ctx(r.Context()).Err(err).
 			Str("calendarID", calendarID).
 			Str("uid", uid).
 			Msg("RecordChange failed")
 	}
+	if decliner != nil {
+		h.replyToOrganizer(r.Context(), decliner, uid, ics)
+	}
+	if h.applyBookings(r.Context(), calendarID, bookings, ics) {
+		rewritten = true
+	}
 
-	w.Header().Set("ETag", `"`+obj.ETag+`"`)
+	if !rewritten {
+		w.Header().Set("ETag", `"`+obj.ETag+`"`)
+	}
+	if obj.ScheduleTag != "" {
+		w.Header().Set("Schedule-Tag", `"`+obj.ScheduleTag+`"`)
+	}
 	if existing == nil {
 		w.WriteHeader(http.StatusCreated)
 	} else {
@@ -257,6 +426,60 @@ func (h *Handlers) HandlePut(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// HandlePost creates an object in a calendar under a name the server picks
+// (RFC 5995), the object's UID where possible. The object then goes through
+// the same checks as a PUT.
+func (h *Handlers) HandlePost(w http.ResponseWriter, r *http.Request) {
+	owner, calURI, rest := splitResourcePath(r.URL.Path, h.basePath)
+	if owner == "" || calURI == "" || len(rest) > 0 {
+		w.Header().Set("Allow", strings.Join(common.AllowedMethods(h.basePath, h.cfg.HTTP.PrincipalLayout, h.cfg.CalDAV.SchedulingCollections, r.URL.Path), ", "))
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	calendarID, calOwner, err := h.resolveCalendar(r.Context(), owner, calURI)
+	if err != nil {
+		h.logger.Debug().Ctx(r.Context()).Err(err).
+			Str("owner", owner).
+			Str("calendar", calURI).
+			Msg("failed to resolve calendar in POST")
+		http.NotFound(w, r)
+		return
+	}
+
+	// POST always adds a member, so check DAV:bind before looking at the
+	// UID; otherwise the conflict would tell anyone whether a UID exists.
+	pr := common.MustPrincipal(r.Context())
+	if pr.UserID != calOwner {
+		eff, err := h.aclProv.Effective(r.Context(), &directory.User{UID: pr.UserID, DN: pr.UserDN, DisplayName: pr.Display}, calURI)
+		if err != nil || !eff.Bind {
+			h.logger.Debug().Ctx(r.Context()).Err(err).
+				Str("user", pr.UserID).
+				Str("calendar", calURI).
+				Msg("insufficient DAV:bind privileges for POST")
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+	}
+
+	raw, err := io.ReadAll(io.LimitReader(r.Body, h.cfg.HTTP.MaxICSBytes+1))
+	if err != nil {
+		h.logger.Error().Ctx(r.Context()).Err(err).Msg("failed to read POST body")
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	_ = r.Body.Close()
+
+	name := common.MemberName(ical.ObjectUID(raw))
+	href := strings.TrimSuffix(r.URL.Path, "/") + "/" + name + ".ics"
+	if existing, _ := h.store.GetObject(r.Context(), calendarID, name); existing != nil {
+		h.logger.Debug().Ctx(r.Context()).Str("uid", name).Str("calendar", calURI).Msg("UID already in use in POST")
+		_ = common.ServeError(w, http.StatusForbidden, common.NoUIDConflict{Href: common.Href{Value: common.EscapedHref(href)}})
+		return
+	}
+	common.PostToCollection(w, r, href, raw, h.HandlePut)
+}
+
 func (h *Handlers) HandleDelete(w http.ResponseWriter, r *http.Request) {
 	pr := common.MustPrincipal(r.Context())
 	owner, calURI, rest := splitResourcePath(r.URL.Path, h.basePath)
@@ -268,7 +491,7 @@ func (h *Handlers) HandleDelete(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if owner == "" || calURI == "" {
-		h.logger.Error().
+		h.logger.Error().Ctx(r.Context()).
 			Str("path", r.URL.Path).
 			Str("owner", owner).
 			Str("calendar", calURI).
@@ -277,15 +500,33 @@ func (h *Handlers) HandleDelete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if h.isBirthdayCalendar(calURI) {
+		h.logger.Debug().Ctx(r.Context()).Str("calendar", calURI).Msg("DELETE in read-only birthday calendar")
+		http.Error(w, "calendar is read-only", http.StatusForbidden)
+		return
+	}
+
+	if h.isSchedulingCollection(calURI) && len(rest) == 0 {
+		h.logger.Debug().Ctx(r.Context()).Str("calendar", calURI).Msg("DELETE of reserved scheduling collection")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if herr := common.ValidateDeleteDepth(r.Header.Get("Depth"), len(rest) == 0); herr != nil {
+		h.logger.Debug().Ctx(r.Context()).Err(herr).Str("path", r.URL.Path).Msg("rejecting DELETE")
+		http.Error(w, herr.Error(), herr.Code)
+		return
+	}
+
 	if len(rest) == 0 {
 		if !common.SafeCollectionName(calURI) {
-			h.logger.Error().Str("calendar", calURI).Msg("unsafe collection name in DELETE")
+			h.logger.Error().Ctx(r.Context()).Str("calendar", calURI).Msg("unsafe collection name in DELETE")
 			http.Error(w, "bad collection name", http.StatusBadRequest)
 			return
 		}
 
 		if pr.UserID != owner {
-			h.logger.Debug().
+			h.logger.Debug().Ctx(r.Context()).
 				Str("user", pr.UserID).
 				Str("calendar", calURI).
 				Msg("insufficient privileges for DELETE calendar")
@@ -293,14 +534,27 @@ func (h *Handlers) HandleDelete(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		personal := calURI == personalCalendarURI(owner)
+		if personal && h.cfg.CalDAV.PersonalDelete == "forbid" {
+			h.logger.Debug().Ctx(r.Context()).
+				Str("owner", owner).
+				Str("calendar", calURI).
+				Msg("DELETE of personal calendar forbidden")
+			http.Error(w, "the personal calendar cannot be deleted", http.StatusForbidden)
+			return
+		}
+
 		if err := h.store.DeleteCalendar(owner, calURI); err != nil {
-			h.logger.Error().Err(err).
+			h.logger.Error().Ctx(r.Context()).Err(err).
 				Str("owner", owner).
 				Str("calendar", calURI).
 				Msg("failed to delete calendar")
 			http.Error(w, "storage error", http.StatusInternalServerError)
 			return
 		}
+		if personal && h.cfg.CalDAV.PersonalDelete == "allow" {
+			h.markPersonalCalendarDeleted(r.Context(), owner)
+		}
 		w.WriteHeader(http.StatusNoContent)
 		return
 	}
@@ -309,7 +563,7 @@ func (h *Handlers) HandleDelete(w http.ResponseWriter, r *http.Request) {
 	uid := strings.TrimSuffix(filename, filepath.Ext(filename))
 
 	if !common.SafeSegment(calURI) || !common.SafeSegment(uid) {
-		h.logger.Error().
+		h.logger.Error().Ctx(r.Context()).
 			Str("calendar", calURI).
 			Str("uid", uid).
 			Msg("unsafe path segments in DELETE object")
@@ -319,7 +573,7 @@ func (h *Handlers) HandleDelete(w http.ResponseWriter, r *http.Request) {
 
 	calendarID, calOwner, err := h.resolveCalendar(r.Context(), owner, calURI)
 	if err != nil {
-		h.logger.Error().Err(err).
+		h.logger.Error().Ctx(r.Context()).Err(err).
 			Str("owner", owner).
 			Str("calendar", calURI).
 			Msg("failed to resolve calendar in DELETE")
@@ -327,10 +581,16 @@ func (h *Handlers) HandleDelete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if h.isSubscribedCalendar(r.Context(), calendarID) {
+		h.logger.Debug().Ctx(r.Context()).Str("calendar", calURI).Msg("DELETE in read-only subscribed calendar")
+		http.Error(w, "calendar is read-only", http.StatusForbidden)
+		return
+	}
+
 	if pr.UserID != calOwner {
 		eff, err := h.aclProv.Effective(r.Context(), &directory.User{UID: pr.UserID, DN: pr.UserDN, DisplayName: pr.Display}, calURI)
 		if err != nil {
-			h.logger.Error().Err(err).
+			h.logger.Error().Ctx(r.Context()).Err(err).
 				Str("user", pr.UserID).
 				Str("calendar", calURI).
 				Msg("ACL check failed in DELETE object")
@@ -338,7 +598,7 @@ func (h *Handlers) HandleDelete(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		if !eff.Unbind {
-			h.logger.Debug().
+			h.logger.Debug().Ctx(r.Context()).
 				Str("user", pr.UserID).
 				Str("calendar", calURI).
 				Msg("insufficient DAV:unbind privileges for DELETE object")
@@ -347,9 +607,24 @@ func (h *Handlers) HandleDelete(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	existing, _ := h.store.GetObject(r.Context(), calendarID, uid)
 	match := common.TrimQuotes(r.Header.Get("If-Match"))
+	if match != "" {
+		// If-Match: * only asks that the object exists (RFC 9110 section 13.1.1).
+		if existing == nil || (match != "*" && existing.ETag != match) {
+			h.logger.Debug().Ctx(r.Context()).
+				Str("uid", uid).
+				Str("expected_etag", match).
+				Msg("precondition failed in DELETE object")
+			http.Error(w, "precondition failed", http.StatusPreconditionFailed)
+			return
+		}
+		if match == "*" {
+			match = ""
+		}
+	}
 	if err := h.store.DeleteObject(r.Context(), calendarID, uid, match); err != nil {
-		h.logger.Error().Err(err).
+		h.logger.Error().Ctx(r.Context()).Err(err).
 			Str("calendarID", calendarID).
 			Str("uid", uid).
 			Msg("failed to delete object")
@@ -358,18 +633,21 @@ func (h *Handlers) HandleDelete(w http.ResponseWriter, r *http.Request) {
 	}
 	_, _, err = h.store.RecordChange(r.Context(), calendarID, uid, true)
 	if err != nil {
-		h.logger.Error().Err(err).
+		h.logger.Error().Ctx(r.Context()).Err(err).
 			Str("calendarID", calendarID).
 			Str("uid", uid).
 			Msg("RecordChange failed for DELETE")
 	}
+	if existing != nil {
+		h.releaseResources(r.Context(), calOwner, uid, []byte(existing.Data))
+	}
 	w.WriteHeader(http.StatusNoContent)
 }
 
 func (h *Handlers) calendarExists(ctx context.Context, owner, uri string) bool {
 	cal, err := h.store.GetCalendarByURI(ctx, uri)
 	if err != nil {
-		h.logger.Error().Err(err).
+		h.logger.Error().Ctx(ctx).Err(err).
 			Str("owner", owner).
 			Str("calendar", uri).
 			Msg("failed to check if calendar exists")
@@ -385,22 +663,28 @@ func (h *Handlers) HandleMkcol(w http.ResponseWriter, r *http.Request) {
 		if o2, c2, ok := tryCalendarShorthand(r.URL.Path, h.basePath, pr.UserID); ok {
 			owner, calURI, rest = o2, c2, nil
 		} else {
-			h.logger.Error().Str("path", r.URL.Path).Msg("MKCOL with invalid path")
+			h.logger.Error().Ctx(r.Context()).Str("path", r.URL.Path).Msg("MKCOL with invalid path")
 			http.Error(w, "bad path", http.StatusBadRequest)
 			return
 		}
 	}
 
 	if !common.SafeCollectionName(calURI) {
-		h.logger.Error().Str("calendar", calURI).Msg("unsafe collection name in MKCOL")
+		h.logger.Error().Ctx(r.Context()).Str("calendar", calURI).Msg("unsafe collection name in MKCOL")
 		http.Error(w, "bad collection name", http.StatusBadRequest)
 		return
 	}
 
+	if h.isSchedulingCollection(calURI) || h.isBirthdayCalendar(calURI) {
+		h.logger.Debug().Ctx(r.Context()).Str("calendar", calURI).Msg("MKCOL on reserved collection")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
 	if pr.UserID != owner {
 		eff, err := h.aclProv.Effective(r.Context(), &directory.User{UID: pr.UserID, DN: pr.UserDN, DisplayName: pr.Display}, "")
 		if err != nil {
-			h.logger.Error().Err(err).
+			h.logger.Error().Ctx(r.Context()).Err(err).
 				Str("user", pr.UserID).
 				Str("owner", owner).
 				Msg("ACL check failed in MKCOL")
@@ -408,7 +692,7 @@ func (h *Handlers) HandleMkcol(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		if !eff.Bind {
-			h.logger.Debug().
+			h.logger.Debug().Ctx(r.Context()).
 				Str("user", pr.UserID).
 				Str("owner", owner).
 				Msg("insufficient DAV:bind privileges for MKCOL")
@@ -417,20 +701,35 @@ func (h *Handlers) HandleMkcol(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	maxBody := h.cfg.HTTP.MaxMkcolBytes
+	if common.ExceedsLimit(r, maxBody) {
+		h.logger.Debug().Ctx(r.Context()).
+			Int64("content_length", r.ContentLength).
+			Int64("limit", maxBody).
+			Msg("MKCOL body too large")
+		common.ServeTooLarge(w, maxBody)
+		return
+	}
+
+	body, err := common.ReadBody(r, maxBody)
+	if errors.Is(err, common.ErrBodyTooLarge) {
+		h.logger.Debug().Ctx(r.Context()).Int64("limit", maxBody).Msg("MKCOL body too large")
+		common.ServeTooLarge(w, maxBody)
+		return
+	}
 	if err != nil {
-		h.logger.Error().Err(err).Msg("failed to read MKCOL body")
+		h.logger.Error().Ctx(r.Context()).Err(err).Msg("failed to read MKCOL body")
 		http.Error(w, "bad request", http.StatusBadRequest)
 		return
 	}
-	_ = r.Body.Close()
 
 	type mkcolProp struct {
 		XMLName      xml.Name `xml:"DAV: prop"`
 		DisplayName  *string  `xml:"DAV: displayname"`
 		Description  *string  `xml:"urn:ietf:params:xml:ns:caldav calendar-description"`
 		ResourceType struct {
-			Calendar *struct{} `xml:"urn:ietf:params:xml:ns:caldav calendar"`
+			Calendar   *struct{} `xml:"urn:ietf:params:xml:ns:caldav calendar"`
+			Subscribed *struct{} `xml:"http://calendarserver.org/ns/ subscribed"`
 		} `xml:"DAV: resourcetype"`
 		Raw []common.RawXMLValue `xml:",any"`
 	}
@@ -444,19 +743,22 @@ func (h *Handlers) HandleMkcol(w http.ResponseWriter, r *http.Request) {
 
 	if len(body) > 0 {
 		if err := xml.Unmarshal(body, &mkcolReq); err != nil {
-			h.logger.Error().Err(err).Msg("failed to unmarshal MKCOL XML")
+			h.logger.Error().Ctx(r.Context()).Err(err).Msg("failed to unmarshal MKCOL XML")
 		}
 	}
 
-	isCalendar := mkcolReq.Set != nil && mkcolReq.Set.Prop.ResourceType.Calendar != nil
+	// Apple clients create webcal subscriptions as CS:subscribed
+	// collections; they become calendars filled from their CS:source.
+	isCalendar := mkcolReq.Set != nil && (mkcolReq.Set.Prop.ResourceType.Calendar != nil ||
+		(h.cfg.CalDAV.Subscriptions && mkcolReq.Set.Prop.ResourceType.Subscribed != nil))
 	if !isCalendar {
-		h.logger.Error().Msg("MKCOL with unsupported collection type")
+		h.logger.Error().Ctx(r.Context()).Msg("MKCOL with unsupported collection type")
 		http.Error(w, "unsupported collection type", http.StatusUnsupportedMediaType)
 		return
 	}
 
 	if h.calendarExists(r.Context(), owner, calURI) {
-		h.logger.Debug().
+		h.logger.Debug().Ctx(r.Context()).
 			Str("owner", owner).
 			Str("calendar", calURI).
 			Msg("calendar already exists in MKCOL")
@@ -509,13 +811,19 @@ func (h *Handlers) HandleMkcol(w http.ResponseWriter, r *http.Request) {
 		Color:       color,
 	}
 	if err := h.store.CreateCalendar(newCal, "", description); err != nil {
-		h.logger.Error().Err(err).
+		if errors.Is(err, storage.ErrExists) {
+			// Lost a race with a concurrent request for the same URI.
+			http.Error(w, "conflict", http.StatusConflict)
+			return
+		}
+		h.logger.Error().Ctx(r.Context()).Err(err).
 			Str("owner", owner).
 			Str("calendar", calURI).
 			Msg("failed to create calendar in MKCOL")
 		http.Error(w, "storage error", http.StatusInternalServerError)
 		return
 	}
+	h.storeCreationProps(r, calURI, mkcolReq.Set.Prop.Raw)
 
 	w.WriteHeader(http.StatusCreated)
 }
@@ -527,14 +835,14 @@ func (h *Handlers) HandleMkcalendar(w http.ResponseWriter, r *http.Request) {
 		if o2, c2, ok := tryCalendarShorthand(r.URL.Path, h.basePath, pr.UserID); ok {
 			owner, calURI, rest = o2, c2, nil
 		} else {
-			h.logger.Error().Str("path", r.URL.Path).Msg("MKCALENDAR with invalid path")
+			h.logger.Error().Ctx(r.Context()).Str("path", r.URL.Path).Msg("MKCALENDAR with invalid path")
 			http.Error(w, "bad path", http.StatusBadRequest)
 			return
 		}
 	}
 
 	if pr.UserID != owner {
-		h.logger.Debug().
+		h.logger.Debug().Ctx(r.Context()).
 			Str("user", pr.UserID).
 			Str("owner", owner).
 			Msg("insufficient privileges for MKCALENDAR")
@@ -543,13 +851,19 @@ func (h *Handlers) HandleMkcalendar(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if !common.SafeCollectionName(calURI) {
-		h.logger.Error().Str("calendar", calURI).Msg("unsafe collection name in MKCALENDAR")
+		h.logger.Error().Ctx(r.Context()).Str("calendar", calURI).Msg("unsafe collection name in MKCALENDAR")
 		http.Error(w, "bad collection name", http.StatusBadRequest)
 		return
 	}
 
+	if h.isSchedulingCollection(calURI) || h.isBirthdayCalendar(calURI) {
+		h.logger.Debug().Ctx(r.Context()).Str("calendar", calURI).Msg("MKCALENDAR on reserved collection")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
 	if h.calendarExists(r.Context(), owner, calURI) {
-		h.logger.Debug().
+		h.logger.Debug().Ctx(r.Context()).
 			Str("owner", owner).
 			Str("calendar", calURI).
 			Msg("calendar already exists in MKCALENDAR")
@@ -557,13 +871,27 @@ func (h *Handlers) HandleMkcalendar(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	maxBody := h.cfg.HTTP.MaxMkcolBytes
+	if common.ExceedsLimit(r, maxBody) {
+		h.logger.Debug().Ctx(r.Context()).
+			Int64("content_length", r.ContentLength).
+			Int64("limit", maxBody).
+			Msg("MKCALENDAR body too large")
+		common.ServeTooLarge(w, maxBody)
+		return
+	}
+
+	body, err := common.ReadBody(r, maxBody)
+	if errors.Is(err, common.ErrBodyTooLarge) {
+		h.logger.Debug().Ctx(r.Context()).Int64("limit", maxBody).Msg("MKCALENDAR body too large")
+		common.ServeTooLarge(w, maxBody)
+		return
+	}
 	if err != nil {
-		h.logger.Error().Err(err).Msg("failed to read MKCALENDAR body")
+		h.logger.Error().Ctx(r.Context()).Err(err).Msg("failed to read MKCALENDAR body")
 		http.Error(w, "bad request", http.StatusBadRequest)
 		return
 	}
-	_ = r.Body.Close()
 
 	type mkcalProp struct {
 		XMLName             xml.Name             `xml:"DAV: prop"`
@@ -585,7 +913,7 @@ func (h *Handlers) HandleMkcalendar(w http.ResponseWriter, r *http.Request) {
 
 	if len(body) > 0 {
 		if err := xml.Unmarshal(body, &mkcalReq); err != nil {
-			h.logger.Error().Err(err).Msg("failed to unmarshal MKCALENDAR XML")
+			h.logger.Error().Ctx(r.Context()).Err(err).Msg("failed to unmarshal MKCALENDAR XML")
 		} else {
 			if mkcalReq.Set != nil {
 				if mkcalReq.Set.Prop.DisplayName != nil {
@@ -633,59 +961,143 @@ func (h *Handlers) HandleMkcalendar(w http.ResponseWriter, r *http.Request) {
 		Color:       color,
 	}
 	if err := h.store.CreateCalendar(newCal, "", description); err != nil {
-		h.logger.Error().Err(err).
+		if errors.Is(err, storage.ErrExists) {
+			// Lost a race with a concurrent request for the same URI.
+			http.Error(w, "conflict", http.StatusConflict)
+			return
+		}
+		h.logger.Error().Ctx(r.Context()).Err(err).
 			Str("owner", owner).
 			Str("calendar", calURI).
 			Msg("failed to create calendar in MKCALENDAR")
 		http.Error(w, "storage error", http.StatusInternalServerError)
 		return
 	}
+	if mkcalReq.Set != nil {
+		h.storeCreationProps(r, calURI, mkcalReq.Set.Prop.Raw)
+	}
 
 	w.WriteHeader(http.StatusCreated)
 }
 
+// storeCreationProps keeps the properties a MKCOL or MKCALENDAR set beyond
+// the ones stored with the calendar row, such as the CS:source of a
+// subscription, as dead properties of the new calendar.
+func (h *Handlers) storeCreationProps(r *http.Request, calURI string, raw []common.RawXMLValue) {
+	if len(raw) == 0 {
+		return
+	}
+	cal, err := h.store.GetCalendarByURI(r.Context(), calURI)
+	if err != nil || cal == nil {
+		h.logger.Error().Ctx(r.Context()).Err(err).Str("calendar", calURI).Msg("failed to load new calendar for its properties")
+		return
+	}
+	var resp common.Response
+	h.patchDeadProperties(r, cal.ID, calURI, raw, nil, &resp)
+}
+
 func (h *Handlers) HandleProppatch(w http.ResponseWriter, r *http.Request) {
+	if uid, ok := common.ParsePrincipalPath(h.basePath, h.cfg.HTTP.PrincipalLayout, r.URL.Path); ok && uid != "" {
+		h.proppatchStored(w, r, uid, common.PrincipalResourceID(uid))
+		return
+	}
+
 	owner, calURI, rest := splitResourcePath(r.URL.Path, h.basePath)
+	if owner != "" && calURI == "" && len(rest) == 0 {
+		h.proppatchStored(w, r, owner, homeResourceID(owner))
+		return
+	}
 	if owner == "" || calURI == "" || len(rest) != 0 {
-		h.logger.Error().Str("path", r.URL.Path).Msg("PROPPATCH with invalid path")
+		h.logger.Error().Ctx(r.Context()).Str("path", r.URL.Path).Msg("PROPPATCH with invalid path")
 		http.Error(w, "bad path", http.StatusBadRequest)
 		return
 	}
 
 	if !common.SafeSegment(calURI) {
-		h.logger.Error().Str("calendar", calURI).Msg("unsafe path in PROPPATCH")
+		h.logger.Error().Ctx(r.Context()).Str("calendar", calURI).Msg("unsafe path in PROPPATCH")
 		http.Error(w, "bad path", http.StatusBadRequest)
 		return
 	}
 
+	if h.isBirthdayCalendar(calURI) {
+		h.logger.Debug().Ctx(r.Context()).Str("calendar", calURI).Msg("PROPPATCH on read-only birthday calendar")
+		http.Error(w, "calendar is read-only", http.StatusForbidden)
+		return
+	}
+
+	calendarID, calOwner, err := h.resolveCalendar(r.Context(), owner, calURI)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	// On a shared mount the path names the sharee rather than the owner.
+	// The sharee's DAV:displayname is kept for them alone; any other
+	// property changes the calendar itself.
+	sharedMount := calOwner != owner
+
 	pr := common.MustPrincipal(r.Context())
-	if pr.UserID != owner {
+	if sharedMount && pr.UserID != owner {
+		h.logger.Debug().Ctx(r.Context()).
+			Str("user", pr.UserID).
+			Str("owner", owner).
+			Msg("PROPPATCH forbidden - user mismatch")
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	canWriteProps := true
+	if pr.UserID != calOwner {
 		eff, err := h.aclProv.Effective(r.Context(), &directory.User{UID: pr.UserID, DN: pr.UserDN, DisplayName: pr.Display}, calURI)
 		if err != nil {
-			h.logger.Error().Err(err).
+			h.logger.Error().Ctx(r.Context()).Err(err).
 				Str("user", pr.UserID).
 				Str("calendar", calURI).
 				Msg("ACL check failed in PROPPATCH")
 			http.Error(w, "forbidden", http.StatusForbidden)
 			return
 		}
-		if !eff.WriteProps {
-			h.logger.Debug().
+		// A mount only exists for calendars the sharee can read; without
+		// read access there is nothing to rename.
+		if !eff.CanRead() {
+			h.logger.Debug().Ctx(r.Context()).
 				Str("user", pr.UserID).
 				Str("calendar", calURI).
-				Msg("insufficient DAV:write-properties privileges for PROPPATCH")
-			http.Error(w, "forbidden", http.StatusForbidden)
+				Str("owner", calOwner).
+				Msg("ACL read denied in PROPPATCH")
+			http.NotFound(w, r)
 			return
 		}
+		canWriteProps = eff.WriteProps
+	}
+	if !canWriteProps && !sharedMount {
+		h.logger.Debug().Ctx(r.Context()).
+			Str("user", pr.UserID).
+			Str("calendar", calURI).
+			Msg("insufficient DAV:write-properties privileges for PROPPATCH")
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
 	}
 
-	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	maxBody := h.cfg.HTTP.MaxProppatchBytes
+	if common.ExceedsLimit(r, maxBody) {
+		h.logger.Debug().Ctx(r.Context()).
+			Int64("content_length", r.ContentLength).
+			Int64("limit", maxBody).
+			Msg("PROPPATCH body too large")
+		common.ServeTooLarge(w, maxBody)
+		return
+	}
+
+	body, err := common.ReadBody(r, maxBody)
+	if errors.Is(err, common.ErrBodyTooLarge) {
+		h.logger.Debug().Ctx(r.Context()).Int64("limit", maxBody).Msg("PROPPATCH body too large")
+		common.ServeTooLarge(w, maxBody)
+		return
+	}
 	if err != nil {
-		h.logger.Error().Err(err).Msg("failed to read PROPPATCH body")
+		h.logger.Error().Ctx(r.Context()).Err(err).Msg("failed to read PROPPATCH body")
 		http.Error(w, "bad request", http.StatusBadRequest)
 		return
 	}
-	_ = r.Body.Close()
 
 	type setRemoveProp struct {
 		DisplayName *string              `xml:"DAV: displayname"`
@@ -703,10 +1115,19 @@ func (h *Handlers) HandleProppatch(w http.ResponseWriter, r *http.Request) {
 
 	okXML := true
 	if err := xml.Unmarshal(body, &req); err != nil {
-		h.logger.Error().Err(err).Msg("failed to unmarshal PROPPATCH XML")
+		h.logger.Error().Ctx(r.Context()).Err(err).Msg("failed to unmarshal PROPPATCH XML")
 		okXML = false
 	}
 
+	if !canWriteProps && okXML && ((req.Set != nil && len(req.Set.Prop.Raw) > 0) || (req.Remove != nil && len(req.Remove.Prop.Raw) > 0)) {
+		h.logger.Debug().Ctx(r.Context()).
+			Str("user", pr.UserID).
+			Str("calendar", calURI).
+			Msg("insufficient DAV:write-properties privileges for PROPPATCH on shared mount")
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
 	var newName *string
 	var newColor string
 	hasColorUpdate := false
@@ -775,11 +1196,35 @@ func (h *Handlers) HandleProppatch(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	alarmUpdates := []*defaultAlarmUpdate{
+		{local: "default-alarm-vevent-datetime"},
+		{local: "default-alarm-vevent-date", allDay: true},
+	}
+	for _, a := range alarmUpdates {
+		if okXML && req.Set != nil {
+			if v, ok := findRawProp(req.Set.Prop.Raw, common.NSCS, a.local); ok {
+				a.value = v
+				a.requested = true
+			}
+		}
+		if okXML && req.Remove != nil {
+			if _, ok := findRawProp(req.Remove.Prop.Raw, common.NSCS, a.local); ok {
+				a.value = ""
+				a.requested = true
+			}
+		}
+	}
+
 	var displayNameStatus int = http.StatusOK
 
 	if newName != nil || (okXML && req.Remove != nil && req.Remove.Prop.DisplayName != nil) {
-		if err := h.store.UpdateCalendarDisplayName(r.Context(), owner, calURI, newName); err != nil {
-			h.logger.Error().Err(err).Msg("Failed to update calendar display name")
+		if sharedMount {
+			err = common.SetStoredDisplayName(r.Context(), h.store, MountResourceID(owner, calendarID), newName)
+		} else {
+			err = h.store.UpdateCalendarDisplayName(r.Context(), calOwner, calURI, newName)
+		}
+		if err != nil {
+			h.logger.Error().Ctx(r.Context()).Err(err).Msg("Failed to update calendar display name")
 			displayNameStatus = http.StatusInternalServerError
 		}
 	}
@@ -788,13 +1233,24 @@ func (h *Handlers) HandleProppatch(w http.ResponseWriter, r *http.Request) {
 		if !common.IsValidHexColor(newColor) {
 			colorStatus = http.StatusBadRequest
 		} else {
-			if err := h.store.UpdateCalendarColor(r.Context(), owner, calURI, newColor); err != nil {
-				h.logger.Error().Err(err).Msg("Failed to update calendar color")
+			if err := h.store.UpdateCalendarColor(r.Context(), calOwner, calURI, newColor); err != nil {
+				h.logger.Error().Ctx(r.Context()).Err(err).Msg("Failed to update calendar color")
 				colorStatus = http.StatusInternalServerError
 			}
 		}
 	}
 
+	for _, a := range alarmUpdates {
+		if !a.requested {
+			continue
+		}
+		a.status = http.StatusOK
+		if err := h.store.UpdateCalendarDefaultAlarm(r.Context(), calOwner, calURI, a.allDay, a.value); err != nil {
+			h.logger.Error().Ctx(r.Context()).Err(err).Str("property", a.local).Msg("Failed to update calendar default alarm")
+			a.status = http.StatusInternalServerError
+		}
+	}
+
 	resp := common.Response{
 		Hrefs: []common.Href{{Value: r.URL.Path}},
 	}
@@ -805,7 +1261,7 @@ func (h *Handlers) HandleProppatch(w http.ResponseWriter, r *http.Request) {
 			propValue = *newName
 		}
 		if err := resp.EncodeProp(displayNameStatus, common.DisplayName{Name: propValue}); err != nil {
-			h.logger.Error().Err(err).Msg("failed to encode DisplayName property in PROPPATCH")
+			h.logger.Error().Ctx(r.Context()).Err(err).Msg("failed to encode DisplayName property in PROPPATCH")
 		}
 	}
 
@@ -815,21 +1271,126 @@ func (h *Handlers) HandleProppatch(w http.ResponseWriter, r *http.Request) {
 				XMLName xml.Name `xml:"http://apple.com/ns/ical/ calendar-color"`
 				Text    string   `xml:",chardata"`
 			}{Text: newColor}); err != nil {
-				h.logger.Error().Err(err).Msg("failed to encode calendar-color property in PROPPATCH")
+				h.logger.Error().Ctx(r.Context()).Err(err).Msg("failed to encode calendar-color property in PROPPATCH")
 			}
 		} else {
 			if err := resp.EncodeProp(colorStatus, struct {
 				XMLName xml.Name `xml:"http://apple.com/ns/ical/ calendar-color"`
 			}{}); err != nil {
-				h.logger.Error().Err(err).Msg("failed to encode calendar-color error in PROPPATCH")
+				h.logger.Error().Ctx(r.Context()).Err(err).Msg("failed to encode calendar-color error in PROPPATCH")
 			}
 		}
 	}
 
+	for _, a := range alarmUpdates {
+		if !a.requested {
+			continue
+		}
+		if err := resp.EncodeProp(a.status, struct {
+			XMLName xml.Name
+		}{XMLName: xml.Name{Space: common.NSCS, Local: a.local}}); err != nil {
+			h.logger.Error().Ctx(r.Context()).Err(err).Str("property", a.local).Msg("failed to encode default alarm property in PROPPATCH")
+		}
+	}
+
+	if okXML {
+		var set, remove []common.RawXMLValue
+		if req.Set != nil {
+			set = req.Set.Prop.Raw
+		}
+		if req.Remove != nil {
+			remove = req.Remove.Prop.Raw
+		}
+		h.patchDeadProperties(r, calendarID, calURI, set, remove, &resp)
+	}
+
 	ms := common.MultiStatus{Responses: []common.Response{resp}}
 	if err := common.ServeMultiStatus(w, &ms); err != nil {
-		h.logger.Error().Err(err).Msg("failed to serve MultiStatus for PROPPATCH")
+		h.logger.Error().Ctx(r.Context()).Err(err).Msg("failed to serve MultiStatus for PROPPATCH")
+	}
+}
+
+// protectedCalendarProps are the live CalDAV properties PROPFIND reports
+// from the calendar itself. Storing a client value for them as a dead
+// property would be shadowed by the live one, so changes are refused.
+var protectedCalendarProps = map[xml.Name]bool{
+	{Space: common.NSCalDAV, Local: "calendar-description"}:             true,
+	{Space: common.NSCalDAV, Local: "calendar-timezone"}:                true,
+	{Space: common.NSCalDAV, Local: "schedule-calendar-transp"}:         true,
+	{Space: common.NSCalDAV, Local: "supported-calendar-component-set"}: true,
+}
+
+// patchDeadProperties persists the PROPPATCH properties the calendar does
+// not handle itself and refuses changes to protected live ones. A CS:source
+// is refused on calendars that cannot become subscriptions.
+func (h *Handlers) patchDeadProperties(r *http.Request, calendarID, calURI string, set, remove []common.RawXMLValue, resp *common.Response) {
+	if len(set) == 0 && len(remove) == 0 {
+		return
+	}
+	for _, raw := range append(append([]common.RawXMLValue(nil), set...), remove...) {
+		if name, ok := raw.Name(); ok && protectedCalendarProps[name] {
+			common.EncodeProtectedProp(resp, name)
+		}
+	}
+	refuseSource := false
+	for _, raw := range set {
+		if name, ok := raw.Name(); ok && name == subscriptionSourceProp {
+			refuseSource = !h.canSubscribe(r.Context(), calendarID)
+			if refuseSource {
+				h.logger.Debug().Ctx(r.Context()).Str("calendar", calURI).Msg("refusing CS:source on a calendar with objects")
+				common.EncodeProtectedProp(resp, name)
+			}
+			break
+		}
+	}
+	handled := func(name xml.Name) bool {
+		switch {
+		case name == subscriptionSourceProp && refuseSource:
+			return true
+		case name.Space == "http://apple.com/ns/ical/" && name.Local == "calendar-color":
+			return true
+		case name.Space == common.NSCS && strings.HasPrefix(name.Local, "default-alarm-vevent-"):
+			return true
+		case protectedCalendarProps[name]:
+			return true
+		}
+		return false
+	}
+	if err := common.PatchDeadProperties(r.Context(), h.store, calendarID, set, remove, handled, resp); err != nil {
+		h.logger.Error().Ctx(r.Context()).Err(err).Str("calendar", calURI).Msg("failed to update dead properties")
+	}
+}
+
+// defaultAlarmUpdate tracks a PROPPATCH of one of the calendarserver
+// default-alarm-vevent-* properties.
+type defaultAlarmUpdate struct {
+	local     string
+	allDay    bool
+	value     string
+	requested bool
+	status    int
+}
+
+// findRawProp looks up a property by name among raw PROPPATCH values and
+// returns its character data.
+func findRawProp(raw []common.RawXMLValue, space, local string) (string, bool) {
+	for _, rawProp := range raw {
+		xmlBytes, err := xml.Marshal(&rawProp)
+		if err != nil {
+			continue
+		}
+		var prop struct {
+			XMLName xml.Name
+			Text    string `xml:",chardata"`
+		}
+		if err := xml.Unmarshal(xmlBytes, &prop); err != nil {
+			continue
+		}
+		if prop.XMLName.Space == space && prop.XMLName.Local == local {
+			return prop.Text, true
+		}
 	}
+	return "", false
 }
 
 func (h *Handlers) HandleReport(w http.ResponseWriter, r *http.Request) {
@@ -839,7 +1400,7 @@ func (h *Handlers) HandleReport(w http.ResponseWriter, r *http.Request) {
 	if owner != "" && calURI != "" && len(rest) == 0 {
 		_, calOwner, err := h.resolveCalendar(r.Context(), owner, calURI)
 		if err != nil {
-			h.logger.Error().Err(err).
+			h.logger.Error().Ctx(r.Context()).Err(err).
 				Str("owner", owner).
 				Str("calendar", calURI).
 				Msg("failed to resolve calendar in REPORT")
@@ -850,7 +1411,7 @@ func (h *Handlers) HandleReport(w http.ResponseWriter, r *http.Request) {
 		if pr.UserID != calOwner {
 			eff, err := h.aclProv.Effective(r.Context(), &directory.User{UID: pr.UserID, DN: pr.UserDN, DisplayName: pr.Display}, calURI)
 			if err != nil {
-				h.logger.Error().Err(err).
+				h.logger.Error().Ctx(r.Context()).Err(err).
 					Str("user", pr.UserID).
 					Str("calendar", calURI).
 					Msg("ACL check failed in REPORT")
@@ -858,7 +1419,7 @@ func (h *Handlers) HandleReport(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 			if !eff.Read {
-				h.logger.Debug().
+				h.logger.Debug().Ctx(r.Context()).
 					Str("user", pr.UserID).
 					Str("calendar", calURI).
 					Msg("insufficient DAV:read privileges for REPORT")
@@ -868,19 +1429,40 @@ func (h *Handlers) HandleReport(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	body, err := io.ReadAll(io.LimitReader(r.Body, 8<<20))
+	maxBody := h.cfg.HTTP.MaxReportBytes
+	if common.ExceedsLimit(r, maxBody) {
+		h.logger.Debug().Ctx(r.Context()).
+			Int64("content_length", r.ContentLength).
+			Int64("limit", maxBody).
+			Msg("REPORT body too large")
+		common.ServeTooLarge(w, maxBody)
+		return
+	}
+
+	body, err := common.ReadBody(r, maxBody)
+	if errors.Is(err, common.ErrBodyTooLarge) {
+		h.logger.Debug().Ctx(r.Context()).Int64("limit", maxBody).Msg("REPORT body too large")
+		common.ServeTooLarge(w, maxBody)
+		return
+	}
 	if err != nil {
-		h.logger.Error().Err(err).Msg("failed to read REPORT body")
+		h.logger.Error().Ctx(r.Context()).Err(err).Msg("failed to read REPORT body")
 		http.Error(w, "bad request", http.StatusBadRequest)
 		return
 	}
-	_ = r.Body.Close()
+	// The body alone identifies the report: clients that omit Content-Type
+	// are served as long as it parses as XML.
+	if len(bytes.TrimSpace(body)) == 0 {
+		h.logger.Debug().Ctx(r.Context()).Msg("REPORT without a body")
+		http.Error(w, "REPORT requires an XML body naming the report", http.StatusBadRequest)
+		return
+	}
 
 	root := struct {
 		XMLName xml.Name
 	}{}
 	if err := xml.Unmarshal(body, &root); err != nil {
-		h.logger.Error().Err(err).Msg("failed to unmarshal REPORT XML")
+		h.logger.Error().Ctx(r.Context()).Err(err).Msg("failed to unmarshal REPORT XML")
 		http.Error(w, "bad xml", http.StatusBadRequest)
 		return
 	}
@@ -889,32 +1471,37 @@ func (h *Handlers) HandleReport(w http.ResponseWriter, r *http.Request) {
 	case common.NSCalDAV + " calendar-query":
 		var q common.CalendarQuery
 		if err := xml.Unmarshal(body, &q); err != nil {
-			h.logger.Error().Err(err).Msg("failed to unmarshal calendar-query")
+			h.logger.Error().Ctx(r.Context()).Err(err).Msg("failed to unmarshal calendar-query")
 		}
 		h.ReportCalendarQuery(w, r, q)
 	case common.NSCalDAV + " calendar-multiget":
 		var mg common.CalendarMultiget
 		if err := xml.Unmarshal(body, &mg); err != nil {
-			h.logger.Error().Err(err).Msg("failed to unmarshal calendar-multiget")
+			h.logger.Error().Ctx(r.Context()).Err(err).Msg("failed to unmarshal calendar-multiget")
 		}
 		h.ReportCalendarMultiget(w, r, mg)
 	case common.NSDAV + " sync-collection":
 		var sc common.SyncCollection
 		if err := xml.Unmarshal(body, &sc); err != nil {
-			h.logger.Error().Err(err).Msg("failed to unmarshal sync-collection")
+			h.logger.Error().Ctx(r.Context()).Err(err).Msg("failed to unmarshal sync-collection")
+		}
+		if herr := common.ValidateSyncCollection(r.Header.Get("Depth"), sc); herr != nil {
+			h.logger.Debug().Ctx(r.Context()).Err(herr).Msg("rejecting sync-collection")
+			http.Error(w, herr.Error(), herr.Code)
+			return
 		}
 		h.ReportSyncCollection(w, r, sc)
 	case common.NSCalDAV + " free-busy-query":
 		var fb common.FreeBusyQuery
 		if err := xml.Unmarshal(body, &fb); err != nil {
-			h.logger.Error().Err(err).Msg("failed to unmarshal free-busy-query")
+			h.logger.Error().Ctx(r.Context()).Err(err).Msg("failed to unmarshal free-busy-query")
 		}
 		h.ReportFreeBusyQuery(w, r, fb)
 	default:
-		h.logger.Error().
+		h.logger.Error().Ctx(r.Context()).
 			Str("namespace", root.XMLName.Space).
 			Str("local", root.XMLName.Local).
 			Msg("unsupported REPORT type")
-		http.Error(w, "unsupported REPORT", http.StatusBadRequest)
+		_ = common.ServeUnsupportedReport(w, supportedReportSetValue())
 	}
 }
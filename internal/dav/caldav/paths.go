@@ -1,7 +1,10 @@
 package caldav
 
 import (
+	"path/filepath"
 	"strings"
+
+	"github.com/sonroyaalmerol/ldap-dav/internal/dav/common"
 )
 
 // tryCalendarShorthand interprets /calendars/{calURI} as /calendars/{currentUserID}/{calURI}
@@ -71,3 +74,14 @@ func splitResourcePath(urlPath, basePath string) (owner, collection string, rest
 	}
 	return "", "", nil
 }
+
+// objectTarget returns the UID of the calendar object a request path names
+// below its calendar, or ok=false when the remainder is not a single .ics
+// resource.
+func objectTarget(rest []string) (uid string, ok bool) {
+	if len(rest) != 1 || !strings.HasSuffix(strings.ToLower(rest[0]), ".ics") {
+		return "", false
+	}
+	uid = strings.TrimSuffix(rest[0], filepath.Ext(rest[0]))
+	return uid, common.SafeSegment(uid)
+}
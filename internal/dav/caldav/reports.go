@@ -1,6 +1,7 @@
 package caldav
 
 import (
+	"context"
 	"encoding/xml"
 	"fmt"
 	"net/http"
@@ -14,10 +15,21 @@ import (
 )
 
 func (h *Handlers) ReportCalendarQuery(w http.ResponseWriter, r *http.Request, q common.CalendarQuery) {
-	owner, calURI, _ := splitResourcePath(r.URL.Path, h.basePath)
+	owner, calURI, rest := splitResourcePath(r.URL.Path, h.basePath)
+	// RFC 4791 section 7.8: a calendar-query on an object resource applies
+	// the query to that resource alone.
+	var targetUID string
+	if len(rest) > 0 {
+		uid, ok := objectTarget(rest)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		targetUID = uid
+	}
 	calendarID, calOwner, err := h.resolveCalendar(r.Context(), owner, calURI)
 	if err != nil {
-		h.logger.Error().Err(err).
+		h.logger.Error().Ctx(r.Context()).Err(err).
 			Str("owner", owner).
 			Str("calendar", calURI).
 			Msg("failed to resolve calendar in calendar-query")
@@ -28,56 +40,136 @@ func (h *Handlers) ReportCalendarQuery(w http.ResponseWriter, r *http.Request, q
 	if ok := h.mustCanRead(w, r.Context(), pr, calURI, calOwner); !ok {
 		return
 	}
+	if targetUID != "" {
+		if obj, err := h.getObject(r.Context(), calendarID, targetUID); err != nil || obj == nil {
+			h.logger.Debug().Ctx(r.Context()).
+				Str("calendar", calURI).
+				Str("uid", targetUID).
+				Msg("calendar-query on missing object")
+			http.NotFound(w, r)
+			return
+		}
+	}
 
 	props := common.ParsePropRequest(q.Prop)
+	if !supportedCalDataType(props.CalDataType) {
+		h.logger.Debug().Ctx(r.Context()).
+			Str("content_type", props.CalDataType.ContentType).
+			Str("version", props.CalDataType.Version).
+			Msg("unsupported calendar-data type in calendar-query")
+		_ = common.ServeError(w, http.StatusForbidden, common.CalendarDataUnsupported{})
+		return
+	}
+	if cond, code := checkFilter(q.Filter); cond != nil {
+		h.logger.Debug().Ctx(r.Context()).
+			Str("calendar", calURI).
+			Int("status", code).
+			Msg("rejecting calendar-query filter")
+		_ = common.ServeError(w, code, cond)
+		return
+	}
 
 	var start, end *time.Time
 	if tr := common.ExtractTimeRange(q.Filter); tr != nil {
-		if tr.Start != "" {
-			if t, err := common.ParseICalTime(tr.Start); err == nil {
-				start = &t
-			}
-		}
-		if tr.End != "" {
-			if t, err := common.ParseICalTime(tr.End); err == nil {
-				end = &t
-			}
-		}
+		start, end = parseTimeRangeBounds(tr)
 	}
 
 	comps := common.ExtractComponentFilterNames(q.Filter)
 	if len(comps) == 0 {
-		comps = []string{"VEVENT", "VTODO", "VJOURNAL"}
+		comps = []string{"VEVENT", "VTODO", "VJOURNAL", "VFREEBUSY"}
 	}
 
-	objs, err := h.store.ListObjectsByComponent(r.Context(), calendarID, comps, start, end)
+	expandStart, expandEnd := start, end
+	if props.Expand != nil {
+		// <C:expand> carries its own window, independent of the filter
+		expandStart, expandEnd = parseTimeRangeBounds(props.Expand)
+	}
+	expand := expandStart != nil && expandEnd != nil && common.ContainsComponent(comps, "VEVENT")
+
+	list := h.listObjectsByComponent
+	if !props.CalendarData && !expand && !filterNeedsData(q.Filter) {
+		// Only hrefs and metadata are reported: skip reading the bodies.
+		list = h.listObjectMetadataByComponent
+	}
+	objs, err := list(r.Context(), calendarID, comps, start, end)
 	if err != nil {
-		h.logger.Error().Err(err).
+		h.logger.Error().Ctx(r.Context()).Err(err).
 			Str("calendarID", calendarID).
 			Msg("failed to list objects in calendar-query")
 		http.Error(w, "storage error", http.StatusInternalServerError)
 		return
 	}
+	if targetUID != "" {
+		objs = objectsWithUID(objs, targetUID)
+	}
+	objs = h.filterByCalendarProps(r.Context(), objs, q.Filter)
+	objs = h.filterByComponentProps(r.Context(), objs, q.Filter)
 
 	var resps []common.Response
 
-	if start != nil && end != nil && common.ContainsComponent(comps, "VEVENT") {
-		resps = h.buildExpandedEventResponses(objs, *start, *end, props, owner, calURI)
+	if expand {
+		resps = h.buildExpandedEventResponses(r.Context(), objs, *expandStart, *expandEnd, props, owner, calURI)
 	} else {
 		for _, o := range objs {
 			hrefStr := common.JoinURL(h.basePath, "calendars", owner, calURI, o.UID+".ics")
-			resps = append(resps, buildReportResponse(hrefStr, props, o))
+			resps = append(resps, h.buildReportResponse(hrefStr, props, o))
 		}
 	}
 
 	ms := common.MultiStatus{Responses: resps}
 	if err := common.ServeMultiStatus(w, &ms); err != nil {
-		h.logger.Error().Err(err).Msg("failed to serve MultiStatus for calendar-query")
+		h.logger.Error().Ctx(r.Context()).Err(err).Msg("failed to serve MultiStatus for calendar-query")
+	}
+}
+
+// objectsWithUID keeps the objects stored under uid.
+func objectsWithUID(objs []*storage.Object, uid string) []*storage.Object {
+	var out []*storage.Object
+	for _, o := range objs {
+		if o.UID == uid {
+			out = append(out, o)
+		}
+	}
+	return out
+}
+
+// supportedCalDataType reports whether calendar-data can be returned in
+// the requested media type. Only iCalendar 2.0 is stored, and there is no
+// other version to convert to.
+func supportedCalDataType(t *common.SupportedCalData) bool {
+	if t == nil {
+		return true
+	}
+	if t.ContentType != "" && !strings.EqualFold(t.ContentType, "text/calendar") {
+		return false
 	}
+	return t.Version == "" || t.Version == "2.0"
+}
+
+func parseTimeRangeBounds(tr *common.TimeRange) (start, end *time.Time) {
+	if tr.Start != "" {
+		if t, err := common.ParseICalTime(tr.Start); err == nil {
+			start = &t
+		}
+	}
+	if tr.End != "" {
+		if t, err := common.ParseICalTime(tr.End); err == nil {
+			end = &t
+		}
+	}
+	return start, end
 }
 
 func (h *Handlers) ReportCalendarMultiget(w http.ResponseWriter, r *http.Request, mg common.CalendarMultiget) {
 	props := common.ParsePropRequest(mg.Prop)
+	if !supportedCalDataType(props.CalDataType) {
+		h.logger.Debug().Ctx(r.Context()).
+			Str("content_type", props.CalDataType.ContentType).
+			Str("version", props.CalDataType.Version).
+			Msg("unsupported calendar-data type in multiget")
+		_ = common.ServeError(w, http.StatusForbidden, common.CalendarDataUnsupported{})
+		return
+	}
 	var resps []common.Response
 	for _, hrefStr := range mg.Hrefs {
 		owner, calURI, rest := splitResourcePath(hrefStr, h.basePath)
@@ -91,7 +183,7 @@ func (h *Handlers) ReportCalendarMultiget(w http.ResponseWriter, r *http.Request
 
 		calendarID, calOwner, err := h.resolveCalendar(r.Context(), owner, calURI)
 		if err != nil {
-			h.logger.Debug().Err(err).
+			h.logger.Debug().Ctx(r.Context()).Err(err).
 				Str("owner", owner).
 				Str("calendar", calURI).
 				Msg("failed to resolve calendar in multiget")
@@ -100,16 +192,16 @@ func (h *Handlers) ReportCalendarMultiget(w http.ResponseWriter, r *http.Request
 		pr := common.MustPrincipal(r.Context())
 		okRead, err := h.aclCheckRead(r.Context(), pr, calURI, calOwner)
 		if err != nil || !okRead {
-			h.logger.Debug().Err(err).
+			h.logger.Debug().Ctx(r.Context()).Err(err).
 				Bool("can_read", okRead).
 				Str("user", pr.UserID).
 				Str("calendar", calURI).
 				Msg("ACL check failed in multiget")
 			continue
 		}
-		o, err := h.store.GetObject(r.Context(), calendarID, uid)
+		o, err := h.getObject(r.Context(), calendarID, uid)
 		if err != nil {
-			h.logger.Debug().Err(err).
+			h.logger.Debug().Ctx(r.Context()).Err(err).
 				Str("calendarID", calendarID).
 				Str("uid", uid).
 				Msg("failed to get object in multiget")
@@ -117,22 +209,22 @@ func (h *Handlers) ReportCalendarMultiget(w http.ResponseWriter, r *http.Request
 		}
 
 		if h.isRecurringInstanceRequest(hrefStr) {
-			instanceResp := h.handleRecurringInstanceRequest(hrefStr, o, props)
+			instanceResp := h.handleRecurringInstanceRequest(r.Context(), hrefStr, o, props)
 			if instanceResp != nil {
 				resps = append(resps, *instanceResp)
 			}
 			continue
 		}
 
-		resps = append(resps, buildReportResponse(hrefStr, props, o))
+		resps = append(resps, h.buildReportResponse(hrefStr, props, o))
 	}
 	ms := common.MultiStatus{Responses: resps}
 	if err := common.ServeMultiStatus(w, &ms); err != nil {
-		h.logger.Error().Err(err).Msg("failed to serve MultiStatus for calendar-multiget")
+		h.logger.Error().Ctx(r.Context()).Err(err).Msg("failed to serve MultiStatus for calendar-multiget")
 	}
 }
 
-func buildReportResponse(hrefStr string, props common.PropRequest, o *storage.Object) common.Response {
+func (h *Handlers) buildReportResponse(hrefStr string, props common.PropRequest, o *storage.Object) common.Response {
 	resp := common.Response{
 		Hrefs: []common.Href{{Value: hrefStr}},
 	}
@@ -142,7 +234,13 @@ func buildReportResponse(hrefStr string, props common.PropRequest, o *storage.Ob
 			XMLName xml.Name `xml:"urn:ietf:params:xml:ns:caldav calendar-data"`
 			Text    string   `xml:",chardata"`
 		}
-		_ = resp.EncodeProp(http.StatusOK, CalendarData{Text: o.Data})
+		var data string
+		if props.Comp != nil {
+			data = h.canonicalData(string(ical.SelectComponents([]byte(o.Data), *props.Comp)))
+		} else {
+			data = h.canonicalObject(o)
+		}
+		_ = resp.EncodeProp(http.StatusOK, CalendarData{Text: data})
 	}
 	if props.GetETag && o.ETag != "" {
 		_ = resp.EncodeProp(http.StatusOK, common.GetETag{ETag: common.ETag(o.ETag)})
@@ -150,14 +248,27 @@ func buildReportResponse(hrefStr string, props common.PropRequest, o *storage.Ob
 	if !o.UpdatedAt.IsZero() {
 		_ = resp.EncodeProp(http.StatusOK, common.GetLastModified{LastModified: common.TimeText(o.UpdatedAt)})
 	}
+	_ = resp.EncodeProp(http.StatusOK, common.ResourceType{})
+	_ = resp.EncodeProp(http.StatusOK, common.GetContentLength{Length: o.ContentLength()})
+	props.Selection.FilterResponse(&resp)
 	return resp
 }
 
 func (h *Handlers) ReportSyncCollection(w http.ResponseWriter, r *http.Request, sc common.SyncCollection) {
-	owner, calURI, _ := splitResourcePath(r.URL.Path, h.basePath)
+	owner, calURI, rest := splitResourcePath(r.URL.Path, h.basePath)
+	if len(rest) > 0 {
+		// RFC 6578 section 3.2: only collections can be synchronized
+		_ = common.ServeUnsupportedReport(w, objectReportSetValue())
+		return
+	}
+	if h.isBirthdayCalendar(calURI) {
+		// projected calendars keep no change log
+		_ = common.ServeUnsupportedReport(w, birthdayReportSetValue())
+		return
+	}
 	calendarID, calOwner, err := h.resolveCalendar(r.Context(), owner, calURI)
 	if err != nil {
-		h.logger.Error().Err(err).
+		h.logger.Error().Ctx(r.Context()).Err(err).
 			Str("owner", owner).
 			Str("calendar", calURI).
 			Msg("failed to resolve calendar in sync-collection")
@@ -173,26 +284,42 @@ func (h *Handlers) ReportSyncCollection(w http.ResponseWriter, r *http.Request,
 
 	curToken, _, err := h.store.GetSyncInfo(r.Context(), calendarID)
 	if err != nil {
-		h.logger.Error().Err(err).
+		h.logger.Error().Ctx(r.Context()).Err(err).
 			Str("calendarID", calendarID).
 			Msg("failed to get sync info")
 		http.Error(w, "storage error", http.StatusInternalServerError)
 		return
 	}
 
+	// An empty sync-token asks for an initial sync: every current member,
+	// without the tombstones kept in the change log.
+	initial := strings.TrimSpace(sc.SyncToken) == ""
 	sinceSeq := int64(0)
-	if sc.SyncToken != "" {
-		if ss, ok := common.ParseSeqToken(sc.SyncToken); ok {
-			sinceSeq = ss
+	if !initial {
+		ss, ok := h.tokens.ParseSeq(calendarID, sc.SyncToken)
+		if !ok {
+			h.logger.Debug().Ctx(r.Context()).
+				Str("calendarID", calendarID).
+				Str("token", sc.SyncToken).
+				Msg("invalid sync-token in sync-collection")
+			_ = common.ServeError(w, http.StatusForbidden, common.ValidSyncToken{})
+			return
 		}
+		sinceSeq = ss
 	}
 	limit := 0
 	if sc.Limit != nil && sc.Limit.NResults > 0 {
 		limit = sc.Limit.NResults
 	}
-	changes, _, err := h.store.ListChangesSince(r.Context(), calendarID, sinceSeq, limit)
+	var changes []storage.Change
+	var lastSeq int64
+	if initial {
+		changes, err = h.initialSyncChanges(r.Context(), calendarID)
+	} else {
+		changes, lastSeq, err = h.store.ListChangesSince(r.Context(), calendarID, sinceSeq, limit)
+	}
 	if err != nil {
-		h.logger.Error().Err(err).
+		h.logger.Error().Ctx(r.Context()).Err(err).
 			Str("calendarID", calendarID).
 			Int64("since", sinceSeq).
 			Int("limit", limit).
@@ -200,6 +327,12 @@ func (h *Handlers) ReportSyncCollection(w http.ResponseWriter, r *http.Request,
 		http.Error(w, "storage error", http.StatusInternalServerError)
 		return
 	}
+	// Members of an initial sync have no change-log position to resume
+	// from, so a result over the limit is refused rather than cut short.
+	if initial && limit > 0 && len(changes) > limit {
+		_ = common.ServeError(w, http.StatusInsufficientStorage, common.NumberOfMatchesWithinLimits{})
+		return
+	}
 
 	var resps []common.Response
 
@@ -224,7 +357,7 @@ func (h *Handlers) ReportSyncCollection(w http.ResponseWriter, r *http.Request,
 			if needObject {
 				obj, getErr = h.store.GetObject(r.Context(), calendarID, ch.UID)
 				if getErr != nil {
-					h.logger.Debug().Err(getErr).
+					h.logger.Debug().Ctx(r.Context()).Err(getErr).
 						Str("calendarID", calendarID).
 						Str("uid", ch.UID).
 						Msg("object disappeared between change listing and fetch")
@@ -241,7 +374,7 @@ func (h *Handlers) ReportSyncCollection(w http.ResponseWriter, r *http.Request,
 					XMLName xml.Name `xml:"urn:ietf:params:xml:ns:caldav calendar-data"`
 					Text    string   `xml:",chardata"`
 				}
-				_ = resp.EncodeProp(http.StatusOK, CalendarData{Text: obj.Data})
+				_ = resp.EncodeProp(http.StatusOK, CalendarData{Text: h.canonicalObject(obj)})
 			}
 			resps = append(resps, resp)
 		}
@@ -249,21 +382,43 @@ func (h *Handlers) ReportSyncCollection(w http.ResponseWriter, r *http.Request,
 
 	ms := common.MultiStatus{
 		Responses: resps,
-		SyncToken: curToken,
+		SyncToken: h.tokens.Encode(calendarID, curToken),
 	}
-	if limit > 0 && len(changes) == limit {
+	if !initial && limit > 0 && len(changes) == limit {
+		// The log may hold more changes: hand out the position of the
+		// last one sent so the client continues from there.
+		ms.SyncToken = h.tokens.Encode(calendarID, fmt.Sprintf("seq:%d", lastSeq))
 		ms.NumberOfMatchesWithinLimits = fmt.Sprintf("%d", len(changes))
 	}
 	if err := common.ServeMultiStatus(w, &ms); err != nil {
-		h.logger.Error().Err(err).Msg("failed to serve MultiStatus for sync-collection")
+		h.logger.Error().Ctx(r.Context()).Err(err).Msg("failed to serve MultiStatus for sync-collection")
 	}
 }
 
+// initialSyncChanges lists the current calendar members as non-deleted
+// changes so an initial sync-collection can reuse the incremental path.
+func (h *Handlers) initialSyncChanges(ctx context.Context, calendarID string) ([]storage.Change, error) {
+	objs, err := h.store.ListObjects(ctx, calendarID, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	changes := make([]storage.Change, 0, len(objs))
+	for _, o := range objs {
+		changes = append(changes, storage.Change{UID: o.UID})
+	}
+	return changes, nil
+}
+
 func (h *Handlers) ReportFreeBusyQuery(w http.ResponseWriter, r *http.Request, fb common.FreeBusyQuery) {
-	owner, calURI, _ := splitResourcePath(r.URL.Path, h.basePath)
+	owner, calURI, rest := splitResourcePath(r.URL.Path, h.basePath)
+	if len(rest) > 0 {
+		// RFC 4791 section 7.10: free-busy-query targets a calendar collection
+		_ = common.ServeUnsupportedReport(w, objectReportSetValue())
+		return
+	}
 	calendarID, calOwner, err := h.resolveCalendar(r.Context(), owner, calURI)
 	if err != nil {
-		h.logger.Error().Err(err).
+		h.logger.Error().Ctx(r.Context()).Err(err).
 			Str("owner", owner).
 			Str("calendar", calURI).
 			Msg("failed to resolve calendar in free-busy-query")
@@ -277,27 +432,27 @@ func (h *Handlers) ReportFreeBusyQuery(w http.ResponseWriter, r *http.Request, f
 	}
 
 	if fb.Time == nil || fb.Time.Start == "" || fb.Time.End == "" {
-		h.logger.Error().Msg("free-busy query missing required time-range")
+		h.logger.Error().Ctx(r.Context()).Msg("free-busy query missing required time-range")
 		http.Error(w, "time-range required", http.StatusBadRequest)
 		return
 	}
 
 	start, err := common.ParseICalTime(fb.Time.Start)
 	if err != nil {
-		h.logger.Error().Err(err).Str("start", fb.Time.Start).Msg("bad start time in free-busy query")
+		h.logger.Error().Ctx(r.Context()).Err(err).Str("start", fb.Time.Start).Msg("bad start time in free-busy query")
 		http.Error(w, "bad start", http.StatusBadRequest)
 		return
 	}
 
 	end, err := common.ParseICalTime(fb.Time.End)
 	if err != nil {
-		h.logger.Error().Err(err).Str("end", fb.Time.End).Msg("bad end time in free-busy query")
+		h.logger.Error().Ctx(r.Context()).Err(err).Str("end", fb.Time.End).Msg("bad end time in free-busy query")
 		http.Error(w, "bad end", http.StatusBadRequest)
 		return
 	}
 
 	if !end.After(start) {
-		h.logger.Error().
+		h.logger.Error().Ctx(r.Context()).
 			Time("start", start).
 			Time("end", end).
 			Msg("invalid time range in free-busy query")
@@ -305,27 +460,26 @@ func (h *Handlers) ReportFreeBusyQuery(w http.ResponseWriter, r *http.Request, f
 		return
 	}
 
-	objs, err := h.store.ListObjectsByComponent(r.Context(), calendarID, []string{"VEVENT"}, &start, &end)
+	objs, err := h.listObjectsByComponent(r.Context(), calendarID, []string{"VEVENT"}, &start, &end)
 	if err != nil {
-		h.logger.Error().Err(err).
+		h.logger.Error().Ctx(r.Context()).Err(err).
 			Str("calendarID", calendarID).
 			Msg("failed to list events for free-busy query")
 		http.Error(w, "storage error", http.StatusInternalServerError)
 		return
 	}
 
-	busy := h.buildBusyIntervals(objs, start, end)
+	busy := h.buildBusyIntervals(r.Context(), objs, start, end)
 
 	icsData := common.BuildFreeBusyICS(start, end, busy, h.cfg.ICS.BuildProdID())
 	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
 	if _, err := w.Write(icsData); err != nil {
-		h.logger.Error().Err(err).Msg("failed to write free-busy response")
+		h.logger.Error().Ctx(r.Context()).Err(err).Msg("failed to write free-busy response")
 	}
 }
 
-func (h *Handlers) buildBusyIntervals(objs []*storage.Object, start, end time.Time) []ical.Interval {
+func (h *Handlers) buildBusyIntervals(ctx context.Context, objs []*storage.Object, start, end time.Time) []ical.Interval {
 	var busy []ical.Interval
-	expander := ical.NewRecurrenceExpander(time.UTC)
 
 	for _, o := range objs {
 		if o.Component != "VEVENT" {
@@ -334,7 +488,7 @@ func (h *Handlers) buildBusyIntervals(objs []*storage.Object, start, end time.Ti
 
 		events, err := ical.ParseCalendar([]byte(o.Data))
 		if err != nil {
-			h.logger.Debug().Err(err).
+			h.logger.Debug().Ctx(ctx).Err(err).
 				Str("uid", o.UID).
 				Msg("failed to parse calendar, using fallback interval if available")
 			if interval := h.extractFallbackInterval(o, start, end); interval != nil {
@@ -343,9 +497,9 @@ func (h *Handlers) buildBusyIntervals(objs []*storage.Object, start, end time.Ti
 			continue
 		}
 
-		expandedEvents, err := expander.ExpandRecurrences(events, start, end)
+		expandedEvents, err := h.expander.ExpandRecurrences(ctx, events, start, end)
 		if err != nil {
-			h.logger.Debug().Err(err).
+			h.logger.Debug().Ctx(ctx).Err(err).
 				Str("uid", o.UID).
 				Msg("failed to expand recurrences, using fallback interval if available")
 			if interval := h.extractFallbackInterval(o, start, end); interval != nil {
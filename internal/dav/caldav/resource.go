@@ -1,15 +1,17 @@
 package caldav
 
 import (
+	"context"
 	"encoding/xml"
 	"net/http"
 	"path/filepath"
 	"strings"
+	"time"
 
-	"github.com/sonroyaalmerol/ldap-dav/internal/acl"
 	"github.com/sonroyaalmerol/ldap-dav/internal/dav/common"
 	"github.com/sonroyaalmerol/ldap-dav/internal/directory"
 	"github.com/sonroyaalmerol/ldap-dav/internal/storage"
+	"github.com/sonroyaalmerol/ldap-dav/pkg/ical"
 )
 
 type CalDAVResourceHandler struct {
@@ -29,48 +31,63 @@ func (c *CalDAVResourceHandler) SplitResourcePath(urlPath string) (owner, collec
 }
 
 func (c *CalDAVResourceHandler) PropfindHome(w http.ResponseWriter, r *http.Request, owner, depth string) {
+	sel := common.PropSelectionFrom(r.Context())
 	u, _ := common.CurrentUser(r.Context())
 	if u == nil {
-		c.handlers.logger.Error().Str("path", r.URL.Path).Msg("PROPFIND home unauthorized")
+		c.handlers.logger.Error().Ctx(r.Context()).Str("path", r.URL.Path).Msg("PROPFIND home unauthorized")
 		http.Error(w, "unauthorized", http.StatusUnauthorized)
 		return
 	}
 	home := common.CalendarHome(c.basePath, owner)
 
 	if u.UID != owner {
-		c.handlers.logger.Debug().Str("user", u.UID).Str("owner", owner).Msg("PROPFIND home forbidden - user mismatch")
+		c.handlers.logger.Debug().Ctx(r.Context()).Str("user", u.UID).Str("owner", owner).Msg("PROPFIND home forbidden - user mismatch")
 		http.Error(w, "forbidden", http.StatusForbidden)
 		return
 	}
 
 	c.handlers.ensurePersonalCalendar(r.Context(), owner)
 
-	owned, err := c.handlers.store.ListCalendarsByOwnerUser(r.Context(), owner)
+	owned, err := c.handlers.ownedCalendars(r.Context(), owner)
 	if err != nil {
-		c.handlers.logger.Error().Err(err).Str("owner", owner).Msg("failed to list owned calendars in PROPFIND home")
+		c.handlers.logger.Error().Ctx(r.Context()).Err(err).Str("owner", owner).Msg("failed to list owned calendars in PROPFIND home")
 		http.Error(w, "storage error", http.StatusInternalServerError)
 		return
 	}
+	c.handlers.sortCalendars(r.Context(), owned, func(cal *storage.Calendar) string { return cal.DisplayName })
 	visible, err := c.handlers.aclProv.VisibleCalendars(r.Context(), u)
 	if err != nil {
-		c.handlers.logger.Error().Err(err).Str("user", u.UID).Msg("failed to compute visible calendars in PROPFIND home")
+		c.handlers.logger.Error().Ctx(r.Context()).Err(err).Str("user", u.UID).Msg("failed to compute visible calendars in PROPFIND home")
 		http.Error(w, "acl error", http.StatusInternalServerError)
 		return
 	}
 
+	shared, err := c.handlers.sharedCalendars(r.Context(), owner, visible)
+	if err != nil {
+		c.handlers.logger.Error().Ctx(r.Context()).Err(err).Msg("failed to list all calendars in PROPFIND home")
+	}
+
 	var resps []common.Response
 
 	homeResp := common.Response{Hrefs: []common.Href{{Value: home}}}
 	_ = homeResp.EncodeProp(http.StatusOK, common.ResourceType{Collection: &struct{}{}})
-	_ = homeResp.EncodeProp(http.StatusOK, common.DisplayName{Name: "Calendar Home"})
-	_ = homeResp.EncodeProp(http.StatusOK, common.Owner{Href: &common.Href{Value: common.PrincipalURL(c.basePath, owner)}})
-	_ = homeResp.EncodeProp(http.StatusOK, common.CurrentUserPrincipal{Href: &common.Href{Value: common.PrincipalURL(c.basePath, owner)}})
-
-	_ = homeResp.EncodeProp(http.StatusOK, c.buildSupportedPrivilegeSet())
+	_ = homeResp.EncodeProp(http.StatusOK, c.handlers.supportedMethodSet(homeResp.Hrefs[0].Value))
+	_ = homeResp.EncodeProp(http.StatusOK, common.DisplayName{Name: common.StoredDisplayName(r.Context(), c.handlers.store, homeResourceID(owner), "Calendar Home")})
+	_ = homeResp.EncodeProp(http.StatusOK, common.Owner{Href: &common.Href{Value: c.handlers.principalURL(owner)}})
+	_ = homeResp.EncodeProp(http.StatusOK, common.CurrentUserPrincipal{Href: &common.Href{Value: c.handlers.principalURL(owner)}})
+	_ = homeResp.EncodeProp(http.StatusOK, common.PrincipalCollectionSetFor(c.basePath))
+
+	if sel.Wants(common.NSDAV, "supported-privilege-set") {
+		_ = homeResp.EncodeProp(http.StatusOK, c.buildSupportedPrivilegeSet())
+	}
 	_ = homeResp.EncodeProp(http.StatusOK, common.CurrentUserPrivilegeSet{
 		Privilege: []common.Privilege{{All: &struct{}{}}},
 	})
-	_ = homeResp.EncodeProp(http.StatusOK, c.buildOwnerACL(owner))
+	if sel.Wants(common.NSDAV, "acl") {
+		_ = homeResp.EncodeProp(http.StatusOK, c.buildOwnerACL(owner))
+	}
+	encodeMemberValidators(&homeResp, append(append([]*storage.Calendar{}, owned...), shared...))
+	c.encodeDeadProperties(r, &homeResp, homeResourceID(owner))
 
 	resps = append(resps, homeResp)
 
@@ -79,112 +96,122 @@ func (c *CalDAVResourceHandler) PropfindHome(w http.ResponseWriter, r *http.Requ
 			hrefStr := common.CalendarPath(c.basePath, owner, cc.URI)
 			resp := common.Response{Hrefs: []common.Href{{Value: hrefStr}}}
 			_ = resp.EncodeProp(http.StatusOK, common.ResourceType{Collection: &struct{}{}, Calendar: &struct{}{}})
+			_ = resp.EncodeProp(http.StatusOK, c.handlers.supportedMethodSet(resp.Hrefs[0].Value))
 			_ = resp.EncodeProp(http.StatusOK, common.DisplayName{Name: cc.DisplayName})
+			c.encodeDescription(&resp, cc)
 			_ = resp.EncodeProp(http.StatusOK, struct {
 				XMLName xml.Name `xml:"http://apple.com/ns/ical/ calendar-color"`
 				Text    string   `xml:",chardata"`
 			}{Text: cc.Color})
-			_ = resp.EncodeProp(http.StatusOK, common.Owner{Href: &common.Href{Value: common.PrincipalURL(c.basePath, owner)}})
-			_ = resp.EncodeProp(http.StatusOK, common.CurrentUserPrincipal{Href: &common.Href{Value: common.PrincipalURL(c.basePath, owner)}})
+			c.encodeDefaultAlarms(&resp, cc)
+			c.encodeScheduleTransp(&resp)
+			_ = resp.EncodeProp(http.StatusOK, common.Owner{Href: &common.Href{Value: c.handlers.principalURL(owner)}})
+			_ = resp.EncodeProp(http.StatusOK, common.CurrentUserPrincipal{Href: &common.Href{Value: c.handlers.principalURL(owner)}})
+			_ = resp.EncodeProp(http.StatusOK, common.PrincipalCollectionSetFor(c.basePath))
 			_ = resp.EncodeProp(http.StatusOK, common.SupportedCompSet{
-				Comp: []common.Comp{{Name: "VEVENT"}, {Name: "VTODO"}, {Name: "VJOURNAL"}},
+				Comp: []common.Comp{{Name: "VEVENT"}, {Name: "VTODO"}, {Name: "VJOURNAL"}, {Name: "VFREEBUSY"}},
 			})
 			_ = resp.EncodeProp(http.StatusOK, supportedReportSetValue())
-			_ = resp.EncodeProp(http.StatusOK, struct {
-				XMLName xml.Name `xml:"DAV: sync-token"`
-				Text    string   `xml:",chardata"`
-			}{Text: cc.CTag})
+			c.encodeSyncToken(r, &resp, cc.ID)
+			_ = resp.EncodeProp(http.StatusOK, common.AddMember{Href: common.Href{Value: resp.Hrefs[0].Value}})
 			_ = resp.EncodeProp(http.StatusOK, struct {
 				XMLName xml.Name `xml:"http://calendarserver.org/ns/ getctag"`
 				Text    string   `xml:",chardata"`
-			}{Text: cc.CTag})
+			}{Text: c.handlers.tokens.Encode(cc.ID, cc.CTag)})
+			_ = resp.EncodeProp(http.StatusOK, common.GetETag{ETag: common.ETag(c.handlers.tokens.Encode(cc.ID, cc.CTag))})
 
 			_ = resp.EncodeProp(http.StatusOK, common.CurrentUserPrivilegeSet{
 				Privilege: []common.Privilege{{All: &struct{}{}}},
 			})
 
-			_ = resp.EncodeProp(http.StatusOK, c.buildOwnerACL(owner))
+			if sel.Wants(common.NSDAV, "acl") {
+				_ = resp.EncodeProp(http.StatusOK, c.buildOwnerACL(owner))
+			}
+			c.encodeDeadProperties(r, &resp, cc.ID)
 			resps = append(resps, resp)
 		}
 
+		if c.handlers.cfg.CalDAV.SchedulingCollections {
+			resps = append(resps, c.schedulingResponses(owner, owned)...)
+		}
+
+		if c.handlers.cfg.CalDAV.BirthdayCalendar {
+			if bresp, err := c.birthdayResponse(r, owner); err != nil {
+				c.handlers.logger.Error().Ctx(r.Context()).Err(err).Str("owner", owner).Msg("failed to project birthday calendar in PROPFIND home")
+			} else {
+				resps = append(resps, bresp)
+			}
+		}
+
 		sharedBase := common.CalendarSharedRoot(c.basePath, owner)
-		sharedResp := common.Response{Hrefs: []common.Href{{Value: sharedBase}}}
-		_ = sharedResp.EncodeProp(http.StatusOK, common.ResourceType{Collection: &struct{}{}})
-		_ = sharedResp.EncodeProp(http.StatusOK, common.DisplayName{Name: "Shared"})
-		_ = sharedResp.EncodeProp(http.StatusOK, common.CurrentUserPrincipal{Href: &common.Href{Value: common.PrincipalURL(c.basePath, owner)}})
-
-		sharedEffectivePrivileges := acl.Effective{
-			Read:                        true,
-			ReadCurrentUserPrivilegeSet: true,
+		if c.handlers.showSharedRoot(shared) {
+			resps = append(resps, c.sharedRootResponse(sharedBase, owner, shared))
 		}
 
-		if sharedEffectivePrivileges.CanReadCurrentUserPrivilegeSet() {
-			_ = sharedResp.EncodeProp(http.StatusOK, common.CurrentUserPrivilegeSet{
-				Privilege: []common.Privilege{{Read: &struct{}{}}},
+		for _, cc := range shared {
+			eff := visible[cc.URI]
+			hrefStr := common.JoinURL(sharedBase, cc.URI) + "/"
+			resp := common.Response{Hrefs: []common.Href{{Value: hrefStr}}}
+			_ = resp.EncodeProp(http.StatusOK, common.MakeSharedCalendarResourcetype())
+			_ = resp.EncodeProp(http.StatusOK, c.handlers.supportedMethodSet(resp.Hrefs[0].Value))
+			_ = resp.EncodeProp(http.StatusOK, common.DisplayName{Name: common.StoredDisplayName(r.Context(), c.handlers.store, MountResourceID(owner, cc.ID), cc.DisplayName)})
+			c.encodeDescription(&resp, cc)
+			_ = resp.EncodeProp(http.StatusOK, struct {
+				XMLName xml.Name `xml:"http://apple.com/ns/ical/ calendar-color"`
+				Text    string   `xml:",chardata"`
+			}{Text: cc.Color})
+			_ = resp.EncodeProp(http.StatusOK, common.Owner{Href: &common.Href{Value: c.ownerPrincipalForCalendar(cc)}})
+			c.encodeOwnerDisplayName(r, &resp, cc.OwnerUserID)
+			_ = resp.EncodeProp(http.StatusOK, common.CurrentUserPrincipal{Href: &common.Href{Value: c.handlers.principalURL(owner)}})
+			_ = resp.EncodeProp(http.StatusOK, common.PrincipalCollectionSetFor(c.basePath))
+			_ = resp.EncodeProp(http.StatusOK, common.SupportedCompSet{
+				Comp: []common.Comp{{Name: "VEVENT"}, {Name: "VTODO"}, {Name: "VJOURNAL"}, {Name: "VFREEBUSY"}},
 			})
-		}
+			_ = resp.EncodeProp(http.StatusOK, supportedReportSetValue())
+			c.encodeSyncToken(r, &resp, cc.ID)
+			_ = resp.EncodeProp(http.StatusOK, common.AddMember{Href: common.Href{Value: resp.Hrefs[0].Value}})
+			_ = resp.EncodeProp(http.StatusOK, struct {
+				XMLName xml.Name `xml:"http://calendarserver.org/ns/ getctag"`
+				Text    string   `xml:",chardata"`
+			}{Text: c.handlers.tokens.Encode(cc.ID, cc.CTag)})
+			_ = resp.EncodeProp(http.StatusOK, common.GetETag{ETag: common.ETag(c.handlers.tokens.Encode(cc.ID, cc.CTag))})
 
-		resps = append(resps, sharedResp)
+			if eff.CanReadCurrentUserPrivilegeSet() && sel.Wants(common.NSDAV, "current-user-privilege-set") {
+				privs := c.effectiveToPrivileges(eff)
+				_ = resp.EncodeProp(http.StatusOK, common.CurrentUserPrivilegeSet{Privilege: privs})
+			}
 
-		all, err := c.handlers.store.ListAllCalendars(r.Context())
-		if err != nil {
-			c.handlers.logger.Error().Err(err).Msg("failed to list all calendars in PROPFIND home")
-		} else {
-			for _, cc := range all {
-				if cc.OwnerUserID == owner {
-					continue
-				}
-				if eff, aok := visible[cc.URI]; aok && eff.CanRead() {
-					hrefStr := common.JoinURL(sharedBase, cc.URI) + "/"
-					resp := common.Response{Hrefs: []common.Href{{Value: hrefStr}}}
-					_ = resp.EncodeProp(http.StatusOK, common.ResourceType{Collection: &struct{}{}, Calendar: &struct{}{}})
-					_ = resp.EncodeProp(http.StatusOK, common.DisplayName{Name: cc.DisplayName})
-					_ = resp.EncodeProp(http.StatusOK, struct {
-						XMLName xml.Name `xml:"http://apple.com/ns/ical/ calendar-color"`
-						Text    string   `xml:",chardata"`
-					}{Text: cc.Color})
-					_ = resp.EncodeProp(http.StatusOK, common.Owner{Href: &common.Href{Value: c.ownerPrincipalForCalendar(cc)}})
-					_ = resp.EncodeProp(http.StatusOK, common.CurrentUserPrincipal{Href: &common.Href{Value: common.PrincipalURL(c.basePath, owner)}})
-					_ = resp.EncodeProp(http.StatusOK, common.SupportedCompSet{
-						Comp: []common.Comp{{Name: "VEVENT"}, {Name: "VTODO"}, {Name: "VJOURNAL"}},
-					})
-					_ = resp.EncodeProp(http.StatusOK, supportedReportSetValue())
-					_ = resp.EncodeProp(http.StatusOK, struct {
-						XMLName xml.Name `xml:"DAV: sync-token"`
-						Text    string   `xml:",chardata"`
-					}{Text: cc.CTag})
-					_ = resp.EncodeProp(http.StatusOK, struct {
-						XMLName xml.Name `xml:"http://calendarserver.org/ns/ getctag"`
-						Text    string   `xml:",chardata"`
-					}{Text: cc.CTag})
-
-					if eff.CanReadCurrentUserPrivilegeSet() {
-						privs := c.effectiveToPrivileges(eff)
-						_ = resp.EncodeProp(http.StatusOK, common.CurrentUserPrivilegeSet{Privilege: privs})
-					}
-
-					if eff.CanReadACL() {
-						acl := c.buildSharedACL(cc.OwnerUserID, owner, eff)
-						_ = resp.EncodeProp(http.StatusOK, acl)
-					}
-					resps = append(resps, resp)
-				}
+			if eff.CanReadACL() && sel.Wants(common.NSDAV, "acl") {
+				acl := c.buildSharedACL(cc.OwnerUserID, owner, eff)
+				_ = resp.EncodeProp(http.StatusOK, acl)
 			}
+			c.encodeDeadProperties(r, &resp, cc.ID)
+			resps = append(resps, resp)
 		}
 	}
 
 	ms := common.MultiStatus{Responses: resps}
-	if err := common.ServeMultiStatus(w, &ms); err != nil {
-		c.handlers.logger.Error().Err(err).Msg("failed to serve MultiStatus in PROPFIND home")
+	if err := common.ServePropfind(w, r, &ms); err != nil {
+		c.handlers.logger.Error().Ctx(r.Context()).Err(err).Msg("failed to serve MultiStatus in PROPFIND home")
 	}
 }
 
 func (c *CalDAVResourceHandler) PropfindCollection(w http.ResponseWriter, r *http.Request, owner, collection, depth string) {
+	sel := common.PropSelectionFrom(r.Context())
+	if c.handlers.isSchedulingCollection(collection) {
+		c.propfindSchedulingCollection(w, r, owner, collection)
+		return
+	}
+	if c.handlers.isBirthdayCalendar(collection) {
+		c.propfindBirthdayCalendar(w, r, owner)
+		return
+	}
+
 	requesterUID := owner
 
 	cals, err := c.handlers.store.ListCalendarsByOwnerUser(r.Context(), owner)
 	if err != nil {
-		c.handlers.logger.Error().Err(err).Str("owner", owner).Msg("failed to list calendars by owner in PROPFIND collection")
+		c.handlers.logger.Error().Ctx(r.Context()).Err(err).Str("owner", owner).Msg("failed to list calendars by owner in PROPFIND collection")
 		http.Error(w, "storage error", http.StatusInternalServerError)
 		return
 	}
@@ -206,7 +233,7 @@ func (c *CalDAVResourceHandler) PropfindCollection(w http.ResponseWriter, r *htt
 				trueOwner = sc.OwnerUserID
 				isSharedMount = true
 			} else if err != nil {
-				c.handlers.logger.Error().Err(err).
+				c.handlers.logger.Error().Ctx(r.Context()).Err(err).
 					Str("calendar", sc.URI).
 					Str("owner", sc.OwnerUserID).
 					Msg("ACL check failed in PROPFIND collection reading shared calendar")
@@ -215,28 +242,36 @@ func (c *CalDAVResourceHandler) PropfindCollection(w http.ResponseWriter, r *htt
 	}
 
 	if cal == nil && collection == "shared" {
-		resp := common.Response{
-			Hrefs: []common.Href{{Value: common.JoinURL(c.basePath, "calendars", owner, "shared") + "/"}},
-		}
-		_ = resp.EncodeProp(http.StatusOK, common.ResourceType{Collection: &struct{}{}})
-		_ = resp.EncodeProp(http.StatusOK, common.DisplayName{Name: "Shared"})
-
 		pr := common.MustPrincipal(r.Context())
-		_ = resp.EncodeProp(http.StatusOK, common.CurrentUserPrincipal{Href: &common.Href{Value: common.PrincipalURL(c.basePath, pr.UserID)}})
-
-		_ = resp.EncodeProp(http.StatusOK, common.CurrentUserPrivilegeSet{
-			Privilege: []common.Privilege{{Read: &struct{}{}}},
-		})
+		u := &directory.User{UID: pr.UserID, DN: pr.UserDN, DisplayName: pr.Display}
+		visible, err := c.handlers.aclProv.VisibleCalendars(r.Context(), u)
+		if err != nil {
+			c.handlers.logger.Error().Ctx(r.Context()).Err(err).Str("user", pr.UserID).Msg("failed to compute visible calendars in PROPFIND shared collection")
+			http.Error(w, "acl error", http.StatusInternalServerError)
+			return
+		}
+		shared, err := c.handlers.sharedCalendars(r.Context(), owner, visible)
+		if err != nil {
+			c.handlers.logger.Error().Ctx(r.Context()).Err(err).Msg("failed to list all calendars in PROPFIND shared collection")
+			http.Error(w, "storage error", http.StatusInternalServerError)
+			return
+		}
+		if !c.handlers.showSharedRoot(shared) {
+			c.handlers.logger.Debug().Ctx(r.Context()).Str("owner", owner).Msg("shared collection hidden - no shared calendars")
+			http.NotFound(w, r)
+			return
+		}
 
+		resp := c.sharedRootResponse(common.JoinURL(c.basePath, "calendars", owner, "shared")+"/", pr.UserID, shared)
 		ms := common.MultiStatus{Responses: []common.Response{resp}}
-		if err := common.ServeMultiStatus(w, &ms); err != nil {
-			c.handlers.logger.Error().Err(err).Msg("failed to serve MultiStatus for PROPFIND shared collection")
+		if err := common.ServePropfind(w, r, &ms); err != nil {
+			c.handlers.logger.Error().Ctx(r.Context()).Err(err).Msg("failed to serve MultiStatus for PROPFIND shared collection")
 		}
 		return
 	}
 
 	if cal == nil {
-		c.handlers.logger.Debug().Str("owner", owner).Str("collection", collection).Msg("collection not found in PROPFIND")
+		c.handlers.logger.Debug().Ctx(r.Context()).Str("owner", owner).Str("collection", collection).Msg("collection not found in PROPFIND")
 		http.NotFound(w, r)
 		return
 	}
@@ -245,10 +280,10 @@ func (c *CalDAVResourceHandler) PropfindCollection(w http.ResponseWriter, r *htt
 	var ownerHref string
 	if isSharedMount {
 		href = common.JoinURL(common.CalendarSharedRoot(c.basePath, requesterUID), collection) + "/"
-		ownerHref = common.PrincipalURL(c.basePath, trueOwner)
+		ownerHref = c.handlers.principalURL(trueOwner)
 	} else {
 		href = common.CalendarPath(c.basePath, owner, collection)
-		ownerHref = common.PrincipalURL(c.basePath, owner)
+		ownerHref = c.handlers.principalURL(owner)
 	}
 
 	pr := common.MustPrincipal(r.Context())
@@ -257,59 +292,79 @@ func (c *CalDAVResourceHandler) PropfindCollection(w http.ResponseWriter, r *htt
 		Hrefs: []common.Href{{Value: href}},
 	}
 
-	_ = propResp.EncodeProp(http.StatusOK, common.ResourceType{Collection: &struct{}{}, Calendar: &struct{}{}})
-	_ = propResp.EncodeProp(http.StatusOK, common.DisplayName{Name: cal.DisplayName})
+	if isSharedMount {
+		_ = propResp.EncodeProp(http.StatusOK, common.MakeSharedCalendarResourcetype())
+	} else {
+		_ = propResp.EncodeProp(http.StatusOK, common.MakeCalendarResourcetype())
+	}
+	_ = propResp.EncodeProp(http.StatusOK, c.handlers.supportedMethodSet(propResp.Hrefs[0].Value))
+	displayName := cal.DisplayName
+	if isSharedMount {
+		displayName = common.StoredDisplayName(r.Context(), c.handlers.store, MountResourceID(requesterUID, cal.ID), displayName)
+	}
+	_ = propResp.EncodeProp(http.StatusOK, common.DisplayName{Name: displayName})
 	_ = propResp.EncodeProp(http.StatusOK, common.Owner{Href: &common.Href{Value: ownerHref}})
-	_ = propResp.EncodeProp(http.StatusOK, common.CurrentUserPrincipal{Href: &common.Href{Value: common.PrincipalURL(c.basePath, pr.UserID)}})
+	if isSharedMount {
+		c.encodeOwnerDisplayName(r, &propResp, trueOwner)
+	}
+	_ = propResp.EncodeProp(http.StatusOK, common.CurrentUserPrincipal{Href: &common.Href{Value: c.handlers.principalURL(pr.UserID)}})
+	_ = propResp.EncodeProp(http.StatusOK, common.PrincipalCollectionSetFor(c.basePath))
 
 	_ = propResp.EncodeProp(http.StatusOK, common.SupportedCompSet{
-		Comp: []common.Comp{{Name: "VEVENT"}, {Name: "VTODO"}, {Name: "VJOURNAL"}},
+		Comp: []common.Comp{{Name: "VEVENT"}, {Name: "VTODO"}, {Name: "VJOURNAL"}, {Name: "VFREEBUSY"}},
 	})
 	_ = propResp.EncodeProp(http.StatusOK, supportedReportSetValue())
-	_ = propResp.EncodeProp(http.StatusOK, struct {
-		XMLName xml.Name `xml:"DAV: sync-token"`
-		Text    string   `xml:",chardata"`
-	}{Text: cal.CTag})
+	c.encodeSyncToken(r, &propResp, cal.ID)
+	_ = propResp.EncodeProp(http.StatusOK, common.AddMember{Href: common.Href{Value: propResp.Hrefs[0].Value}})
 	_ = propResp.EncodeProp(http.StatusOK, struct {
 		XMLName xml.Name `xml:"http://calendarserver.org/ns/ getctag"`
 		Text    string   `xml:",chardata"`
-	}{Text: cal.CTag})
+	}{Text: c.handlers.tokens.Encode(cal.ID, cal.CTag)})
+	_ = propResp.EncodeProp(http.StatusOK, common.GetETag{ETag: common.ETag(c.handlers.tokens.Encode(cal.ID, cal.CTag))})
 
 	if !cal.UpdatedAt.IsZero() {
 		_ = propResp.EncodeProp(http.StatusOK, common.GetLastModified{LastModified: common.TimeText(cal.UpdatedAt.UTC())})
 	}
 
-	_ = propResp.EncodeProp(http.StatusOK, c.buildSupportedPrivilegeSet())
+	if sel.Wants(common.NSDAV, "supported-privilege-set") {
+		_ = propResp.EncodeProp(http.StatusOK, c.buildSupportedPrivilegeSet())
+	}
 
+	wantsPrivs := sel.Wants(common.NSDAV, "current-user-privilege-set") || sel.Wants(common.NSDAV, "acl")
 	if isSharedMount && trueOwner != "" && pr.UserID != trueOwner {
-		if eff, err := c.handlers.aclProv.Effective(r.Context(), &directory.User{UID: pr.UserID, DN: pr.UserDN, DisplayName: pr.Display}, collection); err == nil {
-			if eff.CanReadCurrentUserPrivilegeSet() {
-				currentUserPrivs := c.effectiveToPrivileges(eff)
-				_ = propResp.EncodeProp(http.StatusOK, common.CurrentUserPrivilegeSet{Privilege: currentUserPrivs})
-			}
+		if wantsPrivs {
+			if eff, err := c.handlers.aclProv.Effective(r.Context(), &directory.User{UID: pr.UserID, DN: pr.UserDN, DisplayName: pr.Display}, collection); err == nil {
+				if eff.CanReadCurrentUserPrivilegeSet() && sel.Wants(common.NSDAV, "current-user-privilege-set") {
+					currentUserPrivs := c.effectiveToPrivileges(eff)
+					_ = propResp.EncodeProp(http.StatusOK, common.CurrentUserPrivilegeSet{Privilege: currentUserPrivs})
+				}
 
-			if eff.CanReadACL() {
-				acl := c.buildCollectionACL(trueOwner, pr.UserID, isSharedMount, eff)
-				_ = propResp.EncodeProp(http.StatusOK, acl)
+				if eff.CanReadACL() && sel.Wants(common.NSDAV, "acl") {
+					acl := c.buildCollectionACL(trueOwner, pr.UserID, isSharedMount, eff)
+					_ = propResp.EncodeProp(http.StatusOK, acl)
+				}
 			}
 		}
-	} else {
+	} else if sel.Wants(common.NSDAV, "acl") {
 		acl := c.buildOwnerACL(pr.UserID)
 		_ = propResp.EncodeProp(http.StatusOK, acl)
 	}
 
-	_ = propResp.EncodeProp(http.StatusOK, struct {
-		XMLName xml.Name `xml:"urn:ietf:params:xml:ns:caldav calendar-description"`
-		Text    string   `xml:",chardata"`
-	}{Text: cal.Description})
+	c.encodeDescription(&propResp, cal)
 	_ = propResp.EncodeProp(http.StatusOK, struct {
 		XMLName xml.Name `xml:"http://apple.com/ns/ical/ calendar-color"`
 		Text    string   `xml:",chardata"`
 	}{Text: cal.Color})
-	_ = propResp.EncodeProp(http.StatusOK, struct {
-		XMLName xml.Name `xml:"urn:ietf:params:xml:ns:caldav calendar-timezone"`
-		Text    string   `xml:",chardata"`
-	}{Text: c.getCalendarTimezone(cal)})
+	c.encodeDefaultAlarms(&propResp, cal)
+	if !isSharedMount {
+		c.encodeScheduleTransp(&propResp)
+	}
+	if tz, ok := c.getCalendarTimezone(r.Context(), cal); ok {
+		_ = propResp.EncodeProp(http.StatusOK, struct {
+			XMLName xml.Name `xml:"urn:ietf:params:xml:ns:caldav calendar-timezone"`
+			Text    string   `xml:",chardata"`
+		}{Text: tz})
+	}
 
 	_ = propResp.EncodeProp(http.StatusOK, common.SupportedCalData{ContentType: "text/calendar", Version: "2.0"})
 	_ = propResp.EncodeProp(http.StatusOK, struct {
@@ -319,25 +374,26 @@ func (c *CalDAVResourceHandler) PropfindCollection(w http.ResponseWriter, r *htt
 	_ = propResp.EncodeProp(http.StatusOK, struct {
 		XMLName xml.Name `xml:"urn:ietf:params:xml:ns:caldav min-date-time"`
 		Text    string   `xml:",chardata"`
-	}{Text: "19000101T000000Z"})
+	}{Text: c.handlers.cfg.CalDAV.MinDateTime})
 	_ = propResp.EncodeProp(http.StatusOK, struct {
 		XMLName xml.Name `xml:"urn:ietf:params:xml:ns:caldav max-date-time"`
 		Text    string   `xml:",chardata"`
-	}{Text: "20380119T031407Z"})
+	}{Text: c.handlers.cfg.CalDAV.MaxDateTime})
 	_ = propResp.EncodeProp(http.StatusOK, struct {
 		XMLName xml.Name `xml:"urn:ietf:params:xml:ns:caldav max-instances"`
 		N       int      `xml:",chardata"`
-	}{N: 1000})
+	}{N: c.handlers.cfg.CalDAV.MaxInstances})
 	_ = propResp.EncodeProp(http.StatusOK, struct {
 		XMLName xml.Name `xml:"urn:ietf:params:xml:ns:caldav max-attendees-per-instance"`
 		N       int      `xml:",chardata"`
-	}{N: 100})
+	}{N: c.handlers.cfg.CalDAV.MaxAttendees})
 
 	_ = propResp.EncodeProp(http.StatusOK, c.getSupportedCollationSetValue())
+	c.encodeDeadProperties(r, &propResp, cal.ID)
 
 	ms := common.MultiStatus{Responses: []common.Response{propResp}}
-	if err := common.ServeMultiStatus(w, &ms); err != nil {
-		c.handlers.logger.Error().Err(err).Msg("failed to serve MultiStatus for PROPFIND collection")
+	if err := common.ServePropfind(w, r, &ms); err != nil {
+		c.handlers.logger.Error().Ctx(r.Context()).Err(err).Msg("failed to serve MultiStatus for PROPFIND collection")
 	}
 }
 
@@ -345,7 +401,7 @@ func (c *CalDAVResourceHandler) PropfindObject(w http.ResponseWriter, r *http.Re
 	uid := strings.TrimSuffix(object, filepath.Ext(object))
 	calendarID, calOwner, err := c.handlers.resolveCalendar(r.Context(), owner, collection)
 	if err != nil {
-		c.handlers.logger.Error().Err(err).
+		c.handlers.logger.Error().Ctx(r.Context()).Err(err).
 			Str("owner", owner).
 			Str("collection", collection).
 			Str("object", object).
@@ -356,7 +412,7 @@ func (c *CalDAVResourceHandler) PropfindObject(w http.ResponseWriter, r *http.Re
 	pr := common.MustPrincipal(r.Context())
 	okRead, err := c.handlers.aclCheckRead(r.Context(), pr, collection, calOwner)
 	if err != nil || !okRead {
-		c.handlers.logger.Debug().Err(err).
+		c.handlers.logger.Debug().Ctx(r.Context()).Err(err).
 			Bool("can_read", okRead).
 			Str("user", pr.UserID).
 			Str("collection", collection).
@@ -364,9 +420,9 @@ func (c *CalDAVResourceHandler) PropfindObject(w http.ResponseWriter, r *http.Re
 		http.Error(w, "forbidden", http.StatusForbidden)
 		return
 	}
-	obj, err := c.handlers.store.GetObject(r.Context(), calendarID, uid)
+	obj, err := c.handlers.getObject(r.Context(), calendarID, uid)
 	if err != nil {
-		c.handlers.logger.Debug().Err(err).
+		c.handlers.logger.Debug().Ctx(r.Context()).Err(err).
 			Str("calendarID", calendarID).
 			Str("uid", uid).
 			Msg("object not found in PROPFIND object")
@@ -379,29 +435,96 @@ func (c *CalDAVResourceHandler) PropfindObject(w http.ResponseWriter, r *http.Re
 		Hrefs: []common.Href{{Value: hrefStr}},
 	}
 	_ = resp.EncodeProp(http.StatusOK, common.GetContentType{Type: "text/calendar; charset=utf-8"})
+	_ = resp.EncodeProp(http.StatusOK, c.handlers.supportedMethodSet(hrefStr))
 	if !obj.UpdatedAt.IsZero() {
 		_ = resp.EncodeProp(http.StatusOK, common.GetLastModified{LastModified: common.TimeText(obj.UpdatedAt.UTC())})
 	}
+	if common.PropSelectionFrom(r.Context()).Wants(common.NSDAV, "current-user-privilege-set") {
+		if pr.UserID == calOwner {
+			_ = resp.EncodeProp(http.StatusOK, common.CurrentUserPrivilegeSet{
+				Privilege: []common.Privilege{{All: &struct{}{}}},
+			})
+		} else if eff, err := c.handlers.aclProv.Effective(r.Context(), &directory.User{UID: pr.UserID, DN: pr.UserDN, DisplayName: pr.Display}, collection); err == nil && eff.CanReadCurrentUserPrivilegeSet() {
+			_ = resp.EncodeProp(http.StatusOK, common.CurrentUserPrivilegeSet{Privilege: c.objectPrivileges(eff)})
+		}
+	}
 
 	ms := common.MultiStatus{Responses: []common.Response{resp}}
-	if err := common.ServeMultiStatus(w, &ms); err != nil {
-		c.handlers.logger.Error().Err(err).Msg("failed to serve MultiStatus for PROPFIND object")
+	if err := common.ServePropfind(w, r, &ms); err != nil {
+		c.handlers.logger.Error().Ctx(r.Context()).Err(err).Msg("failed to serve MultiStatus for PROPFIND object")
 	}
 }
 
+// encodeDescription emits the CALDAV:calendar-description of cal.
+func (c *CalDAVResourceHandler) encodeDescription(resp *common.Response, cal *storage.Calendar) {
+	_ = resp.EncodeProp(http.StatusOK, struct {
+		XMLName xml.Name `xml:"urn:ietf:params:xml:ns:caldav calendar-description"`
+		Text    string   `xml:",chardata"`
+	}{Text: cal.Description})
+}
+
+// sharedRootResponse describes the pseudo-collection that lists the
+// calendars shared with a user.
+func (c *CalDAVResourceHandler) sharedRootResponse(href, requester string, shared []*storage.Calendar) common.Response {
+	resp := common.Response{Hrefs: []common.Href{{Value: href}}}
+	_ = resp.EncodeProp(http.StatusOK, common.MakeSharedRootResourcetype())
+	_ = resp.EncodeProp(http.StatusOK, c.handlers.supportedMethodSet(href))
+	_ = resp.EncodeProp(http.StatusOK, common.DisplayName{Name: "Shared"})
+	_ = resp.EncodeProp(http.StatusOK, common.CurrentUserPrincipal{Href: &common.Href{Value: c.handlers.principalURL(requester)}})
+	_ = resp.EncodeProp(http.StatusOK, common.PrincipalCollectionSetFor(c.basePath))
+	_ = resp.EncodeProp(http.StatusOK, common.CurrentUserPrivilegeSet{Privilege: c.sharedRootPrivileges()})
+	encodeMemberValidators(&resp, shared)
+	return resp
+}
+
 func (c *CalDAVResourceHandler) GetHomeSetProperty(basePath, uid string) interface{} {
 	return &common.Href{Value: common.CalendarHome(basePath, uid)}
 }
 
+// encodeOwnerDisplayName adds the owner's directory display name so sharing
+// UIs can show who a shared calendar belongs to.
+func (c *CalDAVResourceHandler) encodeOwnerDisplayName(r *http.Request, resp *common.Response, ownerUID string) {
+	if !common.PropSelectionFrom(r.Context()).Wants("http://sabredav.org/ns", "owner-displayname") {
+		return
+	}
+	name := c.handlers.ownerDisplayName(r.Context(), ownerUID)
+	if name == "" {
+		return
+	}
+	_ = resp.EncodeProp(http.StatusOK, struct {
+		XMLName xml.Name `xml:"http://sabredav.org/ns owner-displayname"`
+		Text    string   `xml:",chardata"`
+	}{Text: name})
+}
+
 func (c *CalDAVResourceHandler) ownerPrincipalForCalendar(cal *storage.Calendar) string {
 	if cal.OwnerUserID != "" {
-		return common.PrincipalURL(c.basePath, cal.OwnerUserID)
+		return c.handlers.principalURL(cal.OwnerUserID)
 	}
-	return common.JoinURL(c.basePath, "principals")
+	return common.PrincipalCollectionURL(c.basePath)
+}
+
+func (c *CalDAVResourceHandler) encodeDefaultAlarms(resp *common.Response, cal *storage.Calendar) {
+	_ = resp.EncodeProp(http.StatusOK, struct {
+		XMLName xml.Name `xml:"http://calendarserver.org/ns/ default-alarm-vevent-datetime"`
+		Text    string   `xml:",chardata"`
+	}{Text: cal.DefaultAlarmVEventDateTime})
+	_ = resp.EncodeProp(http.StatusOK, struct {
+		XMLName xml.Name `xml:"http://calendarserver.org/ns/ default-alarm-vevent-date"`
+		Text    string   `xml:",chardata"`
+	}{Text: cal.DefaultAlarmVEventDate})
 }
 
-func (c *CalDAVResourceHandler) getCalendarTimezone(_ *storage.Calendar) string {
-	return "BEGIN:VTIMEZONE\r\nTZID:UTC\r\nEND:VTIMEZONE\r\n"
+// getCalendarTimezone returns the server time zone as a VCALENDAR holding
+// its VTIMEZONE. It reports false when the zone is unknown, in which case
+// the property is left out.
+func (c *CalDAVResourceHandler) getCalendarTimezone(ctx context.Context, _ *storage.Calendar) (string, bool) {
+	data, err := ical.BuildTimezone(c.handlers.cfg.Timezone, c.handlers.cfg.ICS.BuildProdID(), time.Now().Year())
+	if err != nil {
+		c.handlers.logger.Debug().Ctx(ctx).Err(err).Str("tz", c.handlers.cfg.Timezone).Msg("failed to build calendar-timezone")
+		return "", false
+	}
+	return string(data), true
 }
 
 func (c *CalDAVResourceHandler) getMaxResourceSize() int {
@@ -418,12 +541,46 @@ func (c *CalDAVResourceHandler) getSupportedCollationSetValue() interface{} {
 	}
 }
 
-func supportedReportSetValue() interface{} {
+func supportedReportSetValue() *common.SupportedReportSet {
 	return &common.SupportedReportSet{
 		SupportedReport: []common.SupportedReport{
 			{Report: common.ReportType{CalendarQuery: &struct{}{}}},
 			{Report: common.ReportType{CalendarMultiget: &struct{}{}}},
 			{Report: common.ReportType{SyncCollection: &struct{}{}}},
+			{Report: common.ReportType{FreeBusyQuery: &struct{}{}}},
 		},
 	}
 }
+
+// objectReportSetValue lists the reports that can target a single calendar
+// object resource.
+func objectReportSetValue() *common.SupportedReportSet {
+	return &common.SupportedReportSet{
+		SupportedReport: []common.SupportedReport{
+			{Report: common.ReportType{CalendarQuery: &struct{}{}}},
+			{Report: common.ReportType{CalendarMultiget: &struct{}{}}},
+		},
+	}
+}
+
+// encodeDeadProperties adds the dead properties set on a calendar through
+// PROPPATCH.
+func (c *CalDAVResourceHandler) encodeDeadProperties(r *http.Request, resp *common.Response, id string) {
+	if err := common.EncodeDeadProperties(r.Context(), c.handlers.store, id, resp); err != nil {
+		c.handlers.logger.Error().Ctx(r.Context()).Err(err).Str("calendarID", id).Msg("failed to load dead properties")
+	}
+}
+
+// encodeSyncToken adds the DAV:sync-token a sync-collection REPORT on the
+// calendar would return, so clients can start WebDAV-Sync from PROPFIND.
+func (c *CalDAVResourceHandler) encodeSyncToken(r *http.Request, resp *common.Response, id string) {
+	tok, _, err := c.handlers.store.GetSyncInfo(r.Context(), id)
+	if err != nil {
+		c.handlers.logger.Error().Ctx(r.Context()).Err(err).Str("calendarID", id).Msg("failed to get sync info")
+		return
+	}
+	_ = resp.EncodeProp(http.StatusOK, struct {
+		XMLName xml.Name `xml:"DAV: sync-token"`
+		Text    string   `xml:",chardata"`
+	}{Text: c.handlers.tokens.Encode(id, tok)})
+}
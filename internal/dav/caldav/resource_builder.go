@@ -3,6 +3,7 @@ package caldav
 import (
 	"github.com/sonroyaalmerol/ldap-dav/internal/acl"
 	"github.com/sonroyaalmerol/ldap-dav/internal/dav/common"
+	"github.com/sonroyaalmerol/ldap-dav/internal/directory"
 )
 
 func (c *CalDAVResourceHandler) buildSupportedPrivilegeSet() common.SupportedPrivilegeSet {
@@ -124,13 +125,58 @@ func (c *CalDAVResourceHandler) effectiveToPrivileges(eff acl.Effective) []commo
 	return privs
 }
 
+// objectPrivileges is the current-user-privilege-set of a calendar object
+// under a collection granting eff. Bind and unbind act on the collection,
+// not on its members, so an object only carries the read and write halves;
+// DAV:write is reported when both write-properties and write-content are
+// granted.
+func (c *CalDAVResourceHandler) objectPrivileges(eff acl.Effective) []common.Privilege {
+	var privs []common.Privilege
+	if eff.CanRead() {
+		privs = append(privs, common.Privilege{Read: &struct{}{}})
+	}
+	if eff.WriteProps && eff.WriteContent {
+		privs = append(privs, common.Privilege{Write: &struct{}{}})
+	} else {
+		if eff.WriteProps {
+			privs = append(privs, common.Privilege{WriteProperties: &struct{}{}})
+		}
+		if eff.WriteContent {
+			privs = append(privs, common.Privilege{WriteContent: &struct{}{}})
+		}
+	}
+	if eff.CanUnlock() {
+		privs = append(privs, common.Privilege{Unlock: &struct{}{}})
+	}
+	if eff.CanReadACL() {
+		privs = append(privs, common.Privilege{ReadACL: &struct{}{}})
+	}
+	return append(privs, common.Privilege{ReadCurrentUserPrivilegeSet: &struct{}{}})
+}
+
+// sharedRootPrivileges is the current-user-privilege-set of the shared
+// pseudo-collection, as configured in CALDAV_SHARED_ROOT_PRIVILEGES.
+func (c *CalDAVResourceHandler) sharedRootPrivileges() []common.Privilege {
+	g := directory.PrivilegesFromList("", c.handlers.cfg.CalDAV.SharedRootPrivileges)
+	return c.effectiveToPrivileges(acl.Effective{
+		Read:                        g.Read,
+		WriteProps:                  g.WriteProps,
+		WriteContent:                g.WriteContent,
+		Bind:                        g.Bind,
+		Unbind:                      g.Unbind,
+		Unlock:                      g.Unlock,
+		ReadACL:                     g.ReadACL,
+		ReadCurrentUserPrivilegeSet: g.ReadCurrentUserPrivilegeSet,
+	})
+}
+
 // Owner gets DAV:all which contains everything
 func (c *CalDAVResourceHandler) buildOwnerACL(owner string) common.ACL {
 	return common.ACL{
 		ACE: []common.ACE{{
 			Principal: common.Principal{
 				Href: &common.Href{
-					Value: common.PrincipalURL(c.basePath, owner),
+					Value: c.handlers.principalURL(owner),
 				},
 			},
 			Grant: &common.Grant{
@@ -148,7 +194,7 @@ func (c *CalDAVResourceHandler) buildSharedACL(trueOwner, requester string, eff
 	aces = append(aces, common.ACE{
 		Principal: common.Principal{
 			Href: &common.Href{
-				Value: common.PrincipalURL(c.basePath, trueOwner),
+				Value: c.handlers.principalURL(trueOwner),
 			},
 		},
 		Grant: &common.Grant{
@@ -164,7 +210,7 @@ func (c *CalDAVResourceHandler) buildSharedACL(trueOwner, requester string, eff
 			aces = append(aces, common.ACE{
 				Principal: common.Principal{
 					Href: &common.Href{
-						Value: common.PrincipalURL(c.basePath, requester),
+						Value: c.handlers.principalURL(requester),
 					},
 				},
 				Grant: &common.Grant{
@@ -180,9 +226,9 @@ func (c *CalDAVResourceHandler) buildSharedACL(trueOwner, requester string, eff
 func (c *CalDAVResourceHandler) buildCollectionACL(trueOwner, requesterID string, isSharedMount bool, eff acl.Effective) common.ACL {
 	var aces []common.ACE
 
-	ownerPrincipalURL := common.PrincipalURL(c.basePath, trueOwner)
+	ownerPrincipalURL := c.handlers.principalURL(trueOwner)
 	if trueOwner == "" {
-		ownerPrincipalURL = common.PrincipalURL(c.basePath, requesterID)
+		ownerPrincipalURL = c.handlers.principalURL(requesterID)
 	}
 
 	// Owner (or requester if no owner) gets DAV:all
@@ -203,7 +249,7 @@ func (c *CalDAVResourceHandler) buildCollectionACL(trueOwner, requesterID string
 			aces = append(aces, common.ACE{
 				Principal: common.Principal{
 					Href: &common.Href{
-						Value: common.PrincipalURL(c.basePath, requesterID),
+						Value: c.handlers.principalURL(requesterID),
 					},
 				},
 				Grant: &common.Grant{
@@ -0,0 +1,379 @@
+package caldav
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"sync"
+
+	"github.com/sonroyaalmerol/ldap-dav/internal/directory"
+	"github.com/sonroyaalmerol/ldap-dav/internal/storage"
+	"github.com/sonroyaalmerol/ldap-dav/pkg/ical"
+)
+
+// bookResources answers for the rooms and equipment invited to an event
+// the owner organizes. Each ROOM or RESOURCE attendee whose address maps to
+// a resource calendar is accepted when that calendar is free for every
+// upcoming instance and declined otherwise, including when the calendar
+// already holds the UID as another organizer's booking. The answer is
+// given while the reply is still pending and again whenever the organizer
+// changes the event significantly (RFC 6638 section 3.2.10), so a
+// rescheduled meeting is checked against the room's new slot. Accepted
+// bookings are kept on the resource's calendar and removed when the
+// resource declines or is dropped from the event. old is the previously
+// stored organizer copy, if any; the organizer's copy is returned with the
+// replies filled in, along with the bookings to apply with applyBookings
+// once that copy is stored. The resource calendars stay locked until the
+// caller releases the bookings, so no other booking can take the slot in
+// between.
+func (h *Handlers) bookResources(r *http.Request, owner, uid string, old, data []byte) ([]byte, *resourceBookings) {
+	if len(h.cfg.CalDAV.ResourceCalendars) == 0 {
+		return data, nil
+	}
+	ctx := r.Context()
+	if !h.organizes(ctx, owner, data) {
+		return data, h.heldResources(ctx, owner, uid, old, nil)
+	}
+	attendees, err := ical.Attendees(data)
+	if err != nil {
+		return data, nil
+	}
+	rescheduled := old != nil && hasSignificantChange(old, data)
+	organizer := ical.Organizer(data)
+
+	// Lock every resource calendar involved, in a fixed order so two events
+	// booking the same rooms cannot deadlock.
+	bookings := &resourceBookings{uid: uid, organizer: organizer, locks: h.bookings}
+	calOf := map[string]*storage.Calendar{}
+	var ids []string
+	for _, a := range attendees {
+		if cal := h.resourceCalendar(ctx, a); cal != nil {
+			calOf[a.Address] = cal
+			ids = append(ids, cal.ID)
+		}
+	}
+	slices.Sort(ids)
+	for _, id := range slices.Compact(ids) {
+		bookings.lock(id)
+	}
+
+	var instances []*ical.Event
+	invited := map[string]bool{}
+	for _, a := range attendees {
+		cal := calOf[a.Address]
+		if cal == nil {
+			continue
+		}
+		invited[a.Address] = true
+
+		// A booking with the same UID made by someone else is a conflict,
+		// not a copy of this event to overwrite.
+		taken := h.bookingHeldByOther(ctx, cal, uid, organizer)
+
+		partStat := a.PartStat
+		if partStat == "NEEDS-ACTION" || rescheduled || (taken && partStat == "ACCEPTED") {
+			if instances == nil && !taken {
+				instances = h.upcomingInstances(ctx, data)
+			}
+			partStat = "ACCEPTED"
+			if taken || len(instances) == 0 || h.conflictsWithBusy(ctx, &directory.User{Mail: a.Address}, []*storage.Calendar{cal}, uid, instances) {
+				partStat = "DECLINED"
+			}
+			if updated, _, err := ical.SetAttendeePartStat(data, a.Address, partStat); err == nil {
+				data = updated
+			}
+			h.logger.Info().Ctx(ctx).
+				Str("resource", a.Address).
+				Str("uid", uid).
+				Str("partstat", partStat).
+				Msg("answered resource invitation")
+		}
+
+		if partStat == "ACCEPTED" {
+			bookings.store = append(bookings.store, resourceBooking{cal: cal, address: a.Address})
+		} else if !taken {
+			bookings.remove = append(bookings.remove, resourceBooking{cal: cal, address: a.Address})
+		}
+	}
+
+	if dropped := h.heldResources(ctx, owner, uid, old, invited); dropped != nil {
+		bookings.remove = append(bookings.remove, dropped.remove...)
+	}
+	return data, bookings
+}
+
+// resourceBookings are the changes bookResources decided on for resource
+// calendars. They are applied by applyBookings only after the organizer's
+// copy is stored, so a failed PUT books nothing.
+type resourceBookings struct {
+	uid       string
+	organizer string
+	store     []resourceBooking
+	remove    []resourceBooking
+	locks     *calendarLocks
+	locked    []string
+}
+
+// resourceBooking is a resource calendar together with the attendee
+// address it answers for.
+type resourceBooking struct {
+	cal     *storage.Calendar
+	address string
+}
+
+// lock takes the booking lock of a resource calendar until release.
+func (b *resourceBookings) lock(calendarID string) {
+	b.locks.lock(calendarID)
+	b.locked = append(b.locked, calendarID)
+}
+
+// release gives up the calendar locks taken by bookResources. It is safe to
+// call on nil bookings and more than once.
+func (b *resourceBookings) release() {
+	if b == nil {
+		return
+	}
+	for _, id := range b.locked {
+		b.locks.unlock(id)
+	}
+	b.locked = nil
+}
+
+// calendarLocks serializes the free-busy check and the booking write per
+// resource calendar within this process. Entries are dropped once nobody
+// holds or waits for them.
+type calendarLocks struct {
+	mu    sync.Mutex
+	locks map[string]*calendarLock
+}
+
+type calendarLock struct {
+	sync.Mutex
+	refs int
+}
+
+func (l *calendarLocks) lock(id string) {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*calendarLock)
+	}
+	k := l.locks[id]
+	if k == nil {
+		k = &calendarLock{}
+		l.locks[id] = k
+	}
+	k.refs++
+	l.mu.Unlock()
+	k.Lock()
+}
+
+func (l *calendarLocks) unlock(id string) {
+	l.mu.Lock()
+	k := l.locks[id]
+	k.refs--
+	if k.refs == 0 {
+		delete(l.locks, id)
+	}
+	l.mu.Unlock()
+	k.Unlock()
+}
+
+// applyBookings writes the organizer's stored copy data to the calendars
+// of the accepting resources and removes the bookings given up. Each
+// booking is checked for conflicts once more right before it is written,
+// since the resource calendar can also change outside bookResources; a
+// booking that no longer fits is not written and the resource is declined
+// in the organizer's copy on calendarID instead. It reports whether that
+// copy was changed.
+func (h *Handlers) applyBookings(ctx context.Context, calendarID string, b *resourceBookings, data []byte) bool {
+	if b == nil {
+		return false
+	}
+	declined := false
+	var instances []*ical.Event
+	for _, res := range b.store {
+		if instances == nil {
+			instances = h.upcomingInstances(ctx, data)
+		}
+		if h.bookingHeldByOther(ctx, res.cal, b.uid, b.organizer) ||
+			len(instances) > 0 && h.conflictsWithBusy(ctx, &directory.User{Mail: res.address}, []*storage.Calendar{res.cal}, b.uid, instances) {
+			h.logger.Warn().Ctx(ctx).
+				Str("resource", res.address).
+				Str("uid", b.uid).
+				Msg("resource became busy before booking - declining")
+			declined = h.declineResource(ctx, calendarID, b.uid, res.address) || declined
+			continue
+		}
+		h.storeBooking(ctx, res, b.uid, b.organizer, data)
+	}
+	for _, res := range b.remove {
+		h.removeBooking(ctx, res, b.uid, b.organizer)
+	}
+	return declined
+}
+
+// declineResource sets the resource's PARTSTAT to DECLINED in the stored
+// organizer copy of uid on calendarID, reporting whether it was changed.
+func (h *Handlers) declineResource(ctx context.Context, calendarID, uid, address string) bool {
+	obj, err := h.store.GetObject(ctx, calendarID, uid)
+	if err != nil || obj == nil {
+		return false
+	}
+	updated, changed, err := ical.SetAttendeePartStat([]byte(obj.Data), address, "DECLINED")
+	if err != nil || !changed {
+		return false
+	}
+	declined := &storage.Object{
+		CalendarID:  calendarID,
+		UID:         uid,
+		Data:        string(updated),
+		Component:   obj.Component,
+		ScheduleTag: nextScheduleTag([]byte(obj.Data), obj.ScheduleTag, updated),
+	}
+	declined.StartAt, declined.EndAt, declined.HasRecurrence = ical.EventWindow(updated)
+	if err := h.store.PutObject(ctx, declined); err != nil {
+		h.logger.Error().Ctx(ctx).Err(err).
+			Str("calendarID", calendarID).
+			Str("uid", uid).
+			Msg("failed to decline resource in organizer copy")
+		return false
+	}
+	if _, _, err := h.store.RecordChange(ctx, calendarID, uid, false); err != nil {
+		h.logger.Error().Ctx(ctx).Err(err).
+			Str("calendarID", calendarID).
+			Str("uid", uid).
+			Msg("RecordChange failed for declined resource")
+	}
+	h.notifyDelivery(ctx, "REPLY", calendarID, uid, address, ical.Organizer(updated))
+	return true
+}
+
+// releaseResources removes the bookings the owner's event old holds on
+// resource calendars. It is used when the event is deleted.
+func (h *Handlers) releaseResources(ctx context.Context, owner, uid string, old []byte) {
+	h.applyBookings(ctx, "", h.heldResources(ctx, owner, uid, old, nil), nil)
+}
+
+// heldResources returns the removal of the bookings the owner's event old
+// holds on resource calendars, except for the resources in keep, or nil
+// when there are none to consider.
+func (h *Handlers) heldResources(ctx context.Context, owner, uid string, old []byte, keep map[string]bool) *resourceBookings {
+	if old == nil || len(h.cfg.CalDAV.ResourceCalendars) == 0 || !h.organizes(ctx, owner, old) {
+		return nil
+	}
+	attendees, err := ical.Attendees(old)
+	if err != nil {
+		return nil
+	}
+	b := &resourceBookings{uid: uid, organizer: ical.Organizer(old)}
+	for _, a := range attendees {
+		if keep[a.Address] {
+			continue
+		}
+		if cal := h.resourceCalendar(ctx, a); cal != nil {
+			b.remove = append(b.remove, resourceBooking{cal: cal, address: a.Address})
+		}
+	}
+	return b
+}
+
+// organizes reports whether the owner is the ORGANIZER of data.
+func (h *Handlers) organizes(ctx context.Context, owner string, data []byte) bool {
+	organizer := ical.Organizer(data)
+	if organizer == "" {
+		return false
+	}
+	u, err := h.dir.LookupUserByAttr(ctx, h.cfg.LDAP.TokenUserAttr, owner)
+	return err == nil && u != nil && u.HasAddress(organizer)
+}
+
+// resourceCalendar returns the calendar mapped to a ROOM or RESOURCE
+// attendee, or nil for people and unmapped resources.
+func (h *Handlers) resourceCalendar(ctx context.Context, a ical.Attendee) *storage.Calendar {
+	if !a.IsResource() {
+		return nil
+	}
+	uri, ok := h.cfg.CalDAV.ResourceCalendars[a.Address]
+	if !ok {
+		return nil
+	}
+	cal, err := h.store.GetCalendarByURI(ctx, uri)
+	if err != nil || cal == nil {
+		h.logger.Error().Ctx(ctx).Err(err).
+			Str("resource", a.Address).
+			Str("calendar", uri).
+			Msg("resource calendar not found")
+		return nil
+	}
+	return cal
+}
+
+// bookingHeldByOther reports whether the resource calendar already holds
+// an object with uid whose ORGANIZER is not organizer.
+func (h *Handlers) bookingHeldByOther(ctx context.Context, cal *storage.Calendar, uid, organizer string) bool {
+	existing, err := h.store.GetObject(ctx, cal.ID, uid)
+	if err != nil || existing == nil {
+		return false
+	}
+	return ical.Organizer([]byte(existing.Data)) != organizer
+}
+
+// storeBooking writes the resource's copy of an accepted event to its
+// calendar. A copy that is already up to date is left alone, so its change
+// log and schedule tag only move when the event does.
+func (h *Handlers) storeBooking(ctx context.Context, res resourceBooking, uid, organizer string, data []byte) {
+	cal := res.cal
+	obj := &storage.Object{
+		CalendarID: cal.ID,
+		UID:        uid,
+		Data:       string(data),
+		Component:  "VEVENT",
+	}
+	if existing, err := h.store.GetObject(ctx, cal.ID, uid); err == nil && existing != nil {
+		if existing.Data == obj.Data {
+			return
+		}
+		obj.ScheduleTag = nextScheduleTag([]byte(existing.Data), existing.ScheduleTag, data)
+	} else {
+		obj.ScheduleTag = nextScheduleTag(nil, "", data)
+	}
+	obj.StartAt, obj.EndAt, obj.HasRecurrence = ical.EventWindow(data)
+	if err := h.store.PutObject(ctx, obj); err != nil {
+		h.logger.Error().Ctx(ctx).Err(err).
+			Str("calendar", cal.URI).
+			Str("uid", uid).
+			Msg("failed to store resource booking")
+		return
+	}
+	if _, _, err := h.store.RecordChange(ctx, cal.ID, uid, false); err != nil {
+		h.logger.Error().Ctx(ctx).Err(err).
+			Str("calendarID", cal.ID).
+			Str("uid", uid).
+			Msg("RecordChange failed for resource booking")
+	}
+	h.notifyDelivery(ctx, "REQUEST", cal.ID, uid, organizer, res.address)
+}
+
+// removeBooking deletes the resource's copy of an event, if it holds one.
+// A booking under the same UID made by another organizer is left alone.
+func (h *Handlers) removeBooking(ctx context.Context, res resourceBooking, uid, organizer string) {
+	cal := res.cal
+	existing, _ := h.store.GetObject(ctx, cal.ID, uid)
+	if existing == nil || ical.Organizer([]byte(existing.Data)) != organizer {
+		return
+	}
+	if err := h.store.DeleteObject(ctx, cal.ID, uid, ""); err != nil {
+		h.logger.Error().Ctx(ctx).Err(err).
+			Str("calendar", cal.URI).
+			Str("uid", uid).
+			Msg("failed to remove resource booking")
+		return
+	}
+	if _, _, err := h.store.RecordChange(ctx, cal.ID, uid, true); err != nil {
+		h.logger.Error().Ctx(ctx).Err(err).
+			Str("calendarID", cal.ID).
+			Str("uid", uid).
+			Msg("RecordChange failed for removed resource booking")
+	}
+	h.notifyDelivery(ctx, "CANCEL", cal.ID, uid, organizer, res.address)
+}
@@ -0,0 +1,201 @@
+package caldav
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sonroyaalmerol/ldap-dav/internal/config"
+	"github.com/sonroyaalmerol/ldap-dav/internal/directory"
+	"github.com/sonroyaalmerol/ldap-dav/internal/storage"
+	"github.com/sonroyaalmerol/ldap-dav/internal/webhook"
+	"github.com/sonroyaalmerol/ldap-dav/pkg/ical"
+)
+
+func TestStoreBookingSkipsUnchangedCopy(t *testing.T) {
+	h, store := newTestHandlers(t, nil, nil)
+	ctx := context.Background()
+	room := createCalendar(t, store, "rooms", "room-a")
+	booking := resourceBooking{cal: room, address: "room-a@example.com"}
+
+	event := func(start string) []byte {
+		return []byte("BEGIN:VCALENDAR\r\nVERSION:2.0\r\nBEGIN:VEVENT\r\nUID:standup\r\nDTSTART:" + start + "\r\n" +
+			"ORGANIZER:mailto:alice@example.com\r\n" +
+			"ATTENDEE;CUTYPE=ROOM;PARTSTAT=ACCEPTED:mailto:room-a@example.com\r\n" +
+			"END:VEVENT\r\nEND:VCALENDAR\r\n")
+	}
+
+	h.storeBooking(ctx, booking, "standup", "alice@example.com", event("20260101T100000Z"))
+	first, err := store.GetObject(ctx, room.ID, "standup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, seq, err := store.GetSyncInfo(ctx, room.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h.storeBooking(ctx, booking, "standup", "alice@example.com", event("20260101T100000Z"))
+	again, err := store.GetObject(ctx, room.ID, "standup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.ETag != first.ETag || again.ScheduleTag != first.ScheduleTag {
+		t.Errorf("unchanged booking was rewritten: etag %s -> %s, schedule tag %s -> %s",
+			first.ETag, again.ETag, first.ScheduleTag, again.ScheduleTag)
+	}
+	if _, after, _ := store.GetSyncInfo(ctx, room.ID); after != seq {
+		t.Errorf("unchanged booking recorded a change: seq %d -> %d", seq, after)
+	}
+
+	h.storeBooking(ctx, booking, "standup", "alice@example.com", event("20260102T100000Z"))
+	moved, err := store.GetObject(ctx, room.ID, "standup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved.ScheduleTag == first.ScheduleTag {
+		t.Error("rescheduled booking kept its schedule tag")
+	}
+	if _, after, _ := store.GetSyncInfo(ctx, room.ID); after == seq {
+		t.Error("rescheduled booking recorded no change")
+	}
+}
+
+// newBookingHandlers returns handlers with room-a@example.com mapped to the
+// resource calendar room-a and the given users as organizers, each with a
+// calendar named after them.
+func newBookingHandlers(t *testing.T, organizers ...string) (*Handlers, *storage.Calendar) {
+	t.Helper()
+	dir := &fakeDirectory{}
+	for _, uid := range organizers {
+		dir.users = append(dir.users, &directory.User{UID: uid, Mail: uid + "@example.com"})
+	}
+	h, store := newTestHandlers(t, dir, func(cfg *config.Config) {
+		cfg.CalDAV.ResourceCalendars = map[string]string{"room-a@example.com": "room-a"}
+	})
+	for _, uid := range organizers {
+		createCalendar(t, store, uid, uid)
+	}
+	return h, createCalendar(t, store, "rooms", "room-a")
+}
+
+// roomMeeting is an event organized by organizer that invites room-a.
+func roomMeeting(organizer, uid string, start time.Time) string {
+	stamp := func(t time.Time) string { return t.Format("20060102T150405Z") }
+	return vevent(
+		"UID:"+uid,
+		"DTSTAMP:"+stamp(start),
+		"DTSTART:"+stamp(start),
+		"DTEND:"+stamp(start.Add(time.Hour)),
+		"ORGANIZER:mailto:"+organizer+"@example.com",
+		"ATTENDEE;CUTYPE=ROOM;PARTSTAT=NEEDS-ACTION:mailto:room-a@example.com",
+	)
+}
+
+func TestBookResourcesSerializesPerCalendar(t *testing.T) {
+	h, room := newBookingHandlers(t, "alice", "bob")
+	ctx := context.Background()
+	slot := time.Now().UTC().Add(48 * time.Hour).Truncate(time.Hour)
+
+	r := httptest.NewRequest(http.MethodPut, "/dav/calendars/alice/alice/standup.ics", nil)
+	first, firstBookings := h.bookResources(r, "alice", "standup", nil, []byte(roomMeeting("alice", "standup", slot)))
+	if !strings.Contains(string(first), "PARTSTAT=ACCEPTED") {
+		t.Fatalf("free room was not accepted:\n%s", first)
+	}
+
+	// bob checks the same slot while alice's booking is still being written.
+	second := make(chan []byte)
+	go func() {
+		r := httptest.NewRequest(http.MethodPut, "/dav/calendars/bob/bob/review.ics", nil)
+		data, bookings := h.bookResources(r, "bob", "review", nil, []byte(roomMeeting("bob", "review", slot)))
+		bookings.release()
+		second <- data
+	}()
+	select {
+	case <-second:
+		t.Fatal("second booking was checked while the first held the room")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	h.applyBookings(ctx, "", firstBookings, first)
+	firstBookings.release()
+	if got := <-second; !strings.Contains(string(got), "PARTSTAT=DECLINED") {
+		t.Errorf("second booking of a taken room was not declined:\n%s", got)
+	}
+	if booked, _ := h.store.ListObjects(ctx, room.ID, nil, nil); len(booked) != 1 {
+		t.Errorf("room holds %d bookings, want 1", len(booked))
+	}
+}
+
+func TestApplyBookingsRechecksConflicts(t *testing.T) {
+	h, room := newBookingHandlers(t, "alice", "bob")
+	ctx := context.Background()
+	slot := time.Now().UTC().Add(48 * time.Hour).Truncate(time.Hour)
+
+	r := httptest.NewRequest(http.MethodPut, "/dav/calendars/alice/alice/standup.ics", nil)
+	data, bookings := h.bookResources(r, "alice", "standup", nil, []byte(roomMeeting("alice", "standup", slot)))
+	defer bookings.release()
+	if !strings.Contains(string(data), "PARTSTAT=ACCEPTED") || len(bookings.store) != 1 {
+		t.Fatalf("free room was not accepted:\n%s", data)
+	}
+	alice, err := h.store.GetCalendarByURI(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.store.PutObject(ctx, &storage.Object{CalendarID: alice.ID, UID: "standup", Component: "VEVENT", Data: string(data)}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The room is taken by a write that does not go through bookResources.
+	other := roomMeeting("bob", "review", slot)
+	obj := &storage.Object{CalendarID: room.ID, UID: "review", Component: "VEVENT", Data: other}
+	obj.StartAt, obj.EndAt, obj.HasRecurrence = ical.EventWindow([]byte(other))
+	if err := h.store.PutObject(ctx, obj); err != nil {
+		t.Fatal(err)
+	}
+
+	if !h.applyBookings(ctx, alice.ID, bookings, data) {
+		t.Error("applyBookings reported the organizer copy unchanged")
+	}
+	if got, _ := h.store.GetObject(ctx, room.ID, "standup"); got != nil {
+		t.Error("conflicting booking was stored")
+	}
+	stored, err := h.store.GetObject(ctx, alice.ID, "standup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(stored.Data, "PARTSTAT=DECLINED") {
+		t.Errorf("room not declined in the organizer copy:\n%s", stored.Data)
+	}
+}
+
+func TestResourceBookingWebhooks(t *testing.T) {
+	h, room := newBookingHandlers(t, "alice")
+	delivered := recordWebhooks(t, h)
+	slot := time.Now().UTC().Add(48 * time.Hour).Truncate(time.Hour)
+
+	if w := serve(h, "alice", http.MethodPut, "/dav/calendars/alice/alice/standup.ics", roomMeeting("alice", "standup", slot), nil); w.Code != http.StatusCreated {
+		t.Fatalf("PUT = %d, want 201: %s", w.Code, w.Body)
+	}
+	if w := serve(h, "alice", http.MethodDelete, "/dav/calendars/alice/alice/standup.ics", "", nil); w.Code != http.StatusNoContent {
+		t.Fatalf("DELETE = %d, want 204: %s", w.Code, w.Body)
+	}
+
+	want := []webhook.Event{
+		{Type: webhook.EventSchedulingDelivery, CollectionID: room.ID, UID: "standup", Method: "REQUEST", Originator: "alice@example.com", Recipient: "room-a@example.com"},
+		{Type: webhook.EventSchedulingDelivery, CollectionID: room.ID, UID: "standup", Deleted: true, Method: "CANCEL", Originator: "alice@example.com", Recipient: "room-a@example.com"},
+	}
+	got := delivered()
+	if len(got) != len(want) {
+		t.Fatalf("got %d webhooks, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		got[i].Time = time.Time{}
+		if got[i] != want[i] {
+			t.Errorf("webhook %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
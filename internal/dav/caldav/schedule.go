@@ -0,0 +1,30 @@
+package caldav
+
+import (
+	"github.com/google/uuid"
+	"github.com/sonroyaalmerol/ldap-dav/pkg/ical"
+)
+
+// hasSignificantChange reports whether an update to a scheduling object
+// changes anything the organizer cares about. Attendee PARTSTAT replies and
+// alarm edits do not.
+func hasSignificantChange(oldData, newData []byte) bool {
+	oldSig, err := ical.SchedulingSignature(oldData)
+	if err != nil {
+		return true
+	}
+	newSig, err := ical.SchedulingSignature(newData)
+	if err != nil {
+		return true
+	}
+	return oldSig != newSig
+}
+
+// nextScheduleTag returns the Schedule-Tag for a stored scheduling object,
+// keeping the existing tag when the update is not organizer-significant.
+func nextScheduleTag(existing []byte, existingTag string, updated []byte) string {
+	if existingTag != "" && !hasSignificantChange(existing, updated) {
+		return existingTag
+	}
+	return uuid.New().String()
+}
@@ -0,0 +1,112 @@
+package caldav
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/sonroyaalmerol/ldap-dav/internal/dav/common"
+	"github.com/sonroyaalmerol/ldap-dav/internal/storage"
+)
+
+const (
+	scheduleInboxURI  = "inbox"
+	scheduleOutboxURI = "outbox"
+)
+
+// isSchedulingCollection reports whether calURI names one of the scheduling
+// collections provisioned in every calendar home when enabled. They are not
+// stored; their URIs are reserved instead, so a stored calendar with the
+// same URI is shadowed by every method until the option is turned off.
+func (h *Handlers) isSchedulingCollection(calURI string) bool {
+	if !h.cfg.CalDAV.SchedulingCollections {
+		return false
+	}
+	return calURI == scheduleInboxURI || calURI == scheduleOutboxURI
+}
+
+// ownedCalendars lists the calendars owner can reach in their home, leaving
+// out those shadowed by the scheduling collections.
+func (h *Handlers) ownedCalendars(ctx context.Context, owner string) ([]*storage.Calendar, error) {
+	cals, err := h.store.ListCalendarsByOwnerUser(ctx, owner)
+	if err != nil {
+		return nil, err
+	}
+	out := cals[:0]
+	for _, cal := range cals {
+		if !h.isSchedulingCollection(cal.URI) {
+			out = append(out, cal)
+		}
+	}
+	return out, nil
+}
+
+// freeBusySet lists the owner's calendars that contribute to free-busy.
+func (c *CalDAVResourceHandler) freeBusySet(owner string, owned []*storage.Calendar) common.CalendarFreeBusySet {
+	set := common.CalendarFreeBusySet{}
+	for _, cal := range owned {
+		set.Hrefs = append(set.Hrefs, common.Href{Value: common.CalendarPath(c.basePath, owner, cal.URI)})
+	}
+	return set
+}
+
+func (c *CalDAVResourceHandler) schedulingResponses(owner string, owned []*storage.Calendar) []common.Response {
+	ownerHref := &common.Href{Value: c.handlers.principalURL(owner)}
+
+	inbox := common.Response{Hrefs: []common.Href{{Value: common.ScheduleInboxPath(c.basePath, owner)}}}
+	_ = inbox.EncodeProp(http.StatusOK, common.ResourceType{Collection: &struct{}{}, ScheduleIn: &struct{}{}})
+	_ = inbox.EncodeProp(http.StatusOK, common.DisplayName{Name: "Inbox"})
+	_ = inbox.EncodeProp(http.StatusOK, common.Owner{Href: ownerHref})
+	_ = inbox.EncodeProp(http.StatusOK, c.freeBusySet(owner, owned))
+	_ = inbox.EncodeProp(http.StatusOK, common.CurrentUserPrivilegeSet{
+		Privilege: []common.Privilege{{All: &struct{}{}}},
+	})
+
+	outbox := common.Response{Hrefs: []common.Href{{Value: common.ScheduleOutboxPath(c.basePath, owner)}}}
+	_ = outbox.EncodeProp(http.StatusOK, common.ResourceType{Collection: &struct{}{}, ScheduleOut: &struct{}{}})
+	_ = outbox.EncodeProp(http.StatusOK, common.DisplayName{Name: "Outbox"})
+	_ = outbox.EncodeProp(http.StatusOK, common.Owner{Href: ownerHref})
+	_ = outbox.EncodeProp(http.StatusOK, common.CurrentUserPrivilegeSet{
+		Privilege: []common.Privilege{{All: &struct{}{}}},
+	})
+
+	return []common.Response{inbox, outbox}
+}
+
+func (c *CalDAVResourceHandler) propfindSchedulingCollection(w http.ResponseWriter, r *http.Request, owner, collection string) {
+	pr := common.MustPrincipal(r.Context())
+	if pr.UserID != owner {
+		c.handlers.logger.Debug().Ctx(r.Context()).
+			Str("user", pr.UserID).
+			Str("owner", owner).
+			Str("collection", collection).
+			Msg("PROPFIND scheduling collection forbidden - user mismatch")
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	owned, err := c.handlers.ownedCalendars(r.Context(), owner)
+	if err != nil {
+		c.handlers.logger.Error().Ctx(r.Context()).Err(err).Str("owner", owner).Msg("failed to list calendars for scheduling collection")
+		http.Error(w, "storage error", http.StatusInternalServerError)
+		return
+	}
+
+	resps := c.schedulingResponses(owner, owned)
+	resp := resps[0]
+	if collection == scheduleOutboxURI {
+		resp = resps[1]
+	}
+
+	ms := common.MultiStatus{Responses: []common.Response{resp}}
+	if err := common.ServePropfind(w, r, &ms); err != nil {
+		c.handlers.logger.Error().Ctx(r.Context()).Err(err).Msg("failed to serve MultiStatus for scheduling collection")
+	}
+}
+
+// encodeScheduleTransp marks owned calendars as contributing to free-busy.
+func (c *CalDAVResourceHandler) encodeScheduleTransp(resp *common.Response) {
+	if !c.handlers.cfg.CalDAV.SchedulingCollections {
+		return
+	}
+	_ = resp.EncodeProp(http.StatusOK, common.ScheduleCalendarTransp{Opaque: &struct{}{}})
+}
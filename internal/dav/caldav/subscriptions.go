@@ -0,0 +1,354 @@
+package caldav
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/sonroyaalmerol/ldap-dav/internal/dav/common"
+	"github.com/sonroyaalmerol/ldap-dav/internal/storage"
+	"github.com/sonroyaalmerol/ldap-dav/pkg/ical"
+)
+
+var (
+	// subscriptionSourceProp is CS:source, the feed URL of a subscribed
+	// calendar. A calendar carrying it is filled from the feed and
+	// read-only to clients.
+	subscriptionSourceProp = xml.Name{Space: common.NSCS, Local: "source"}
+
+	// subscriptionRefreshProp is Apple's refreshrate, an ISO 8601
+	// duration overriding the configured refresh interval.
+	subscriptionRefreshProp = xml.Name{Space: "http://apple.com/ns/ical/", Local: "refreshrate"}
+)
+
+const (
+	// subscriptionTick is how often due subscriptions are looked for.
+	subscriptionTick = time.Minute
+
+	// minSubscriptionRefresh keeps a client-chosen refreshrate from
+	// hammering the feed's server.
+	minSubscriptionRefresh = 5 * time.Minute
+)
+
+type subscription struct {
+	calendar *storage.Calendar
+	source   string
+	interval time.Duration
+}
+
+// subscriptionOf reads the subscription settings stored on a calendar. It
+// reports false for ordinary calendars and when subscriptions are off.
+func (h *Handlers) subscriptionOf(ctx context.Context, cal *storage.Calendar) (*subscription, bool) {
+	if !h.cfg.CalDAV.Subscriptions {
+		return nil, false
+	}
+	props, err := h.store.ListDeadProperties(ctx, cal.ID)
+	if err != nil {
+		h.logger.Debug().Ctx(ctx).Err(err).Str("calendar", cal.URI).Msg("failed to list calendar dead properties")
+		return nil, false
+	}
+
+	sub := &subscription{calendar: cal, interval: h.cfg.CalDAV.SubscriptionRefresh}
+	for _, p := range props {
+		var v struct {
+			Href string `xml:"DAV: href"`
+			Text string `xml:",chardata"`
+		}
+		if err := xml.Unmarshal([]byte(p.Value), &v); err != nil {
+			continue
+		}
+		switch (xml.Name{Space: p.Space, Local: p.Local}) {
+		case subscriptionSourceProp:
+			sub.source = strings.TrimSpace(v.Href)
+			if sub.source == "" {
+				sub.source = strings.TrimSpace(v.Text)
+			}
+		case subscriptionRefreshProp:
+			if d, err := ical.ParseDuration(v.Text); err == nil && d > 0 {
+				sub.interval = max(d, minSubscriptionRefresh)
+			}
+		}
+	}
+	return sub, sub.source != ""
+}
+
+// isSubscribedCalendar reports whether the calendar is filled from a feed,
+// so clients must not change its objects.
+func (h *Handlers) isSubscribedCalendar(ctx context.Context, calendarID string) bool {
+	_, ok := h.subscriptionOf(ctx, &storage.Calendar{ID: calendarID})
+	return ok
+}
+
+// canSubscribe reports whether the calendar may be given a CS:source.
+// Refreshing replaces every object with the feed's, so only calendars that
+// hold no objects, such as ones just created, or that are already filled
+// from a feed qualify.
+func (h *Handlers) canSubscribe(ctx context.Context, calendarID string) bool {
+	if h.isSubscribedCalendar(ctx, calendarID) {
+		return true
+	}
+	objs, err := h.store.ListObjectMetadataByComponent(ctx, calendarID, nil, nil, nil)
+	if err != nil {
+		h.logger.Error().Ctx(ctx).Err(err).Str("calendarID", calendarID).Msg("failed to list objects before setting CS:source")
+		return false
+	}
+	return len(objs) == 0
+}
+
+// subscriptionFetch records when a calendar's feed was last fetched and
+// from which source.
+type subscriptionFetch struct {
+	source string
+	at     time.Time
+}
+
+// RunSubscriptions keeps subscribed calendars in step with their feeds
+// until ctx is done. Each feed is fetched once at start and again whenever
+// its refresh interval has passed. Ticks are skipped while read-only
+// maintenance mode is on, so feeds due meanwhile are fetched once it ends.
+func (h *Handlers) RunSubscriptions(ctx context.Context) {
+	last := make(map[string]subscriptionFetch)
+
+	ticker := time.NewTicker(subscriptionTick)
+	defer ticker.Stop()
+	for {
+		if h.readOnly.Enabled() {
+			h.logger.Debug().Ctx(ctx).Msg("read-only maintenance mode, skipping subscription refresh")
+		} else {
+			h.refreshDueSubscriptions(ctx, last)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// refreshDueSubscriptions refreshes the subscribed calendars whose refresh
+// interval has passed since the fetch recorded in last.
+func (h *Handlers) refreshDueSubscriptions(ctx context.Context, last map[string]subscriptionFetch) {
+	cals, err := h.subscribedCalendars(ctx)
+	if err != nil {
+		h.logger.Error().Ctx(ctx).Err(err).Msg("failed to list calendars for subscription refresh")
+	}
+	now := time.Now()
+	for _, cal := range cals {
+		sub, ok := h.subscriptionOf(ctx, cal)
+		if !ok {
+			continue
+		}
+		// A changed source is fetched right away.
+		if f, seen := last[cal.ID]; seen && f.source == sub.source && now.Sub(f.at) < sub.interval {
+			continue
+		}
+		last[cal.ID] = subscriptionFetch{source: sub.source, at: now}
+		if err := h.refreshSubscription(ctx, sub); err != nil {
+			h.logger.Warn().Ctx(ctx).Err(err).
+				Str("calendar", cal.URI).
+				Str("source", sub.source).
+				Msg("failed to refresh subscribed calendar")
+		}
+	}
+}
+
+// subscribedCalendars lists the calendars carrying a CS:source, so only
+// their properties are read on every tick.
+func (h *Handlers) subscribedCalendars(ctx context.Context) ([]*storage.Calendar, error) {
+	ids, err := h.store.ListResourcesWithDeadProperty(ctx, subscriptionSourceProp.Space, subscriptionSourceProp.Local)
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+	sources := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		sources[id] = true
+	}
+	all, err := h.store.ListAllCalendars(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var out []*storage.Calendar
+	for _, cal := range all {
+		if sources[cal.ID] {
+			out = append(out, cal)
+		}
+	}
+	return out, nil
+}
+
+// refreshSubscription replaces the objects of a subscribed calendar with
+// those of its feed, recording a change for every object added, altered
+// or dropped so sync clients pick up the difference.
+func (h *Handlers) refreshSubscription(ctx context.Context, sub *subscription) error {
+	data, err := h.fetchFeed(ctx, sub.source)
+	if err != nil {
+		return err
+	}
+	if h.lenientICS() {
+		data, _ = ical.RepairICS(data, h.cfg.ICS.BuildProdID())
+	}
+	objs, err := ical.SplitCalendar(data, h.cfg.ICS.BuildProdID())
+	if err != nil {
+		return fmt.Errorf("parse feed: %w", err)
+	}
+
+	calendarID := sub.calendar.ID
+	existing, err := h.store.ListObjects(ctx, calendarID, nil, nil)
+	if err != nil {
+		return err
+	}
+	current := make(map[string]*storage.Object, len(existing))
+	for _, o := range existing {
+		current[o.UID] = o
+	}
+
+	changed := 0
+	for uid, fo := range objs {
+		if !common.SafeSegment(uid) {
+			h.logger.Debug().Ctx(ctx).Str("uid", uid).Str("source", sub.source).Msg("skipping feed object with unsafe UID")
+			continue
+		}
+		if old, ok := current[uid]; ok && old.Data == string(fo.Data) {
+			continue
+		}
+		obj := &storage.Object{
+			CalendarID: calendarID,
+			UID:        uid,
+			Data:       string(fo.Data),
+			Component:  fo.Component,
+		}
+		if fo.Component == "VEVENT" {
+			obj.StartAt, obj.EndAt, obj.HasRecurrence = ical.EventWindow(fo.Data)
+		}
+		if err := h.store.PutObject(ctx, obj); err != nil {
+			return err
+		}
+		if _, _, err := h.store.RecordChange(ctx, calendarID, uid, false); err != nil {
+			h.logger.Error().Ctx(ctx).Err(err).Str("calendarID", calendarID).Str("uid", uid).Msg("RecordChange failed")
+		}
+		changed++
+	}
+
+	for uid := range current {
+		if _, ok := objs[uid]; ok && common.SafeSegment(uid) {
+			continue
+		}
+		if err := h.store.DeleteObject(ctx, calendarID, uid, ""); err != nil {
+			return err
+		}
+		if _, _, err := h.store.RecordChange(ctx, calendarID, uid, true); err != nil {
+			h.logger.Error().Ctx(ctx).Err(err).Str("calendarID", calendarID).Str("uid", uid).Msg("RecordChange failed")
+		}
+		changed++
+	}
+
+	h.logger.Debug().Ctx(ctx).
+		Str("calendar", sub.calendar.URI).
+		Int("objects", len(objs)).
+		Int("changed", changed).
+		Msg("refreshed subscribed calendar")
+	return nil
+}
+
+// newFeedClient returns the HTTP client feeds are fetched with. Feed URLs
+// come from users, so connections to loopback, private, link-local (which
+// includes cloud metadata endpoints) and other non-public addresses are
+// refused. The check runs on the resolved address of every dial, redirects
+// included, and no proxy is used so it cannot be bypassed.
+func newFeedClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: feedDialControl,
+	}
+	return &http.Client{
+		Timeout: 30 * time.Second,
+		Transport: &http.Transport{
+			Proxy:               nil,
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: 10 * time.Second,
+		},
+	}
+}
+
+// feedDialControl refuses connections to addresses that are not publicly
+// routable.
+func feedDialControl(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("feed address %q is not an IP address", host)
+	}
+	if !publicAddress(ip) {
+		return fmt.Errorf("feed address %s is not public", ip)
+	}
+	return nil
+}
+
+// sharedAddressSpace is the carrier-grade NAT range (RFC 6598), which
+// net.IP.IsPrivate does not cover.
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+func publicAddress(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	return !ip.IsLoopback() &&
+		!ip.IsPrivate() &&
+		!ip.IsLinkLocalUnicast() &&
+		!ip.IsLinkLocalMulticast() &&
+		!ip.IsInterfaceLocalMulticast() &&
+		!ip.IsMulticast() &&
+		!ip.IsUnspecified() &&
+		!sharedAddressSpace.Contains(ip)
+}
+
+// fetchFeed downloads an iCalendar feed. webcal:// and webcals:// URLs are
+// fetched over HTTPS.
+func (h *Handlers) fetchFeed(ctx context.Context, source string) ([]byte, error) {
+	u, err := url.Parse(source)
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "webcal", "webcals":
+		u.Scheme = "https"
+	case "http", "https":
+	default:
+		return nil, fmt.Errorf("unsupported feed scheme %q", u.Scheme)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/calendar")
+	resp, err := h.feeds.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("feed returned %s", resp.Status)
+	}
+
+	limit := h.cfg.CalDAV.SubscriptionMaxBytes
+	data, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("feed larger than %d bytes", limit)
+	}
+	return common.ToUTF8(data, resp.Header.Get("Content-Type"))
+}
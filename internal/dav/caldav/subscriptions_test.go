@@ -0,0 +1,104 @@
+package caldav
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sonroyaalmerol/ldap-dav/internal/config"
+	"github.com/sonroyaalmerol/ldap-dav/internal/storage"
+)
+
+func TestFeedDialControl(t *testing.T) {
+	tests := []struct {
+		name    string
+		address string
+		public  bool
+	}{
+		{name: "loopback", address: "127.0.0.1:80", public: false},
+		{name: "ipv6 loopback", address: "[::1]:80", public: false},
+		{name: "rfc1918 10/8", address: "10.1.2.3:443", public: false},
+		{name: "rfc1918 172.16/12", address: "172.20.0.1:443", public: false},
+		{name: "rfc1918 192.168/16", address: "192.168.1.1:443", public: false},
+		{name: "link-local metadata", address: "169.254.169.254:80", public: false},
+		{name: "cgnat", address: "100.64.0.1:80", public: false},
+		{name: "cgnat upper bound", address: "100.127.255.254:80", public: false},
+		{name: "unspecified", address: "0.0.0.0:80", public: false},
+		{name: "ipv6 unique local", address: "[fd00::1]:443", public: false},
+		{name: "ipv4-mapped loopback", address: "[::ffff:127.0.0.1]:80", public: false},
+		{name: "ipv4-mapped metadata", address: "[::ffff:169.254.169.254]:80", public: false},
+		{name: "public ipv4", address: "93.184.216.34:443", public: true},
+		{name: "public next to cgnat", address: "100.128.0.1:443", public: true},
+		{name: "public ipv4-mapped", address: "[::ffff:93.184.216.34]:443", public: true},
+		{name: "public ipv6", address: "[2606:4700::1111]:443", public: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := feedDialControl("tcp", tt.address, nil)
+			if got := err == nil; got != tt.public {
+				t.Errorf("feedDialControl(%q) = %v, want public=%v", tt.address, err, tt.public)
+			}
+			host, _, _ := net.SplitHostPort(tt.address)
+			if got := publicAddress(net.ParseIP(host)); got != tt.public {
+				t.Errorf("publicAddress(%s) = %v, want %v", host, got, tt.public)
+			}
+		})
+	}
+
+	if err := feedDialControl("tcp", "example.com:443", nil); err == nil {
+		t.Error("feedDialControl accepted an unresolved host name")
+	}
+}
+
+func TestProppatchSubscriptionSource(t *testing.T) {
+	h, store := newTestHandlers(t, nil, func(cfg *config.Config) { cfg.CalDAV.Subscriptions = true })
+
+	ctx := context.Background()
+	work := createCalendar(t, store, "alice", "work")
+	createCalendar(t, store, "alice", "empty")
+	if err := store.PutObject(ctx, &storage.Object{
+		CalendarID: work.ID,
+		UID:        "meeting",
+		Component:  "VEVENT",
+		Data:       "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nBEGIN:VEVENT\r\nUID:meeting\r\nDTSTART:20260101T100000Z\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	proppatch := func(uri string) *httptest.ResponseRecorder {
+		body := `<?xml version="1.0" encoding="utf-8"?>
+<D:propertyupdate xmlns:D="DAV:" xmlns:CS="http://calendarserver.org/ns/">
+  <D:set><D:prop><CS:source><D:href>https://example.com/feed.ics</D:href></CS:source></D:prop></D:set>
+</D:propertyupdate>`
+		return serve(h, "alice", "PROPPATCH", "/dav/calendars/alice/"+uri+"/", body, nil)
+	}
+
+	w := proppatch("work")
+	if w.Code != http.StatusMultiStatus {
+		t.Fatalf("PROPPATCH status = %d, want 207", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "403") || !strings.Contains(w.Body.String(), "cannot-modify-protected-property") {
+		t.Errorf("PROPPATCH of CS:source on a calendar with objects was not refused:\n%s", w.Body.String())
+	}
+	if h.isSubscribedCalendar(ctx, work.ID) {
+		t.Error("CS:source was stored on a calendar with objects")
+	}
+	if _, err := store.GetObject(ctx, work.ID, "meeting"); err != nil {
+		t.Errorf("object lost: %v", err)
+	}
+
+	w = proppatch("empty")
+	if w.Code != http.StatusMultiStatus || strings.Contains(w.Body.String(), "cannot-modify-protected-property") {
+		t.Fatalf("PROPPATCH of CS:source on an empty calendar was refused:\n%s", w.Body.String())
+	}
+	empty, err := store.GetCalendarByURI(ctx, "empty")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !h.isSubscribedCalendar(ctx, empty.ID) {
+		t.Error("CS:source was not stored on an empty calendar")
+	}
+}
@@ -0,0 +1,90 @@
+package caldav
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/sonroyaalmerol/ldap-dav/internal/config"
+	"github.com/sonroyaalmerol/ldap-dav/pkg/ical"
+)
+
+// Validator enforces a deployment rule on calendar objects. It runs in PUT
+// after normalization; a non-nil error rejects the object with 403 and its
+// message is returned to the client.
+type Validator interface {
+	Validate(ics []byte) error
+}
+
+// ValidatorFactory builds a validator from the server configuration.
+type ValidatorFactory func(cfg *config.Config) Validator
+
+// validatorFactories holds the validators CALDAV_VALIDATORS can select, by
+// name.
+var validatorFactories = map[string]ValidatorFactory{
+	"max-duration": func(cfg *config.Config) Validator {
+		return maxDurationValidator{limit: cfg.CalDAV.MaxEventDuration}
+	},
+	"require-category": func(*config.Config) Validator {
+		return requireCategoryValidator{}
+	},
+}
+
+// RegisterValidator makes a validator selectable by name in
+// CALDAV_VALIDATORS. It must be called before NewHandlers.
+func RegisterValidator(name string, factory ValidatorFactory) {
+	validatorFactories[name] = factory
+}
+
+type namedValidator struct {
+	name string
+	Validator
+}
+
+// buildValidators instantiates the validators named in CALDAV_VALIDATORS, in
+// order. Unknown names are skipped with a warning.
+func (h *Handlers) buildValidators() []namedValidator {
+	var out []namedValidator
+	for _, name := range h.cfg.CalDAV.Validators {
+		factory, ok := validatorFactories[name]
+		if !ok {
+			h.logger.Warn().Str("validator", name).Msg("unknown validator in CALDAV_VALIDATORS, ignoring")
+			continue
+		}
+		out = append(out, namedValidator{name: name, Validator: factory(h.cfg)})
+	}
+	return out
+}
+
+// validate runs the configured validators over an object, returning the
+// name of the first one to reject it and its reason.
+func (h *Handlers) validate(ics []byte) (string, error) {
+	for _, v := range h.validators {
+		if err := v.Validate(ics); err != nil {
+			return v.name, err
+		}
+	}
+	return "", nil
+}
+
+// maxDurationValidator rejects events longer than CALDAV_VALIDATOR_MAX_DURATION.
+type maxDurationValidator struct {
+	limit time.Duration
+}
+
+func (v maxDurationValidator) Validate(ics []byte) error {
+	if d := ical.LongestEvent(ics); d > v.limit {
+		return fmt.Errorf("event lasts %s, longer than the allowed %s", d, v.limit)
+	}
+	return nil
+}
+
+// requireCategoryValidator rejects components without CATEGORIES.
+type requireCategoryValidator struct{}
+
+func (requireCategoryValidator) Validate(ics []byte) error {
+	if ical.MissingCategories(ics) {
+		return errors.New("every component must carry at least one category")
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package carddav
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/sonroyaalmerol/ldap-dav/internal/dav/common"
+	"github.com/sonroyaalmerol/ldap-dav/internal/storage"
+	"github.com/sonroyaalmerol/ldap-dav/pkg/vcard"
+)
+
+// checkFilter reports the CARDDAV:supported-filter precondition and its
+// status when an addressbook-query filter cannot be evaluated (RFC 6352
+// section 10.5), or nil when it can.
+func checkFilter(f common.AddressbookFilter) (interface{}, int) {
+	unsupported := func() (interface{}, int) { return common.CardSupportedFilter{}, http.StatusForbidden }
+
+	if !validTest(f.Test) {
+		return unsupported()
+	}
+	for _, pf := range f.PropFilters {
+		if pf.Name == "" || !validTest(pf.Test) {
+			return unsupported()
+		}
+		if pf.IsNotDefined != nil && (len(pf.TextMatches) > 0 || len(pf.ParamFilters) > 0) {
+			return unsupported()
+		}
+		for _, tm := range pf.TextMatches {
+			if !validMatchType(tm.MatchType) {
+				return unsupported()
+			}
+		}
+		for _, pmf := range pf.ParamFilters {
+			if pmf.Name == "" || (pmf.IsNotDefined != nil && pmf.TextMatch != nil) {
+				return unsupported()
+			}
+			if pmf.TextMatch != nil && !validMatchType(pmf.TextMatch.MatchType) {
+				return unsupported()
+			}
+		}
+	}
+	return nil, 0
+}
+
+func validTest(test string) bool {
+	switch strings.ToLower(test) {
+	case "", "anyof", "allof":
+		return true
+	}
+	return false
+}
+
+func validMatchType(matchType string) bool {
+	switch matchType {
+	case "", "equals", "contains", "starts-with", "ends-with":
+		return true
+	}
+	return false
+}
+
+// toVCardFilter converts a parsed filter element into the matcher
+// representation used by pkg/vcard.
+func toVCardFilter(f common.AddressbookFilter) vcard.Filter {
+	out := vcard.Filter{AllOf: strings.EqualFold(f.Test, "allof")}
+	for _, pf := range f.PropFilters {
+		p := vcard.PropFilter{
+			Name:         pf.Name,
+			AllOf:        strings.EqualFold(pf.Test, "allof"),
+			IsNotDefined: pf.IsNotDefined != nil,
+		}
+		for i := range pf.TextMatches {
+			p.TextMatches = append(p.TextMatches, *toVCardTextMatch(&pf.TextMatches[i]))
+		}
+		for _, pmf := range pf.ParamFilters {
+			p.ParamFilters = append(p.ParamFilters, vcard.ParamFilter{
+				Name:         pmf.Name,
+				IsNotDefined: pmf.IsNotDefined != nil,
+				TextMatch:    toVCardTextMatch(pmf.TextMatch),
+			})
+		}
+		out.PropFilters = append(out.PropFilters, p)
+	}
+	return out
+}
+
+func toVCardTextMatch(tm *common.TextMatch) *vcard.TextMatch {
+	if tm == nil {
+		return nil
+	}
+	return &vcard.TextMatch{
+		Text:          tm.Text,
+		MatchType:     tm.MatchType,
+		CaseSensitive: tm.Collation == "i;octet",
+		Negate:        strings.EqualFold(tm.Negate, "yes"),
+	}
+}
+
+// filterContacts keeps the contacts satisfying the filter of an
+// addressbook-query.
+func (h *Handlers) filterContacts(ctx context.Context, contacts []*storage.Contact, f common.AddressbookFilter) []*storage.Contact {
+	if len(f.PropFilters) == 0 {
+		return contacts
+	}
+	filter := toVCardFilter(f)
+
+	out := contacts[:0]
+	for _, c := range contacts {
+		ok, err := vcard.MatchFilter([]byte(c.Data), filter)
+		if err != nil {
+			h.logger.Debug().Ctx(ctx).Err(err).Str("uid", c.UID).Msg("failed to parse contact for prop-filter")
+			continue
+		}
+		if ok {
+			out = append(out, c)
+		}
+	}
+	return out
+}
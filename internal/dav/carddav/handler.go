@@ -11,6 +11,7 @@ import (
 	"github.com/sonroyaalmerol/ldap-dav/internal/acl"
 	"github.com/sonroyaalmerol/ldap-dav/internal/auth"
 	"github.com/sonroyaalmerol/ldap-dav/internal/config"
+	"github.com/sonroyaalmerol/ldap-dav/internal/dav/common"
 	"github.com/sonroyaalmerol/ldap-dav/internal/directory"
 	"github.com/sonroyaalmerol/ldap-dav/internal/storage"
 )
@@ -23,9 +24,12 @@ type Handlers struct {
 	basePath        string
 	dir             directory.Directory
 	addressbookDirs map[string]directory.ContactDirectory
+	tokens          *common.SyncTokens
+	readOnly        common.ReadOnly
+	canonical       *common.CanonicalBodies
 }
 
-func NewHandlers(cfg *config.Config, store storage.Store, dir directory.Directory, logger zerolog.Logger) *Handlers {
+func NewHandlers(cfg *config.Config, store storage.Store, dir directory.Directory, readOnly common.ReadOnly, tokens *common.SyncTokens, logger zerolog.Logger) *Handlers {
 	addressbookDirs := make(map[string]directory.ContactDirectory)
 	for _, f := range cfg.LDAP.AddressbookFilters {
 		if !f.Enabled {
@@ -50,10 +54,27 @@ func NewHandlers(cfg *config.Config, store storage.Store, dir directory.Director
 		logger:          logger,
 		basePath:        cfg.HTTP.BasePath,
 		addressbookDirs: addressbookDirs,
+		tokens:          tokens,
+		readOnly:        readOnly,
+		canonical:       common.NewCanonicalBodies(cfg.HTTP.CanonicalCache),
 	}
 }
 
+// principalURL builds uid's principal URL under the configured layout.
+func (h *Handlers) principalURL(uid string) string {
+	return common.PrincipalURL(h.basePath, h.cfg.HTTP.PrincipalLayout, uid)
+}
+
+func (h *Handlers) supportedMethodSet(href string) common.SupportedMethodSet {
+	return common.SupportedMethodSetFor(h.basePath, h.cfg.HTTP.PrincipalLayout, h.cfg.CalDAV.SchedulingCollections, href)
+}
+
+// ensurePersonalAddressbook creates the owner's personal address book if it
+// is missing. Nothing is created in read-only maintenance mode.
 func (h *Handlers) ensurePersonalAddressbook(ctx context.Context, ownerUID string) {
+	if h.readOnly.Enabled() {
+		return
+	}
 	abURI := fmt.Sprintf("personal-%s", ownerUID)
 	ab := storage.Addressbook{
 		ID:          "",
@@ -67,7 +88,7 @@ func (h *Handlers) ensurePersonalAddressbook(ctx context.Context, ownerUID strin
 
 	if existingAB, err := h.store.GetAddressbookByURI(ctx, abURI); err != nil || existingAB == nil {
 		if err := h.store.CreateAddressbook(ab, "", "Personal Address Book"); err != nil {
-			h.logger.Error().Err(err).
+			h.logger.Error().Ctx(ctx).Err(err).
 				Str("user", ownerUID).
 				Str("addressbook", abURI).
 				Str("owner", ownerUID).
@@ -80,9 +101,9 @@ func (h *Handlers) mustCanRead(w http.ResponseWriter, ctx context.Context, pr *a
 	if pr.UserID == abOwner {
 		return true
 	}
-	eff, err := h.aclProv.Effective(ctx, &directory.User{UID: pr.UserID, DN: pr.UserDN, DisplayName: pr.Display}, abURI)
+	eff, err := h.aclProv.EffectiveAddressbook(ctx, &directory.User{UID: pr.UserID, DN: pr.UserDN, DisplayName: pr.Display}, abOwner, abURI)
 	if err != nil {
-		h.logger.Error().Err(err).
+		h.logger.Error().Ctx(ctx).Err(err).
 			Str("user", pr.UserID).
 			Str("addressbook", abURI).
 			Str("owner", abOwner).
@@ -91,7 +112,7 @@ func (h *Handlers) mustCanRead(w http.ResponseWriter, ctx context.Context, pr *a
 		return false
 	}
 	if !eff.CanRead() {
-		h.logger.Debug().
+		h.logger.Debug().Ctx(ctx).
 			Str("user", pr.UserID).
 			Str("addressbook", abURI).
 			Str("owner", abOwner).
@@ -111,7 +132,7 @@ func (h *Handlers) loadAddressbookByOwnerURI(ctx context.Context, ownerUID, abUR
 
 	addressbooks, err := h.store.ListAddressbooksByOwnerUser(ctx, ownerUID)
 	if err != nil {
-		h.logger.Error().Err(err).
+		h.logger.Error().Ctx(ctx).Err(err).
 			Str("owner", ownerUID).
 			Str("addressbook", abURI).
 			Msg("failed to list addressbooks by owner")
@@ -142,7 +163,7 @@ func (h *Handlers) resolveAddressbook(ctx context.Context, owner, abURI string)
 			return ab.ID, owner, nil
 		}
 	}
-	h.logger.Debug().
+	h.logger.Debug().Ctx(ctx).
 		Str("owner", owner).
 		Str("addressbook", abURI).
 		Msg("addressbook not found in resolveAddressbook")
@@ -153,9 +174,9 @@ func (h *Handlers) aclCheckRead(ctx context.Context, pr *auth.Principal, abURI,
 	if pr.UserID == abOwner {
 		return true, nil
 	}
-	eff, err := h.aclProv.Effective(ctx, &directory.User{UID: pr.UserID, DN: pr.UserDN, DisplayName: pr.Display}, abURI)
+	eff, err := h.aclProv.EffectiveAddressbook(ctx, &directory.User{UID: pr.UserID, DN: pr.UserDN, DisplayName: pr.Display}, abOwner, abURI)
 	if err != nil {
-		h.logger.Error().Err(err).
+		h.logger.Error().Ctx(ctx).Err(err).
 			Str("user", pr.UserID).
 			Str("addressbook", abURI).
 			Str("owner", abOwner).
@@ -168,7 +189,7 @@ func (h *Handlers) aclCheckRead(ctx context.Context, pr *auth.Principal, abURI,
 func (h *Handlers) addressbookExists(ctx context.Context, owner, uri string) bool {
 	ab, err := h.store.GetAddressbookByURI(ctx, uri)
 	if err != nil {
-		h.logger.Error().Err(err).
+		h.logger.Error().Ctx(ctx).Err(err).
 			Str("owner", owner).
 			Str("addressbook", uri).
 			Msg("failed to check if addressbook exists")
@@ -0,0 +1,134 @@
+package carddav
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/sonroyaalmerol/ldap-dav/internal/auth"
+	"github.com/sonroyaalmerol/ldap-dav/internal/config"
+	"github.com/sonroyaalmerol/ldap-dav/internal/dav/common"
+	"github.com/sonroyaalmerol/ldap-dav/internal/directory"
+	"github.com/sonroyaalmerol/ldap-dav/internal/storage"
+	"github.com/sonroyaalmerol/ldap-dav/internal/storage/sqlite"
+)
+
+// staticReadOnly is a maintenance switch fixed at construction.
+type staticReadOnly bool
+
+func (s staticReadOnly) Enabled() bool { return bool(s) }
+
+// fakeDirectory serves group ACLs from memory.
+type fakeDirectory struct {
+	acls map[string][]directory.GroupACL
+}
+
+func (d *fakeDirectory) Close() {}
+
+func (d *fakeDirectory) BindUser(ctx context.Context, username, password string) (*directory.User, error) {
+	return nil, nil
+}
+
+func (d *fakeDirectory) LookupUserByAttr(ctx context.Context, attr, value string) (*directory.User, error) {
+	return nil, nil
+}
+
+func (d *fakeDirectory) UserGroupsACL(ctx context.Context, user *directory.User) ([]directory.GroupACL, error) {
+	return d.acls[user.UID], nil
+}
+
+func (d *fakeDirectory) IntrospectToken(ctx context.Context, token, url, authHeader string) (bool, string, error) {
+	return false, "", nil
+}
+
+// newTestHandlers returns handlers over a fresh SQLite store with the
+// default configuration and an address book "contacts" owned by alice.
+func newTestHandlers(t *testing.T, dir *fakeDirectory) (*Handlers, *sqlite.Store) {
+	t.Helper()
+	store, err := sqlite.New(filepath.Join(t.TempDir(), "dav.db"), zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(store.Close)
+	if err := store.CreateAddressbook(storage.Addressbook{OwnerUserID: "alice", URI: "contacts"}, "", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.HTTP.BasePath = "/dav"
+	if dir == nil {
+		dir = &fakeDirectory{}
+	}
+	return NewHandlers(cfg, store, dir, staticReadOnly(false), common.NewSyncTokens(cfg.HTTP.SyncTokenFormat, cfg.HTTP.SyncTokenSecret), zerolog.Nop()), store
+}
+
+// serve runs a request as user through the handler for its method.
+func serve(h *Handlers, user, method, path, body string, header http.Header) *httptest.ResponseRecorder {
+	var rd io.Reader
+	if body != "" {
+		rd = strings.NewReader(body)
+	}
+	r := httptest.NewRequest(method, path, rd)
+	for k, v := range header {
+		r.Header[k] = v
+	}
+	r = r.WithContext(auth.WithPrincipal(r.Context(), &auth.Principal{UserID: user}))
+	w := httptest.NewRecorder()
+	switch method {
+	case http.MethodPut:
+		h.HandlePut(w, r)
+	case http.MethodPost:
+		h.HandlePost(w, r)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+	return w
+}
+
+func TestPutIfMatchAny(t *testing.T) {
+	h, _ := newTestHandlers(t, nil)
+	ifMatchAny := http.Header{"If-Match": {"*"}}
+	card := "BEGIN:VCARD\r\nVERSION:3.0\r\nUID:bob\r\nFN:Bob\r\nN:;Bob;;;\r\nEND:VCARD\r\n"
+
+	if w := serve(h, "alice", http.MethodPut, "/dav/addressbooks/alice/contacts/bob.vcf", card, ifMatchAny); w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("PUT If-Match: * on a missing contact = %d, want 412", w.Code)
+	}
+	if w := serve(h, "alice", http.MethodPut, "/dav/addressbooks/alice/contacts/bob.vcf", card, nil); w.Code != http.StatusCreated {
+		t.Fatalf("PUT = %d, want 201: %s", w.Code, w.Body)
+	}
+	changed := strings.Replace(card, "FN:Bob", "FN:Bob Smith", 1)
+	if w := serve(h, "alice", http.MethodPut, "/dav/addressbooks/alice/contacts/bob.vcf", changed, ifMatchAny); w.Code != http.StatusNoContent {
+		t.Fatalf("PUT If-Match: * on an existing contact = %d, want 204: %s", w.Code, w.Body)
+	}
+}
+
+func TestPostUIDConflictNeedsBind(t *testing.T) {
+	dir := &fakeDirectory{acls: map[string][]directory.GroupACL{
+		"bob":   {{AddressbookID: "alice/contacts", Read: true, Bind: true, WriteContent: true}},
+		"carol": {{AddressbookID: "alice/contacts", Read: true}},
+	}}
+	h, _ := newTestHandlers(t, dir)
+	card := "BEGIN:VCARD\r\nVERSION:3.0\r\nUID:dave\r\nFN:Dave\r\nN:;Dave;;;\r\nEND:VCARD\r\n"
+	if w := serve(h, "alice", http.MethodPut, "/dav/addressbooks/alice/contacts/dave.vcf", card, nil); w.Code != http.StatusCreated {
+		t.Fatalf("PUT = %d, want 201: %s", w.Code, w.Body)
+	}
+
+	w := serve(h, "bob", http.MethodPost, "/dav/addressbooks/alice/contacts/", card, nil)
+	if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "no-uid-conflict") {
+		t.Errorf("POST by a writer = %d %q, want 403 with no-uid-conflict", w.Code, w.Body)
+	}
+	for _, uid := range []string{"dave", "missing"} {
+		w := serve(h, "carol", http.MethodPost, "/dav/addressbooks/alice/contacts/", strings.Replace(card, "UID:dave", "UID:"+uid, 1), nil)
+		if w.Code != http.StatusForbidden || strings.Contains(w.Body.String(), "no-uid-conflict") {
+			t.Errorf("POST of %s by a reader = %d %q, want a plain 403", uid, w.Code, w.Body)
+		}
+	}
+}
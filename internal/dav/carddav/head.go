@@ -1,9 +0,0 @@
-package carddav
-
-import "net/http"
-
-type headResponseWriter struct {
-	http.ResponseWriter
-}
-
-func (hrw *headResponseWriter) Write(b []byte) (int, error) { return len(b), nil }
@@ -3,6 +3,8 @@ package carddav
 import (
 	"fmt"
 	"hash/fnv"
+	"net/http"
+	"time"
 
 	"github.com/sonroyaalmerol/ldap-dav/internal/directory"
 )
@@ -24,3 +26,16 @@ func computeStableETag(ct *directory.Contact) string {
 	write(ct.Title)
 	return fmt.Sprintf("%x", h.Sum(nil))
 }
+
+// notModifiedSince reports whether If-Modified-Since covers modified.
+// It is ignored when If-None-Match is present, per RFC 9110.
+func notModifiedSince(r *http.Request, modified time.Time) bool {
+	if modified.IsZero() || r.Header.Get("If-None-Match") != "" {
+		return false
+	}
+	ims, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	return !modified.Truncate(time.Second).After(ims)
+}
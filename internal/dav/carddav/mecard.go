@@ -0,0 +1,152 @@
+package carddav
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/sonroyaalmerol/ldap-dav/internal/dav/common"
+)
+
+// homePropertyPrefix keys the dead properties of an addressbook home, such
+// as CS:me-card, which has no stored collection of its own.
+const homePropertyPrefix = "addressbook-home:"
+
+func homeResourceID(owner string) string {
+	return homePropertyPrefix + owner
+}
+
+// meCardName is the calendarserver property macOS Contacts uses to mark the
+// user's own card.
+var meCardName = xml.Name{Space: common.NSCS, Local: "me-card"}
+
+// validMeCard reports whether a CS:me-card value points at an existing
+// contact in one of the owner's addressbooks.
+func (h *Handlers) validMeCard(ctx context.Context, raw common.RawXMLValue, owner string) bool {
+	data, err := xml.Marshal(&raw)
+	if err != nil {
+		return false
+	}
+	var v struct {
+		Href string `xml:"DAV: href"`
+	}
+	if err := xml.Unmarshal(data, &v); err != nil {
+		return false
+	}
+	u, err := url.Parse(v.Href)
+	if err != nil {
+		return false
+	}
+	o, abURI, rest := splitResourcePath(u.Path, h.basePath)
+	if o != owner || abURI == "" || len(rest) != 1 {
+		return false
+	}
+	uid := strings.TrimSuffix(rest[0], filepath.Ext(rest[0]))
+	if !common.SafeSegment(abURI) || !common.SafeSegment(uid) {
+		return false
+	}
+
+	addressbookID, _, err := h.resolveAddressbook(ctx, owner, abURI)
+	if err != nil {
+		return false
+	}
+	if strings.HasPrefix(addressbookID, "ldap_") {
+		dir := h.addressbookDirs[abURI]
+		if dir == nil {
+			return false
+		}
+		contact, err := dir.GetContact(ctx, uid)
+		return err == nil && contact != nil
+	}
+	contact, err := h.store.GetContact(ctx, addressbookID, uid)
+	return err == nil && contact != nil
+}
+
+// proppatchHome stores properties set on the addressbook home, including
+// its DAV:displayname.
+func (h *Handlers) proppatchHome(w http.ResponseWriter, r *http.Request, owner string) {
+	pr := common.MustPrincipal(r.Context())
+	if pr.UserID != owner {
+		h.logger.Debug().Ctx(r.Context()).
+			Str("user", pr.UserID).
+			Str("owner", owner).
+			Msg("PROPPATCH addressbook home forbidden - user mismatch")
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	maxBody := h.cfg.HTTP.MaxProppatchBytes
+	if common.ExceedsLimit(r, maxBody) {
+		common.ServeTooLarge(w, maxBody)
+		return
+	}
+	body, err := common.ReadBody(r, maxBody)
+	if errors.Is(err, common.ErrBodyTooLarge) {
+		common.ServeTooLarge(w, maxBody)
+		return
+	}
+	if err != nil {
+		h.logger.Error().Ctx(r.Context()).Err(err).Msg("failed to read PROPPATCH body")
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	type setRemove struct {
+		Prop common.Prop `xml:"DAV: prop"`
+	}
+	var req struct {
+		XMLName xml.Name   `xml:"DAV: propertyupdate"`
+		Set     *setRemove `xml:"DAV: set"`
+		Remove  *setRemove `xml:"DAV: remove"`
+	}
+	if err := xml.Unmarshal(body, &req); err != nil {
+		h.logger.Debug().Ctx(r.Context()).Err(err).Msg("failed to unmarshal PROPPATCH XML")
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	resp := common.Response{Hrefs: []common.Href{{Value: r.URL.Path}}}
+
+	var set, remove []common.RawXMLValue
+	if req.Set != nil {
+		for _, raw := range req.Set.Prop.Raw {
+			if name, ok := raw.Name(); ok && name == meCardName && !h.validMeCard(r.Context(), raw, owner) {
+				_ = resp.EncodeProp(http.StatusConflict, struct {
+					XMLName xml.Name `xml:"http://calendarserver.org/ns/ me-card"`
+				}{})
+				continue
+			}
+			set = append(set, raw)
+		}
+	}
+	if req.Remove != nil {
+		remove = req.Remove.Prop.Raw
+	}
+
+	isDisplayName := func(name xml.Name) bool { return name == common.DisplayNameProp }
+	patchName := func(props []common.RawXMLValue, removing bool) {
+		for _, raw := range props {
+			if name, ok := raw.Name(); !ok || !isDisplayName(name) {
+				continue
+			}
+			if err := common.PatchDisplayName(r.Context(), h.store, homeResourceID(owner), raw, removing, &resp); err != nil {
+				h.logger.Error().Ctx(r.Context()).Err(err).Str("owner", owner).Msg("failed to update addressbook home display name")
+			}
+		}
+	}
+	patchName(set, false)
+	patchName(remove, true)
+
+	if err := common.PatchDeadProperties(r.Context(), h.store, homeResourceID(owner), set, remove, isDisplayName, &resp); err != nil {
+		h.logger.Error().Ctx(r.Context()).Err(err).Str("owner", owner).Msg("failed to update addressbook home properties")
+	}
+
+	ms := common.MultiStatus{Responses: []common.Response{resp}}
+	if err := common.ServeMultiStatus(w, &ms); err != nil {
+		h.logger.Error().Ctx(r.Context()).Err(err).Msg("failed to serve MultiStatus for PROPPATCH")
+	}
+}
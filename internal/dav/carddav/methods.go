@@ -1,7 +1,9 @@
 package carddav
 
 import (
+	"bytes"
 	"encoding/xml"
+	"errors"
 	"io"
 	"net/http"
 	"path/filepath"
@@ -18,14 +20,14 @@ func (h *Handlers) GetCapabilities() string {
 }
 
 func (h *Handlers) HandleHead(w http.ResponseWriter, r *http.Request) {
-	hrw := &headResponseWriter{ResponseWriter: w}
+	hrw := &common.HeadResponseWriter{ResponseWriter: w}
 	h.HandleGet(hrw, r)
 }
 
 func (h *Handlers) HandleGet(w http.ResponseWriter, r *http.Request) {
 	owner, abURI, rest := splitResourcePath(r.URL.Path, h.basePath)
 	if owner == "" || len(rest) == 0 {
-		h.logger.Debug().Str("path", r.URL.Path).Msg("GET request with invalid path")
+		h.logger.Debug().Ctx(r.Context()).Str("path", r.URL.Path).Msg("GET request with invalid path")
 		http.NotFound(w, r)
 		return
 	}
@@ -33,7 +35,7 @@ func (h *Handlers) HandleGet(w http.ResponseWriter, r *http.Request) {
 	uid := strings.TrimSuffix(filename, filepath.Ext(filename))
 
 	if !common.SafeSegment(abURI) || !common.SafeSegment(uid) {
-		h.logger.Error().
+		h.logger.Error().Ctx(r.Context()).
 			Str("addressbook", abURI).
 			Str("uid", uid).
 			Msg("GET request with unsafe path segments")
@@ -43,7 +45,7 @@ func (h *Handlers) HandleGet(w http.ResponseWriter, r *http.Request) {
 
 	addressbookID, abOwner, err := h.resolveAddressbook(r.Context(), owner, abURI)
 	if err != nil {
-		h.logger.Error().Err(err).
+		h.logger.Error().Ctx(r.Context()).Err(err).
 			Str("owner", owner).
 			Str("addressbook", abURI).
 			Msg("failed to resolve addressbook in GET")
@@ -54,7 +56,7 @@ func (h *Handlers) HandleGet(w http.ResponseWriter, r *http.Request) {
 	if strings.HasPrefix(addressbookID, "ldap_") {
 		dir := h.addressbookDirs[abURI]
 		if dir == nil {
-			h.logger.Error().Err(err).
+			h.logger.Error().Ctx(r.Context()).Err(err).
 				Str("owner", owner).
 				Str("addressbook", abURI).
 				Msg("failed to resolve addressbook ldap in GET")
@@ -63,7 +65,7 @@ func (h *Handlers) HandleGet(w http.ResponseWriter, r *http.Request) {
 		}
 		contact, err := dir.GetContact(r.Context(), uid)
 		if err != nil {
-			h.logger.Error().Err(err).
+			h.logger.Error().Ctx(r.Context()).Err(err).
 				Str("owner", owner).
 				Str("addressbook", abURI).
 				Msg("failed to resolve addressbook in GET")
@@ -73,22 +75,25 @@ func (h *Handlers) HandleGet(w http.ResponseWriter, r *http.Request) {
 
 		etag := computeStableETag(contact)
 		inm := common.TrimQuotes(r.Header.Get("If-None-Match"))
-		if inm != "" && inm == etag {
+		if (inm != "" && inm == etag) || notModifiedSince(r, contact.ModifiedAt) {
 			w.WriteHeader(http.StatusNotModified)
 			return
 		}
 		w.Header().Set("Content-Type", "text/vcard; charset=utf-8")
 		w.Header().Set("ETag", `"`+etag+`"`)
-		_, _ = io.WriteString(w, contact.VCardData)
+		if !contact.ModifiedAt.IsZero() {
+			w.Header().Set("Last-Modified", contact.ModifiedAt.Format(http.TimeFormat))
+		}
+		common.WriteBody(w, h.canonicalData(contact.VCardData))
 		return
 	}
 
 	pr := common.MustPrincipal(r.Context())
 
 	if pr.UserID != abOwner {
-		eff, err := h.aclProv.Effective(r.Context(), &directory.User{UID: pr.UserID, DN: pr.UserDN, DisplayName: pr.Display}, abURI)
+		eff, err := h.aclProv.EffectiveAddressbook(r.Context(), &directory.User{UID: pr.UserID, DN: pr.UserDN, DisplayName: pr.Display}, abOwner, abURI)
 		if err != nil {
-			h.logger.Error().Err(err).
+			h.logger.Error().Ctx(r.Context()).Err(err).
 				Str("user", pr.UserID).
 				Str("addressbook", abURI).
 				Msg("ACL check failed in GET")
@@ -96,7 +101,7 @@ func (h *Handlers) HandleGet(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		if !eff.Read {
-			h.logger.Debug().
+			h.logger.Debug().Ctx(r.Context()).
 				Str("user", pr.UserID).
 				Str("addressbook", abURI).
 				Msg("insufficient DAV:read privileges for GET")
@@ -107,7 +112,7 @@ func (h *Handlers) HandleGet(w http.ResponseWriter, r *http.Request) {
 
 	contact, err := h.store.GetContact(r.Context(), addressbookID, uid)
 	if err != nil {
-		h.logger.Error().Err(err).
+		h.logger.Error().Ctx(r.Context()).Err(err).
 			Str("addressbookID", addressbookID).
 			Str("uid", uid).
 			Msg("failed to get contact in GET")
@@ -126,26 +131,45 @@ func (h *Handlers) HandleGet(w http.ResponseWriter, r *http.Request) {
 	if !contact.UpdatedAt.IsZero() {
 		w.Header().Set("Last-Modified", contact.UpdatedAt.UTC().Format("Mon, 02 Jan 2006 15:04:05 GMT"))
 	}
-	_, _ = io.WriteString(w, contact.Data)
+	common.WriteBody(w, h.canonicalContact(contact))
+}
+
+// canonicalData serves a card with CRLF-folded lines, whatever form it was
+// stored in, unless HTTP_CANONICAL_GET is off. GET and REPORT both go
+// through it so they return the same bytes.
+func (h *Handlers) canonicalData(data string) string {
+	if !h.cfg.HTTP.CanonicalGET {
+		return data
+	}
+	return common.FoldContentLines(data)
+}
+
+// canonicalContact is canonicalData for a stored card, reused until the
+// card's ETag changes.
+func (h *Handlers) canonicalContact(c *storage.Contact) string {
+	if !h.cfg.HTTP.CanonicalGET {
+		return c.Data
+	}
+	return h.canonical.Get(c.AddressbookID+"/"+c.UID, c.ETag, func() string { return h.canonicalData(c.Data) })
 }
 
 func (h *Handlers) HandlePut(w http.ResponseWriter, r *http.Request) {
 	owner, abURI, rest := splitResourcePath(r.URL.Path, h.basePath)
 	if owner == "" || len(rest) == 0 {
-		h.logger.Debug().Str("path", r.URL.Path).Msg("PUT request with invalid path")
+		h.logger.Debug().Ctx(r.Context()).Str("path", r.URL.Path).Msg("PUT request with invalid path")
 		http.NotFound(w, r)
 		return
 	}
 	filename := rest[len(rest)-1]
 	if !strings.HasSuffix(strings.ToLower(filename), ".vcf") {
-		h.logger.Error().Str("filename", filename).Msg("PUT request with invalid filename")
+		h.logger.Error().Ctx(r.Context()).Str("filename", filename).Msg("PUT request with invalid filename")
 		http.Error(w, "bad contact name", http.StatusBadRequest)
 		return
 	}
 	uid := strings.TrimSuffix(filename, filepath.Ext(filename))
 
 	if !common.SafeSegment(abURI) || !common.SafeSegment(uid) {
-		h.logger.Error().
+		h.logger.Error().Ctx(r.Context()).
 			Str("addressbook", abURI).
 			Str("uid", uid).
 			Msg("PUT request with unsafe path segments")
@@ -155,16 +179,17 @@ func (h *Handlers) HandlePut(w http.ResponseWriter, r *http.Request) {
 
 	addressbookID, abOwner, err := h.resolveAddressbook(r.Context(), owner, abURI)
 	if err != nil {
-		h.logger.Error().Err(err).
+		h.logger.Error().Ctx(r.Context()).Err(err).
 			Str("owner", owner).
 			Str("addressbook", abURI).
 			Msg("failed to resolve addressbook in PUT")
-		http.NotFound(w, r)
+		// RFC 4918 9.7.1: a PUT whose parent collection does not exist is a conflict
+		http.Error(w, "conflict: parent collection does not exist", http.StatusConflict)
 		return
 	}
 
 	if strings.HasPrefix(addressbookID, "ldap_") {
-		h.logger.Debug().Str("addressbook", abURI).Msg("PUT denied - LDAP addressbooks are read-only")
+		h.logger.Debug().Ctx(r.Context()).Str("addressbook", abURI).Msg("PUT denied - LDAP addressbooks are read-only")
 		http.Error(w, "method not allowed - LDAP addressbooks are read-only", http.StatusMethodNotAllowed)
 		return
 	}
@@ -174,9 +199,9 @@ func (h *Handlers) HandlePut(w http.ResponseWriter, r *http.Request) {
 	existing, _ := h.store.GetContact(r.Context(), addressbookID, uid)
 
 	if pr.UserID != abOwner {
-		eff, err := h.aclProv.Effective(r.Context(), &directory.User{UID: pr.UserID, DN: pr.UserDN, DisplayName: pr.Display}, abURI)
+		eff, err := h.aclProv.EffectiveAddressbook(r.Context(), &directory.User{UID: pr.UserID, DN: pr.UserDN, DisplayName: pr.Display}, abOwner, abURI)
 		if err != nil {
-			h.logger.Error().Err(err).
+			h.logger.Error().Ctx(r.Context()).Err(err).
 				Str("user", pr.UserID).
 				Str("addressbook", abURI).
 				Msg("ACL check failed in PUT")
@@ -186,7 +211,7 @@ func (h *Handlers) HandlePut(w http.ResponseWriter, r *http.Request) {
 
 		if existing == nil {
 			if !eff.Bind {
-				h.logger.Debug().
+				h.logger.Debug().Ctx(r.Context()).
 					Str("user", pr.UserID).
 					Str("addressbook", abURI).
 					Msg("insufficient DAV:bind privileges for creating new contact")
@@ -195,7 +220,7 @@ func (h *Handlers) HandlePut(w http.ResponseWriter, r *http.Request) {
 			}
 		} else {
 			if !eff.WriteContent {
-				h.logger.Debug().
+				h.logger.Debug().Ctx(r.Context()).
 					Str("user", pr.UserID).
 					Str("addressbook", abURI).
 					Msg("insufficient DAV:write-content privileges for modifying existing contact")
@@ -208,19 +233,19 @@ func (h *Handlers) HandlePut(w http.ResponseWriter, r *http.Request) {
 	maxVCard := h.cfg.HTTP.MaxVCFBytes
 	raw, err := io.ReadAll(io.LimitReader(r.Body, maxVCard+1))
 	if err != nil {
-		h.logger.Error().Err(err).Msg("failed to read PUT body")
+		h.logger.Error().Ctx(r.Context()).Err(err).Msg("failed to read PUT body")
 		http.Error(w, "bad request", http.StatusBadRequest)
 		return
 	}
 	_ = r.Body.Close()
 	if len(raw) == 0 {
-		h.logger.Error().Msg("empty body in PUT request")
+		h.logger.Error().Ctx(r.Context()).Msg("empty body in PUT request")
 		http.Error(w, "empty body", http.StatusBadRequest)
 		return
 	}
 
 	if maxVCard > 0 && int64(len(raw)) > maxVCard {
-		h.logger.Error().
+		h.logger.Error().Ctx(r.Context()).
 			Int("size", len(raw)).
 			Int64("max", maxVCard).
 			Msg("payload too large in PUT")
@@ -228,30 +253,62 @@ func (h *Handlers) HandlePut(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	raw, err = common.ToUTF8(raw, r.Header.Get("Content-Type"))
+	if err != nil {
+		h.logger.Debug().Ctx(r.Context()).Err(err).Msg("unsupported charset in PUT")
+		http.Error(w, "unsupported charset", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	if fixed, inserted := vcard.EnsureUID(raw, uid); inserted {
+		h.logger.Debug().Ctx(r.Context()).Str("uid", uid).Msg("assigned resource name as vCard UID")
+		raw = fixed
+	}
+
 	// Validate vCard data
 	if err := vcard.ValidateVCard(raw); err != nil {
-		h.logger.Error().Err(err).Msg("invalid vCard in PUT")
+		h.logger.Error().Ctx(r.Context()).Err(err).Msg("invalid vCard in PUT")
 		http.Error(w, "invalid vcard", http.StatusBadRequest)
 		return
 	}
 
+	if h.cfg.CardDAV.RejectStaleRev && existing != nil {
+		newRev, okNew := vcard.Revision(raw)
+		oldRev, okOld := vcard.Revision([]byte(existing.Data))
+		if okNew && okOld && newRev.Before(oldRev) {
+			h.logger.Debug().Ctx(r.Context()).
+				Str("uid", uid).
+				Time("rev", newRev).
+				Time("stored_rev", oldRev).
+				Msg("conflict - vCard REV older than stored")
+			http.Error(w, "conflict: stale REV", http.StatusConflict)
+			return
+		}
+	}
+
 	vcard, err := vcard.NormalizeVCard(raw, "")
 	if err != nil {
-		h.logger.Error().Err(err).Bytes("raw_vcard", raw).Msg("normalize vcard failed")
+		h.logger.Error().Ctx(r.Context()).Err(err).Bytes("raw_vcard", raw).Msg("normalize vcard failed")
 		http.Error(w, "invalid vcard", http.StatusBadRequest)
 		return
 	}
 
-	wantNew := r.Header.Get("If-None-Match") == "*"
+	wantNew := common.NoOverwrite(r)
 	match := common.TrimQuotes(r.Header.Get("If-Match"))
 
 	if wantNew && existing != nil {
-		h.logger.Debug().Str("uid", uid).Msg("precondition failed - contact exists")
+		h.logger.Debug().Ctx(r.Context()).Str("uid", uid).Msg("precondition failed - contact exists")
 		http.Error(w, "precondition failed", http.StatusPreconditionFailed)
 		return
 	}
-	if match != "" && existing != nil && existing.ETag != match {
-		h.logger.Debug().
+	// If-Match: * only asks that the contact exists (RFC 9110 section 13.1.1).
+	if match == "*" && existing == nil {
+		h.logger.Debug().Ctx(r.Context()).Str("uid", uid).Msg("precondition failed - contact does not exist")
+		http.Error(w, "precondition failed", http.StatusPreconditionFailed)
+		return
+	}
+	if match != "" && match != "*" && existing != nil && existing.ETag != match {
+		h.logger.Debug().Ctx(r.Context()).
 			Str("uid", uid).
 			Str("expected_etag", match).
 			Str("actual_etag", existing.ETag).
@@ -260,13 +317,19 @@ func (h *Handlers) HandlePut(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if h.cfg.HTTP.RequireIfMatch && existing != nil && existing.Data != string(vcard) && common.BlindOverwrite(r) {
+		h.logger.Debug().Ctx(r.Context()).Str("uid", uid).Msg("conflict - blind overwrite of a different contact")
+		http.Error(w, "conflict: If-Match required to replace this contact", http.StatusConflict)
+		return
+	}
+
 	contact := &storage.Contact{
 		AddressbookID: addressbookID,
 		UID:           uid,
 		Data:          string(vcard),
 	}
 	if err := h.store.PutContact(r.Context(), contact); err != nil {
-		h.logger.Error().Err(err).
+		h.logger.Error().Ctx(r.Context()).Err(err).
 			Str("addressbookID", addressbookID).
 			Str("uid", uid).
 			Msg("PutContact failed")
@@ -275,7 +338,7 @@ func (h *Handlers) HandlePut(w http.ResponseWriter, r *http.Request) {
 	}
 	_, _, err = h.store.RecordAddressbookChange(r.Context(), addressbookID, uid, false)
 	if err != nil {
-		h.logger.Error().Err(err).
+		h.logger.Error().Ctx(r.Context()).Err(err).
 			Str("addressbookID", addressbookID).
 			Str("uid", uid).
 			Msg("RecordAddressbookChange failed")
@@ -289,6 +352,60 @@ func (h *Handlers) HandlePut(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// HandlePost creates a contact in an addressbook under a name the server picks
+// (RFC 5995), the contact's UID where possible. The contact then goes through
+// the same checks as a PUT.
+func (h *Handlers) HandlePost(w http.ResponseWriter, r *http.Request) {
+	owner, abURI, rest := splitResourcePath(r.URL.Path, h.basePath)
+	if owner == "" || abURI == "" || len(rest) > 0 {
+		w.Header().Set("Allow", strings.Join(common.AllowedMethods(h.basePath, h.cfg.HTTP.PrincipalLayout, h.cfg.CalDAV.SchedulingCollections, r.URL.Path), ", "))
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	addressbookID, abOwner, err := h.resolveAddressbook(r.Context(), owner, abURI)
+	if err != nil {
+		h.logger.Debug().Ctx(r.Context()).Err(err).
+			Str("owner", owner).
+			Str("addressbook", abURI).
+			Msg("failed to resolve addressbook in POST")
+		http.NotFound(w, r)
+		return
+	}
+
+	// POST always adds a member, so check DAV:bind before looking at the
+	// UID; otherwise the conflict would tell anyone whether a UID exists.
+	pr := common.MustPrincipal(r.Context())
+	if pr.UserID != abOwner {
+		eff, err := h.aclProv.EffectiveAddressbook(r.Context(), &directory.User{UID: pr.UserID, DN: pr.UserDN, DisplayName: pr.Display}, abOwner, abURI)
+		if err != nil || !eff.Bind {
+			h.logger.Debug().Ctx(r.Context()).Err(err).
+				Str("user", pr.UserID).
+				Str("addressbook", abURI).
+				Msg("insufficient DAV:bind privileges for POST")
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+	}
+
+	raw, err := io.ReadAll(io.LimitReader(r.Body, h.cfg.HTTP.MaxVCFBytes+1))
+	if err != nil {
+		h.logger.Error().Ctx(r.Context()).Err(err).Msg("failed to read POST body")
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	_ = r.Body.Close()
+
+	name := common.MemberName(vcard.UID(raw))
+	href := strings.TrimSuffix(r.URL.Path, "/") + "/" + name + ".vcf"
+	if existing, _ := h.store.GetContact(r.Context(), addressbookID, name); existing != nil {
+		h.logger.Debug().Ctx(r.Context()).Str("uid", name).Str("addressbook", abURI).Msg("UID already in use in POST")
+		_ = common.ServeError(w, http.StatusForbidden, common.NoCardUIDConflict{Href: common.Href{Value: common.EscapedHref(href)}})
+		return
+	}
+	common.PostToCollection(w, r, href, raw, h.HandlePut)
+}
+
 func (h *Handlers) HandleDelete(w http.ResponseWriter, r *http.Request) {
 	pr := common.MustPrincipal(r.Context())
 	owner, abURI, rest := splitResourcePath(r.URL.Path, h.basePath)
@@ -300,7 +417,7 @@ func (h *Handlers) HandleDelete(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if owner == "" || abURI == "" {
-		h.logger.Error().
+		h.logger.Error().Ctx(r.Context()).
 			Str("path", r.URL.Path).
 			Str("owner", owner).
 			Str("addressbook", abURI).
@@ -309,15 +426,21 @@ func (h *Handlers) HandleDelete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if herr := common.ValidateDeleteDepth(r.Header.Get("Depth"), len(rest) == 0); herr != nil {
+		h.logger.Debug().Ctx(r.Context()).Err(herr).Str("path", r.URL.Path).Msg("rejecting DELETE")
+		http.Error(w, herr.Error(), herr.Code)
+		return
+	}
+
 	if len(rest) == 0 {
 		if !common.SafeCollectionName(abURI) {
-			h.logger.Error().Str("addressbook", abURI).Msg("unsafe collection name in DELETE")
+			h.logger.Error().Ctx(r.Context()).Str("addressbook", abURI).Msg("unsafe collection name in DELETE")
 			http.Error(w, "bad collection name", http.StatusBadRequest)
 			return
 		}
 
 		if pr.UserID != owner {
-			h.logger.Debug().
+			h.logger.Debug().Ctx(r.Context()).
 				Str("user", pr.UserID).
 				Str("addressbook", abURI).
 				Msg("insufficient privileges for DELETE addressbook")
@@ -326,7 +449,7 @@ func (h *Handlers) HandleDelete(w http.ResponseWriter, r *http.Request) {
 		}
 
 		if err := h.store.DeleteAddressbook(owner, abURI); err != nil {
-			h.logger.Error().Err(err).
+			h.logger.Error().Ctx(r.Context()).Err(err).
 				Str("owner", owner).
 				Str("addressbook", abURI).
 				Msg("failed to delete addressbook")
@@ -341,7 +464,7 @@ func (h *Handlers) HandleDelete(w http.ResponseWriter, r *http.Request) {
 	uid := strings.TrimSuffix(filename, filepath.Ext(filename))
 
 	if !common.SafeSegment(abURI) || !common.SafeSegment(uid) {
-		h.logger.Error().
+		h.logger.Error().Ctx(r.Context()).
 			Str("addressbook", abURI).
 			Str("uid", uid).
 			Msg("unsafe path segments in DELETE contact")
@@ -351,7 +474,7 @@ func (h *Handlers) HandleDelete(w http.ResponseWriter, r *http.Request) {
 
 	addressbookID, abOwner, err := h.resolveAddressbook(r.Context(), owner, abURI)
 	if err != nil {
-		h.logger.Error().Err(err).
+		h.logger.Error().Ctx(r.Context()).Err(err).
 			Str("owner", owner).
 			Str("addressbook", abURI).
 			Msg("failed to resolve addressbook in DELETE")
@@ -360,7 +483,7 @@ func (h *Handlers) HandleDelete(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if strings.HasPrefix(addressbookID, "ldap_") {
-		h.logger.Debug().
+		h.logger.Debug().Ctx(r.Context()).
 			Str("addressbook", abURI).
 			Msg("DELETE request denied - LDAP addressbooks are read-only")
 		http.Error(w, "method not allowed - LDAP addressbooks are read-only", http.StatusMethodNotAllowed)
@@ -368,9 +491,9 @@ func (h *Handlers) HandleDelete(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if pr.UserID != abOwner {
-		eff, err := h.aclProv.Effective(r.Context(), &directory.User{UID: pr.UserID, DN: pr.UserDN, DisplayName: pr.Display}, abURI)
+		eff, err := h.aclProv.EffectiveAddressbook(r.Context(), &directory.User{UID: pr.UserID, DN: pr.UserDN, DisplayName: pr.Display}, abOwner, abURI)
 		if err != nil {
-			h.logger.Error().Err(err).
+			h.logger.Error().Ctx(r.Context()).Err(err).
 				Str("user", pr.UserID).
 				Str("addressbook", abURI).
 				Msg("ACL check failed in DELETE contact")
@@ -378,7 +501,7 @@ func (h *Handlers) HandleDelete(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		if !eff.Unbind {
-			h.logger.Debug().
+			h.logger.Debug().Ctx(r.Context()).
 				Str("user", pr.UserID).
 				Str("addressbook", abURI).
 				Msg("insufficient DAV:unbind privileges for DELETE contact")
@@ -388,8 +511,23 @@ func (h *Handlers) HandleDelete(w http.ResponseWriter, r *http.Request) {
 	}
 
 	match := common.TrimQuotes(r.Header.Get("If-Match"))
+	if match != "" {
+		// If-Match: * only asks that the contact exists (RFC 9110 section 13.1.1).
+		existing, _ := h.store.GetContact(r.Context(), addressbookID, uid)
+		if existing == nil || (match != "*" && existing.ETag != match) {
+			h.logger.Debug().Ctx(r.Context()).
+				Str("uid", uid).
+				Str("expected_etag", match).
+				Msg("precondition failed in DELETE contact")
+			http.Error(w, "precondition failed", http.StatusPreconditionFailed)
+			return
+		}
+		if match == "*" {
+			match = ""
+		}
+	}
 	if err := h.store.DeleteContact(r.Context(), addressbookID, uid, match); err != nil {
-		h.logger.Error().Err(err).
+		h.logger.Error().Ctx(r.Context()).Err(err).
 			Str("addressbookID", addressbookID).
 			Str("uid", uid).
 			Msg("failed to delete contact")
@@ -398,7 +536,7 @@ func (h *Handlers) HandleDelete(w http.ResponseWriter, r *http.Request) {
 	}
 	_, _, err = h.store.RecordAddressbookChange(r.Context(), addressbookID, uid, true)
 	if err != nil {
-		h.logger.Error().Err(err).
+		h.logger.Error().Ctx(r.Context()).Err(err).
 			Str("addressbookID", addressbookID).
 			Str("uid", uid).
 			Msg("RecordAddressbookChange failed for DELETE")
@@ -419,22 +557,22 @@ func (h *Handlers) HandleMkcol(w http.ResponseWriter, r *http.Request) {
 		if o2, ab2, ok := tryAddressbookShorthand(r.URL.Path, h.basePath, pr.UserID); ok {
 			owner, abURI, rest = o2, ab2, nil
 		} else {
-			h.logger.Error().Str("path", r.URL.Path).Msg("MKCOL with invalid path")
+			h.logger.Error().Ctx(r.Context()).Str("path", r.URL.Path).Msg("MKCOL with invalid path")
 			http.Error(w, "bad path", http.StatusBadRequest)
 			return
 		}
 	}
 
 	if !common.SafeCollectionName(abURI) {
-		h.logger.Error().Str("addressbook", abURI).Msg("unsafe collection name in MKCOL")
+		h.logger.Error().Ctx(r.Context()).Str("addressbook", abURI).Msg("unsafe collection name in MKCOL")
 		http.Error(w, "bad collection name", http.StatusBadRequest)
 		return
 	}
 
 	if pr.UserID != owner {
-		eff, err := h.aclProv.Effective(r.Context(), &directory.User{UID: pr.UserID, DN: pr.UserDN, DisplayName: pr.Display}, "")
+		eff, err := h.aclProv.EffectiveAddressbook(r.Context(), &directory.User{UID: pr.UserID, DN: pr.UserDN, DisplayName: pr.Display}, owner, "")
 		if err != nil {
-			h.logger.Error().Err(err).
+			h.logger.Error().Ctx(r.Context()).Err(err).
 				Str("user", pr.UserID).
 				Str("owner", owner).
 				Msg("ACL check failed in MKCOL")
@@ -442,7 +580,7 @@ func (h *Handlers) HandleMkcol(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		if !eff.Bind {
-			h.logger.Debug().
+			h.logger.Debug().Ctx(r.Context()).
 				Str("user", pr.UserID).
 				Str("owner", owner).
 				Msg("insufficient DAV:bind privileges for MKCOL")
@@ -451,13 +589,27 @@ func (h *Handlers) HandleMkcol(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	maxBody := h.cfg.HTTP.MaxMkcolBytes
+	if common.ExceedsLimit(r, maxBody) {
+		h.logger.Debug().Ctx(r.Context()).
+			Int64("content_length", r.ContentLength).
+			Int64("limit", maxBody).
+			Msg("MKCOL body too large")
+		common.ServeTooLarge(w, maxBody)
+		return
+	}
+
+	body, err := common.ReadBody(r, maxBody)
+	if errors.Is(err, common.ErrBodyTooLarge) {
+		h.logger.Debug().Ctx(r.Context()).Int64("limit", maxBody).Msg("MKCOL body too large")
+		common.ServeTooLarge(w, maxBody)
+		return
+	}
 	if err != nil {
-		h.logger.Error().Err(err).Msg("failed to read MKCOL body")
+		h.logger.Error().Ctx(r.Context()).Err(err).Msg("failed to read MKCOL body")
 		http.Error(w, "bad request", http.StatusBadRequest)
 		return
 	}
-	_ = r.Body.Close()
 
 	type mkcolProp struct {
 		XMLName      xml.Name `xml:"DAV: prop"`
@@ -478,19 +630,19 @@ func (h *Handlers) HandleMkcol(w http.ResponseWriter, r *http.Request) {
 
 	if len(body) > 0 {
 		if err := xml.Unmarshal(body, &mkcolReq); err != nil {
-			h.logger.Error().Err(err).Msg("failed to unmarshal MKCOL XML")
+			h.logger.Error().Ctx(r.Context()).Err(err).Msg("failed to unmarshal MKCOL XML")
 		}
 	}
 
 	isAddressbook := mkcolReq.Set != nil && mkcolReq.Set.Prop.ResourceType.Addressbook != nil
 	if !isAddressbook {
-		h.logger.Error().Msg("MKCOL with unsupported collection type")
+		h.logger.Error().Ctx(r.Context()).Msg("MKCOL with unsupported collection type")
 		http.Error(w, "unsupported collection type", http.StatusUnsupportedMediaType)
 		return
 	}
 
 	if h.addressbookExists(r.Context(), owner, abURI) {
-		h.logger.Debug().
+		h.logger.Debug().Ctx(r.Context()).
 			Str("owner", owner).
 			Str("addressbook", abURI).
 			Msg("addressbook already exists in MKCOL")
@@ -517,7 +669,7 @@ func (h *Handlers) HandleMkcol(w http.ResponseWriter, r *http.Request) {
 		Description: description,
 	}
 	if err := h.store.CreateAddressbook(newAB, "", description); err != nil {
-		h.logger.Error().Err(err).
+		h.logger.Error().Ctx(r.Context()).Err(err).
 			Str("owner", owner).
 			Str("addressbook", abURI).
 			Msg("failed to create addressbook in MKCOL")
@@ -540,23 +692,28 @@ func (h *Handlers) HandleProppatch(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if owner != "" && abURI == "" && len(rest) == 0 {
+		h.proppatchHome(w, r, owner)
+		return
+	}
+
 	if owner == "" || abURI == "" || len(rest) != 0 {
-		h.logger.Error().Str("path", r.URL.Path).Msg("PROPPATCH with invalid path")
+		h.logger.Error().Ctx(r.Context()).Str("path", r.URL.Path).Msg("PROPPATCH with invalid path")
 		http.Error(w, "bad path", http.StatusBadRequest)
 		return
 	}
 
 	if !common.SafeSegment(abURI) {
-		h.logger.Error().Str("addressbook", abURI).Msg("unsafe path in PROPPATCH")
+		h.logger.Error().Ctx(r.Context()).Str("addressbook", abURI).Msg("unsafe path in PROPPATCH")
 		http.Error(w, "bad path", http.StatusBadRequest)
 		return
 	}
 
 	pr := common.MustPrincipal(r.Context())
 	if pr.UserID != owner {
-		eff, err := h.aclProv.Effective(r.Context(), &directory.User{UID: pr.UserID, DN: pr.UserDN, DisplayName: pr.Display}, abURI)
+		eff, err := h.aclProv.EffectiveAddressbook(r.Context(), &directory.User{UID: pr.UserID, DN: pr.UserDN, DisplayName: pr.Display}, owner, abURI)
 		if err != nil {
-			h.logger.Error().Err(err).
+			h.logger.Error().Ctx(r.Context()).Err(err).
 				Str("user", pr.UserID).
 				Str("addressbook", abURI).
 				Msg("ACL check failed in PROPPATCH")
@@ -564,7 +721,7 @@ func (h *Handlers) HandleProppatch(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		if !eff.WriteProps {
-			h.logger.Debug().
+			h.logger.Debug().Ctx(r.Context()).
 				Str("user", pr.UserID).
 				Str("addressbook", abURI).
 				Msg("insufficient DAV:write-properties privileges for PROPPATCH")
@@ -573,13 +730,27 @@ func (h *Handlers) HandleProppatch(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	maxBody := h.cfg.HTTP.MaxProppatchBytes
+	if common.ExceedsLimit(r, maxBody) {
+		h.logger.Debug().Ctx(r.Context()).
+			Int64("content_length", r.ContentLength).
+			Int64("limit", maxBody).
+			Msg("PROPPATCH body too large")
+		common.ServeTooLarge(w, maxBody)
+		return
+	}
+
+	body, err := common.ReadBody(r, maxBody)
+	if errors.Is(err, common.ErrBodyTooLarge) {
+		h.logger.Debug().Ctx(r.Context()).Int64("limit", maxBody).Msg("PROPPATCH body too large")
+		common.ServeTooLarge(w, maxBody)
+		return
+	}
 	if err != nil {
-		h.logger.Error().Err(err).Msg("failed to read PROPPATCH body")
+		h.logger.Error().Ctx(r.Context()).Err(err).Msg("failed to read PROPPATCH body")
 		http.Error(w, "bad request", http.StatusBadRequest)
 		return
 	}
-	_ = r.Body.Close()
 
 	type setRemoveProp struct {
 		DisplayName *string              `xml:"DAV: displayname"`
@@ -597,7 +768,7 @@ func (h *Handlers) HandleProppatch(w http.ResponseWriter, r *http.Request) {
 
 	okXML := true
 	if err := xml.Unmarshal(body, &req); err != nil {
-		h.logger.Error().Err(err).Msg("failed to unmarshal PROPPATCH XML")
+		h.logger.Error().Ctx(r.Context()).Err(err).Msg("failed to unmarshal PROPPATCH XML")
 		okXML = false
 	}
 
@@ -618,7 +789,7 @@ func (h *Handlers) HandleProppatch(w http.ResponseWriter, r *http.Request) {
 
 	if newName != nil || (okXML && req.Remove != nil && req.Remove.Prop.DisplayName != nil) {
 		if err := h.store.UpdateAddressbookDisplayName(r.Context(), owner, abURI, newName); err != nil {
-			h.logger.Error().Err(err).Msg("Failed to update addressbook display name")
+			h.logger.Error().Ctx(r.Context()).Err(err).Msg("Failed to update addressbook display name")
 			displayNameStatus = http.StatusInternalServerError
 		}
 	}
@@ -633,13 +804,31 @@ func (h *Handlers) HandleProppatch(w http.ResponseWriter, r *http.Request) {
 			propValue = *newName
 		}
 		if err := resp.EncodeProp(displayNameStatus, common.DisplayName{Name: propValue}); err != nil {
-			h.logger.Error().Err(err).Msg("failed to encode DisplayName property in PROPPATCH")
+			h.logger.Error().Ctx(r.Context()).Err(err).Msg("failed to encode DisplayName property in PROPPATCH")
+		}
+	}
+
+	var set, remove []common.RawXMLValue
+	if okXML && req.Set != nil {
+		set = req.Set.Prop.Raw
+	}
+	if okXML && req.Remove != nil {
+		remove = req.Remove.Prop.Raw
+	}
+	if len(set) > 0 || len(remove) > 0 {
+		if addressbookID, _, err := h.resolveAddressbook(r.Context(), owner, abURI); err != nil {
+			h.logger.Error().Ctx(r.Context()).Err(err).
+				Str("owner", owner).
+				Str("addressbook", abURI).
+				Msg("failed to resolve addressbook for dead properties")
+		} else if err := common.PatchDeadProperties(r.Context(), h.store, addressbookID, set, remove, func(xml.Name) bool { return false }, &resp); err != nil {
+			h.logger.Error().Ctx(r.Context()).Err(err).Str("addressbook", abURI).Msg("failed to update dead properties")
 		}
 	}
 
 	ms := common.MultiStatus{Responses: []common.Response{resp}}
 	if err := common.ServeMultiStatus(w, &ms); err != nil {
-		h.logger.Error().Err(err).Msg("failed to serve MultiStatus for PROPPATCH")
+		h.logger.Error().Ctx(r.Context()).Err(err).Msg("failed to serve MultiStatus for PROPPATCH")
 	}
 }
 
@@ -650,7 +839,7 @@ func (h *Handlers) HandleReport(w http.ResponseWriter, r *http.Request) {
 	if owner != "" && abURI != "" && len(rest) == 0 {
 		_, abOwner, err := h.resolveAddressbook(r.Context(), owner, abURI)
 		if err != nil {
-			h.logger.Error().Err(err).
+			h.logger.Error().Ctx(r.Context()).Err(err).
 				Str("owner", owner).
 				Str("addressbook", abURI).
 				Msg("failed to resolve addressbook in REPORT")
@@ -659,9 +848,9 @@ func (h *Handlers) HandleReport(w http.ResponseWriter, r *http.Request) {
 		}
 
 		if pr.UserID != abOwner {
-			eff, err := h.aclProv.Effective(r.Context(), &directory.User{UID: pr.UserID, DN: pr.UserDN, DisplayName: pr.Display}, abURI)
+			eff, err := h.aclProv.EffectiveAddressbook(r.Context(), &directory.User{UID: pr.UserID, DN: pr.UserDN, DisplayName: pr.Display}, abOwner, abURI)
 			if err != nil {
-				h.logger.Error().Err(err).
+				h.logger.Error().Ctx(r.Context()).Err(err).
 					Str("user", pr.UserID).
 					Str("addressbook", abURI).
 					Msg("ACL check failed in REPORT")
@@ -669,7 +858,7 @@ func (h *Handlers) HandleReport(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 			if !eff.Read {
-				h.logger.Debug().
+				h.logger.Debug().Ctx(r.Context()).
 					Str("user", pr.UserID).
 					Str("addressbook", abURI).
 					Msg("insufficient DAV:read privileges for REPORT")
@@ -679,21 +868,42 @@ func (h *Handlers) HandleReport(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	body, err := io.ReadAll(io.LimitReader(r.Body, 8<<20))
+	maxBody := h.cfg.HTTP.MaxReportBytes
+	if common.ExceedsLimit(r, maxBody) {
+		h.logger.Debug().Ctx(r.Context()).
+			Int64("content_length", r.ContentLength).
+			Int64("limit", maxBody).
+			Msg("REPORT body too large")
+		common.ServeTooLarge(w, maxBody)
+		return
+	}
+
+	body, err := common.ReadBody(r, maxBody)
+	if errors.Is(err, common.ErrBodyTooLarge) {
+		h.logger.Debug().Ctx(r.Context()).Int64("limit", maxBody).Msg("REPORT body too large")
+		common.ServeTooLarge(w, maxBody)
+		return
+	}
 	if err != nil {
-		h.logger.Error().Err(err).Msg("failed to read REPORT body")
+		h.logger.Error().Ctx(r.Context()).Err(err).Msg("failed to read REPORT body")
 		http.Error(w, "bad request", http.StatusBadRequest)
 		return
 	}
-	_ = r.Body.Close()
+	// The body alone identifies the report: clients that omit Content-Type
+	// are served as long as it parses as XML.
+	if len(bytes.TrimSpace(body)) == 0 {
+		h.logger.Debug().Ctx(r.Context()).Msg("REPORT without a body")
+		http.Error(w, "REPORT requires an XML body naming the report", http.StatusBadRequest)
+		return
+	}
 
-	h.logger.Debug().Str("request_body", string(body)).Msg("received request")
+	h.logger.Debug().Ctx(r.Context()).Str("request_body", string(body)).Msg("received request")
 
 	root := struct {
 		XMLName xml.Name
 	}{}
 	if err := xml.Unmarshal(body, &root); err != nil {
-		h.logger.Error().Err(err).Msg("failed to unmarshal REPORT XML")
+		h.logger.Error().Ctx(r.Context()).Err(err).Msg("failed to unmarshal REPORT XML")
 		http.Error(w, "bad xml", http.StatusBadRequest)
 		return
 	}
@@ -702,26 +912,31 @@ func (h *Handlers) HandleReport(w http.ResponseWriter, r *http.Request) {
 	case common.NSCardDAV + " addressbook-query":
 		var q common.AddressbookQuery
 		if err := xml.Unmarshal(body, &q); err != nil {
-			h.logger.Error().Err(err).Msg("failed to unmarshal addressbook-query")
+			h.logger.Error().Ctx(r.Context()).Err(err).Msg("failed to unmarshal addressbook-query")
 		}
 		h.ReportAddressbookQuery(w, r, q)
 	case common.NSCardDAV + " addressbook-multiget":
 		var mg common.AddressbookMultiget
 		if err := xml.Unmarshal(body, &mg); err != nil {
-			h.logger.Error().Err(err).Msg("failed to unmarshal addressbook-multiget")
+			h.logger.Error().Ctx(r.Context()).Err(err).Msg("failed to unmarshal addressbook-multiget")
 		}
 		h.ReportAddressbookMultiget(w, r, mg)
 	case common.NSDAV + " sync-collection":
 		var sc common.SyncCollection
 		if err := xml.Unmarshal(body, &sc); err != nil {
-			h.logger.Error().Err(err).Msg("failed to unmarshal sync-collection")
+			h.logger.Error().Ctx(r.Context()).Err(err).Msg("failed to unmarshal sync-collection")
+		}
+		if herr := common.ValidateSyncCollection(r.Header.Get("Depth"), sc); herr != nil {
+			h.logger.Debug().Ctx(r.Context()).Err(herr).Msg("rejecting sync-collection")
+			http.Error(w, herr.Error(), herr.Code)
+			return
 		}
 		h.ReportSyncCollection(w, r, sc)
 	default:
-		h.logger.Error().
+		h.logger.Error().Ctx(r.Context()).
 			Str("namespace", root.XMLName.Space).
 			Str("local", root.XMLName.Local).
 			Msg("unsupported REPORT type")
-		http.Error(w, "unsupported REPORT", http.StatusBadRequest)
+		_ = common.ServeUnsupportedReport(w, supportedReportSetValue())
 	}
 }
@@ -1,6 +1,7 @@
 package carddav
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"encoding/xml"
@@ -12,13 +13,14 @@ import (
 	"github.com/sonroyaalmerol/ldap-dav/internal/dav/common"
 	"github.com/sonroyaalmerol/ldap-dav/internal/directory"
 	"github.com/sonroyaalmerol/ldap-dav/internal/storage"
+	"github.com/sonroyaalmerol/ldap-dav/pkg/vcard"
 )
 
 func (h *Handlers) ReportAddressbookQuery(w http.ResponseWriter, r *http.Request, q common.AddressbookQuery) {
 	owner, abURI, _ := splitResourcePath(r.URL.Path, h.basePath)
 	addressbookID, abOwner, err := h.resolveAddressbook(r.Context(), owner, abURI)
 	if err != nil {
-		h.logger.Error().Err(err).
+		h.logger.Error().Ctx(r.Context()).Err(err).
 			Str("owner", owner).
 			Str("addressbook", abURI).
 			Msg("failed to resolve addressbook in addressbook-query")
@@ -33,20 +35,33 @@ func (h *Handlers) ReportAddressbookQuery(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	if cond, code := checkFilter(q.Filter); cond != nil {
+		h.logger.Debug().Ctx(r.Context()).
+			Str("addressbook", abURI).
+			Int("status", code).
+			Msg("rejecting addressbook-query filter")
+		_ = common.ServeError(w, code, cond)
+		return
+	}
+
 	filterProps := common.ExtractPropFilterNames(q.Filter)
+	limit := 0
+	if q.Limit != nil && q.Limit.NResults > 0 {
+		limit = q.Limit.NResults
+	}
 
 	if strings.HasPrefix(addressbookID, "ldap_") {
 		dir := h.addressbookDirs[abURI]
 		if dir == nil {
-			h.logger.Error().Err(err).
+			h.logger.Error().Ctx(r.Context()).Err(err).
 				Str("addressbookID", addressbookID).
 				Msg("failed to list ldap contacts in addressbook-query")
 			http.NotFound(w, r)
 			return
 		}
-		contacts, err := dir.ListContacts(r.Context())
+		contacts, truncated, err := dir.SearchContacts(r.Context(), limit)
 		if err != nil {
-			h.logger.Error().Err(err).
+			h.logger.Error().Ctx(r.Context()).Err(err).
 				Str("addressbookID", addressbookID).
 				Msg("failed to list ldap contacts in addressbook-query")
 			http.Error(w, "ldap error", http.StatusInternalServerError)
@@ -55,36 +70,114 @@ func (h *Handlers) ReportAddressbookQuery(w http.ResponseWriter, r *http.Request
 		var resps []common.Response
 		for _, ct := range contacts {
 			hrefStr := common.JoinURL(h.basePath, "addressbooks", owner, abURI, ct.ID+".vcf")
-			resps = append(resps, buildReportResponseLDAP(hrefStr, props, &ct))
+			resps = append(resps, h.buildReportResponseLDAP(hrefStr, props, &ct))
 		}
 		ms := common.MultiStatus{Responses: resps}
+		if truncated {
+			markTruncated(&ms, r.URL.Path, len(contacts))
+		}
 		if err := common.ServeMultiStatus(w, &ms); err != nil {
-			h.logger.Error().Err(err).Msg("failed to serve MultiStatus for addressbook-query")
+			h.logger.Error().Ctx(r.Context()).Err(err).Msg("failed to serve MultiStatus for addressbook-query")
 		}
 		return
 	}
 
 	contacts, err := h.store.ListContactsByFilter(r.Context(), addressbookID, filterProps)
 	if err != nil {
-		h.logger.Error().Err(err).
+		h.logger.Error().Ctx(r.Context()).Err(err).
 			Str("addressbookID", addressbookID).
 			Msg("failed to list contacts in addressbook-query")
 		http.Error(w, "storage error", http.StatusInternalServerError)
 		return
 	}
 
+	contacts = h.filterContacts(r.Context(), contacts, q.Filter)
+
+	truncated := limit > 0 && len(contacts) > limit
+	if truncated {
+		contacts = contacts[:limit]
+	}
+	// Expanded group members are returned alongside but are not matches.
+	matches := len(contacts)
+
+	if h.cfg.CardDAV.ExpandGroupMembers {
+		members, err := h.groupMembers(r.Context(), addressbookID, contacts)
+		if err != nil {
+			h.logger.Error().Ctx(r.Context()).Err(err).
+				Str("addressbookID", addressbookID).
+				Msg("failed to expand group members in addressbook-query")
+			http.Error(w, "storage error", http.StatusInternalServerError)
+			return
+		}
+		contacts = append(contacts, members...)
+	}
+
 	var resps []common.Response
 	for _, contact := range contacts {
 		hrefStr := common.JoinURL(h.basePath, "addressbooks", owner, abURI, contact.UID+".vcf")
-		resps = append(resps, buildReportResponse(hrefStr, props, contact))
+		resps = append(resps, h.buildReportResponse(hrefStr, props, contact))
 	}
 
 	ms := common.MultiStatus{Responses: resps}
+	if truncated {
+		markTruncated(&ms, r.URL.Path, matches)
+	}
 	if err := common.ServeMultiStatus(w, &ms); err != nil {
-		h.logger.Error().Err(err).Msg("failed to serve MultiStatus for addressbook-query")
+		h.logger.Error().Ctx(r.Context()).Err(err).Msg("failed to serve MultiStatus for addressbook-query")
 	}
 }
 
+// groupMembers returns the cards referenced by the groups in matched that
+// live in the same address book and are not already part of the result.
+// References are resolved against each card's UID as well as its resource
+// name.
+func (h *Handlers) groupMembers(ctx context.Context, addressbookID string, matched []*storage.Contact) ([]*storage.Contact, error) {
+	var refs []string
+	for _, c := range matched {
+		refs = append(refs, vcard.GroupMembers([]byte(c.Data))...)
+	}
+	if len(refs) == 0 {
+		return nil, nil
+	}
+
+	all, err := h.store.ListContacts(ctx, addressbookID)
+	if err != nil {
+		return nil, err
+	}
+	byUID := make(map[string]*storage.Contact, len(all))
+	for _, c := range all {
+		byUID[c.UID] = c
+		if uid := vcard.UID([]byte(c.Data)); uid != "" {
+			byUID[uid] = c
+		}
+	}
+
+	seen := make(map[*storage.Contact]bool, len(matched))
+	for _, c := range matched {
+		seen[byUID[c.UID]] = true
+	}
+	var out []*storage.Contact
+	for _, ref := range refs {
+		c, ok := byUID[ref]
+		if !ok || seen[c] {
+			continue
+		}
+		seen[c] = true
+		out = append(out, c)
+	}
+	return out, nil
+}
+
+// markTruncated flags a limited addressbook-query result as incomplete with
+// a 507 response for the request-URI (RFC 6352 section 8.6.1).
+func markTruncated(ms *common.MultiStatus, requestURI string, n int) {
+	ms.Responses = append(ms.Responses, common.Response{
+		Hrefs:  []common.Href{{Value: requestURI}},
+		Status: &common.Status{Code: http.StatusInsufficientStorage},
+	})
+	ms.NumberOfMatchesWithinLimits = fmt.Sprintf("%d", n)
+}
+
 func (h *Handlers) ReportAddressbookMultiget(w http.ResponseWriter, r *http.Request, mg common.AddressbookMultiget) {
 	props := common.ParsePropRequest(mg.Prop)
 	var resps []common.Response
@@ -105,7 +198,7 @@ func (h *Handlers) ReportAddressbookMultiget(w http.ResponseWriter, r *http.Requ
 
 		addressbookID, abOwner, err := h.resolveAddressbook(r.Context(), owner, abURI)
 		if err != nil {
-			h.logger.Debug().Err(err).
+			h.logger.Debug().Ctx(r.Context()).Err(err).
 				Str("owner", owner).
 				Str("addressbook", abURI).
 				Msg("failed to resolve addressbook in multiget")
@@ -120,7 +213,7 @@ func (h *Handlers) ReportAddressbookMultiget(w http.ResponseWriter, r *http.Requ
 		if strings.HasPrefix(addressbookID, "ldap_") {
 			dir := h.addressbookDirs[abURI]
 			if dir == nil {
-				h.logger.Debug().Err(err).
+				h.logger.Debug().Ctx(r.Context()).Err(err).
 					Str("addressbookID", addressbookID).
 					Str("uid", uid).
 					Msg("failed to get contact in ldap multiget")
@@ -134,7 +227,7 @@ func (h *Handlers) ReportAddressbookMultiget(w http.ResponseWriter, r *http.Requ
 
 			contact, err := dir.GetContact(r.Context(), uid)
 			if err != nil {
-				h.logger.Debug().Err(err).
+				h.logger.Debug().Ctx(r.Context()).Err(err).
 					Str("addressbookID", addressbookID).
 					Str("uid", uid).
 					Msg("failed to get contact in ldap multiget")
@@ -146,14 +239,14 @@ func (h *Handlers) ReportAddressbookMultiget(w http.ResponseWriter, r *http.Requ
 				continue
 			}
 
-			resps = append(resps, buildReportResponseLDAP(hrefStr, props, contact))
+			resps = append(resps, h.buildReportResponseLDAP(hrefStr, props, contact))
 			continue
 		}
 
 		pr := common.MustPrincipal(r.Context())
 		okRead, err := h.aclCheckRead(r.Context(), pr, abURI, abOwner)
 		if err != nil || !okRead {
-			h.logger.Debug().Err(err).
+			h.logger.Debug().Ctx(r.Context()).Err(err).
 				Bool("can_read", okRead).
 				Str("user", pr.UserID).
 				Str("addressbook", abURI).
@@ -168,7 +261,7 @@ func (h *Handlers) ReportAddressbookMultiget(w http.ResponseWriter, r *http.Requ
 
 		contact, err := h.store.GetContact(r.Context(), addressbookID, uid)
 		if err != nil {
-			h.logger.Debug().Err(err).
+			h.logger.Debug().Ctx(r.Context()).Err(err).
 				Str("addressbookID", addressbookID).
 				Str("uid", uid).
 				Msg("failed to get contact in multiget")
@@ -180,16 +273,16 @@ func (h *Handlers) ReportAddressbookMultiget(w http.ResponseWriter, r *http.Requ
 			continue
 		}
 
-		resps = append(resps, buildReportResponse(hrefStr, props, contact))
+		resps = append(resps, h.buildReportResponse(hrefStr, props, contact))
 	}
 
 	ms := common.MultiStatus{Responses: resps}
 	if err := common.ServeMultiStatus(w, &ms); err != nil {
-		h.logger.Error().Err(err).Msg("failed to serve MultiStatus for addressbook-multiget")
+		h.logger.Error().Ctx(r.Context()).Err(err).Msg("failed to serve MultiStatus for addressbook-multiget")
 	}
 }
 
-func buildReportResponse(hrefStr string, props common.PropRequest, contact *storage.Contact) common.Response {
+func (h *Handlers) buildReportResponse(hrefStr string, props common.PropRequest, contact *storage.Contact) common.Response {
 	resp := common.Response{
 		Hrefs: []common.Href{{Value: hrefStr}},
 	}
@@ -199,7 +292,7 @@ func buildReportResponse(hrefStr string, props common.PropRequest, contact *stor
 			XMLName xml.Name `xml:"urn:ietf:params:xml:ns:carddav address-data"`
 			Text    string   `xml:",chardata"`
 		}
-		_ = resp.EncodeProp(http.StatusOK, AddressData{Text: contact.Data})
+		_ = resp.EncodeProp(http.StatusOK, AddressData{Text: h.canonicalContact(contact)})
 	}
 	if props.GetETag && contact.ETag != "" {
 		_ = resp.EncodeProp(http.StatusOK, common.GetETag{ETag: common.ETag(contact.ETag)})
@@ -207,10 +300,13 @@ func buildReportResponse(hrefStr string, props common.PropRequest, contact *stor
 	if !contact.UpdatedAt.IsZero() {
 		_ = resp.EncodeProp(http.StatusOK, common.GetLastModified{LastModified: common.TimeText(contact.UpdatedAt)})
 	}
+	_ = resp.EncodeProp(http.StatusOK, common.ResourceType{})
+	_ = resp.EncodeProp(http.StatusOK, common.GetContentLength{Length: int64(len(contact.Data))})
+	props.Selection.FilterResponse(&resp)
 	return resp
 }
 
-func buildReportResponseLDAP(hrefStr string, props common.PropRequest, contact *directory.Contact) common.Response {
+func (h *Handlers) buildReportResponseLDAP(hrefStr string, props common.PropRequest, contact *directory.Contact) common.Response {
 	vcardStr := contact.VCardData
 	etag := computeStableETag(contact)
 
@@ -221,11 +317,14 @@ func buildReportResponseLDAP(hrefStr string, props common.PropRequest, contact *
 			XMLName xml.Name `xml:"urn:ietf:params:xml:ns:carddav address-data"`
 			Text    string   `xml:",chardata"`
 		}
-		_ = resp.EncodeProp(http.StatusOK, AddressData{Text: vcardStr})
+		_ = resp.EncodeProp(http.StatusOK, AddressData{Text: h.canonicalData(vcardStr)})
 	}
 	if props.GetETag && etag != "" {
 		_ = resp.EncodeProp(http.StatusOK, common.GetETag{ETag: common.ETag(etag)})
 	}
+	_ = resp.EncodeProp(http.StatusOK, common.ResourceType{})
+	_ = resp.EncodeProp(http.StatusOK, common.GetContentLength{Length: int64(len(vcardStr))})
+	props.Selection.FilterResponse(&resp)
 	return resp
 }
 
@@ -233,7 +332,7 @@ func (h *Handlers) ReportSyncCollection(w http.ResponseWriter, r *http.Request,
 	owner, abURI, _ := splitResourcePath(r.URL.Path, h.basePath)
 	addressbookID, abOwner, err := h.resolveAddressbook(r.Context(), owner, abURI)
 	if err != nil {
-		h.logger.Error().Err(err).
+		h.logger.Error().Ctx(r.Context()).Err(err).
 			Str("owner", owner).
 			Str("addressbook", abURI).
 			Msg("failed to resolve addressbook in sync-collection")
@@ -255,26 +354,42 @@ func (h *Handlers) ReportSyncCollection(w http.ResponseWriter, r *http.Request,
 
 	curToken, _, err := h.store.GetAddressbookSyncInfo(r.Context(), addressbookID)
 	if err != nil {
-		h.logger.Error().Err(err).
+		h.logger.Error().Ctx(r.Context()).Err(err).
 			Str("addressbookID", addressbookID).
 			Msg("failed to get sync info")
 		http.Error(w, "storage error", http.StatusInternalServerError)
 		return
 	}
 
+	// An empty sync-token asks for an initial sync: every current member,
+	// without the tombstones kept in the change log.
+	initial := strings.TrimSpace(sc.SyncToken) == ""
 	sinceSeq := int64(0)
-	if sc.SyncToken != "" {
-		if ss, ok := common.ParseSeqToken(sc.SyncToken); ok {
-			sinceSeq = ss
+	if !initial {
+		ss, ok := h.tokens.ParseSeq(addressbookID, sc.SyncToken)
+		if !ok {
+			h.logger.Debug().Ctx(r.Context()).
+				Str("addressbookID", addressbookID).
+				Str("token", sc.SyncToken).
+				Msg("invalid sync-token in sync-collection")
+			_ = common.ServeError(w, http.StatusForbidden, common.ValidSyncToken{})
+			return
 		}
+		sinceSeq = ss
 	}
 	limit := 0
 	if sc.Limit != nil && sc.Limit.NResults > 0 {
 		limit = sc.Limit.NResults
 	}
-	changes, _, err := h.store.ListAddressbookChangesSince(r.Context(), addressbookID, sinceSeq, limit)
+	var changes []storage.Change
+	var lastSeq int64
+	if initial {
+		changes, err = h.initialSyncChanges(r.Context(), addressbookID)
+	} else {
+		changes, lastSeq, err = h.store.ListAddressbookChangesSince(r.Context(), addressbookID, sinceSeq, limit)
+	}
 	if err != nil {
-		h.logger.Error().Err(err).
+		h.logger.Error().Ctx(r.Context()).Err(err).
 			Str("addressbookID", addressbookID).
 			Int64("since", sinceSeq).
 			Int("limit", limit).
@@ -282,6 +397,12 @@ func (h *Handlers) ReportSyncCollection(w http.ResponseWriter, r *http.Request,
 		http.Error(w, "storage error", http.StatusInternalServerError)
 		return
 	}
+	// Members of an initial sync have no change-log position to resume
+	// from, so a result over the limit is refused rather than cut short.
+	if initial && limit > 0 && len(changes) > limit {
+		_ = common.ServeError(w, http.StatusInsufficientStorage, common.NumberOfMatchesWithinLimits{})
+		return
+	}
 
 	var resps []common.Response
 
@@ -306,7 +427,7 @@ func (h *Handlers) ReportSyncCollection(w http.ResponseWriter, r *http.Request,
 			if needContact {
 				contact, getErr = h.store.GetContact(r.Context(), addressbookID, ch.UID)
 				if getErr != nil {
-					h.logger.Debug().Err(getErr).
+					h.logger.Debug().Ctx(r.Context()).Err(getErr).
 						Str("addressbookID", addressbookID).
 						Str("uid", ch.UID).
 						Msg("contact disappeared between change listing and fetch")
@@ -323,7 +444,7 @@ func (h *Handlers) ReportSyncCollection(w http.ResponseWriter, r *http.Request,
 					XMLName xml.Name `xml:"urn:ietf:params:xml:ns:carddav address-data"`
 					Text    string   `xml:",chardata"`
 				}
-				_ = resp.EncodeProp(http.StatusOK, AddressData{Text: contact.Data})
+				_ = resp.EncodeProp(http.StatusOK, AddressData{Text: h.canonicalContact(contact)})
 			}
 			resps = append(resps, resp)
 		}
@@ -331,20 +452,37 @@ func (h *Handlers) ReportSyncCollection(w http.ResponseWriter, r *http.Request,
 
 	ms := common.MultiStatus{
 		Responses: resps,
-		SyncToken: curToken,
+		SyncToken: h.tokens.Encode(addressbookID, curToken),
 	}
-	if limit > 0 && len(changes) == limit {
+	if !initial && limit > 0 && len(changes) == limit {
+		// The log may hold more changes: hand out the position of the
+		// last one sent so the client continues from there.
+		ms.SyncToken = h.tokens.Encode(addressbookID, fmt.Sprintf("seq:%d", lastSeq))
 		ms.NumberOfMatchesWithinLimits = fmt.Sprintf("%d", len(changes))
 	}
 	if err := common.ServeMultiStatus(w, &ms); err != nil {
-		h.logger.Error().Err(err).Msg("failed to serve MultiStatus for sync-collection")
+		h.logger.Error().Ctx(r.Context()).Err(err).Msg("failed to serve MultiStatus for sync-collection")
 	}
 }
 
+// initialSyncChanges lists the current address book members as non-deleted
+// changes so an initial sync-collection can reuse the incremental path.
+func (h *Handlers) initialSyncChanges(ctx context.Context, addressbookID string) ([]storage.Change, error) {
+	contacts, err := h.store.ListContacts(ctx, addressbookID)
+	if err != nil {
+		return nil, err
+	}
+	changes := make([]storage.Change, 0, len(contacts))
+	for _, c := range contacts {
+		changes = append(changes, storage.Change{UID: c.UID})
+	}
+	return changes, nil
+}
+
 func (h *Handlers) handleLDAPSyncCollection(w http.ResponseWriter, r *http.Request, sc common.SyncCollection, abURI, addressbookID string) {
 	dir := h.addressbookDirs[abURI]
 	if dir == nil {
-		h.logger.Error().
+		h.logger.Error().Ctx(r.Context()).
 			Str("addressbookID", addressbookID).
 			Msg("failed to get ldap directory in sync-collection")
 		http.NotFound(w, r)
@@ -355,7 +493,7 @@ func (h *Handlers) handleLDAPSyncCollection(w http.ResponseWriter, r *http.Reque
 
 	currentContacts, err := dir.ListContacts(r.Context())
 	if err != nil {
-		h.logger.Error().Err(err).
+		h.logger.Error().Ctx(r.Context()).Err(err).
 			Str("addressbookID", addressbookID).
 			Msg("failed to list ldap contacts in sync-collection")
 		http.Error(w, "ldap error", http.StatusInternalServerError)
@@ -378,7 +516,7 @@ func (h *Handlers) handleLDAPSyncCollection(w http.ResponseWriter, r *http.Reque
 
 	var previousETags map[string]string
 	if sc.SyncToken != "" && sc.SyncToken != "seq:0" {
-		previousETags = h.parseLDAPSyncToken(sc.SyncToken)
+		previousETags = h.parseLDAPSyncToken(r.Context(), sc.SyncToken)
 	} else {
 		previousETags = make(map[string]string)
 	}
@@ -388,7 +526,7 @@ func (h *Handlers) handleLDAPSyncCollection(w http.ResponseWriter, r *http.Reque
 
 		if !existed || previousETag != currentETag {
 			hrefStr := baseHref + uid + ".vcf"
-			resp := buildReportResponseLDAP(hrefStr, props, currentContactMap[uid])
+			resp := h.buildReportResponseLDAP(hrefStr, props, currentContactMap[uid])
 			resps = append(resps, resp)
 		}
 	}
@@ -404,7 +542,7 @@ func (h *Handlers) handleLDAPSyncCollection(w http.ResponseWriter, r *http.Reque
 		}
 	}
 
-	newSyncToken := h.generateLDAPSyncToken(currentETags)
+	newSyncToken := h.generateLDAPSyncToken(r.Context(), currentETags)
 
 	ms := common.MultiStatus{
 		Responses: resps,
@@ -412,11 +550,21 @@ func (h *Handlers) handleLDAPSyncCollection(w http.ResponseWriter, r *http.Reque
 	}
 
 	if err := common.ServeMultiStatus(w, &ms); err != nil {
-		h.logger.Error().Err(err).Msg("failed to serve MultiStatus for ldap sync-collection")
+		h.logger.Error().Ctx(r.Context()).Err(err).Msg("failed to serve MultiStatus for ldap sync-collection")
+	}
+}
+
+// ldapSyncToken is the token a sync-collection REPORT on an LDAP
+// addressbook holding contacts returns.
+func (h *Handlers) ldapSyncToken(ctx context.Context, contacts []directory.Contact) string {
+	etags := make(map[string]string, len(contacts))
+	for i := range contacts {
+		etags[contacts[i].ID] = computeStableETag(&contacts[i])
 	}
+	return h.generateLDAPSyncToken(ctx, etags)
 }
 
-func (h *Handlers) parseLDAPSyncToken(token string) map[string]string {
+func (h *Handlers) parseLDAPSyncToken(ctx context.Context, token string) map[string]string {
 	if !strings.HasPrefix(token, "ldap:") {
 		return make(map[string]string)
 	}
@@ -424,23 +572,23 @@ func (h *Handlers) parseLDAPSyncToken(token string) map[string]string {
 	encoded := token[5:]
 	decoded, err := base64.StdEncoding.DecodeString(encoded)
 	if err != nil {
-		h.logger.Debug().Err(err).Msg("failed to decode ldap sync token")
+		h.logger.Debug().Ctx(ctx).Err(err).Msg("failed to decode ldap sync token")
 		return make(map[string]string)
 	}
 
 	var etags map[string]string
 	if err := json.Unmarshal(decoded, &etags); err != nil {
-		h.logger.Debug().Err(err).Msg("failed to unmarshal ldap sync token")
+		h.logger.Debug().Ctx(ctx).Err(err).Msg("failed to unmarshal ldap sync token")
 		return make(map[string]string)
 	}
 
 	return etags
 }
 
-func (h *Handlers) generateLDAPSyncToken(etags map[string]string) string {
+func (h *Handlers) generateLDAPSyncToken(ctx context.Context, etags map[string]string) string {
 	jsonData, err := json.Marshal(etags)
 	if err != nil {
-		h.logger.Error().Err(err).Msg("failed to marshal ldap sync token")
+		h.logger.Error().Ctx(ctx).Err(err).Msg("failed to marshal ldap sync token")
 		return "ldap:error"
 	}
 
@@ -7,6 +7,7 @@ import (
 	"strings"
 
 	"github.com/sonroyaalmerol/ldap-dav/internal/dav/common"
+	"github.com/sonroyaalmerol/ldap-dav/internal/directory"
 	"github.com/sonroyaalmerol/ldap-dav/internal/storage"
 )
 
@@ -27,16 +28,17 @@ func (c *CardDAVResourceHandler) SplitResourcePath(urlPath string) (owner, colle
 }
 
 func (c *CardDAVResourceHandler) PropfindHome(w http.ResponseWriter, r *http.Request, owner, depth string) {
+	sel := common.PropSelectionFrom(r.Context())
 	u, _ := common.CurrentUser(r.Context())
 	if u == nil {
-		c.handlers.logger.Error().Str("path", r.URL.Path).Msg("PROPFIND home unauthorized")
+		c.handlers.logger.Error().Ctx(r.Context()).Str("path", r.URL.Path).Msg("PROPFIND home unauthorized")
 		http.Error(w, "unauthorized", http.StatusUnauthorized)
 		return
 	}
 	home := common.AddressbookHome(c.basePath, owner)
 
 	if u.UID != owner {
-		c.handlers.logger.Debug().Str("user", u.UID).Str("owner", owner).Msg("PROPFIND home forbidden - user mismatch")
+		c.handlers.logger.Debug().Ctx(r.Context()).Str("user", u.UID).Str("owner", owner).Msg("PROPFIND home forbidden - user mismatch")
 		http.Error(w, "forbidden", http.StatusForbidden)
 		return
 	}
@@ -45,7 +47,7 @@ func (c *CardDAVResourceHandler) PropfindHome(w http.ResponseWriter, r *http.Req
 
 	owned, err := c.handlers.store.ListAddressbooksByOwnerUser(r.Context(), owner)
 	if err != nil {
-		c.handlers.logger.Error().Err(err).Str("owner", owner).Msg("failed to list owned addressbooks in PROPFIND home")
+		c.handlers.logger.Error().Ctx(r.Context()).Err(err).Str("owner", owner).Msg("failed to list owned addressbooks in PROPFIND home")
 		http.Error(w, "storage error", http.StatusInternalServerError)
 		return
 	}
@@ -54,15 +56,22 @@ func (c *CardDAVResourceHandler) PropfindHome(w http.ResponseWriter, r *http.Req
 
 	homeResp := common.Response{Hrefs: []common.Href{{Value: home}}}
 	_ = homeResp.EncodeProp(http.StatusOK, common.ResourceType{Collection: &struct{}{}})
-	_ = homeResp.EncodeProp(http.StatusOK, common.DisplayName{Name: "Addressbook Home"})
-	_ = homeResp.EncodeProp(http.StatusOK, common.Owner{Href: &common.Href{Value: common.PrincipalURL(c.basePath, owner)}})
-	_ = homeResp.EncodeProp(http.StatusOK, common.CurrentUserPrincipal{Href: &common.Href{Value: common.PrincipalURL(c.basePath, owner)}})
-
-	_ = homeResp.EncodeProp(http.StatusOK, c.buildSupportedPrivilegeSet())
+	_ = homeResp.EncodeProp(http.StatusOK, c.handlers.supportedMethodSet(homeResp.Hrefs[0].Value))
+	_ = homeResp.EncodeProp(http.StatusOK, common.DisplayName{Name: common.StoredDisplayName(r.Context(), c.handlers.store, homeResourceID(owner), "Addressbook Home")})
+	_ = homeResp.EncodeProp(http.StatusOK, common.Owner{Href: &common.Href{Value: c.handlers.principalURL(owner)}})
+	_ = homeResp.EncodeProp(http.StatusOK, common.CurrentUserPrincipal{Href: &common.Href{Value: c.handlers.principalURL(owner)}})
+	_ = homeResp.EncodeProp(http.StatusOK, common.PrincipalCollectionSetFor(c.basePath))
+
+	if sel.Wants(common.NSDAV, "supported-privilege-set") {
+		_ = homeResp.EncodeProp(http.StatusOK, c.buildSupportedPrivilegeSet())
+	}
 	_ = homeResp.EncodeProp(http.StatusOK, common.CurrentUserPrivilegeSet{
 		Privilege: []common.Privilege{{All: &struct{}{}}},
 	})
-	_ = homeResp.EncodeProp(http.StatusOK, c.buildOwnerACL(owner))
+	if sel.Wants(common.NSDAV, "acl") {
+		_ = homeResp.EncodeProp(http.StatusOK, c.buildOwnerACL(owner))
+	}
+	c.encodeDeadProperties(r, &homeResp, homeResourceID(owner))
 
 	resps = append(resps, homeResp)
 
@@ -72,24 +81,28 @@ func (c *CardDAVResourceHandler) PropfindHome(w http.ResponseWriter, r *http.Req
 			hrefStr := common.AddressbookPath(c.basePath, owner, ab.URI)
 			resp := common.Response{Hrefs: []common.Href{{Value: hrefStr}}}
 			_ = resp.EncodeProp(http.StatusOK, common.ResourceType{Collection: &struct{}{}, Addressbook: &struct{}{}})
+			_ = resp.EncodeProp(http.StatusOK, c.handlers.supportedMethodSet(resp.Hrefs[0].Value))
 			_ = resp.EncodeProp(http.StatusOK, common.DisplayName{Name: ab.DisplayName})
-			_ = resp.EncodeProp(http.StatusOK, common.Owner{Href: &common.Href{Value: common.PrincipalURL(c.basePath, owner)}})
-			_ = resp.EncodeProp(http.StatusOK, common.CurrentUserPrincipal{Href: &common.Href{Value: common.PrincipalURL(c.basePath, owner)}})
+			_ = resp.EncodeProp(http.StatusOK, common.Owner{Href: &common.Href{Value: c.handlers.principalURL(owner)}})
+			_ = resp.EncodeProp(http.StatusOK, common.CurrentUserPrincipal{Href: &common.Href{Value: c.handlers.principalURL(owner)}})
+			_ = resp.EncodeProp(http.StatusOK, common.PrincipalCollectionSetFor(c.basePath))
 			_ = resp.EncodeProp(http.StatusOK, supportedReportSetValue())
-			_ = resp.EncodeProp(http.StatusOK, struct {
-				XMLName xml.Name `xml:"DAV: sync-token"`
-				Text    string   `xml:",chardata"`
-			}{Text: ab.CTag})
+			c.encodeSyncToken(r, &resp, ab.ID)
+			_ = resp.EncodeProp(http.StatusOK, common.AddMember{Href: common.Href{Value: resp.Hrefs[0].Value}})
 			_ = resp.EncodeProp(http.StatusOK, struct {
 				XMLName xml.Name `xml:"http://calendarserver.org/ns/ getctag"`
 				Text    string   `xml:",chardata"`
-			}{Text: ab.CTag})
+			}{Text: c.handlers.tokens.Encode(ab.ID, ab.CTag)})
+			_ = resp.EncodeProp(http.StatusOK, common.GetETag{ETag: common.ETag(c.handlers.tokens.Encode(ab.ID, ab.CTag))})
 
 			_ = resp.EncodeProp(http.StatusOK, common.CurrentUserPrivilegeSet{
 				Privilege: []common.Privilege{{All: &struct{}{}}},
 			})
 
-			_ = resp.EncodeProp(http.StatusOK, c.buildOwnerACL(owner))
+			if sel.Wants(common.NSDAV, "acl") {
+				_ = resp.EncodeProp(http.StatusOK, c.buildOwnerACL(owner))
+			}
+			c.encodeDeadProperties(r, &resp, ab.ID)
 			resps = append(resps, resp)
 		}
 
@@ -97,28 +110,28 @@ func (c *CardDAVResourceHandler) PropfindHome(w http.ResponseWriter, r *http.Req
 		for uri, dir := range c.handlers.addressbookDirs {
 			abs, err := dir.ListAddressbooks(r.Context())
 			if err != nil {
-				c.handlers.logger.Error().Err(err).Str("ldap_ab", uri).Msg("failed to list LDAP addressbooks")
+				c.handlers.logger.Error().Ctx(r.Context()).Err(err).Str("ldap_ab", uri).Msg("failed to list LDAP addressbooks")
 				continue
 			}
 			for _, ab := range abs {
 				hrefStr := common.AddressbookPath(c.basePath, owner, ab.URI)
 				resp := common.Response{Hrefs: []common.Href{{Value: hrefStr}}}
 				_ = resp.EncodeProp(http.StatusOK, common.ResourceType{Collection: &struct{}{}, Addressbook: &struct{}{}})
+				_ = resp.EncodeProp(http.StatusOK, c.handlers.supportedMethodSet(resp.Hrefs[0].Value))
 				_ = resp.EncodeProp(http.StatusOK, common.DisplayName{Name: ab.Name})
-				_ = resp.EncodeProp(http.StatusOK, common.Owner{Href: &common.Href{Value: common.PrincipalURL(c.basePath, owner)}})
-				_ = resp.EncodeProp(http.StatusOK, common.CurrentUserPrincipal{Href: &common.Href{Value: common.PrincipalURL(c.basePath, owner)}})
+				_ = resp.EncodeProp(http.StatusOK, common.Owner{Href: &common.Href{Value: c.handlers.principalURL(owner)}})
+				_ = resp.EncodeProp(http.StatusOK, common.CurrentUserPrincipal{Href: &common.Href{Value: c.handlers.principalURL(owner)}})
+				_ = resp.EncodeProp(http.StatusOK, common.PrincipalCollectionSetFor(c.basePath))
 				_ = resp.EncodeProp(http.StatusOK, supportedReportSetValue())
 
 				// Read-only: privileges limited to read
 				_ = resp.EncodeProp(http.StatusOK, common.CurrentUserPrivilegeSet{
 					Privilege: []common.Privilege{{Read: &struct{}{}}},
 				})
-				_ = resp.EncodeProp(http.StatusOK, c.buildOwnerACL(owner))
-				// Fixed sync token for read-only LDAP (no change tracking)
-				_ = resp.EncodeProp(http.StatusOK, struct {
-					XMLName xml.Name `xml:"DAV: sync-token"`
-					Text    string   `xml:",chardata"`
-				}{Text: "seq:0"})
+				if sel.Wants(common.NSDAV, "acl") {
+					_ = resp.EncodeProp(http.StatusOK, c.buildOwnerACL(owner))
+				}
+				c.encodeLDAPSyncToken(r, &resp, dir)
 
 				resps = append(resps, resp)
 			}
@@ -126,55 +139,59 @@ func (c *CardDAVResourceHandler) PropfindHome(w http.ResponseWriter, r *http.Req
 	}
 
 	ms := common.MultiStatus{Responses: resps}
-	if err := common.ServeMultiStatus(w, &ms); err != nil {
-		c.handlers.logger.Error().Err(err).Msg("failed to serve MultiStatus in PROPFIND home")
+	if err := common.ServePropfind(w, r, &ms); err != nil {
+		c.handlers.logger.Error().Ctx(r.Context()).Err(err).Msg("failed to serve MultiStatus in PROPFIND home")
 	}
 }
 
 func (c *CardDAVResourceHandler) PropfindCollection(w http.ResponseWriter, r *http.Request, owner, collection, depth string) {
+	sel := common.PropSelectionFrom(r.Context())
 	u, _ := common.CurrentUser(r.Context())
 	if u == nil {
-		c.handlers.logger.Error().Str("path", r.URL.Path).Msg("PROPFIND collection unauthorized")
+		c.handlers.logger.Error().Ctx(r.Context()).Str("path", r.URL.Path).Msg("PROPFIND collection unauthorized")
 		http.Error(w, "unauthorized", http.StatusUnauthorized)
 		return
 	}
 
 	if u.UID != owner {
-		c.handlers.logger.Debug().Str("user", u.UID).Str("owner", owner).Msg("PROPFIND collection forbidden - user mismatch")
+		c.handlers.logger.Debug().Ctx(r.Context()).Str("user", u.UID).Str("owner", owner).Msg("PROPFIND collection forbidden - user mismatch")
 		http.Error(w, "forbidden", http.StatusForbidden)
 		return
 	}
 
 	if strings.HasPrefix(collection, "ldap_") {
 		if _, ok := c.handlers.addressbookDirs[collection]; !ok {
-			c.handlers.logger.Debug().Str("user", u.UID).Str("owner", owner).Msg("PROPFIND collection forbidden - user mismatch")
+			c.handlers.logger.Debug().Ctx(r.Context()).Str("user", u.UID).Str("owner", owner).Msg("PROPFIND collection forbidden - user mismatch")
 			http.NotFound(w, r)
 			return
 		}
 		href := common.AddressbookPath(c.basePath, owner, collection)
-		ownerHref := common.PrincipalURL(c.basePath, owner)
+		ownerHref := c.handlers.principalURL(owner)
 
 		var resps []common.Response
 
 		resp := common.Response{Hrefs: []common.Href{{Value: href}}}
 		_ = resp.EncodeProp(http.StatusOK, common.ResourceType{Collection: &struct{}{}, Addressbook: &struct{}{}})
+		_ = resp.EncodeProp(http.StatusOK, c.handlers.supportedMethodSet(resp.Hrefs[0].Value))
 		_ = resp.EncodeProp(http.StatusOK, common.DisplayName{Name: collection})
 		_ = resp.EncodeProp(http.StatusOK, common.Owner{Href: &common.Href{Value: ownerHref}})
 		_ = resp.EncodeProp(http.StatusOK, common.CurrentUserPrincipal{Href: &common.Href{Value: ownerHref}})
+		_ = resp.EncodeProp(http.StatusOK, common.PrincipalCollectionSetFor(c.basePath))
 		_ = resp.EncodeProp(http.StatusOK, supportedReportSetValue())
-		_ = resp.EncodeProp(http.StatusOK, c.buildSupportedPrivilegeSet())
+		if sel.Wants(common.NSDAV, "supported-privilege-set") {
+			_ = resp.EncodeProp(http.StatusOK, c.buildSupportedPrivilegeSet())
+		}
 		_ = resp.EncodeProp(http.StatusOK, common.CurrentUserPrivilegeSet{Privilege: []common.Privilege{{Read: &struct{}{}}}})
-		_ = resp.EncodeProp(http.StatusOK, c.buildOwnerACL(owner))
+		if sel.Wants(common.NSDAV, "acl") {
+			_ = resp.EncodeProp(http.StatusOK, c.buildOwnerACL(owner))
+		}
 		_ = resp.EncodeProp(http.StatusOK, common.SupportedAddressData{
 			AddressDataType: []common.AddressDataType{
 				{ContentType: "text/vcard", Version: "3.0"},
 				{ContentType: "text/vcard", Version: "4.0"},
 			},
 		})
-		_ = resp.EncodeProp(http.StatusOK, struct {
-			XMLName xml.Name `xml:"DAV: sync-token"`
-			Text    string   `xml:",chardata"`
-		}{Text: "seq:0"})
+		c.encodeLDAPSyncToken(r, &resp, c.handlers.addressbookDirs[collection])
 		resps = append(resps, resp)
 
 		if depth == "1" {
@@ -182,7 +199,7 @@ func (c *CardDAVResourceHandler) PropfindCollection(w http.ResponseWriter, r *ht
 			if dir != nil {
 				contacts, err := dir.ListContacts(r.Context())
 				if err != nil {
-					c.handlers.logger.Error().Err(err).Str("collection", collection).Msg("failed to list LDAP contacts in PROPFIND")
+					c.handlers.logger.Error().Ctx(r.Context()).Err(err).Str("collection", collection).Msg("failed to list LDAP contacts in PROPFIND")
 				} else {
 					for _, contact := range contacts {
 						contactHref := common.JoinURL(c.basePath, "addressbooks", owner, collection, contact.ID+".vcf")
@@ -199,13 +216,13 @@ func (c *CardDAVResourceHandler) PropfindCollection(w http.ResponseWriter, r *ht
 		}
 
 		ms := common.MultiStatus{Responses: resps}
-		_ = common.ServeMultiStatus(w, &ms)
+		_ = common.ServePropfind(w, r, &ms)
 		return
 	}
 
 	addressbooks, err := c.handlers.store.ListAddressbooksByOwnerUser(r.Context(), owner)
 	if err != nil {
-		c.handlers.logger.Error().Err(err).Str("owner", owner).Msg("failed to list addressbooks by owner in PROPFIND collection")
+		c.handlers.logger.Error().Ctx(r.Context()).Err(err).Str("owner", owner).Msg("failed to list addressbooks by owner in PROPFIND collection")
 		http.Error(w, "storage error", http.StatusInternalServerError)
 		return
 	}
@@ -219,13 +236,13 @@ func (c *CardDAVResourceHandler) PropfindCollection(w http.ResponseWriter, r *ht
 	}
 
 	if ab == nil {
-		c.handlers.logger.Debug().Str("owner", owner).Str("collection", collection).Msg("collection not found in PROPFIND")
+		c.handlers.logger.Debug().Ctx(r.Context()).Str("owner", owner).Str("collection", collection).Msg("collection not found in PROPFIND")
 		http.NotFound(w, r)
 		return
 	}
 
 	href := common.AddressbookPath(c.basePath, owner, collection)
-	ownerHref := common.PrincipalURL(c.basePath, owner)
+	ownerHref := c.handlers.principalURL(owner)
 	pr := common.MustPrincipal(r.Context())
 
 	var resps []common.Response
@@ -235,29 +252,34 @@ func (c *CardDAVResourceHandler) PropfindCollection(w http.ResponseWriter, r *ht
 	}
 
 	_ = propResp.EncodeProp(http.StatusOK, common.ResourceType{Collection: &struct{}{}, Addressbook: &struct{}{}})
+	_ = propResp.EncodeProp(http.StatusOK, c.handlers.supportedMethodSet(propResp.Hrefs[0].Value))
 	_ = propResp.EncodeProp(http.StatusOK, common.DisplayName{Name: ab.DisplayName})
 	_ = propResp.EncodeProp(http.StatusOK, common.Owner{Href: &common.Href{Value: ownerHref}})
-	_ = propResp.EncodeProp(http.StatusOK, common.CurrentUserPrincipal{Href: &common.Href{Value: common.PrincipalURL(c.basePath, pr.UserID)}})
+	_ = propResp.EncodeProp(http.StatusOK, common.CurrentUserPrincipal{Href: &common.Href{Value: c.handlers.principalURL(pr.UserID)}})
+	_ = propResp.EncodeProp(http.StatusOK, common.PrincipalCollectionSetFor(c.basePath))
 
 	_ = propResp.EncodeProp(http.StatusOK, supportedReportSetValue())
-	_ = propResp.EncodeProp(http.StatusOK, struct {
-		XMLName xml.Name `xml:"DAV: sync-token"`
-		Text    string   `xml:",chardata"`
-	}{Text: ab.CTag})
+	c.encodeSyncToken(r, &propResp, ab.ID)
+	_ = propResp.EncodeProp(http.StatusOK, common.AddMember{Href: common.Href{Value: propResp.Hrefs[0].Value}})
 	_ = propResp.EncodeProp(http.StatusOK, struct {
 		XMLName xml.Name `xml:"http://calendarserver.org/ns/ getctag"`
 		Text    string   `xml:",chardata"`
-	}{Text: ab.CTag})
+	}{Text: c.handlers.tokens.Encode(ab.ID, ab.CTag)})
+	_ = propResp.EncodeProp(http.StatusOK, common.GetETag{ETag: common.ETag(c.handlers.tokens.Encode(ab.ID, ab.CTag))})
 
 	if !ab.UpdatedAt.IsZero() {
 		_ = propResp.EncodeProp(http.StatusOK, common.GetLastModified{LastModified: common.TimeText(ab.UpdatedAt.UTC())})
 	}
 
-	_ = propResp.EncodeProp(http.StatusOK, c.buildSupportedPrivilegeSet())
+	if sel.Wants(common.NSDAV, "supported-privilege-set") {
+		_ = propResp.EncodeProp(http.StatusOK, c.buildSupportedPrivilegeSet())
+	}
 	_ = propResp.EncodeProp(http.StatusOK, common.CurrentUserPrivilegeSet{
 		Privilege: []common.Privilege{{All: &struct{}{}}},
 	})
-	_ = propResp.EncodeProp(http.StatusOK, c.buildOwnerACL(owner))
+	if sel.Wants(common.NSDAV, "acl") {
+		_ = propResp.EncodeProp(http.StatusOK, c.buildOwnerACL(owner))
+	}
 
 	// CardDAV capabilities
 	_ = propResp.EncodeProp(http.StatusOK, common.SupportedAddressData{
@@ -270,13 +292,14 @@ func (c *CardDAVResourceHandler) PropfindCollection(w http.ResponseWriter, r *ht
 		XMLName xml.Name `xml:"urn:ietf:params:xml:ns:carddav max-resource-size"`
 		Size    int      `xml:",chardata"`
 	}{Size: c.getMaxResourceSize()})
+	c.encodeDeadProperties(r, &propResp, ab.ID)
 
 	resps = append(resps, propResp)
 
 	if depth == "1" {
 		contacts, err := c.handlers.store.ListContacts(r.Context(), ab.ID)
 		if err != nil {
-			c.handlers.logger.Error().Err(err).Str("addressbook", ab.URI).Msg("failed to list contacts in PROPFIND collection")
+			c.handlers.logger.Error().Ctx(r.Context()).Err(err).Str("addressbook", ab.URI).Msg("failed to list contacts in PROPFIND collection")
 		} else {
 			for _, contact := range contacts {
 				contactHref := common.JoinURL(c.basePath, "addressbooks", owner, collection, contact.UID+".vcf")
@@ -294,15 +317,15 @@ func (c *CardDAVResourceHandler) PropfindCollection(w http.ResponseWriter, r *ht
 	}
 
 	ms := common.MultiStatus{Responses: resps}
-	if err := common.ServeMultiStatus(w, &ms); err != nil {
-		c.handlers.logger.Error().Err(err).Msg("failed to serve MultiStatus for PROPFIND collection")
+	if err := common.ServePropfind(w, r, &ms); err != nil {
+		c.handlers.logger.Error().Ctx(r.Context()).Err(err).Msg("failed to serve MultiStatus for PROPFIND collection")
 	}
 }
 
 func (c *CardDAVResourceHandler) PropfindObject(w http.ResponseWriter, r *http.Request, owner, collection, object string) {
 	u, _ := common.CurrentUser(r.Context())
 	if u == nil {
-		c.handlers.logger.Error().Str("path", r.URL.Path).Msg("PROPFIND object unauthorized")
+		c.handlers.logger.Error().Ctx(r.Context()).Str("path", r.URL.Path).Msg("PROPFIND object unauthorized")
 		http.Error(w, "unauthorized", http.StatusUnauthorized)
 		return
 	}
@@ -310,27 +333,28 @@ func (c *CardDAVResourceHandler) PropfindObject(w http.ResponseWriter, r *http.R
 	if strings.HasPrefix(collection, "ldap_") {
 		dir := c.handlers.addressbookDirs[collection]
 		if dir == nil {
-			c.handlers.logger.Debug().Str("user", u.UID).Str("owner", owner).Msg("PROPFIND object forbidden - user mismatch")
+			c.handlers.logger.Debug().Ctx(r.Context()).Str("user", u.UID).Str("owner", owner).Msg("PROPFIND object forbidden - user mismatch")
 			http.NotFound(w, r)
 			return
 		}
 		uid := strings.TrimSuffix(object, filepath.Ext(object))
 		_, err := dir.GetContact(r.Context(), uid)
 		if err != nil {
-			c.handlers.logger.Error().Err(err).Str("user", u.UID).Str("owner", owner).Msg("PROPFIND object forbidden - user mismatch")
+			c.handlers.logger.Error().Ctx(r.Context()).Err(err).Str("user", u.UID).Str("owner", owner).Msg("PROPFIND object forbidden - user mismatch")
 			http.NotFound(w, r)
 			return
 		}
 		hrefStr := common.JoinURL(c.handlers.basePath, "addressbooks", owner, collection, uid+".vcf")
 		resp := common.Response{Hrefs: []common.Href{{Value: hrefStr}}}
 		_ = resp.EncodeProp(http.StatusOK, common.GetContentType{Type: "text/vcard; charset=utf-8"})
+		_ = resp.EncodeProp(http.StatusOK, c.handlers.supportedMethodSet(hrefStr))
 		ms := common.MultiStatus{Responses: []common.Response{resp}}
-		_ = common.ServeMultiStatus(w, &ms)
+		_ = common.ServePropfind(w, r, &ms)
 		return
 	}
 
 	if u.UID != owner {
-		c.handlers.logger.Debug().Str("user", u.UID).Str("owner", owner).Msg("PROPFIND object forbidden - user mismatch")
+		c.handlers.logger.Debug().Ctx(r.Context()).Str("user", u.UID).Str("owner", owner).Msg("PROPFIND object forbidden - user mismatch")
 		http.Error(w, "forbidden", http.StatusForbidden)
 		return
 	}
@@ -338,7 +362,7 @@ func (c *CardDAVResourceHandler) PropfindObject(w http.ResponseWriter, r *http.R
 	uid := strings.TrimSuffix(object, filepath.Ext(object))
 	addressbookID, abOwner, err := c.handlers.resolveAddressbook(r.Context(), owner, collection)
 	if err != nil {
-		c.handlers.logger.Error().Err(err).
+		c.handlers.logger.Error().Ctx(r.Context()).Err(err).
 			Str("owner", owner).
 			Str("collection", collection).
 			Str("object", object).
@@ -349,7 +373,7 @@ func (c *CardDAVResourceHandler) PropfindObject(w http.ResponseWriter, r *http.R
 
 	// Since we only allow access to own addressbooks, the owner should match
 	if abOwner != owner {
-		c.handlers.logger.Debug().
+		c.handlers.logger.Debug().Ctx(r.Context()).
 			Str("user", owner).
 			Str("addressbook_owner", abOwner).
 			Str("collection", collection).
@@ -360,7 +384,7 @@ func (c *CardDAVResourceHandler) PropfindObject(w http.ResponseWriter, r *http.R
 
 	contact, err := c.handlers.store.GetContact(r.Context(), addressbookID, uid)
 	if err != nil {
-		c.handlers.logger.Debug().Err(err).
+		c.handlers.logger.Debug().Ctx(r.Context()).Err(err).
 			Str("addressbookID", addressbookID).
 			Str("uid", uid).
 			Msg("object not found in PROPFIND object")
@@ -374,13 +398,14 @@ func (c *CardDAVResourceHandler) PropfindObject(w http.ResponseWriter, r *http.R
 		Hrefs: []common.Href{{Value: hrefStr}},
 	}
 	_ = resp.EncodeProp(http.StatusOK, common.GetContentType{Type: "text/vcard; charset=utf-8"})
+	_ = resp.EncodeProp(http.StatusOK, c.handlers.supportedMethodSet(hrefStr))
 	if !contact.UpdatedAt.IsZero() {
 		_ = resp.EncodeProp(http.StatusOK, common.GetLastModified{LastModified: common.TimeText(contact.UpdatedAt.UTC())})
 	}
 
 	ms := common.MultiStatus{Responses: []common.Response{resp}}
-	if err := common.ServeMultiStatus(w, &ms); err != nil {
-		c.handlers.logger.Error().Err(err).Msg("failed to serve MultiStatus for PROPFIND object")
+	if err := common.ServePropfind(w, r, &ms); err != nil {
+		c.handlers.logger.Error().Ctx(r.Context()).Err(err).Msg("failed to serve MultiStatus for PROPFIND object")
 	}
 }
 
@@ -415,7 +440,7 @@ func (c *CardDAVResourceHandler) buildOwnerACL(owner string) common.ACL {
 		ACE: []common.ACE{{
 			Principal: common.Principal{
 				Href: &common.Href{
-					Value: common.PrincipalURL(c.basePath, owner),
+					Value: c.handlers.principalURL(owner),
 				},
 			},
 			Grant: &common.Grant{
@@ -426,7 +451,7 @@ func (c *CardDAVResourceHandler) buildOwnerACL(owner string) common.ACL {
 	}
 }
 
-func supportedReportSetValue() interface{} {
+func supportedReportSetValue() *common.SupportedReportSet {
 	return &common.SupportedReportSet{
 		SupportedReport: []common.SupportedReport{
 			{Report: common.ReportType{AddressbookQuery: &struct{}{}}},
@@ -435,3 +460,42 @@ func supportedReportSetValue() interface{} {
 		},
 	}
 }
+
+// encodeDeadProperties adds the dead properties set on a addressbook through
+// PROPPATCH.
+func (c *CardDAVResourceHandler) encodeDeadProperties(r *http.Request, resp *common.Response, id string) {
+	if err := common.EncodeDeadProperties(r.Context(), c.handlers.store, id, resp); err != nil {
+		c.handlers.logger.Error().Ctx(r.Context()).Err(err).Str("addressbookID", id).Msg("failed to load dead properties")
+	}
+}
+
+// encodeSyncToken adds the DAV:sync-token a sync-collection REPORT on the
+// addressbook would return, so clients can start WebDAV-Sync from PROPFIND.
+func (c *CardDAVResourceHandler) encodeSyncToken(r *http.Request, resp *common.Response, id string) {
+	tok, _, err := c.handlers.store.GetAddressbookSyncInfo(r.Context(), id)
+	if err != nil {
+		c.handlers.logger.Error().Ctx(r.Context()).Err(err).Str("addressbookID", id).Msg("failed to get sync info")
+		return
+	}
+	_ = resp.EncodeProp(http.StatusOK, struct {
+		XMLName xml.Name `xml:"DAV: sync-token"`
+		Text    string   `xml:",chardata"`
+	}{Text: c.handlers.tokens.Encode(id, tok)})
+}
+
+// encodeLDAPSyncToken adds the sync-token of an LDAP addressbook, derived
+// from its current contacts the same way sync-collection derives it.
+func (c *CardDAVResourceHandler) encodeLDAPSyncToken(r *http.Request, resp *common.Response, dir directory.ContactDirectory) {
+	if dir == nil {
+		return
+	}
+	contacts, err := dir.ListContacts(r.Context())
+	if err != nil {
+		c.handlers.logger.Error().Ctx(r.Context()).Err(err).Msg("failed to list ldap contacts for sync-token")
+		return
+	}
+	_ = resp.EncodeProp(http.StatusOK, struct {
+		XMLName xml.Name `xml:"DAV: sync-token"`
+		Text    string   `xml:",chardata"`
+	}{Text: c.handlers.ldapSyncToken(r.Context(), contacts)})
+}
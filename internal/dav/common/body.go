@@ -0,0 +1,45 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// ErrBodyTooLarge is returned by ReadBody when the body exceeds its limit.
+var ErrBodyTooLarge = errors.New("request body too large")
+
+// ExceedsLimit reports whether the request declares a body larger than limit.
+func ExceedsLimit(r *http.Request, limit int64) bool {
+	return limit > 0 && r.ContentLength > limit
+}
+
+// ServeTooLarge answers with 413 and a short explanation of the limit.
+func ServeTooLarge(w http.ResponseWriter, limit int64) {
+	http.Error(w, fmt.Sprintf("request body exceeds %d bytes", limit), http.StatusRequestEntityTooLarge)
+}
+
+// ReadBody reads the request body up to limit bytes. One extra byte is
+// requested so a body that had to be cut off is reported as ErrBodyTooLarge
+// instead of surfacing later as malformed XML.
+func ReadBody(r *http.Request, limit int64) ([]byte, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, limit+1))
+	_ = r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > limit {
+		return nil, ErrBodyTooLarge
+	}
+	return body, nil
+}
+
+// WriteBody writes a response body with its Content-Length. HEAD is served
+// by running GET against a writer that discards the body, so setting the
+// length up front is what lets HEAD report the size GET would send.
+func WriteBody(w http.ResponseWriter, body string) {
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	_, _ = io.WriteString(w, body)
+}
@@ -0,0 +1,63 @@
+package common
+
+import (
+	"fmt"
+	"mime"
+	"strings"
+	"unicode/utf8"
+)
+
+// cp1252 maps the 0x80-0x9F range of windows-1252, where it differs from
+// ISO-8859-1. Unassigned positions are left as zero and decode as U+FFFD.
+var cp1252 = [32]rune{
+	0x20AC, 0, 0x201A, 0x0192, 0x201E, 0x2026, 0x2020, 0x2021,
+	0x02C6, 0x2030, 0x0160, 0x2039, 0x0152, 0, 0x017D, 0,
+	0, 0x2018, 0x2019, 0x201C, 0x201D, 0x2022, 0x2013, 0x2014,
+	0x02DC, 0x2122, 0x0161, 0x203A, 0x0153, 0, 0x017E, 0x0178,
+}
+
+// ToUTF8 transcodes a request body to UTF-8 according to the charset
+// parameter of its Content-Type. Bodies without a charset, or already in
+// UTF-8 or US-ASCII, are returned unchanged. ISO-8859-1 and windows-1252,
+// the charsets legacy clients still send, are transcoded; any other charset
+// is an error.
+func ToUTF8(body []byte, contentType string) ([]byte, error) {
+	if contentType == "" {
+		return body, nil
+	}
+	_, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return body, nil
+	}
+	charset := strings.ToLower(strings.TrimSpace(params["charset"]))
+
+	var high func(b byte) rune
+	switch charset {
+	case "", "utf-8", "utf8", "us-ascii", "ascii":
+		return body, nil
+	case "iso-8859-1", "iso8859-1", "latin1", "l1":
+		high = func(b byte) rune { return rune(b) }
+	case "windows-1252", "cp1252":
+		high = func(b byte) rune {
+			if b >= 0x80 && b < 0xA0 {
+				if r := cp1252[b-0x80]; r != 0 {
+					return r
+				}
+				return utf8.RuneError
+			}
+			return rune(b)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported charset %q", charset)
+	}
+
+	out := make([]byte, 0, len(body)+len(body)/4)
+	for _, b := range body {
+		if b < 0x80 {
+			out = append(out, b)
+			continue
+		}
+		out = utf8.AppendRune(out, high(b))
+	}
+	return out, nil
+}
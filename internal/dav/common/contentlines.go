@@ -0,0 +1,102 @@
+package common
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/sonroyaalmerol/ldap-dav/internal/cache"
+)
+
+// maxLineOctets is the longest content line, excluding the CRLF, that
+// iCalendar and vCard allow before folding (RFC 5545 section 3.1, RFC 6350
+// section 3.2).
+const maxLineOctets = 75
+
+// FoldContentLines returns data with CRLF line endings and every content
+// line folded at 75 octets, never inside a UTF-8 sequence. Existing folds
+// are undone first, so the output is the same however data was stored.
+func FoldContentLines(data string) string {
+	text := strings.ReplaceAll(data, "\r\n", "\n")
+	var b strings.Builder
+	b.Grow(len(text) + len(text)/maxLineOctets*3)
+	var line strings.Builder
+	flush := func() {
+		if line.Len() > 0 {
+			writeFolded(&b, line.String())
+			line.Reset()
+		}
+	}
+	for _, l := range strings.Split(text, "\n") {
+		l = strings.TrimSuffix(l, "\r")
+		switch {
+		case l == "":
+			continue
+		case l[0] == ' ' || l[0] == '\t':
+			line.WriteString(l[1:])
+		default:
+			flush()
+			line.WriteString(l)
+		}
+	}
+	flush()
+	return b.String()
+}
+
+func writeFolded(b *strings.Builder, line string) {
+	limit := maxLineOctets
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		if cut == 0 {
+			cut = limit
+		}
+		b.WriteString(line[:cut])
+		b.WriteString("\r\n ")
+		line = line[cut:]
+		// Continuation lines spend one octet on the leading space.
+		limit = maxLineOctets - 1
+	}
+	b.WriteString(line)
+	b.WriteString("\r\n")
+}
+
+type canonicalBody struct {
+	etag string
+	body string
+}
+
+// CanonicalBodies caches the canonical form of stored resources, keyed by
+// resource and checked against its ETag, so GET and REPORT do not redo the
+// normalization pass for unchanged data. The cached bodies are bounded in
+// total size, least recently used first out.
+type CanonicalBodies struct {
+	cache *cache.LRU[string, canonicalBody]
+}
+
+// NewCanonicalBodies returns a cache holding up to maxBytes of bodies, or
+// nil, which caches nothing, when maxBytes is not positive.
+func NewCanonicalBodies(maxBytes int64) *CanonicalBodies {
+	if maxBytes <= 0 {
+		return nil
+	}
+	return &CanonicalBodies{cache: cache.NewLRU[string](maxBytes, func(b canonicalBody) int64 {
+		return int64(len(b.body))
+	})}
+}
+
+// Get returns the canonical body of the resource at key with the given
+// ETag, computing it with build when the cached one is missing or stale.
+// Resources without an ETag are never cached.
+func (c *CanonicalBodies) Get(key, etag string, build func() string) string {
+	if c == nil || etag == "" {
+		return build()
+	}
+	if v, ok := c.cache.Get(key); ok && v.etag == etag {
+		return v.body
+	}
+	body := build()
+	c.cache.Set(key, canonicalBody{etag: etag, body: body})
+	return body
+}
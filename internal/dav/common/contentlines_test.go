@@ -0,0 +1,31 @@
+package common
+
+import "testing"
+
+func TestCanonicalBodiesFollowETag(t *testing.T) {
+	c := NewCanonicalBodies(1 << 10)
+	builds := 0
+	build := func(body string) func() string {
+		return func() string { builds++; return body }
+	}
+
+	if got := c.Get("cal/uid", "1", build("v1")); got != "v1" {
+		t.Fatalf("Get = %q, want v1", got)
+	}
+	if got := c.Get("cal/uid", "1", build("other")); got != "v1" || builds != 1 {
+		t.Errorf("unchanged ETag: Get = %q after %d builds, want v1 after 1", got, builds)
+	}
+	if got := c.Get("cal/uid", "2", build("v2")); got != "v2" || builds != 2 {
+		t.Errorf("new ETag: Get = %q after %d builds, want v2 after 2", got, builds)
+	}
+	c.Get("cal/none", "", build("x"))
+	c.Get("cal/none", "", build("x"))
+	if builds != 4 {
+		t.Errorf("resource without an ETag was cached")
+	}
+
+	var off *CanonicalBodies
+	if got := off.Get("cal/uid", "1", build("v3")); got != "v3" {
+		t.Errorf("nil cache: Get = %q, want v3", got)
+	}
+}
@@ -0,0 +1,201 @@
+package common
+
+import (
+	"context"
+	"encoding/xml"
+	"net/http"
+	"strings"
+
+	"github.com/sonroyaalmerol/ldap-dav/internal/storage"
+)
+
+// DeadPropertyStore is the part of storage.Store that persists dead
+// properties.
+type DeadPropertyStore interface {
+	ListDeadProperties(ctx context.Context, resourceID string) ([]storage.DeadProperty, error)
+	SetDeadProperty(ctx context.Context, p storage.DeadProperty) error
+	RemoveDeadProperty(ctx context.Context, resourceID, space, local string) error
+}
+
+// PatchDeadProperties stores or removes every property in set and remove
+// that handled does not claim, and reports the outcome of each in resp.
+// Unclaimed DAV: properties are live and cannot be set, so they are
+// reported as protected.
+// The first storage error is returned after all properties were reported.
+func PatchDeadProperties(ctx context.Context, store DeadPropertyStore, resourceID string, set, remove []RawXMLValue, handled func(xml.Name) bool, resp *Response) error {
+	var firstErr error
+	apply := func(raw RawXMLValue, removing bool) {
+		name, ok := raw.Name()
+		if !ok || handled(name) {
+			return
+		}
+		if name.Space == NSDAV {
+			EncodeProtectedProp(resp, name)
+			return
+		}
+
+		if err := storeDeadProperty(ctx, store, resourceID, name, raw, removing, resp); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	for _, raw := range set {
+		apply(raw, false)
+	}
+	for _, raw := range remove {
+		apply(raw, true)
+	}
+	return firstErr
+}
+
+// EncodeProtectedProp reports name in resp as a live property the client
+// cannot change: 403 with the DAV:cannot-modify-protected-property
+// precondition.
+func EncodeProtectedProp(resp *Response, name xml.Name) {
+	_ = resp.EncodeProp(http.StatusForbidden, emptyProp{XMLName: name})
+	for i := range resp.PropStats {
+		ps := &resp.PropStats[i]
+		if ps.Status.Code != http.StatusForbidden || ps.Error != nil {
+			continue
+		}
+		raw, err := EncodeRawXMLElement(struct {
+			XMLName xml.Name `xml:"DAV: cannot-modify-protected-property"`
+		}{})
+		if err == nil {
+			ps.Error = &Error{Raw: []RawXMLValue{*raw}}
+		}
+	}
+}
+
+// storeDeadProperty sets or removes a single property and reports the
+// outcome in resp.
+func storeDeadProperty(ctx context.Context, store DeadPropertyStore, resourceID string, name xml.Name, raw RawXMLValue, removing bool, resp *Response) error {
+	var err error
+	if removing {
+		err = store.RemoveDeadProperty(ctx, resourceID, name.Space, name.Local)
+	} else {
+		var value []byte
+		value, err = xml.Marshal(&raw)
+		if err == nil {
+			err = store.SetDeadProperty(ctx, storage.DeadProperty{
+				ResourceID: resourceID,
+				Space:      name.Space,
+				Local:      name.Local,
+				Value:      string(value),
+			})
+		}
+	}
+
+	status := http.StatusOK
+	if err != nil {
+		status = http.StatusInternalServerError
+	}
+	_ = resp.EncodeProp(status, emptyProp{XMLName: name})
+	return err
+}
+
+// DisplayNameProp is DAV:displayname. Homes and principals have no stored
+// row to carry one, so a name set on them is kept as a dead property.
+var DisplayNameProp = xml.Name{Space: NSDAV, Local: "displayname"}
+
+// PrincipalResourceID keys the dead properties of a user's principal.
+func PrincipalResourceID(uid string) string {
+	return "principal:" + uid
+}
+
+// PatchDisplayName sets or removes the DAV:displayname kept as a dead
+// property of resourceID and reports the outcome in resp.
+func PatchDisplayName(ctx context.Context, store DeadPropertyStore, resourceID string, raw RawXMLValue, removing bool, resp *Response) error {
+	return storeDeadProperty(ctx, store, resourceID, DisplayNameProp, raw, removing, resp)
+}
+
+// StoredDisplayName returns the DAV:displayname kept as a dead property of
+// resourceID, or def when none was set.
+func StoredDisplayName(ctx context.Context, store DeadPropertyStore, resourceID, def string) string {
+	props, err := store.ListDeadProperties(ctx, resourceID)
+	if err != nil {
+		return def
+	}
+	for _, p := range props {
+		if p.Space != DisplayNameProp.Space || p.Local != DisplayNameProp.Local {
+			continue
+		}
+		var v DisplayName
+		if err := xml.Unmarshal([]byte(p.Value), &v); err == nil {
+			return v.Name
+		}
+	}
+	return def
+}
+
+// StoredPropertyText returns the character data of the dead property name
+// kept for resourceID, and whether one was set.
+func StoredPropertyText(ctx context.Context, store DeadPropertyStore, resourceID string, name xml.Name) (string, bool) {
+	props, err := store.ListDeadProperties(ctx, resourceID)
+	if err != nil {
+		return "", false
+	}
+	for _, p := range props {
+		if p.Space != name.Space || p.Local != name.Local {
+			continue
+		}
+		var v struct {
+			Text string `xml:",chardata"`
+		}
+		if err := xml.Unmarshal([]byte(p.Value), &v); err == nil {
+			return strings.TrimSpace(v.Text), true
+		}
+	}
+	return "", false
+}
+
+// SetStoredDisplayName keeps name as the DAV:displayname dead property of
+// resourceID, removing it when name is nil.
+func SetStoredDisplayName(ctx context.Context, store DeadPropertyStore, resourceID string, name *string) error {
+	if name == nil {
+		return store.RemoveDeadProperty(ctx, resourceID, DisplayNameProp.Space, DisplayNameProp.Local)
+	}
+	value, err := xml.Marshal(DisplayName{Name: *name})
+	if err != nil {
+		return err
+	}
+	return store.SetDeadProperty(ctx, storage.DeadProperty{
+		ResourceID: resourceID,
+		Space:      DisplayNameProp.Space,
+		Local:      DisplayNameProp.Local,
+		Value:      string(value),
+	})
+}
+
+// EncodeDeadProperties adds the stored dead properties of a resource to
+// resp. Properties resp already carries are live and take precedence.
+func EncodeDeadProperties(ctx context.Context, store DeadPropertyStore, resourceID string, resp *Response) error {
+	props, err := store.ListDeadProperties(ctx, resourceID)
+	if err != nil {
+		return err
+	}
+	if len(props) == 0 {
+		return nil
+	}
+
+	present := make(map[xml.Name]bool)
+	for _, ps := range resp.PropStats {
+		for _, v := range ps.Prop.Raw {
+			if name, ok := v.Name(); ok {
+				present[name] = true
+			}
+		}
+	}
+
+	for _, p := range props {
+		if present[xml.Name{Space: p.Space, Local: p.Local}] {
+			continue
+		}
+		var raw RawXMLValue
+		if err := xml.Unmarshal([]byte(p.Value), &raw); err != nil {
+			continue
+		}
+		_ = resp.EncodeProp(http.StatusOK, &raw)
+	}
+	return nil
+}
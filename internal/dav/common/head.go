@@ -0,0 +1,11 @@
+package common
+
+import "net/http"
+
+// HeadResponseWriter serves HEAD through a GET handler: headers, status
+// and Content-Length are passed on while the body is dropped.
+type HeadResponseWriter struct {
+	http.ResponseWriter
+}
+
+func (hrw *HeadResponseWriter) Write(b []byte) (int, error) { return len(b), nil }
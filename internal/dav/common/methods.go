@@ -0,0 +1,86 @@
+package common
+
+import (
+	"encoding/xml"
+	"strings"
+)
+
+type SupportedMethodSet struct {
+	XMLName xml.Name          `xml:"DAV: supported-method-set"`
+	Methods []SupportedMethod `xml:"DAV: supported-method"`
+}
+
+type SupportedMethod struct {
+	Name string `xml:"name,attr"`
+}
+
+// AllowedMethods returns the methods supported on the resource at urlPath:
+// homes accept collection creation, collections accept REPORT/PROPPATCH/DELETE
+// and POST of new members, and objects accept GET/HEAD/PUT/DELETE.
+// LDAP-backed address books are read-only. A principal's free-busy URL
+// only serves GET/HEAD, and when scheduling is set the scheduling inbox and
+// outbox can only be listed.
+func AllowedMethods(basePath, layout string, scheduling bool, urlPath string) []string {
+	if _, ok := ParsePrincipalFreeBusyPath(basePath, layout, urlPath); ok {
+		return []string{"OPTIONS", "GET", "HEAD"}
+	}
+	methods := []string{"OPTIONS", "PROPFIND"}
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(urlPath, basePath), "/"), "/")
+	var mk []string
+	switch parts[0] {
+	case "calendars":
+		mk = []string{"MKCOL", "MKCALENDAR"}
+	case "addressbooks":
+		mk = []string{"MKCOL"}
+	case "principals":
+		// a user's principal takes DAV:displayname
+		if uid, ok := ParsePrincipalPath(basePath, layout, urlPath); ok && uid != "" {
+			methods = append(methods, "PROPPATCH")
+		}
+		return methods
+	default:
+		return methods
+	}
+
+	depth := len(parts)
+	if depth >= 3 && parts[2] == "shared" {
+		if depth == 3 {
+			// shared root is a read-only listing
+			return methods
+		}
+		parts = append(parts[:2], parts[3:]...)
+		depth--
+	}
+	if scheduling && parts[0] == "calendars" && depth >= 3 && (parts[2] == "inbox" || parts[2] == "outbox") {
+		return methods
+	}
+	readOnly := parts[0] == "addressbooks" && depth >= 3 && strings.HasPrefix(parts[2], "ldap_")
+
+	switch {
+	case depth == 2:
+		// homes take DAV:displayname, and the addressbook home CS:me-card
+		methods = append(methods, "PROPPATCH")
+		methods = append(methods, mk...)
+	case depth <= 2:
+		methods = append(methods, mk...)
+	case depth == 3 && readOnly:
+		methods = append(methods, "REPORT")
+	case depth == 3:
+		methods = append(methods, "REPORT", "PROPPATCH", "DELETE", "POST")
+		methods = append(methods, mk...)
+	case readOnly:
+		methods = append(methods, "GET", "HEAD")
+	default:
+		methods = append(methods, "GET", "HEAD", "PUT", "DELETE")
+	}
+	return methods
+}
+
+func SupportedMethodSetFor(basePath, layout string, scheduling bool, href string) SupportedMethodSet {
+	var set SupportedMethodSet
+	for _, m := range AllowedMethods(basePath, layout, scheduling, href) {
+		set.Methods = append(set.Methods, SupportedMethod{Name: m})
+	}
+	return set
+}
@@ -0,0 +1,45 @@
+package common
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestAllowedMethods(t *testing.T) {
+	tests := []struct {
+		name       string
+		layout     string
+		scheduling bool
+		path       string
+		want       []string
+	}{
+		{"root", PrincipalLayoutUsers, false, "/dav/", []string{"OPTIONS", "PROPFIND"}},
+		{"principal collection", PrincipalLayoutUsers, false, "/dav/principals/", []string{"OPTIONS", "PROPFIND"}},
+		{"principal", PrincipalLayoutUsers, false, "/dav/principals/users/alice/", []string{"OPTIONS", "PROPFIND", "PROPPATCH"}},
+		{"flat principal", PrincipalLayoutFlat, false, "/dav/principals/alice/", []string{"OPTIONS", "PROPFIND", "PROPPATCH"}},
+		{"free-busy", PrincipalLayoutUsers, false, "/dav/principals/users/alice/freebusy", []string{"OPTIONS", "GET", "HEAD"}},
+		{"flat free-busy", PrincipalLayoutFlat, false, "/dav/principals/alice/freebusy", []string{"OPTIONS", "GET", "HEAD"}},
+		{"calendar root", PrincipalLayoutUsers, false, "/dav/calendars/", []string{"OPTIONS", "PROPFIND", "MKCOL", "MKCALENDAR"}},
+		{"calendar home", PrincipalLayoutUsers, false, "/dav/calendars/alice/", []string{"OPTIONS", "PROPFIND", "PROPPATCH", "MKCOL", "MKCALENDAR"}},
+		{"calendar", PrincipalLayoutUsers, false, "/dav/calendars/alice/work/", []string{"OPTIONS", "PROPFIND", "REPORT", "PROPPATCH", "DELETE", "POST", "MKCOL", "MKCALENDAR"}},
+		{"calendar object", PrincipalLayoutUsers, false, "/dav/calendars/alice/work/e.ics", []string{"OPTIONS", "PROPFIND", "GET", "HEAD", "PUT", "DELETE"}},
+		{"shared root", PrincipalLayoutUsers, false, "/dav/calendars/alice/shared/", []string{"OPTIONS", "PROPFIND"}},
+		{"shared calendar", PrincipalLayoutUsers, false, "/dav/calendars/alice/shared/team/", []string{"OPTIONS", "PROPFIND", "REPORT", "PROPPATCH", "DELETE", "POST", "MKCOL", "MKCALENDAR"}},
+		{"scheduling inbox", PrincipalLayoutUsers, true, "/dav/calendars/alice/inbox/", []string{"OPTIONS", "PROPFIND"}},
+		{"scheduling outbox", PrincipalLayoutUsers, true, "/dav/calendars/alice/outbox/", []string{"OPTIONS", "PROPFIND"}},
+		{"inbox member", PrincipalLayoutUsers, true, "/dav/calendars/alice/inbox/e.ics", []string{"OPTIONS", "PROPFIND"}},
+		{"inbox calendar without scheduling", PrincipalLayoutUsers, false, "/dav/calendars/alice/inbox/", []string{"OPTIONS", "PROPFIND", "REPORT", "PROPPATCH", "DELETE", "POST", "MKCOL", "MKCALENDAR"}},
+		{"addressbook home", PrincipalLayoutUsers, false, "/dav/addressbooks/alice/", []string{"OPTIONS", "PROPFIND", "PROPPATCH", "MKCOL"}},
+		{"addressbook", PrincipalLayoutUsers, false, "/dav/addressbooks/alice/contacts/", []string{"OPTIONS", "PROPFIND", "REPORT", "PROPPATCH", "DELETE", "POST", "MKCOL"}},
+		{"card", PrincipalLayoutUsers, false, "/dav/addressbooks/alice/contacts/c.vcf", []string{"OPTIONS", "PROPFIND", "GET", "HEAD", "PUT", "DELETE"}},
+		{"LDAP addressbook", PrincipalLayoutUsers, false, "/dav/addressbooks/alice/ldap_staff/", []string{"OPTIONS", "PROPFIND", "REPORT"}},
+		{"LDAP card", PrincipalLayoutUsers, false, "/dav/addressbooks/alice/ldap_staff/c.vcf", []string{"OPTIONS", "PROPFIND", "GET", "HEAD"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := AllowedMethods("/dav", tt.layout, tt.scheduling, tt.path); !slices.Equal(got, tt.want) {
+				t.Errorf("AllowedMethods(%q) = %v, want %v", tt.path, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package common
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// namespacePrefixes binds the namespaces DAV responses are made of to fixed
+// prefixes, declared once on the root element.
+var namespacePrefixes = []struct{ space, prefix string }{
+	{NSDAV, "d"},
+	{NSCalDAV, "cal"},
+	{NSCardDAV, "card"},
+	{NSCS, "cs"},
+	{NSApple, "ical"},
+}
+
+// xmlNamespace is the namespace encoding/xml reports for the reserved xml
+// prefix.
+const xmlNamespace = "http://www.w3.org/XML/1998/namespace"
+
+// EncodeXML writes v to w with every element in a prefixed namespace.
+// encoding/xml instead re-declares the default namespace on each element
+// whose namespace differs from its parent's, so a DAV:href inside a CalDAV
+// property carries its own xmlns="DAV:", which strict clients such as
+// Thunderbird have been seen to mishandle. The well-known namespaces are
+// declared on the root element; any other, such as that of a client's dead
+// property, is declared on the element where it first appears.
+func EncodeXML(w io.Writer, v interface{}) error {
+	raw, err := xml.Marshal(v)
+	if err != nil {
+		return err
+	}
+	out, err := prefixNamespaces(raw)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(out)
+	return err
+}
+
+// prefixNamespaces rewrites an XML document so that elements and
+// attributes use prefixes instead of default namespace declarations.
+func prefixNamespaces(raw []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	// scopes holds, per open element, the namespaces declared on it beyond
+	// the well-known ones.
+	var scopes []map[string]string
+	generated := 0
+
+	lookup := func(space string) (string, bool) {
+		for _, ns := range namespacePrefixes {
+			if ns.space == space {
+				return ns.prefix, true
+			}
+		}
+		for i := len(scopes) - 1; i >= 0; i-- {
+			if p, ok := scopes[i][space]; ok {
+				return p, true
+			}
+		}
+		return "", false
+	}
+
+	dec := xml.NewDecoder(bytes.NewReader(raw))
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			declared := map[string]string{}
+			var decls strings.Builder
+			if len(scopes) == 0 {
+				for _, ns := range namespacePrefixes {
+					fmt.Fprintf(&decls, ` xmlns:%s="%s"`, ns.prefix, ns.space)
+				}
+			}
+			qualify := func(n xml.Name) string {
+				if n.Space == "" {
+					return n.Local
+				}
+				if n.Space == xmlNamespace {
+					// The xml prefix is bound by definition and may not
+					// be declared for another (Namespaces in XML 1.0,
+					// section 3), so xml:lang and the like stay as they
+					// are.
+					return "xml:" + n.Local
+				}
+				p, ok := lookup(n.Space)
+				if !ok {
+					if p, ok = declared[n.Space]; !ok {
+						p = fmt.Sprintf("ns%d", generated)
+						generated++
+						declared[n.Space] = p
+						decls.WriteString(` xmlns:` + p + `="`)
+						_ = xml.EscapeText(&decls, []byte(n.Space))
+						decls.WriteString(`"`)
+					}
+				}
+				return p + ":" + n.Local
+			}
+
+			name := qualify(t.Name)
+			var attrs strings.Builder
+			for _, a := range t.Attr {
+				if a.Name.Space == "xmlns" || (a.Name.Space == "" && a.Name.Local == "xmlns") {
+					continue
+				}
+				attrs.WriteString(" " + qualify(a.Name) + `="`)
+				_ = xml.EscapeText(&attrs, []byte(a.Value))
+				attrs.WriteString(`"`)
+			}
+			buf.WriteString("<" + name + decls.String() + attrs.String() + ">")
+			scopes = append(scopes, declared)
+		case xml.EndElement:
+			name := t.Name.Local
+			if t.Name.Space == xmlNamespace {
+				name = "xml:" + name
+			} else if p, ok := lookup(t.Name.Space); ok && t.Name.Space != "" {
+				name = p + ":" + name
+			}
+			buf.WriteString("</" + name + ">")
+			scopes = scopes[:len(scopes)-1]
+		case xml.CharData:
+			_ = xml.EscapeText(&buf, t)
+		case xml.Comment:
+			buf.WriteString("<!--" + string(t) + "-->")
+		}
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,110 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/sonroyaalmerol/ldap-dav/internal/storage"
+)
+
+type deadPropertyList []storage.DeadProperty
+
+func (l deadPropertyList) ListDeadProperties(_ context.Context, _ string) ([]storage.DeadProperty, error) {
+	return l, nil
+}
+
+func (l deadPropertyList) SetDeadProperty(context.Context, storage.DeadProperty) error { return nil }
+
+func (l deadPropertyList) RemoveDeadProperty(context.Context, string, string, string) error {
+	return nil
+}
+
+func TestEncodeXMLRoundTrip(t *testing.T) {
+	const (
+		homeHref    = "/dav/calendars/alice/"
+		foreignNS   = "http://example.com/ns/"
+		foreignProp = `<x:color xmlns:x="http://example.com/ns/" xml:lang="en">blue</x:color>`
+	)
+
+	resp := Response{Hrefs: []Href{{Value: "/dav/principals/users/alice/"}}}
+	if err := resp.EncodeProp(http.StatusOK, CalendarHomeSet{Hrefs: []Href{{Value: homeHref}}}); err != nil {
+		t.Fatalf("encode calendar-home-set: %v", err)
+	}
+	store := deadPropertyList{{ResourceID: "principal:alice", Space: foreignNS, Local: "color", Value: foreignProp}}
+	if err := EncodeDeadProperties(context.Background(), store, "principal:alice", &resp); err != nil {
+		t.Fatalf("encode dead properties: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := EncodeXML(&buf, NewMultiStatus(resp)); err != nil {
+		t.Fatalf("EncodeXML: %v", err)
+	}
+	out := buf.String()
+
+	if strings.Contains(out, `xmlns="`) {
+		t.Errorf("output declares a default namespace: %s", out)
+	}
+	if strings.Contains(out, `="`+xmlNamespace+`"`) {
+		t.Errorf("output rebinds the xml namespace: %s", out)
+	}
+
+	var (
+		stack     []xml.Name
+		gotHref   bool
+		gotColor  bool
+		colorLang string
+	)
+	dec := xml.NewDecoder(strings.NewReader(out))
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("output is not well-formed: %v\n%s", err, out)
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			stack = append(stack, tok.Name)
+			if tok.Name == (xml.Name{Space: foreignNS, Local: "color"}) {
+				gotColor = true
+				for _, a := range tok.Attr {
+					if a.Name == (xml.Name{Space: xmlNamespace, Local: "lang"}) {
+						colorLang = a.Value
+					}
+				}
+			}
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			n := len(stack)
+			if n >= 2 && stack[n-1] == (xml.Name{Space: NSDAV, Local: "href"}) &&
+				stack[n-2] == (xml.Name{Space: NSCalDAV, Local: "calendar-home-set"}) &&
+				string(tok) == homeHref {
+				gotHref = true
+			}
+		}
+	}
+	if !gotHref {
+		t.Errorf("DAV:href inside CALDAV:calendar-home-set lost: %s", out)
+	}
+	if !gotColor {
+		t.Errorf("dead property in %s lost: %s", foreignNS, out)
+	}
+	if colorLang != "en" {
+		t.Errorf("xml:lang = %q, want %q: %s", colorLang, "en", out)
+	}
+
+	var ms MultiStatus
+	if err := xml.Unmarshal(buf.Bytes(), &ms); err != nil {
+		t.Fatalf("decode multistatus: %v", err)
+	}
+	if len(ms.Responses) != 1 || len(ms.Responses[0].Hrefs) != 1 || ms.Responses[0].Hrefs[0].Value != "/dav/principals/users/alice/" {
+		t.Errorf("response hrefs did not round-trip: %+v", ms.Responses)
+	}
+}
@@ -2,13 +2,76 @@ package common
 
 import (
 	"context"
+	"net/http"
 	"strings"
 )
 
-func PrincipalURL(basePath, uid string) string {
+const (
+	// PrincipalLayoutUsers places user principals at principals/users/<uid>.
+	PrincipalLayoutUsers = "users"
+	// PrincipalLayoutFlat places user principals directly at principals/<uid>.
+	PrincipalLayoutFlat = "flat"
+)
+
+// PrincipalURL builds a user's principal URL under layout. Unknown layouts
+// are treated as PrincipalLayoutUsers.
+func PrincipalURL(basePath, layout, uid string) string {
+	if layout == PrincipalLayoutFlat {
+		return JoinURL(basePath, "principals", uid)
+	}
 	return JoinURL(basePath, "principals", "users", uid)
 }
 
+func PrincipalCollectionURL(basePath string) string {
+	return JoinURL(basePath, "principals") + "/"
+}
+
+// PrincipalCollectionSetFor points clients at the principals collection, the
+// scope for principal-property-search.
+func PrincipalCollectionSetFor(basePath string) PrincipalCollectionSet {
+	return PrincipalCollectionSet{Hrefs: []Href{{Value: PrincipalCollectionURL(basePath)}}}
+}
+
+// ParsePrincipalPath extracts the user id from a principal URL under
+// layout. An empty uid with ok=true denotes the principal collection itself.
+func ParsePrincipalPath(basePath, layout, urlPath string) (uid string, ok bool) {
+	p := strings.Trim(strings.TrimPrefix(urlPath, basePath), "/")
+	parts := strings.Split(p, "/")
+	if len(parts) == 0 || parts[0] != "principals" {
+		return "", false
+	}
+	parts = parts[1:]
+	if len(parts) == 0 {
+		return "", true
+	}
+	if layout == PrincipalLayoutFlat {
+		if len(parts) == 1 {
+			return parts[0], true
+		}
+		return "", false
+	}
+	if len(parts) == 2 && parts[0] == "users" {
+		return parts[1], true
+	}
+	return "", false
+}
+
+// PrincipalFreeBusyURL is where a user's combined free-busy is published.
+func PrincipalFreeBusyURL(basePath, layout, uid string) string {
+	return JoinURL(PrincipalURL(basePath, layout, uid), "freebusy")
+}
+
+// ParsePrincipalFreeBusyPath extracts the user id from a free-busy URL as
+// built by PrincipalFreeBusyURL.
+func ParsePrincipalFreeBusyPath(basePath, layout, urlPath string) (uid string, ok bool) {
+	p := strings.TrimSuffix(urlPath, "/")
+	if !strings.HasSuffix(p, "/freebusy") {
+		return "", false
+	}
+	uid, ok = ParsePrincipalPath(basePath, layout, strings.TrimSuffix(p, "/freebusy"))
+	return uid, ok && uid != ""
+}
+
 func JoinURL(parts ...string) string {
 	s := strings.Join(parts, "/")
 	s = strings.ReplaceAll(s, "//", "/")
@@ -30,12 +93,20 @@ func CalendarSharedRoot(basePath, uid string) string {
 	return JoinURL(basePath, "calendars", uid, "shared") + "/"
 }
 
-func CurrentUserPrincipalHref(ctx context.Context, basePath string) string {
+func ScheduleInboxPath(basePath, uid string) string {
+	return JoinURL(basePath, "calendars", uid, "inbox") + "/"
+}
+
+func ScheduleOutboxPath(basePath, uid string) string {
+	return JoinURL(basePath, "calendars", uid, "outbox") + "/"
+}
+
+func CurrentUserPrincipalHref(ctx context.Context, basePath, layout string) string {
 	u, _ := CurrentUser(ctx)
 	if u == nil {
-		return JoinURL(basePath, "principals")
+		return PrincipalCollectionURL(basePath)
 	}
-	return PrincipalURL(basePath, u.UID)
+	return PrincipalURL(basePath, layout, u.UID)
 }
 
 func AddressbookHome(basePath, uid string) string {
@@ -49,3 +120,16 @@ func AddressbookPath(basePath, owner, abURI string) string {
 func AddressbookSharedRoot(basePath, uid string) string {
 	return JoinURL(basePath, "addressbooks", uid, "shared") + "/"
 }
+
+// AbsoluteURL turns a server path into an absolute URL as the client sees
+// it. Scheme and host reflect X-Forwarded-* when a trusted proxy set them.
+func AbsoluteURL(r *http.Request, p string) string {
+	scheme := r.URL.Scheme
+	if scheme == "" {
+		scheme = "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+	}
+	return scheme + "://" + r.Host + p
+}
@@ -0,0 +1,63 @@
+package common
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/google/uuid"
+)
+
+// AddMember is the DAV:add-member property (RFC 5995 section 3.2): the URL
+// clients POST to when they let the server name a new member.
+type AddMember struct {
+	XMLName xml.Name `xml:"DAV: add-member"`
+	Href    Href     `xml:"DAV: href"`
+}
+
+// MemberName picks the name of a member created by POST: the object's UID
+// when it is a safe path segment that needs no escaping in a URL, otherwise
+// a fresh UUID.
+func MemberName(uid string) string {
+	if uid != "" && SafeSegment(uid) && url.PathEscape(uid) == uid {
+		return uid
+	}
+	return uuid.New().String()
+}
+
+// EscapedHref returns the path p escaped for use in a DAV:href or a
+// Location header.
+func EscapedHref(p string) string {
+	return (&url.URL{Path: p}).EscapedPath()
+}
+
+// PostToCollection serves a POST to a collection (RFC 5995) by handing
+// body to put as a PUT of href that must not overwrite anything. When the
+// member is created, the Location header names it. href is the unescaped
+// path.
+func PostToCollection(w http.ResponseWriter, r *http.Request, href string, body []byte, put http.HandlerFunc) {
+	req := r.Clone(r.Context())
+	req.Method = http.MethodPut
+	req.URL.Path = href
+	req.URL.RawPath = ""
+	req.Header.Set("If-None-Match", "*")
+	req.Header.Del("If-Match")
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	put(&locationWriter{ResponseWriter: w, location: EscapedHref(href)}, req)
+}
+
+// locationWriter adds a Location header to a 201 Created response.
+type locationWriter struct {
+	http.ResponseWriter
+	location string
+}
+
+func (lw *locationWriter) WriteHeader(code int) {
+	if code == http.StatusCreated {
+		lw.Header().Set("Location", lw.location)
+	}
+	lw.ResponseWriter.WriteHeader(code)
+}
@@ -0,0 +1,216 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// PropSelection is the set of properties a PROPFIND asked for. A nil
+// selection, an empty body and DAV:allprop all select every property.
+type PropSelection struct {
+	names map[xml.Name]bool
+}
+
+// ParsePropfind reads the DAV:prop list out of a PROPFIND body.
+func ParsePropfind(body []byte) (*PropSelection, error) {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil, nil
+	}
+	dec := xml.NewDecoder(bytes.NewReader(body))
+	depth := 0
+	inProp := false
+	var names map[xml.Name]bool
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			depth++
+			if depth == 2 && t.Name.Space == NSDAV && t.Name.Local == "prop" {
+				inProp = true
+				names = make(map[xml.Name]bool)
+			} else if depth == 3 && inProp {
+				names[t.Name] = true
+			}
+		case xml.EndElement:
+			if depth == 2 {
+				inProp = false
+			}
+			depth--
+		}
+	}
+	if names == nil {
+		return nil, nil
+	}
+	return &PropSelection{names: names}, nil
+}
+
+// Wants reports whether the property should be computed and returned.
+func (s *PropSelection) Wants(space, local string) bool {
+	if s == nil {
+		return true
+	}
+	return s.names[xml.Name{Space: space, Local: local}]
+}
+
+// Filter drops unrequested properties from every response and reports
+// requested properties the resource does not have with 404.
+func (s *PropSelection) Filter(ms *MultiStatus) {
+	if s == nil {
+		return
+	}
+	for i := range ms.Responses {
+		s.FilterResponse(&ms.Responses[i])
+	}
+}
+
+// FilterResponse applies Filter to a single response.
+func (s *PropSelection) FilterResponse(resp *Response) {
+	if s == nil || resp.Status != nil {
+		return
+	}
+	found := make(map[xml.Name]bool)
+	var kept []PropStat
+	for _, ps := range resp.PropStats {
+		var raw []RawXMLValue
+		for _, v := range ps.Prop.Raw {
+			name, ok := v.Name()
+			if ok && !s.names[name] {
+				continue
+			}
+			found[name] = true
+			raw = append(raw, v)
+		}
+		if len(raw) > 0 {
+			ps.Prop.Raw = raw
+			kept = append(kept, ps)
+		}
+	}
+	resp.PropStats = kept
+	for name := range s.names {
+		if !found[name] {
+			_ = resp.EncodeProp(http.StatusNotFound, emptyProp{XMLName: name})
+		}
+	}
+}
+
+type emptyProp struct {
+	XMLName xml.Name
+}
+
+type propSelectionKey struct{}
+
+func WithPropSelection(ctx context.Context, s *PropSelection) context.Context {
+	return context.WithValue(ctx, propSelectionKey{}, s)
+}
+
+// PropSelectionFrom returns the selection of the PROPFIND being served, or
+// nil (everything) outside PROPFIND.
+func PropSelectionFrom(ctx context.Context) *PropSelection {
+	s, _ := ctx.Value(propSelectionKey{}).(*PropSelection)
+	return s
+}
+
+// ServePropfind filters ms down to the properties the PROPFIND requested
+// and writes it.
+func ServePropfind(w http.ResponseWriter, r *http.Request, ms *MultiStatus) error {
+	encodeLockProperties(ms)
+	PropSelectionFrom(r.Context()).Filter(ms)
+	w.Header().Add("Vary", "Prefer")
+	if r.Header.Get("Depth") == "1" && prefers(r, "depth-noroot") {
+		dropRootResponse(ms, r.URL.Path)
+		w.Header().Set("Preference-Applied", "depth-noroot")
+	}
+	return ServeMultiStatus(w, ms)
+}
+
+// prefers reports whether the Prefer header of r carries the preference
+// token (RFC 7240).
+func prefers(r *http.Request, token string) bool {
+	for _, v := range r.Header.Values("Prefer") {
+		for _, pref := range strings.Split(v, ",") {
+			name, _, _ := strings.Cut(pref, ";")
+			name, _, _ = strings.Cut(name, "=")
+			if strings.EqualFold(strings.TrimSpace(name), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// dropRootResponse removes the response for the collection itself, as
+// Prefer: depth-noroot asks (RFC 8144 section 3). The preference does not
+// apply to plain resources, so only a collection href, which ends in a
+// slash, is dropped.
+func dropRootResponse(ms *MultiStatus, root string) {
+	root = strings.TrimSuffix(root, "/")
+	kept := ms.Responses[:0]
+	for _, resp := range ms.Responses {
+		isRoot := false
+		for _, h := range resp.Hrefs {
+			if strings.HasSuffix(h.Value, "/") && strings.TrimSuffix(h.Value, "/") == root {
+				isRoot = true
+				break
+			}
+		}
+		if !isRoot {
+			kept = append(kept, resp)
+		}
+	}
+	ms.Responses = kept
+}
+
+// encodeLockProperties adds the lock properties, identical on every
+// resource, to each response that reports properties.
+func encodeLockProperties(ms *MultiStatus) {
+	for i := range ms.Responses {
+		resp := &ms.Responses[i]
+		if resp.Status != nil || len(resp.PropStats) == 0 {
+			continue
+		}
+		_ = resp.EncodeProp(http.StatusOK, SupportedLock{})
+		_ = resp.EncodeProp(http.StatusOK, LockDiscovery{})
+	}
+}
+
+// Name returns the element name of a property value, when it can be told
+// without encoding it.
+func (val *RawXMLValue) Name() (xml.Name, bool) {
+	if start, ok := val.tok.(xml.StartElement); ok {
+		return start.Name, true
+	}
+	if val.out == nil {
+		return xml.Name{}, false
+	}
+	if inner, ok := val.out.(*RawXMLValue); ok {
+		return inner.Name()
+	}
+	t := reflect.TypeOf(val.out)
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return xml.Name{}, false
+	}
+	f, ok := t.FieldByName("XMLName")
+	if !ok {
+		return xml.Name{}, false
+	}
+	tag, _, _ := strings.Cut(f.Tag.Get("xml"), ",")
+	space, local, ok := strings.Cut(tag, " ")
+	if !ok || local == "" {
+		return xml.Name{}, false
+	}
+	return xml.Name{Space: space, Local: local}, true
+}
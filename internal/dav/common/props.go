@@ -1,20 +1,29 @@
 package common
 
 import (
+	"bytes"
 	"encoding/xml"
 	"fmt"
 	"net/http"
 )
 
-func ServeXML(w http.ResponseWriter) *xml.Encoder {
-	w.Header().Set("Content-Type", "application/xml; charset=\"utf-8\"")
-	_, _ = w.Write([]byte(xml.Header))
-	return xml.NewEncoder(w)
+func ServeMultiStatus(w http.ResponseWriter, ms *MultiStatus) error {
+	return serveXMLDocument(w, http.StatusMultiStatus, ms)
 }
 
-func ServeMultiStatus(w http.ResponseWriter, ms *MultiStatus) error {
-	w.WriteHeader(http.StatusMultiStatus)
-	return ServeXML(w).Encode(ms)
+// serveXMLDocument writes v as the whole XML body of a response with the
+// given status. The body is built before anything is sent, so an encoding
+// failure does not leave a half-written document behind a success status.
+func serveXMLDocument(w http.ResponseWriter, code int, v interface{}) error {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	if err := EncodeXML(&buf, v); err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/xml; charset=\"utf-8\"")
+	w.WriteHeader(code)
+	_, err := w.Write(buf.Bytes())
+	return err
 }
 
 func WriteMultiStatus(w http.ResponseWriter, ms MultiStatus) {
@@ -23,6 +32,32 @@ func WriteMultiStatus(w http.ResponseWriter, ms MultiStatus) {
 	}
 }
 
+// ServeError writes a DAV:error body carrying the given precondition or
+// postcondition elements with the supplied status code.
+func ServeError(w http.ResponseWriter, code int, conditions ...interface{}) error {
+	e := Error{}
+	for _, c := range conditions {
+		raw, err := EncodeRawXMLElement(c)
+		if err != nil {
+			return err
+		}
+		e.Raw = append(e.Raw, *raw)
+	}
+	return serveXMLDocument(w, code, &e)
+}
+
+// ServeUnsupportedReport answers a REPORT the collection does not implement
+// with the RFC 3253 DAV:supported-report precondition, followed by the set of
+// reports that are actually available.
+func ServeUnsupportedReport(w http.ResponseWriter, supported *SupportedReportSet) error {
+	return ServeError(w, http.StatusForbidden,
+		struct {
+			XMLName xml.Name `xml:"DAV: supported-report"`
+		}{},
+		supported,
+	)
+}
+
 func Ok() string { return "HTTP/1.1 200 OK" }
 
 func MakeCalendarResourcetype() *ResourceType {
@@ -31,6 +66,24 @@ func MakeCalendarResourcetype() *ResourceType {
 		Calendar:   &struct{}{},
 	}
 }
+
+// MakeSharedCalendarResourcetype marks a calendar mounted under the shared
+// root as belonging to another user.
+func MakeSharedCalendarResourcetype() *ResourceType {
+	return &ResourceType{
+		Collection: &struct{}{},
+		Calendar:   &struct{}{},
+		Shared:     &struct{}{},
+	}
+}
+
+// MakeSharedRootResourcetype describes the shared root. It only groups
+// calendar collections, which must not nest inside each other (RFC 4791
+// 4.2), so it is a plain collection.
+func MakeSharedRootResourcetype() *ResourceType {
+	return MakeCollectionResourcetype()
+}
+
 func MakeCollectionResourcetype() *ResourceType {
 	return &ResourceType{
 		Collection: &struct{}{},
@@ -0,0 +1,8 @@
+package common
+
+// ReadOnly is the runtime read-only maintenance switch. While it is
+// enabled nothing may write to storage, including the provisioning and
+// background work that read requests would otherwise trigger.
+type ReadOnly interface {
+	Enabled() bool
+}
@@ -0,0 +1,106 @@
+package common
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"strconv"
+	"strings"
+)
+
+const (
+	SyncTokenFormatSeq    = "seq"
+	SyncTokenFormatOpaque = "opaque"
+
+	// syncTokenNamespace prefixes opaque tokens and keys their MAC when no
+	// secret is configured. That key is public, so without a secret the MAC
+	// only catches corrupted tokens, not forged ones.
+	syncTokenNamespace = "urn:ldap-dav:sync:"
+	syncTokenMACLen    = 16
+)
+
+// SyncTokens converts stored "seq:N" tokens to and from their wire form.
+type SyncTokens struct {
+	format string
+	key    []byte
+}
+
+// NewSyncTokens returns a codec for the given wire format, which config
+// validation restricts to SyncTokenFormatSeq or SyncTokenFormatOpaque. A
+// non-empty secret signs opaque tokens so that forged ones are rejected.
+func NewSyncTokens(format string, secret []byte) *SyncTokens {
+	t := &SyncTokens{format: SyncTokenFormatOpaque, key: []byte(syncTokenNamespace)}
+	if format == SyncTokenFormatSeq {
+		t.format = SyncTokenFormatSeq
+	}
+	if len(secret) > 0 {
+		t.key = secret
+	}
+	return t
+}
+
+// Encode converts a stored "seq:N" token of the collection collectionID to
+// its wire form. Tokens that are not sequence tokens are returned unchanged.
+func (t *SyncTokens) Encode(collectionID, tok string) string {
+	if t.format == SyncTokenFormatSeq {
+		return tok
+	}
+	seq, ok := parseRawSeqToken(tok)
+	if !ok {
+		return tok
+	}
+	payload := make([]byte, 8, 8+syncTokenMACLen)
+	binary.BigEndian.PutUint64(payload, uint64(seq))
+	payload = append(payload, t.mac(collectionID, payload)...)
+	return syncTokenNamespace + base64.RawURLEncoding.EncodeToString(payload)
+}
+
+// ParseSeq extracts the sequence number from a client-supplied token for
+// the collection collectionID. Both opaque tokens and legacy "seq:N" tokens
+// are accepted; opaque tokens whose MAC does not verify, including those
+// issued for another collection, are rejected.
+func (t *SyncTokens) ParseSeq(collectionID, tok string) (int64, bool) {
+	tok = strings.TrimSpace(tok)
+	if !strings.HasPrefix(tok, syncTokenNamespace) {
+		return parseRawSeqToken(tok)
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(tok, syncTokenNamespace))
+	if err != nil || len(payload) != 8+syncTokenMACLen {
+		return 0, false
+	}
+	if !hmac.Equal(payload[8:], t.mac(collectionID, payload[:8])) {
+		return 0, false
+	}
+	seq := binary.BigEndian.Uint64(payload[:8])
+	if seq > 1<<63-1 {
+		return 0, false
+	}
+	return int64(seq), true
+}
+
+func parseRawSeqToken(tok string) (int64, bool) {
+	tok = strings.TrimSpace(tok)
+	if strings.HasPrefix(tok, "seq:") {
+		v := strings.TrimPrefix(tok, "seq:")
+		if v == "" {
+			return 0, false
+		}
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return 0, false
+		}
+		return n, true
+	}
+	return 0, false
+}
+
+// mac binds the sequence number to its collection, so a token issued for
+// one collection is not accepted by another.
+func (t *SyncTokens) mac(collectionID string, seq []byte) []byte {
+	m := hmac.New(sha256.New, t.key)
+	m.Write([]byte(collectionID))
+	m.Write([]byte{0})
+	m.Write(seq)
+	return m.Sum(nil)[:syncTokenMACLen]
+}
@@ -0,0 +1,54 @@
+package common
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestSyncTokens(t *testing.T) {
+	const collection = "cal-1"
+
+	tokens := NewSyncTokens(SyncTokenFormatOpaque, []byte("secret"))
+	opaque := tokens.Encode(collection, "seq:42")
+
+	flipped := func(tok string) string {
+		payload, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(tok, syncTokenNamespace))
+		if err != nil {
+			t.Fatalf("decode %q: %v", tok, err)
+		}
+		payload[3] ^= 0x01
+		return syncTokenNamespace + base64.RawURLEncoding.EncodeToString(payload)
+	}
+
+	tests := []struct {
+		name       string
+		collection string
+		token      string
+		wantSeq    int64
+		wantOK     bool
+	}{
+		{name: "opaque round-trip", collection: collection, token: opaque, wantSeq: 42, wantOK: true},
+		{name: "legacy seq token", collection: collection, token: "seq:7", wantSeq: 7, wantOK: true},
+		{name: "flipped byte", collection: collection, token: flipped(opaque)},
+		{name: "other secret", collection: collection, token: NewSyncTokens(SyncTokenFormatOpaque, []byte("other")).Encode(collection, "seq:42")},
+		{name: "no secret", collection: collection, token: NewSyncTokens(SyncTokenFormatOpaque, nil).Encode(collection, "seq:42")},
+		{name: "other collection", collection: "cal-2", token: opaque},
+		{name: "garbage", collection: collection, token: syncTokenNamespace + "!!"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seq, ok := tokens.ParseSeq(tt.collection, tt.token)
+			if ok != tt.wantOK || seq != tt.wantSeq {
+				t.Errorf("ParseSeq(%q, %q) = %d, %v; want %d, %v", tt.collection, tt.token, seq, ok, tt.wantSeq, tt.wantOK)
+			}
+		})
+	}
+
+	if !strings.HasPrefix(opaque, syncTokenNamespace) {
+		t.Errorf("opaque token %q lacks the %s prefix", opaque, syncTokenNamespace)
+	}
+	if got := NewSyncTokens(SyncTokenFormatSeq, nil).Encode(collection, "seq:42"); got != "seq:42" {
+		t.Errorf("seq format Encode = %q, want %q", got, "seq:42")
+	}
+}
@@ -8,6 +8,8 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/sonroyaalmerol/ldap-dav/pkg/ical"
 )
 
 const (
@@ -15,6 +17,7 @@ const (
 	NSCalDAV  = "urn:ietf:params:xml:ns:caldav"
 	NSCardDAV = "urn:ietf:params:xml:ns:carddav"
 	NSCS      = "http://calendarserver.org/ns/"
+	NSApple   = "http://apple.com/ns/ical/"
 )
 
 type Status struct {
@@ -159,6 +162,9 @@ type ResourceType struct {
 	Principal   *struct{} `xml:"DAV: principal,omitempty"`
 	Calendar    *struct{} `xml:"urn:ietf:params:xml:ns:caldav calendar,omitempty"`
 	Addressbook *struct{} `xml:"urn:ietf:params:xml:ns:carddav addressbook,omitempty"`
+	ScheduleIn  *struct{} `xml:"urn:ietf:params:xml:ns:caldav schedule-inbox,omitempty"`
+	ScheduleOut *struct{} `xml:"urn:ietf:params:xml:ns:caldav schedule-outbox,omitempty"`
+	Shared      *struct{} `xml:"http://calendarserver.org/ns/ shared,omitempty"`
 }
 
 type SupportedCalData struct {
@@ -274,14 +280,40 @@ type CurrentUserPrivilegeSet struct {
 	Privilege []Privilege `xml:"DAV: privilege"`
 }
 
+type ScheduleInboxURL struct {
+	XMLName xml.Name `xml:"urn:ietf:params:xml:ns:caldav schedule-inbox-URL"`
+	Href    Href     `xml:"DAV: href"`
+}
+
+type ScheduleOutboxURL struct {
+	XMLName xml.Name `xml:"urn:ietf:params:xml:ns:caldav schedule-outbox-URL"`
+	Href    Href     `xml:"DAV: href"`
+}
+
+type CalendarUserAddressSet struct {
+	XMLName xml.Name `xml:"urn:ietf:params:xml:ns:caldav calendar-user-address-set"`
+	Hrefs   []Href   `xml:"DAV: href"`
+}
+
+type CalendarFreeBusySet struct {
+	XMLName xml.Name `xml:"urn:ietf:params:xml:ns:caldav calendar-free-busy-set"`
+	Hrefs   []Href   `xml:"DAV: href"`
+}
+
+type ScheduleCalendarTransp struct {
+	XMLName     xml.Name  `xml:"urn:ietf:params:xml:ns:caldav schedule-calendar-transp"`
+	Opaque      *struct{} `xml:"urn:ietf:params:xml:ns:caldav opaque,omitempty"`
+	Transparent *struct{} `xml:"urn:ietf:params:xml:ns:caldav transparent,omitempty"`
+}
+
 type CalendarHomeSet struct {
 	XMLName xml.Name `xml:"urn:ietf:params:xml:ns:caldav calendar-home-set"`
-	Hrefs   []Href   `xml:"href,omitempty"`
+	Hrefs   []Href   `xml:"DAV: href,omitempty"`
 }
 
 type AddressBookHomeSet struct {
 	XMLName xml.Name `xml:"urn:ietf:params:xml:ns:carddav addressbook-home-set"`
-	Hrefs   []Href   `xml:"href,omitempty"`
+	Hrefs   []Href   `xml:"DAV: href,omitempty"`
 }
 
 type SupportedReportSet struct {
@@ -308,6 +340,12 @@ type PropRequest struct {
 	GetETag      bool
 	CalendarData bool
 	AddressData  bool
+	Expand       *TimeRange          // calendar-data/expand bounds, if requested
+	Comp         *ical.CompSelection // calendar-data/comp selection, if requested
+	CalDataType  *SupportedCalData   // calendar-data content-type/version, if given
+	// Selection holds every requested property, so responses can drop the
+	// rest and report unknown ones with 404. Nil when DAV:prop was empty.
+	Selection *PropSelection
 }
 
 type PropContainer struct {
@@ -340,6 +378,90 @@ type SyncCollection struct {
 	Prop      PropContainer `xml:"DAV: prop,omitempty"`
 }
 
+// PropfindFiniteDepth is the DAV:propfind-finite-depth precondition.
+type PropfindFiniteDepth struct {
+	XMLName xml.Name `xml:"DAV: propfind-finite-depth"`
+}
+
+type ValidSyncToken struct {
+	XMLName xml.Name `xml:"DAV: valid-sync-token"`
+}
+
+// NumberOfMatchesWithinLimits is the RFC 6578 DAV:number-of-matches-within-limits
+// postcondition, reported when a sync-collection result exceeds DAV:limit.
+type NumberOfMatchesWithinLimits struct {
+	XMLName xml.Name `xml:"DAV: number-of-matches-within-limits"`
+}
+
+// CalendarDataUnsupported is the CALDAV:supported-calendar-data
+// precondition, for a calendar-data media type the server cannot produce or
+// a request body that is not iCalendar data.
+type CalendarDataUnsupported struct {
+	XMLName xml.Name `xml:"urn:ietf:params:xml:ns:caldav supported-calendar-data"`
+}
+
+// ValidFilter is the CALDAV:valid-filter precondition, for a calendar-query
+// filter that does not follow RFC 4791 section 9.7.
+type ValidFilter struct {
+	XMLName xml.Name `xml:"urn:ietf:params:xml:ns:caldav valid-filter"`
+}
+
+// SupportedFilter is the CALDAV:supported-filter precondition, for a
+// well-formed filter using a component, property or test the server cannot
+// evaluate.
+type SupportedFilter struct {
+	XMLName xml.Name `xml:"urn:ietf:params:xml:ns:caldav supported-filter"`
+}
+
+// CalendarComponentUnsupported is the CALDAV:supported-calendar-component
+// precondition, for calendar data holding no component the calendar accepts.
+type CalendarComponentUnsupported struct {
+	XMLName xml.Name `xml:"urn:ietf:params:xml:ns:caldav supported-calendar-component"`
+}
+
+// NoUIDConflict is the CALDAV:no-uid-conflict precondition; Href names the
+// resource already holding the UID.
+type NoUIDConflict struct {
+	XMLName xml.Name `xml:"urn:ietf:params:xml:ns:caldav no-uid-conflict"`
+	Href    Href     `xml:"DAV: href"`
+}
+
+// CardSupportedFilter is the CARDDAV:supported-filter precondition, for an
+// addressbook-query filter the server cannot evaluate.
+type CardSupportedFilter struct {
+	XMLName xml.Name `xml:"urn:ietf:params:xml:ns:carddav supported-filter"`
+}
+
+// NoCardUIDConflict is the CARDDAV:no-uid-conflict precondition.
+type NoCardUIDConflict struct {
+	XMLName xml.Name `xml:"urn:ietf:params:xml:ns:carddav no-uid-conflict"`
+	Href    Href     `xml:"DAV: href"`
+}
+
+// MaxInstancesExceeded is the CALDAV:max-instances precondition.
+type MaxInstancesExceeded struct {
+	XMLName xml.Name `xml:"urn:ietf:params:xml:ns:caldav max-instances"`
+}
+
+// ValidCalendarObjectResource is the CALDAV:valid-calendar-object-resource
+// precondition.
+type ValidCalendarObjectResource struct {
+	XMLName xml.Name `xml:"urn:ietf:params:xml:ns:caldav valid-calendar-object-resource"`
+}
+
+// ValidationFailed names the server-side validator that rejected a PUT and
+// why, next to the precondition it violates.
+type ValidationFailed struct {
+	XMLName   xml.Name `xml:"urn:ldap-dav validation-failed"`
+	Validator string   `xml:"validator,attr"`
+	Reason    string   `xml:",chardata"`
+}
+
+// MaxAttendeesExceeded is the CALDAV:max-attendees-per-instance precondition.
+type MaxAttendeesExceeded struct {
+	XMLName xml.Name `xml:"urn:ietf:params:xml:ns:caldav max-attendees-per-instance"`
+}
+
 type SyncLimit struct {
 	XMLName  xml.Name `xml:"DAV: limit"`
 	NResults int      `xml:"DAV: nresults"`
@@ -351,10 +473,39 @@ type CalendarFilter struct {
 }
 
 type CompFilter struct {
-	XMLName    xml.Name    `xml:"urn:ietf:params:xml:ns:caldav comp-filter"`
-	Name       string      `xml:"name,attr"`
-	CompFilter *CompFilter `xml:"urn:ietf:params:xml:ns:caldav comp-filter,omitempty"`
-	TimeRange  *TimeRange  `xml:"urn:ietf:params:xml:ns:caldav time-range,omitempty"`
+	XMLName      xml.Name        `xml:"urn:ietf:params:xml:ns:caldav comp-filter"`
+	Name         string          `xml:"name,attr"`
+	IsNotDefined *struct{}       `xml:"urn:ietf:params:xml:ns:caldav is-not-defined,omitempty"`
+	CompFilter   *CompFilter     `xml:"urn:ietf:params:xml:ns:caldav comp-filter,omitempty"`
+	TimeRange    *TimeRange      `xml:"urn:ietf:params:xml:ns:caldav time-range,omitempty"`
+	PropFilters  []CalPropFilter `xml:"urn:ietf:params:xml:ns:caldav prop-filter"`
+	// Other holds any child element not listed above.
+	Other []RawXMLValue `xml:",any"`
+}
+
+type CalPropFilter struct {
+	XMLName      xml.Name         `xml:"urn:ietf:params:xml:ns:caldav prop-filter"`
+	Name         string           `xml:"name,attr"`
+	IsNotDefined *struct{}        `xml:"urn:ietf:params:xml:ns:caldav is-not-defined,omitempty"`
+	TimeRange    *TimeRange       `xml:"urn:ietf:params:xml:ns:caldav time-range,omitempty"`
+	TextMatch    *CalTextMatch    `xml:"urn:ietf:params:xml:ns:caldav text-match,omitempty"`
+	ParamFilters []CalParamFilter `xml:"urn:ietf:params:xml:ns:caldav param-filter"`
+	Other        []RawXMLValue    `xml:",any"`
+}
+
+type CalParamFilter struct {
+	XMLName      xml.Name      `xml:"urn:ietf:params:xml:ns:caldav param-filter"`
+	Name         string        `xml:"name,attr"`
+	IsNotDefined *struct{}     `xml:"urn:ietf:params:xml:ns:caldav is-not-defined,omitempty"`
+	TextMatch    *CalTextMatch `xml:"urn:ietf:params:xml:ns:caldav text-match,omitempty"`
+	Other        []RawXMLValue `xml:",any"`
+}
+
+type CalTextMatch struct {
+	XMLName   xml.Name `xml:"urn:ietf:params:xml:ns:caldav text-match"`
+	Collation string   `xml:"collation,attr,omitempty"`
+	Negate    string   `xml:"negate-condition,attr,omitempty"` // "yes"|"no"
+	Text      string   `xml:",chardata"`
 }
 
 type TimeRange struct {
@@ -395,6 +546,16 @@ func (t *TimeText) MarshalText() ([]byte, error) {
 	return []byte(s), nil
 }
 
+// SupportedLock and LockDiscovery are the RFC 4918 section 15 lock
+// properties. The server implements no locking, so both are always empty.
+type SupportedLock struct {
+	XMLName xml.Name `xml:"DAV: supportedlock"`
+}
+
+type LockDiscovery struct {
+	XMLName xml.Name `xml:"DAV: lockdiscovery"`
+}
+
 type GetLastModified struct {
 	XMLName      xml.Name `xml:"DAV: getlastmodified"`
 	LastModified TimeText `xml:",chardata"`
@@ -446,6 +607,12 @@ type AddressbookQuery struct {
 	XMLName xml.Name          `xml:"urn:ietf:params:xml:ns:carddav addressbook-query"`
 	Prop    PropContainer     `xml:"DAV: prop"`
 	Filter  AddressbookFilter `xml:"urn:ietf:params:xml:ns:carddav filter,omitempty"`
+	Limit   *CardDAVLimit     `xml:"urn:ietf:params:xml:ns:carddav limit,omitempty"`
+}
+
+type CardDAVLimit struct {
+	XMLName  xml.Name `xml:"urn:ietf:params:xml:ns:carddav limit"`
+	NResults int      `xml:"urn:ietf:params:xml:ns:carddav nresults"`
 }
 
 type AddressbookMultiget struct {
@@ -456,20 +623,24 @@ type AddressbookMultiget struct {
 
 type AddressbookFilter struct {
 	XMLName     xml.Name     `xml:"urn:ietf:params:xml:ns:carddav filter"`
+	Test        string       `xml:"test,attr,omitempty"` // "anyof" (default) | "allof"
 	PropFilters []PropFilter `xml:"prop-filter"`
 }
 
 type PropFilter struct {
 	XMLName      xml.Name      `xml:"urn:ietf:params:xml:ns:carddav prop-filter"`
 	Name         string        `xml:"name,attr"`
-	TextMatch    *TextMatch    `xml:"text-match,omitempty"`
+	Test         string        `xml:"test,attr,omitempty"` // "anyof" (default) | "allof"
+	IsNotDefined *struct{}     `xml:"is-not-defined,omitempty"`
+	TextMatches  []TextMatch   `xml:"text-match"`
 	ParamFilters []ParamFilter `xml:"param-filter"`
 }
 
 type ParamFilter struct {
-	XMLName   xml.Name   `xml:"urn:ietf:params:xml:ns:carddav param-filter"`
-	Name      string     `xml:"name,attr"`
-	TextMatch *TextMatch `xml:"text-match,omitempty"`
+	XMLName      xml.Name   `xml:"urn:ietf:params:xml:ns:carddav param-filter"`
+	Name         string     `xml:"name,attr"`
+	IsNotDefined *struct{}  `xml:"is-not-defined,omitempty"`
+	TextMatch    *TextMatch `xml:"text-match,omitempty"`
 }
 
 type TextMatch struct {
@@ -2,11 +2,12 @@ package common
 
 import (
 	"encoding/xml"
-	"strconv"
+	"net/http"
 	"strings"
 	"time"
 
 	"github.com/sonroyaalmerol/ldap-dav/pkg/ical"
+	"github.com/sonroyaalmerol/ldap-dav/pkg/vcard"
 )
 
 func ParseICalTime(s string) (time.Time, error) {
@@ -81,6 +82,24 @@ func SafeCollectionName(s string) bool {
 	return true
 }
 
+// NoOverwrite reports whether the request forbids replacing an existing
+// resource, via If-None-Match: * or the WebDAV Overwrite: F header.
+func NoOverwrite(r *http.Request) bool {
+	if strings.TrimSpace(r.Header.Get("If-None-Match")) == "*" {
+		return true
+	}
+	return strings.EqualFold(strings.TrimSpace(r.Header.Get("Overwrite")), "F")
+}
+
+// BlindOverwrite reports whether a request replaces a resource without
+// naming the version it expects or explicitly asking to overwrite it.
+func BlindOverwrite(r *http.Request) bool {
+	if r.Header.Get("If-Match") != "" || r.Header.Get("If-Schedule-Tag-Match") != "" {
+		return false
+	}
+	return !strings.EqualFold(strings.TrimSpace(r.Header.Get("Overwrite")), "T")
+}
+
 func StrPtr(s string) *string { return &s }
 func IntPtr(i int) *int       { return &i }
 
@@ -104,24 +123,28 @@ func ExtractTimeRange(f CalendarFilter) *TimeRange {
 	return nil
 }
 
+// ExtractPropFilterNames lists the properties every contact matching f must
+// define, so storage can narrow the candidates before the filter is
+// evaluated. It is empty unless f requires all of its prop-filters to hold.
 func ExtractPropFilterNames(f AddressbookFilter) []string {
+	if !strings.EqualFold(f.Test, "allof") {
+		return nil
+	}
 	seen := map[string]struct{}{}
 	var out []string
-
-	var visitProp func(p PropFilter)
-	visitProp = func(p PropFilter) {
-		if p.Name != "" {
-			if _, ok := seen[p.Name]; !ok {
-				seen[p.Name] = struct{}{}
-				out = append(out, p.Name)
-			}
-		}
-	}
-
 	for _, p := range f.PropFilters {
-		visitProp(p)
+		if p.IsNotDefined != nil {
+			continue
+		}
+		name := vcard.PropFilterProperty(p.Name)
+		if name == "" {
+			continue
+		}
+		if _, ok := seen[name]; !ok {
+			seen[name] = struct{}{}
+			out = append(out, name)
+		}
 	}
-
 	return out
 }
 
@@ -133,7 +156,7 @@ func ExtractComponentFilterNames(f CalendarFilter) []string {
 			switch strings.ToUpper(c.Name) {
 			case "VCALENDAR":
 				// skip; descend
-			case "VEVENT", "VTODO", "VJOURNAL":
+			case "VEVENT", "VTODO", "VJOURNAL", "VFREEBUSY":
 				names = append(names, strings.ToUpper(c.Name))
 			}
 		}
@@ -144,6 +167,7 @@ func ExtractComponentFilterNames(f CalendarFilter) []string {
 
 func ParsePropRequest(container PropContainer) PropRequest {
 	var req PropRequest
+	names := make(map[xml.Name]bool)
 
 	for _, raw := range container.Raw {
 		if raw.tok == nil {
@@ -152,42 +176,159 @@ func ParsePropRequest(container PropContainer) PropRequest {
 
 		switch startEl := raw.tok.(type) {
 		case xml.StartElement:
+			names[startEl.Name] = true
 			switch {
 			case startEl.Name.Space == "DAV:" && startEl.Name.Local == "getetag":
 				req.GetETag = true
 			case startEl.Name.Space == "urn:ietf:params:xml:ns:caldav" && startEl.Name.Local == "calendar-data":
 				req.CalendarData = true
+				req.Expand = findExpand(raw.children)
+				req.Comp = findComp(raw.children)
+				if ct, v := xmlAttr(startEl, "content-type"), xmlAttr(startEl, "version"); ct != "" || v != "" {
+					req.CalDataType = &SupportedCalData{ContentType: ct, Version: v}
+				}
 			case startEl.Name.Space == "urn:ietf:params:xml:ns:carddav" && startEl.Name.Local == "address-data":
 				req.AddressData = true
 			}
 		}
 	}
 
+	if len(names) > 0 {
+		req.Selection = &PropSelection{names: names}
+	}
+
 	if !req.GetETag && !req.CalendarData && !req.AddressData {
 		return PropRequest{
 			GetETag:      true,
 			CalendarData: true,
 			AddressData:  true,
+			Selection:    req.Selection,
 		}
 	}
 
 	return req
 }
 
-func ParseSeqToken(tok string) (int64, bool) {
-	tok = strings.TrimSpace(tok)
-	if strings.HasPrefix(tok, "seq:") {
-		v := strings.TrimPrefix(tok, "seq:")
-		if v == "" {
-			return 0, false
+func findExpand(children []RawXMLValue) *TimeRange {
+	for _, child := range children {
+		el, ok := child.tok.(xml.StartElement)
+		if !ok || el.Name.Space != NSCalDAV || el.Name.Local != "expand" {
+			continue
+		}
+		tr := &TimeRange{}
+		for _, attr := range el.Attr {
+			switch attr.Name.Local {
+			case "start":
+				tr.Start = attr.Value
+			case "end":
+				tr.End = attr.Value
+			}
 		}
-		n, err := strconv.ParseInt(v, 10, 64)
-		if err != nil {
-			return 0, false
+		return tr
+	}
+	return nil
+}
+
+// findComp parses the CALDAV:comp element of a calendar-data request, if
+// any (RFC 4791 section 9.6.1).
+func findComp(children []RawXMLValue) *ical.CompSelection {
+	for _, child := range children {
+		el, ok := child.tok.(xml.StartElement)
+		if !ok || el.Name.Space != NSCalDAV || el.Name.Local != "comp" {
+			continue
+		}
+		sel := parseComp(el, child.children)
+		return &sel
+	}
+	return nil
+}
+
+func parseComp(el xml.StartElement, children []RawXMLValue) ical.CompSelection {
+	sel := ical.CompSelection{Name: xmlAttr(el, "name")}
+	for _, child := range children {
+		cel, ok := child.tok.(xml.StartElement)
+		if !ok || cel.Name.Space != NSCalDAV {
+			continue
+		}
+		switch cel.Name.Local {
+		case "allprop":
+			sel.AllProps = true
+		case "prop":
+			sel.Props = append(sel.Props, xmlAttr(cel, "name"))
+		case "allcomp":
+			sel.AllComps = true
+		case "comp":
+			sel.Comps = append(sel.Comps, parseComp(cel, child.children))
+		}
+	}
+	return sel
+}
+
+func xmlAttr(el xml.StartElement, local string) string {
+	for _, attr := range el.Attr {
+		if attr.Name.Local == local {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+// ValidateSyncCollection checks how a sync-collection REPORT conveys its
+// scope. RFC 6578 clients send DAV:sync-level (with Depth: 0); older clients
+// omit it and send Depth: 1 instead. Anything else is rejected with 400.
+func ValidateSyncCollection(depth string, sc SyncCollection) *HTTPError {
+	switch depth {
+	case "", "0", "1", "infinity":
+	default:
+		return HTTPErrorf(http.StatusBadRequest, "invalid Depth header %q", depth)
+	}
+	switch strings.TrimSpace(sc.SyncLevel) {
+	case "1", "infinite":
+		return nil
+	case "":
+		if depth != "1" {
+			return HTTPErrorf(http.StatusBadRequest, "sync-collection requires Depth: 1 or DAV:sync-level")
+		}
+		return nil
+	default:
+		return HTTPErrorf(http.StatusBadRequest, "invalid sync-level %q", sc.SyncLevel)
+	}
+}
+
+// ValidateDeleteDepth checks the Depth header of a DELETE. RFC 4918 9.6.1
+// requires collections to be deleted as if Depth: infinity; an object has
+// no members, so only Depth: 0 or infinity make sense for it.
+func ValidateDeleteDepth(depth string, collection bool) *HTTPError {
+	switch depth {
+	case "", "infinity":
+		return nil
+	case "0":
+		if collection {
+			return HTTPErrorf(http.StatusBadRequest, "DELETE of a collection requires Depth: infinity")
+		}
+		return nil
+	default:
+		return HTTPErrorf(http.StatusBadRequest, "invalid Depth header %q for DELETE", depth)
+	}
+}
+
+// PropfindDepth resolves the Depth header of a PROPFIND. A missing header
+// means infinity (RFC 4918 section 9.1). No resource here lies deeper than
+// a member of a collection, so infinity is answered as Depth: 1 unless
+// rejectInfinity is set, in which case a 403 is returned for the caller to
+// serve with DAV:propfind-finite-depth.
+func PropfindDepth(header string, rejectInfinity bool) (string, *HTTPError) {
+	switch header {
+	case "0", "1":
+		return header, nil
+	case "", "infinity":
+		if rejectInfinity {
+			return "", HTTPErrorf(http.StatusForbidden, "PROPFIND with Depth: infinity is not supported")
 		}
-		return n, true
+		return "1", nil
+	default:
+		return "", HTTPErrorf(http.StatusBadRequest, "invalid Depth header %q for PROPFIND", header)
 	}
-	return 0, false
 }
 
 func BuildFreeBusyICS(start, end time.Time, busyIntervals []ical.Interval, prodID string) []byte {
@@ -2,14 +2,40 @@ package dav
 
 import (
 	"net/http"
+	"strings"
+
+	"github.com/sonroyaalmerol/ldap-dav/internal/dav/common"
 )
 
+// notImplementedMethods are WebDAV methods the server recognizes but does
+// not implement; they get 501 rather than 405.
+var notImplementedMethods = map[string]bool{
+	"COPY":   true,
+	"MOVE":   true,
+	"LOCK":   true,
+	"UNLOCK": true,
+	"ACL":    true,
+	"SEARCH": true,
+	"BIND":   true,
+	"UNBIND": true,
+	"REBIND": true,
+}
+
+func IsNotImplementedMethod(method string) bool {
+	return notImplementedMethods[method]
+}
+
 func (h *Handlers) HandleWellKnown(w http.ResponseWriter, r *http.Request) {
 	// Redirect to base path per RFC 6764
-	http.Redirect(w, r, h.basePath+"/", http.StatusPermanentRedirect)
+	http.Redirect(w, r, common.AbsoluteURL(r, h.basePath+"/"), http.StatusPermanentRedirect)
 }
 
 func (h *Handlers) HandleOptions(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Allow", "OPTIONS, PROPFIND, REPORT, GET, PUT, DELETE, MKCOL, MKCALENDAR, PROPPATCH, HEAD")
+	w.Header().Set("Allow", h.AllowedMethods(r.URL.Path))
 	w.WriteHeader(http.StatusOK)
 }
+
+// AllowedMethods returns the Allow header value for the resource at urlPath.
+func (h *Handlers) AllowedMethods(urlPath string) string {
+	return strings.Join(common.AllowedMethods(h.basePath, h.cfg.HTTP.PrincipalLayout, h.cfg.CalDAV.SchedulingCollections, urlPath), ", ")
+}
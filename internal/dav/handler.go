@@ -6,8 +6,10 @@ import (
 	"github.com/sonroyaalmerol/ldap-dav/internal/config"
 	"github.com/sonroyaalmerol/ldap-dav/internal/dav/caldav"
 	"github.com/sonroyaalmerol/ldap-dav/internal/dav/carddav"
+	"github.com/sonroyaalmerol/ldap-dav/internal/dav/common"
 	"github.com/sonroyaalmerol/ldap-dav/internal/directory"
 	"github.com/sonroyaalmerol/ldap-dav/internal/storage"
+	"github.com/sonroyaalmerol/ldap-dav/internal/webhook"
 
 	"github.com/rs/zerolog"
 )
@@ -28,7 +30,11 @@ type Handlers struct {
 var _ ResourceHandler = (*caldav.CalDAVResourceHandler)(nil)
 var _ ResourceHandler = (*carddav.CardDAVResourceHandler)(nil)
 
-func NewHandlers(cfg *config.Config, store storage.Store, dir directory.Directory, authn *auth.Chain, logger zerolog.Logger) *Handlers {
+func NewHandlers(cfg *config.Config, store storage.Store, dir directory.Directory, authn *auth.Chain, readOnly common.ReadOnly, tokens *common.SyncTokens, notifier *webhook.Notifier, logger zerolog.Logger) *Handlers {
+	if cfg.HTTP.SyncTokenFormat != common.SyncTokenFormatSeq && len(cfg.HTTP.SyncTokenSecret) == 0 {
+		logger.Warn().Msg("HTTP_SYNC_TOKEN_SECRET is not set: opaque sync-tokens can be forged")
+	}
+
 	h := &Handlers{
 		cfg:              cfg,
 		store:            store,
@@ -38,8 +44,8 @@ func NewHandlers(cfg *config.Config, store storage.Store, dir directory.Director
 		logger:           logger,
 		basePath:         cfg.HTTP.BasePath,
 		resourceHandlers: make(map[string]ResourceHandler),
-		CalDAVHandlers:   *caldav.NewHandlers(cfg, store, dir, logger),
-		CardDAVHandlers:  *carddav.NewHandlers(cfg, store, dir, logger),
+		CalDAVHandlers:   *caldav.NewHandlers(cfg, store, dir, readOnly, tokens, notifier, logger),
+		CardDAVHandlers:  *carddav.NewHandlers(cfg, store, dir, readOnly, tokens, logger),
 	}
 
 	h.RegisterResourceHandler("calendars", caldav.NewCalDAVResourceHandler(&h.CalDAVHandlers, h.basePath))
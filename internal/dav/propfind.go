@@ -2,7 +2,7 @@ package dav
 
 import (
 	"encoding/xml"
-	"io"
+	"errors"
 	"net/http"
 	"path"
 	"strings"
@@ -30,18 +30,46 @@ func (h Handlers) isPrincipalPath(p string) bool {
 }
 
 func (h *Handlers) HandlePropfind(w http.ResponseWriter, r *http.Request) {
-	depth := r.Header.Get("Depth")
-	if depth == "" {
-		depth = "0"
+	depth, herr := common.PropfindDepth(r.Header.Get("Depth"), h.cfg.HTTP.PropfindInfinity == "reject")
+	if herr != nil {
+		h.logger.Debug().Ctx(r.Context()).Err(herr).Str("path", r.URL.Path).Msg("rejecting PROPFIND")
+		if herr.Code == http.StatusForbidden {
+			_ = common.ServeError(w, herr.Code, common.PropfindFiniteDepth{})
+		} else {
+			http.Error(w, herr.Error(), herr.Code)
+		}
+		return
+	}
+
+	maxBody := h.cfg.HTTP.MaxPropfindBytes
+	if common.ExceedsLimit(r, maxBody) {
+		h.logger.Debug().Ctx(r.Context()).
+			Int64("content_length", r.ContentLength).
+			Int64("limit", maxBody).
+			Msg("PROPFIND body too large")
+		common.ServeTooLarge(w, maxBody)
+		return
 	}
 
-	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	body, err := common.ReadBody(r, maxBody)
+	if errors.Is(err, common.ErrBodyTooLarge) {
+		h.logger.Debug().Ctx(r.Context()).Int64("limit", maxBody).Msg("PROPFIND body too large")
+		common.ServeTooLarge(w, maxBody)
+		return
+	}
 	if err != nil {
-		h.logger.Error().Err(err).Msg("failed to read PROPFIND body")
+		h.logger.Error().Ctx(r.Context()).Err(err).Msg("failed to read PROPFIND body")
 		http.Error(w, "bad request", http.StatusBadRequest)
 		return
 	}
-	_ = r.Body.Close()
+
+	sel, err := common.ParsePropfind(body)
+	if err != nil {
+		h.logger.Debug().Ctx(r.Context()).Err(err).Msg("malformed PROPFIND body")
+		http.Error(w, "bad xml", http.StatusBadRequest)
+		return
+	}
+	r = r.WithContext(common.WithPropSelection(r.Context(), sel))
 
 	if h.isPrincipalPath(r.URL.Path) {
 		h.propfindPrincipal(w, r, depth, body)
@@ -55,6 +83,12 @@ func (h *Handlers) HandlePropfind(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if strings.Trim(strings.TrimPrefix(r.URL.Path, h.basePath), "/") != "" {
+		h.logger.Debug().Ctx(r.Context()).Str("path", r.URL.Path).Msg("PROPFIND on unknown path")
+		http.NotFound(w, r)
+		return
+	}
+
 	h.propfindRoot(w, r, body)
 }
 
@@ -71,17 +105,26 @@ func (h *Handlers) propfindResource(w http.ResponseWriter, r *http.Request, dept
 		handler.PropfindObject(w, r, owner, collection, path.Base(r.URL.Path))
 		return
 	}
+
+	h.logger.Debug().Ctx(r.Context()).Str("path", r.URL.Path).Msg("PROPFIND on unresolvable resource path")
+	http.NotFound(w, r)
 }
 
 func (h *Handlers) propfindPrincipal(w http.ResponseWriter, r *http.Request, _ string, _ []byte) {
 	u, _ := common.CurrentUser(r.Context())
 	if u == nil {
-		h.logger.Error().Msg("unauthorized principal PROPFIND request")
+		h.logger.Error().Ctx(r.Context()).Msg("unauthorized principal PROPFIND request")
 		http.Error(w, "unauthorized", http.StatusUnauthorized)
 		return
 	}
 
-	self := common.PrincipalURL(h.basePath, u.UID)
+	if _, ok := common.ParsePrincipalPath(h.basePath, h.cfg.HTTP.PrincipalLayout, r.URL.Path); !ok {
+		h.logger.Debug().Ctx(r.Context()).Str("path", r.URL.Path).Msg("PROPFIND on principal path outside configured layout")
+		http.NotFound(w, r)
+		return
+	}
+
+	self := common.PrincipalURL(h.basePath, h.cfg.HTTP.PrincipalLayout, u.UID)
 
 	resp := common.Response{
 		Hrefs: []common.Href{{Value: self}},
@@ -91,31 +134,71 @@ func (h *Handlers) propfindPrincipal(w http.ResponseWriter, r *http.Request, _ s
 		Collection: nil,
 		Principal:  &struct{}{},
 	}); err != nil {
-		h.logger.Error().Err(err).Msg("failed to encode ResourceType property")
+		h.logger.Error().Ctx(r.Context()).Err(err).Msg("failed to encode ResourceType property")
 	}
-	if err := resp.EncodeProp(http.StatusOK, common.DisplayName{Name: u.DisplayName}); err != nil {
-		h.logger.Error().Err(err).Msg("failed to encode DisplayName property")
+	if err := resp.EncodeProp(http.StatusOK, common.SupportedMethodSetFor(h.basePath, h.cfg.HTTP.PrincipalLayout, h.cfg.CalDAV.SchedulingCollections, self)); err != nil {
+		h.logger.Error().Ctx(r.Context()).Err(err).Msg("failed to encode supported-method-set property")
+	}
+	displayName := common.StoredDisplayName(r.Context(), h.store, common.PrincipalResourceID(u.UID), u.DisplayName)
+	if err := resp.EncodeProp(http.StatusOK, common.DisplayName{Name: displayName}); err != nil {
+		h.logger.Error().Ctx(r.Context()).Err(err).Msg("failed to encode DisplayName property")
 	}
 	if err := resp.EncodeProp(http.StatusOK, common.CurrentUserPrincipal{Href: &common.Href{Value: self}}); err != nil {
-		h.logger.Error().Err(err).Msg("failed to encode CurrentUserPrincipal property")
+		h.logger.Error().Ctx(r.Context()).Err(err).Msg("failed to encode CurrentUserPrincipal property")
+	}
+	if err := resp.EncodeProp(http.StatusOK, common.PrincipalCollectionSetFor(h.basePath)); err != nil {
+		h.logger.Error().Ctx(r.Context()).Err(err).Msg("failed to encode PrincipalCollectionSet property")
 	}
 	if err := resp.EncodeProp(http.StatusOK, common.CalendarHomeSet{Hrefs: []common.Href{{Value: common.CalendarHome(h.basePath, u.UID)}}}); err != nil {
-		h.logger.Error().Err(err).Msg("failed to encode CalendarHomeSet property")
+		h.logger.Error().Ctx(r.Context()).Err(err).Msg("failed to encode CalendarHomeSet property")
 	}
 	if err := resp.EncodeProp(http.StatusOK, common.AddressBookHomeSet{Hrefs: []common.Href{{Value: common.AddressbookHome(h.basePath, u.UID)}}}); err != nil {
-		h.logger.Error().Err(err).Msg("failed to encode AddressbookHomeSet property")
+		h.logger.Error().Ctx(r.Context()).Err(err).Msg("failed to encode AddressbookHomeSet property")
 	}
 	if err := resp.EncodeProp(http.StatusOK, struct {
 		XMLName xml.Name `xml:"DAV: principal-URL"`
 		Href    common.Href
 	}{Href: common.Href{Value: self}}); err != nil {
-		h.logger.Error().Err(err).Msg("failed to encode principal-URL property")
+		h.logger.Error().Ctx(r.Context()).Err(err).Msg("failed to encode principal-URL property")
+	}
+	if common.PropSelectionFrom(r.Context()).Wants(common.NSCalDAV, "calendar-user-address-set") {
+		if err := resp.EncodeProp(http.StatusOK, h.calendarUserAddressSet(r, u.UID, self)); err != nil {
+			h.logger.Error().Ctx(r.Context()).Err(err).Msg("failed to encode calendar-user-address-set property")
+		}
+	}
+	if h.cfg.CalDAV.SchedulingCollections {
+		if err := resp.EncodeProp(http.StatusOK, common.ScheduleInboxURL{Href: common.Href{Value: common.ScheduleInboxPath(h.basePath, u.UID)}}); err != nil {
+			h.logger.Error().Ctx(r.Context()).Err(err).Msg("failed to encode schedule-inbox-URL property")
+		}
+		if err := resp.EncodeProp(http.StatusOK, common.ScheduleOutboxURL{Href: common.Href{Value: common.ScheduleOutboxPath(h.basePath, u.UID)}}); err != nil {
+			h.logger.Error().Ctx(r.Context()).Err(err).Msg("failed to encode schedule-outbox-URL property")
+		}
 	}
 
 	ms := common.NewMultiStatus(resp)
-	if err := common.ServeMultiStatus(w, ms); err != nil {
-		h.logger.Error().Err(err).Msg("failed to serve MultiStatus for principal")
+	if err := common.ServePropfind(w, r, ms); err != nil {
+		h.logger.Error().Ctx(r.Context()).Err(err).Msg("failed to serve MultiStatus for principal")
+	}
+}
+
+// calendarUserAddressSet lists every mail address of the user, aliases
+// included, so clients recognize events organized via any of them.
+func (h *Handlers) calendarUserAddressSet(r *http.Request, uid, self string) common.CalendarUserAddressSet {
+	set := common.CalendarUserAddressSet{}
+	du, err := h.dir.LookupUserByAttr(r.Context(), h.cfg.LDAP.TokenUserAttr, uid)
+	if err != nil || du == nil {
+		h.logger.Debug().Ctx(r.Context()).Err(err).Str("user", uid).Msg("failed to resolve user addresses")
+	} else {
+		addrs := du.Addresses
+		if len(addrs) == 0 && du.Mail != "" {
+			addrs = []string{du.Mail}
+		}
+		for _, a := range addrs {
+			set.Hrefs = append(set.Hrefs, common.Href{Value: "mailto:" + a})
+		}
 	}
+	set.Hrefs = append(set.Hrefs, common.Href{Value: self})
+	return set
 }
 
 func (h *Handlers) propfindRoot(w http.ResponseWriter, r *http.Request, _ []byte) {
@@ -126,28 +209,29 @@ func (h *Handlers) propfindRoot(w http.ResponseWriter, r *http.Request, _ []byte
 	if err := resp.EncodeProp(http.StatusOK, common.ResourceType{
 		Collection: &struct{}{},
 	}); err != nil {
-		h.logger.Error().Err(err).Msg("failed to encode ResourceType for root")
+		h.logger.Error().Ctx(r.Context()).Err(err).Msg("failed to encode ResourceType for root")
+	}
+	if err := resp.EncodeProp(http.StatusOK, common.SupportedMethodSetFor(h.basePath, h.cfg.HTTP.PrincipalLayout, h.cfg.CalDAV.SchedulingCollections, root)); err != nil {
+		h.logger.Error().Ctx(r.Context()).Err(err).Msg("failed to encode supported-method-set for root")
 	}
 	if err := resp.EncodeProp(http.StatusOK, common.CurrentUserPrincipal{
-		Href: &common.Href{Value: common.CurrentUserPrincipalHref(r.Context(), h.basePath)},
+		Href: &common.Href{Value: common.CurrentUserPrincipalHref(r.Context(), h.basePath, h.cfg.HTTP.PrincipalLayout)},
 	}); err != nil {
-		h.logger.Error().Err(err).Msg("failed to encode CurrentUserPrincipal for root")
+		h.logger.Error().Ctx(r.Context()).Err(err).Msg("failed to encode CurrentUserPrincipal for root")
 	}
 	if err := resp.EncodeProp(http.StatusOK, struct {
 		XMLName xml.Name `xml:"DAV: principal-URL"`
 		Href    common.Href
-	}{Href: common.Href{Value: common.CurrentUserPrincipalHref(r.Context(), h.basePath)}}); err != nil {
-		h.logger.Error().Err(err).Msg("failed to encode principal-URL for root")
+	}{Href: common.Href{Value: common.CurrentUserPrincipalHref(r.Context(), h.basePath, h.cfg.HTTP.PrincipalLayout)}}); err != nil {
+		h.logger.Error().Ctx(r.Context()).Err(err).Msg("failed to encode principal-URL for root")
 	}
-	if err := resp.EncodeProp(http.StatusOK, common.PrincipalCollectionSet{
-		Hrefs: []common.Href{{Value: common.JoinURL(h.basePath, "principals") + "/"}},
-	}); err != nil {
-		h.logger.Error().Err(err).Msg("failed to encode PrincipalCollectionSet for root")
+	if err := resp.EncodeProp(http.StatusOK, common.PrincipalCollectionSetFor(h.basePath)); err != nil {
+		h.logger.Error().Ctx(r.Context()).Err(err).Msg("failed to encode PrincipalCollectionSet for root")
 	}
 
 	ms := common.NewMultiStatus(resp)
-	if err := common.ServeMultiStatus(w, ms); err != nil {
-		h.logger.Error().Err(err).Msg("failed to serve MultiStatus for root")
+	if err := common.ServePropfind(w, r, ms); err != nil {
+		h.logger.Error().Ctx(r.Context()).Err(err).Msg("failed to serve MultiStatus for root")
 	}
 }
 
@@ -2,11 +2,9 @@ package directory
 
 import (
 	"context"
-	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"net"
 	"net/http"
 	"slices"
 	"strings"
@@ -68,21 +66,23 @@ func (l *LDAPClient) Close() {
 func (l *LDAPClient) BindUser(ctx context.Context, username, password string) (*User, error) {
 	searchReq := ldap.NewSearchRequest(
 		l.cfg.UserBaseDN,
-		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 1, int(l.cfg.Timeout.Seconds()), false,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 1, int(l.cfg.SearchTimeout.Seconds()), false,
 		fmt.Sprintf(l.cfg.UserFilter, ldap.EscapeFilter(username), ldap.EscapeFilter(username)),
 		userAttrList(l.cfg),
 		nil,
 	)
-	res, err := l.conn.SearchWithPaging(searchReq, 1)
+	res, err := withTimeout(ctx, l.cfg.SearchTimeout, func() (*ldap.SearchResult, error) {
+		return l.conn.SearchWithPaging(searchReq, 1)
+	})
 	if err != nil {
-		l.logger.Error().Err(err).
+		l.logger.Error().Ctx(ctx).Err(err).
 			Str("user_base_dn", l.cfg.UserBaseDN).
 			Str("username", username).
 			Msg("LDAP search failed in BindUser")
 		return nil, errors.New("user not found")
 	}
 	if len(res.Entries) == 0 {
-		l.logger.Debug().Str("username", username).Msg("user not found in BindUser search")
+		l.logger.Debug().Ctx(ctx).Str("username", username).Msg("user not found in BindUser search")
 		return nil, errors.New("user not found")
 	}
 	entry := res.Entries[0]
@@ -90,12 +90,14 @@ func (l *LDAPClient) BindUser(ctx context.Context, username, password string) (*
 
 	userConn, err := dialLDAPAuto(l.cfg)
 	if err != nil {
-		l.logger.Error().Err(err).Msg("failed to dial LDAP for user bind")
+		l.logger.Error().Ctx(ctx).Err(err).Msg("failed to dial LDAP for user bind")
 		return nil, err
 	}
 	defer userConn.Close()
-	if err := userConn.Bind(userDN, password); err != nil {
-		l.logger.Debug().Err(err).Str("user_dn", userDN).Msg("user bind failed")
+	if _, err := withTimeout(ctx, l.cfg.BindTimeout, func() (struct{}, error) {
+		return struct{}{}, userConn.Bind(userDN, password)
+	}); err != nil {
+		l.logger.Debug().Ctx(ctx).Err(err).Str("user_dn", userDN).Msg("user bind failed")
 		return nil, err
 	}
 
@@ -104,6 +106,7 @@ func (l *LDAPClient) BindUser(ctx context.Context, username, password string) (*
 		DN:          userDN,
 		DisplayName: firstNonEmpty(entry.GetAttributeValue("displayName"), entry.GetAttributeValue("cn")),
 		Mail:        entry.GetAttributeValue("mail"),
+		Addresses:   userAddresses(entry),
 	}
 	return u, nil
 }
@@ -112,14 +115,14 @@ func (l *LDAPClient) LookupUserByAttr(ctx context.Context, attr, value string) (
 	attr = safeAttr(attr)
 	searchReq := ldap.NewSearchRequest(
 		l.cfg.UserBaseDN,
-		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 1, int(l.cfg.Timeout.Seconds()), false,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 1, int(l.cfg.SearchTimeout.Seconds()), false,
 		fmt.Sprintf("(%s=%s)", attr, ldap.EscapeFilter(value)),
-		[]string{"dn", "uid", "cn", "displayName", "mail"},
+		userAttrList(l.cfg),
 		nil,
 	)
-	res, err := l.conn.Search(searchReq)
+	res, err := l.search(ctx, searchReq)
 	if err != nil {
-		l.logger.Error().Err(err).
+		l.logger.Error().Ctx(ctx).Err(err).
 			Str("attr", attr).
 			Str("value", value).
 			Str("user_base_dn", l.cfg.UserBaseDN).
@@ -127,7 +130,7 @@ func (l *LDAPClient) LookupUserByAttr(ctx context.Context, attr, value string) (
 		return nil, errors.New("user not found")
 	}
 	if len(res.Entries) == 0 {
-		l.logger.Debug().Str("attr", attr).Str("value", value).Msg("user not found in LookupUserByAttr")
+		l.logger.Debug().Ctx(ctx).Str("attr", attr).Str("value", value).Msg("user not found in LookupUserByAttr")
 		return nil, errors.New("user not found")
 	}
 	e := res.Entries[0]
@@ -136,6 +139,7 @@ func (l *LDAPClient) LookupUserByAttr(ctx context.Context, attr, value string) (
 		DN:          e.DN,
 		DisplayName: firstNonEmpty(e.GetAttributeValue("displayName"), e.GetAttributeValue("cn")),
 		Mail:        e.GetAttributeValue("mail"),
+		Addresses:   userAddresses(e),
 	}, nil
 }
 
@@ -146,14 +150,14 @@ func (l *LDAPClient) UserGroupsACL(ctx context.Context, user *User) ([]GroupACL,
 	memFilter := fmt.Sprintf("(%s=%s)", safeAttr(l.cfg.MemberAttr), ldap.EscapeFilter(user.DN))
 	search := ldap.NewSearchRequest(
 		l.cfg.GroupBaseDN,
-		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, int(l.cfg.Timeout.Seconds()), false,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, int(l.cfg.SearchTimeout.Seconds()), false,
 		fmt.Sprintf("(&%s%s)", "(objectClass=groupOfNames)", memFilter),
 		attrList(l.cfg),
 		nil,
 	)
-	res, err := l.conn.Search(search)
+	res, err := l.search(ctx, search)
 	if err != nil {
-		l.logger.Error().Err(err).
+		l.logger.Error().Ctx(ctx).Err(err).
 			Str("group_base_dn", l.cfg.GroupBaseDN).
 			Str("member_attr", l.cfg.MemberAttr).
 			Str("user_dn", user.DN).
@@ -165,7 +169,7 @@ func (l *LDAPClient) UserGroupsACL(ctx context.Context, user *User) ([]GroupACL,
 		if l.cfg.BindingsAttr != "" {
 			for _, line := range e.GetAttributeValues(l.cfg.BindingsAttr) {
 				acl := parseBindingLine(line)
-				if acl.CalendarID != "" {
+				if acl.CalendarID != "" || acl.AddressbookID != "" {
 					acls = append(acls, acl)
 				}
 			}
@@ -173,7 +177,7 @@ func (l *LDAPClient) UserGroupsACL(ctx context.Context, user *User) ([]GroupACL,
 			cals := e.GetAttributeValues(l.cfg.CalendarIDsAttr)
 			privs := e.GetAttributeValues(l.cfg.PrivilegesAttr)
 			for _, cal := range cals {
-				acl := privilegesFromList(cal, privs)
+				acl := PrivilegesFromList(cal, privs)
 				acls = append(acls, acl)
 			}
 		}
@@ -185,7 +189,7 @@ func (l *LDAPClient) UserGroupsACL(ctx context.Context, user *User) ([]GroupACL,
 func (l *LDAPClient) IntrospectToken(ctx context.Context, token, url, authHeader string) (bool, string, error) {
 	req, err := http.NewRequestWithContext(ctx, "POST", url, strings.NewReader("token="+token))
 	if err != nil {
-		l.logger.Error().Err(err).Msg("failed to build introspection request")
+		l.logger.Error().Ctx(ctx).Err(err).Msg("failed to build introspection request")
 		return false, "", err
 	}
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
@@ -194,12 +198,12 @@ func (l *LDAPClient) IntrospectToken(ctx context.Context, token, url, authHeader
 	}
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		l.logger.Error().Err(err).Str("url", url).Msg("introspection HTTP request failed")
+		l.logger.Error().Ctx(ctx).Err(err).Str("url", url).Msg("introspection HTTP request failed")
 		return false, "", err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != 200 {
-		l.logger.Debug().Int("status", resp.StatusCode).Msg("token introspection not active")
+		l.logger.Debug().Ctx(ctx).Int("status", resp.StatusCode).Msg("token introspection not active")
 		return false, "", nil
 	}
 	var out struct {
@@ -207,7 +211,7 @@ func (l *LDAPClient) IntrospectToken(ctx context.Context, token, url, authHeader
 		Sub    string `json:"sub"`
 	}
 	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
-		l.logger.Error().Err(err).Msg("failed to decode introspection response")
+		l.logger.Error().Ctx(ctx).Err(err).Msg("failed to decode introspection response")
 		return false, "", err
 	}
 
@@ -215,7 +219,9 @@ func (l *LDAPClient) IntrospectToken(ctx context.Context, token, url, authHeader
 	return out.Active, username, nil
 }
 
-func privilegesFromList(calID string, privs []string) GroupACL {
+// PrivilegesFromList builds the ACL entry for calID from privilege names
+// such as read, write-content or bind.
+func PrivilegesFromList(calID string, privs []string) GroupACL {
 	m := map[string]bool{}
 	for _, p := range privs {
 		m[strings.ToLower(strings.TrimSpace(p))] = true
@@ -246,6 +252,8 @@ func parseBindingLine(s string) GroupACL {
 		switch k {
 		case "calendar-id":
 			acl.CalendarID = v
+		case "addressbook-id":
+			acl.AddressbookID = v
 		case "priv", "privileges":
 			for _, t := range strings.Split(v, ",") {
 				switch strings.ToLower(strings.TrimSpace(t)) {
@@ -272,8 +280,33 @@ func parseBindingLine(s string) GroupACL {
 	return acl
 }
 
+// userAddresses collects every mail address of an entry: all mail values
+// plus SMTP proxyAddresses (Active Directory aliases).
+func userAddresses(e *ldap.Entry) []string {
+	var out []string
+	seen := map[string]bool{}
+	add := func(addr string) {
+		addr = strings.TrimSpace(addr)
+		key := strings.ToLower(addr)
+		if addr == "" || seen[key] {
+			return
+		}
+		seen[key] = true
+		out = append(out, addr)
+	}
+	for _, m := range e.GetAttributeValues("mail") {
+		add(m)
+	}
+	for _, p := range e.GetAttributeValues("proxyAddresses") {
+		if len(p) > 5 && strings.EqualFold(p[:5], "smtp:") {
+			add(p[5:])
+		}
+	}
+	return out
+}
+
 func userAttrList(cfg config.LDAPConfig) []string {
-	attrs := []string{"dn", "displayName", "mail", "uid", "cn"}
+	attrs := []string{"dn", "displayName", "mail", "proxyAddresses", "uid", "cn"}
 	if cfg.TokenUserAttr != "" && !slices.Contains(attrs, cfg.TokenUserAttr) {
 		attrs = append(attrs, cfg.TokenUserAttr)
 	}
@@ -319,39 +352,5 @@ func dialLDAPAuto(cfg config.LDAPConfig) (*ldap.Conn, error) {
 		return nil, errors.New("URL must start with ldap:// or ldaps://")
 	}
 
-	if isLDAPS {
-		tlsConfig := &tls.Config{
-			InsecureSkipVerify: cfg.InsecureSkipVerify,
-		}
-		hostPort := strings.TrimPrefix(u, "ldaps://")
-		if host, _, err := net.SplitHostPort(hostPort); err == nil && host != "" {
-			tlsConfig.ServerName = host
-		} else {
-			tlsConfig.ServerName = hostPort
-		}
-		return ldap.DialURL(u, ldap.DialWithTLSConfig(tlsConfig))
-	}
-
-	conn, err := ldap.DialURL(u)
-	if err != nil {
-		return nil, err
-	}
-
-	if cfg.RequireTLS {
-		tlsConfig := &tls.Config{
-			InsecureSkipVerify: cfg.InsecureSkipVerify,
-		}
-		hostPort := strings.TrimPrefix(u, "ldap://")
-		if host, _, err := net.SplitHostPort(hostPort); err == nil && host != "" {
-			tlsConfig.ServerName = host
-		} else {
-			tlsConfig.ServerName = hostPort
-		}
-		if err := conn.StartTLS(tlsConfig); err != nil {
-			conn.Close()
-			return nil, fmt.Errorf("StartTLS failed: %w", err)
-		}
-	}
-
-	return conn, nil
+	return dialURL(u, isLDAPS, cfg.RequireTLS, cfg.InsecureSkipVerify, cfg.TLS, cfg.Timeout)
 }
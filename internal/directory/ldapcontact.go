@@ -2,10 +2,8 @@ package directory
 
 import (
 	"context"
-	"crypto/tls"
 	"errors"
 	"fmt"
-	"net"
 	"strings"
 	"time"
 
@@ -50,33 +48,10 @@ func dialLDAPFromFilter(f config.LDAPAddressbookFilter) (*ldap.Conn, error) {
 	if !isLDAP && !isLDAPS {
 		return nil, errors.New("URL must start with ldap:// or ldaps://")
 	}
-	if isLDAPS {
-		tlsConfig := &tls.Config{InsecureSkipVerify: f.InsecureSkipVerify}
-		hostPort := strings.TrimPrefix(u, "ldaps://")
-		if host, _, err := net.SplitHostPort(hostPort); err == nil && host != "" {
-			tlsConfig.ServerName = host
-		} else {
-			tlsConfig.ServerName = hostPort
-		}
-		return ldap.DialURL(u, ldap.DialWithTLSConfig(tlsConfig))
-	}
-	conn, err := ldap.DialURL(u)
+	conn, err := dialURL(u, isLDAPS, f.RequireTLS, f.InsecureSkipVerify, f.TLS, f.Timeout)
 	if err != nil {
 		return nil, err
 	}
-	if f.RequireTLS {
-		tlsConfig := &tls.Config{InsecureSkipVerify: f.InsecureSkipVerify}
-		hostPort := strings.TrimPrefix(u, "ldap://")
-		if host, _, err := net.SplitHostPort(hostPort); err == nil && host != "" {
-			tlsConfig.ServerName = host
-		} else {
-			tlsConfig.ServerName = hostPort
-		}
-		if err := conn.StartTLS(tlsConfig); err != nil {
-			conn.Close()
-			return nil, fmt.Errorf("StartTLS failed: %w", err)
-		}
-	}
 	if f.BindDN != "" {
 		if err := conn.Bind(f.BindDN, f.BindPassword); err != nil {
 			conn.Close()
@@ -110,9 +85,12 @@ func (c *LDAPContactClient) ListContacts(ctx context.Context) ([]Contact, error)
 		c.attrsForFilter(),
 		nil,
 	)
-	res, err := c.conn.Search(search)
+	entries, err := withTimeout(ctx, c.cfg.Timeout, func() ([]*ldap.Entry, error) {
+		entries, _, err := pagedSearch(c.conn, search, c.cfg.PageSize, 0)
+		return entries, err
+	})
 	if err != nil {
-		c.logger.Error().Err(err).
+		c.logger.Error().Ctx(ctx).Err(err).
 			Str("url", c.cfg.URL).
 			Str("base_dn", c.cfg.BaseDN).
 			Str("filter", c.cfg.Filter).
@@ -120,17 +98,112 @@ func (c *LDAPContactClient) ListContacts(ctx context.Context) ([]Contact, error)
 		return nil, err
 	}
 
-	out := make([]Contact, 0, len(res.Entries))
-	for _, e := range res.Entries {
+	out := make([]Contact, 0, len(entries))
+	for _, e := range entries {
 		out = append(out, c.mapEntry(e))
 	}
 	c.cache.Set("all", out, time.Now().Add(30*time.Second))
 	return out, nil
 }
 
+// SearchContacts lists at most limit contacts and reports whether the
+// directory held more. A non-positive limit behaves like ListContacts.
+func (c *LDAPContactClient) SearchContacts(ctx context.Context, limit int) ([]Contact, bool, error) {
+	if limit <= 0 {
+		out, err := c.ListContacts(ctx)
+		return out, false, err
+	}
+	if v, ok := c.cache.Get("all"); ok {
+		if len(v) > limit {
+			return v[:limit], true, nil
+		}
+		return v, false, nil
+	}
+	search := ldap.NewSearchRequest(
+		c.cfg.BaseDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, int(c.cfg.Timeout.Seconds()), false,
+		c.cfg.Filter,
+		c.attrsForFilter(),
+		nil,
+	)
+	type page struct {
+		entries   []*ldap.Entry
+		truncated bool
+	}
+	res, err := withTimeout(ctx, c.cfg.Timeout, func() (page, error) {
+		entries, truncated, err := pagedSearch(c.conn, search, c.cfg.PageSize, limit)
+		return page{entries, truncated}, err
+	})
+	entries, truncated := res.entries, res.truncated
+	if err != nil {
+		c.logger.Error().Ctx(ctx).Err(err).
+			Str("url", c.cfg.URL).
+			Str("base_dn", c.cfg.BaseDN).
+			Str("filter", c.cfg.Filter).
+			Int("limit", limit).
+			Msg("LDAP search failed in SearchContacts")
+		return nil, false, err
+	}
+
+	out := make([]Contact, 0, len(entries))
+	for _, e := range entries {
+		out = append(out, c.mapEntry(e))
+	}
+	return out, truncated, nil
+}
+
+// pagedSearch runs req with the simple paged results control (RFC 2696) so
+// large directories are not cut short by server size limits. A positive
+// limit stops paging once more than limit entries are in hand, abandons the
+// rest of the search and reports the result as truncated. A zero page size
+// disables paging.
+func pagedSearch(conn *ldap.Conn, req *ldap.SearchRequest, pageSize uint32, limit int) ([]*ldap.Entry, bool, error) {
+	if pageSize == 0 {
+		res, err := conn.Search(req)
+		if err != nil {
+			return nil, false, err
+		}
+		if limit > 0 && len(res.Entries) > limit {
+			return res.Entries[:limit], true, nil
+		}
+		return res.Entries, false, nil
+	}
+
+	paging := ldap.NewControlPaging(pageSize)
+	req.Controls = append(req.Controls, paging)
+
+	var entries []*ldap.Entry
+	for {
+		res, err := conn.Search(req)
+		if err != nil {
+			return nil, false, err
+		}
+		entries = append(entries, res.Entries...)
+
+		var cookie []byte
+		if ctrl, ok := ldap.FindControl(res.Controls, ldap.ControlTypePaging).(*ldap.ControlPaging); ok {
+			cookie = ctrl.Cookie
+		}
+
+		if limit > 0 && len(entries) > limit {
+			if len(cookie) > 0 {
+				paging.SetCookie(cookie)
+				paging.PagingSize = 0
+				_, _ = conn.Search(req)
+			}
+			return entries[:limit], true, nil
+		}
+		if len(cookie) == 0 {
+			return entries, false, nil
+		}
+		paging.SetCookie(cookie)
+	}
+}
+
 func (c *LDAPContactClient) attrsForFilter() []string {
 	set := map[string]struct{}{
-		"dn": {},
+		"dn":              {},
+		"modifyTimestamp": {},
 	}
 	addSlice := func(attrs []string) {
 		for _, attr := range attrs {
@@ -170,9 +243,11 @@ func (c *LDAPContactClient) GetContact(ctx context.Context, uid string) (*Contac
 			c.attrsForFilter(),
 			nil,
 		)
-		res, err := c.conn.Search(search)
+		res, err := withTimeout(ctx, c.cfg.Timeout, func() (*ldap.SearchResult, error) {
+			return c.conn.Search(search)
+		})
 		if err != nil {
-			c.logger.Error().Err(err).
+			c.logger.Error().Ctx(ctx).Err(err).
 				Str("url", c.cfg.URL).
 				Str("attr", uidAttr).
 				Str("value", uid).
@@ -237,6 +312,7 @@ func (c *LDAPContactClient) mapEntry(e *ldap.Entry) Contact {
 		Phone:        gets(c.cfg.MapPhone),
 		Organization: get(c.cfg.MapOrganization),
 		Title:        get(c.cfg.MapTitle),
+		ModifiedAt:   parseGeneralizedTime(e.GetAttributeValue("modifyTimestamp")),
 	}
 
 	contact.VCardData = c.generateVCard(contact)
@@ -288,6 +364,17 @@ func (c *LDAPContactClient) generateVCard(contact Contact) string {
 	return vcard.String()
 }
 
+// parseGeneralizedTime parses an LDAP GeneralizedTime such as
+// 20240131120000Z, returning the zero time if it can't be parsed.
+func parseGeneralizedTime(s string) time.Time {
+	for _, layout := range []string{"20060102150405Z0700", "20060102150405.999999999Z0700"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC()
+		}
+	}
+	return time.Time{}
+}
+
 func escapeVCardValue(s string) string {
 	s = strings.ReplaceAll(s, "\\", "\\\\")
 	s = strings.ReplaceAll(s, ",", "\\,")
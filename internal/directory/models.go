@@ -1,10 +1,15 @@
 package directory
 
-import "context"
+import (
+	"context"
+	"strings"
+	"time"
+)
 
 type ContactDirectory interface {
 	ListAddressbooks(ctx context.Context) ([]Addressbook, error)
 	ListContacts(ctx context.Context) ([]Contact, error)
+	SearchContacts(ctx context.Context, limit int) ([]Contact, bool, error)
 	GetContact(ctx context.Context, uid string) (*Contact, error)
 }
 
@@ -27,6 +32,7 @@ type Contact struct {
 	Organization string
 	Title        string
 	VCardData    string
+	ModifiedAt   time.Time // from modifyTimestamp; zero if unavailable
 }
 
 type User struct {
@@ -34,10 +40,33 @@ type User struct {
 	DN          string
 	DisplayName string
 	Mail        string
+	Addresses   []string // all mail addresses, including aliases
+}
+
+// HasAddress reports whether addr (optionally a mailto: URI) is one of the
+// user's mail addresses, ignoring case.
+func (u *User) HasAddress(addr string) bool {
+	addr = strings.TrimSpace(addr)
+	if len(addr) > 7 && strings.EqualFold(addr[:7], "mailto:") {
+		addr = addr[7:]
+	}
+	if addr == "" {
+		return false
+	}
+	if strings.EqualFold(addr, u.Mail) {
+		return true
+	}
+	for _, a := range u.Addresses {
+		if strings.EqualFold(addr, a) {
+			return true
+		}
+	}
+	return false
 }
 
 type GroupACL struct {
 	CalendarID                  string
+	AddressbookID               string
 	Read                        bool
 	WriteProps                  bool
 	WriteContent                bool
@@ -0,0 +1,135 @@
+package directory
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// search runs req against the directory. With referral chasing on, the
+// continuation references the server returns are followed, binding to each
+// referred server with the service account, and their entries are merged
+// into the result. The whole search, referrals included, is bounded by the
+// search timeout.
+func (l *LDAPClient) search(ctx context.Context, req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	return withTimeout(ctx, l.cfg.SearchTimeout, func() (*ldap.SearchResult, error) {
+		res, err := l.conn.Search(req)
+		if err != nil || !l.cfg.FollowReferrals || len(res.Referrals) == 0 {
+			return res, err
+		}
+		seen := make(map[string]bool)
+		res.Entries = append(res.Entries, l.chaseReferrals(ctx, req, res.Referrals, l.cfg.ReferralHops, seen)...)
+		res.Referrals = nil
+		return res, nil
+	})
+}
+
+func (l *LDAPClient) chaseReferrals(ctx context.Context, req *ldap.SearchRequest, refs []string, hops int, seen map[string]bool) []*ldap.Entry {
+	if hops <= 0 {
+		l.logger.Debug().Ctx(ctx).Strs("referrals", refs).Msg("LDAP referral hop limit reached")
+		return nil
+	}
+	var entries []*ldap.Entry
+	for _, ref := range refs {
+		if seen[ref] {
+			continue
+		}
+		seen[ref] = true
+
+		res, err := l.searchReferral(req, ref)
+		if err != nil {
+			l.logger.Warn().Ctx(ctx).Err(err).Str("referral", ref).Msg("failed to follow LDAP referral")
+			continue
+		}
+		entries = append(entries, res.Entries...)
+		if len(res.Referrals) > 0 {
+			entries = append(entries, l.chaseReferrals(ctx, req, res.Referrals, hops-1, seen)...)
+		}
+	}
+	return entries
+}
+
+// searchReferral repeats req on the server an LDAP URL refers to, using the
+// base DN the referral names, if any. Only configured referral hosts are
+// contacted, since the service account credentials are sent there.
+func (l *LDAPClient) searchReferral(req *ldap.SearchRequest, ref string) (*ldap.SearchResult, error) {
+	u, err := url.Parse(ref)
+	if err != nil {
+		return nil, err
+	}
+	scheme := strings.ToLower(u.Scheme)
+	if scheme != "ldap" && scheme != "ldaps" {
+		return nil, fmt.Errorf("unsupported referral scheme %q", u.Scheme)
+	}
+	if !l.referralHostAllowed(scheme, u.Host) {
+		return nil, fmt.Errorf("referral host %q is not in LDAP_REFERRAL_HOSTS", u.Host)
+	}
+
+	conn, err := dialURL(scheme+"://"+u.Host, scheme == "ldaps", l.cfg.RequireTLS, l.cfg.InsecureSkipVerify, l.cfg.TLS, l.cfg.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if l.cfg.BindDN != "" {
+		if err := conn.Bind(l.cfg.BindDN, l.cfg.BindPassword); err != nil {
+			return nil, err
+		}
+	}
+
+	sub := *req
+	if dn := strings.TrimPrefix(u.Path, "/"); dn != "" {
+		sub.BaseDN = dn
+	}
+	return conn.Search(&sub)
+}
+
+// referralHostAllowed reports whether host, as named by a referral with the
+// given scheme, is one of the configured referral hosts. Without any
+// configured, only the host of the main LDAP URL is allowed. An allowed
+// entry without a port matches the host on any port.
+func (l *LDAPClient) referralHostAllowed(scheme, host string) bool {
+	name, port := splitHostPort(host, scheme)
+	if name == "" {
+		return false
+	}
+	allowed := l.cfg.ReferralHosts
+	if len(allowed) == 0 {
+		u, err := url.Parse(strings.TrimSpace(l.cfg.URL))
+		if err != nil {
+			return false
+		}
+		allowed = []string{u.Host}
+	}
+	for _, a := range allowed {
+		aName, aPort := splitHostPort(a, "")
+		if !strings.EqualFold(aName, name) {
+			continue
+		}
+		if aPort == "" || aPort == port {
+			return true
+		}
+	}
+	return false
+}
+
+// splitHostPort splits host[:port], filling in the default port of scheme
+// when one is given and the port is missing.
+func splitHostPort(host, scheme string) (string, string) {
+	name, port, err := net.SplitHostPort(host)
+	if err != nil {
+		name, port = strings.Trim(host, "[]"), ""
+	}
+	if port == "" {
+		switch scheme {
+		case "ldap":
+			port = "389"
+		case "ldaps":
+			port = "636"
+		}
+	}
+	return name, port
+}
@@ -0,0 +1,154 @@
+package directory
+
+import (
+	"testing"
+
+	"github.com/sonroyaalmerol/ldap-dav/internal/config"
+)
+
+func TestReferralHostAllowed(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		allowed []string
+		scheme  string
+		host    string
+		want    bool
+	}{
+		{
+			name:    "default port matches explicit entry",
+			allowed: []string{"dc2.example.com:389"},
+			scheme:  "ldap",
+			host:    "dc2.example.com",
+			want:    true,
+		},
+		{
+			name:    "default ldaps port",
+			allowed: []string{"dc2.example.com:636"},
+			scheme:  "ldaps",
+			host:    "dc2.example.com",
+			want:    true,
+		},
+		{
+			name:    "entry without port matches any port",
+			allowed: []string{"dc2.example.com"},
+			scheme:  "ldap",
+			host:    "dc2.example.com:3389",
+			want:    true,
+		},
+		{
+			name:    "explicit port mismatch",
+			allowed: []string{"dc2.example.com:636"},
+			scheme:  "ldap",
+			host:    "dc2.example.com:389",
+			want:    false,
+		},
+		{
+			name:    "default port mismatch",
+			allowed: []string{"dc2.example.com:636"},
+			scheme:  "ldap",
+			host:    "dc2.example.com",
+			want:    false,
+		},
+		{
+			name:    "host is case-insensitive",
+			allowed: []string{"DC2.example.com"},
+			scheme:  "ldap",
+			host:    "dc2.EXAMPLE.com",
+			want:    true,
+		},
+		{
+			name:    "IPv6 with port",
+			allowed: []string{"[2001:db8::2]:389"},
+			scheme:  "ldap",
+			host:    "[2001:db8::2]:389",
+			want:    true,
+		},
+		{
+			name:    "IPv6 brackets without port",
+			allowed: []string{"[2001:db8::2]"},
+			scheme:  "ldaps",
+			host:    "[2001:db8::2]",
+			want:    true,
+		},
+		{
+			name:    "IPv6 other address",
+			allowed: []string{"[2001:db8::2]"},
+			scheme:  "ldap",
+			host:    "[2001:db8::3]",
+			want:    false,
+		},
+		{
+			name:   "LDAP_URL fallback",
+			url:    "ldaps://dc1.example.com:636",
+			scheme: "ldaps",
+			host:   "dc1.example.com",
+			want:   true,
+		},
+		{
+			name:   "LDAP_URL fallback port mismatch",
+			url:    "ldaps://dc1.example.com:636",
+			scheme: "ldap",
+			host:   "dc1.example.com",
+			want:   false,
+		},
+		{
+			name:   "LDAP_URL fallback foreign host",
+			url:    "ldap://dc1.example.com",
+			scheme: "ldap",
+			host:   "evil.example.net",
+			want:   false,
+		},
+		{
+			name:    "foreign host",
+			url:     "ldap://dc1.example.com",
+			allowed: []string{"dc2.example.com"},
+			scheme:  "ldap",
+			host:    "evil.example.net",
+			want:    false,
+		},
+		{
+			name:    "configured hosts replace LDAP_URL",
+			url:     "ldap://dc1.example.com",
+			allowed: []string{"dc2.example.com"},
+			scheme:  "ldap",
+			host:    "dc1.example.com",
+			want:    false,
+		},
+		{
+			name:    "empty host",
+			allowed: []string{"dc2.example.com"},
+			scheme:  "ldap",
+			host:    "",
+			want:    false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &LDAPClient{cfg: config.LDAPConfig{URL: tt.url, ReferralHosts: tt.allowed}}
+			if got := l.referralHostAllowed(tt.scheme, tt.host); got != tt.want {
+				t.Errorf("referralHostAllowed(%q, %q) = %v, want %v", tt.scheme, tt.host, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitHostPort(t *testing.T) {
+	tests := []struct {
+		host, scheme       string
+		wantName, wantPort string
+	}{
+		{"dc1.example.com", "ldap", "dc1.example.com", "389"},
+		{"dc1.example.com", "ldaps", "dc1.example.com", "636"},
+		{"dc1.example.com", "", "dc1.example.com", ""},
+		{"dc1.example.com:10389", "ldap", "dc1.example.com", "10389"},
+		{"[2001:db8::1]", "ldap", "2001:db8::1", "389"},
+		{"[2001:db8::1]:10636", "ldaps", "2001:db8::1", "10636"},
+	}
+	for _, tt := range tests {
+		name, port := splitHostPort(tt.host, tt.scheme)
+		if name != tt.wantName || port != tt.wantPort {
+			t.Errorf("splitHostPort(%q, %q) = %q, %q, want %q, %q", tt.host, tt.scheme, name, port, tt.wantName, tt.wantPort)
+		}
+	}
+}
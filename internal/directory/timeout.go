@@ -0,0 +1,38 @@
+package directory
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// withTimeout runs op and gives up once ctx is done or timeout has passed.
+// go-ldap only bounds the wait for each single response, so a paged search
+// or a server that stalls between messages could otherwise hold a request
+// indefinitely. An abandoned op keeps running until the connection's own
+// request timeout ends it.
+func withTimeout[T any](ctx context.Context, timeout time.Duration, op func() (T, error)) (T, error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	type result struct {
+		v   T
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		v, err := op()
+		done <- result{v, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.v, r.err
+	case <-ctx.Done():
+		var zero T
+		return zero, fmt.Errorf("LDAP operation abandoned: %w", ctx.Err())
+	}
+}
@@ -0,0 +1,91 @@
+package directory
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/sonroyaalmerol/ldap-dav/internal/config"
+)
+
+// dialURL connects to an LDAP URL. ldaps:// connections use TLS from the
+// start; plain ldap:// ones are upgraded with StartTLS when startTLS is set,
+// and the connection is dropped if the upgrade fails. timeout bounds the
+// dial and every later wait for a server response.
+func dialURL(u string, isLDAPS, startTLS, skipVerify bool, opts config.LDAPTLSConfig, timeout time.Duration) (*ldap.Conn, error) {
+	dialOpts := []ldap.DialOpt{ldap.DialWithDialer(&net.Dialer{Timeout: timeout})}
+	var tlsConfig *tls.Config
+	if isLDAPS || startTLS {
+		var err error
+		tlsConfig, err = newTLSConfig(u, skipVerify, opts)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if isLDAPS {
+		dialOpts = append(dialOpts, ldap.DialWithTLSConfig(tlsConfig))
+	}
+
+	conn, err := ldap.DialURL(u, dialOpts...)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(timeout)
+	if !isLDAPS && startTLS {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("StartTLS failed: %w", err)
+		}
+	}
+	return conn, nil
+}
+
+// newTLSConfig builds the TLS settings for an LDAP URL. A CA bundle
+// replaces the system roots, so a server certificate it does not vouch for
+// is rejected; a client certificate enables mutual TLS. Unreadable files are
+// an error rather than a silent fallback.
+func newTLSConfig(rawURL string, skipVerify bool, opts config.LDAPTLSConfig) (*tls.Config, error) {
+	hostPort := rawURL
+	if i := strings.Index(hostPort, "://"); i >= 0 {
+		hostPort = hostPort[i+3:]
+	}
+	hostPort = strings.TrimSuffix(hostPort, "/")
+
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: skipVerify,
+		MinVersion:         opts.MinVersion,
+	}
+	if host, _, err := net.SplitHostPort(hostPort); err == nil && host != "" {
+		tlsConfig.ServerName = host
+	} else {
+		tlsConfig.ServerName = hostPort
+	}
+
+	if opts.CACertFile != "" {
+		pem, err := os.ReadFile(opts.CACertFile)
+		if err != nil {
+			return nil, fmt.Errorf("read LDAP CA bundle: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("LDAP CA bundle holds no PEM certificates")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if opts.ClientCertFile != "" || opts.ClientKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(opts.ClientCertFile, opts.ClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load LDAP client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
@@ -11,16 +11,23 @@ import (
 	"github.com/sonroyaalmerol/ldap-dav/internal/auth"
 	"github.com/sonroyaalmerol/ldap-dav/internal/config"
 	"github.com/sonroyaalmerol/ldap-dav/internal/dav"
+	"github.com/sonroyaalmerol/ldap-dav/internal/dav/common"
 	"github.com/sonroyaalmerol/ldap-dav/internal/directory"
 	"github.com/sonroyaalmerol/ldap-dav/internal/router"
 	"github.com/sonroyaalmerol/ldap-dav/internal/storage"
 	"github.com/sonroyaalmerol/ldap-dav/internal/storage/postgres"
 	"github.com/sonroyaalmerol/ldap-dav/internal/storage/sqlite"
+	"github.com/sonroyaalmerol/ldap-dav/internal/webhook"
 )
 
+// webhookDrainTimeout bounds how long shutdown waits for queued webhook
+// deliveries.
+const webhookDrainTimeout = 30 * time.Second
+
 type Server struct {
-	http   *http.Server
-	logger zerolog.Logger
+	http        *http.Server
+	maintenance *router.Maintenance
+	logger      zerolog.Logger
 }
 
 func NewServer(cfg *config.Config, logger zerolog.Logger) (*Server, func(), error) {
@@ -40,6 +47,13 @@ func NewServer(cfg *config.Config, logger zerolog.Logger) (*Server, func(), erro
 		return nil, nil, err
 	}
 
+	tokens := common.NewSyncTokens(cfg.HTTP.SyncTokenFormat, cfg.HTTP.SyncTokenSecret)
+	var notifier *webhook.Notifier
+	if len(cfg.Webhook.URLs) > 0 {
+		notifier = webhook.New(cfg.Webhook, logger)
+		store = webhook.WrapStore(store, notifier, tokens)
+	}
+
 	dir, err := directory.NewLDAPClient(cfg.LDAP, logger)
 	if err != nil {
 		store.Close()
@@ -47,8 +61,9 @@ func NewServer(cfg *config.Config, logger zerolog.Logger) (*Server, func(), erro
 	}
 
 	authn := auth.NewChain(cfg, dir, logger)
-	davh := dav.NewHandlers(cfg, store, dir, authn, logger)
-	mux := router.New(cfg, davh, authn, logger)
+	maintenance := router.NewMaintenance(cfg.HTTP.ReadOnly, cfg.HTTP.RetryAfter)
+	davh := dav.NewHandlers(cfg, store, dir, authn, maintenance, tokens, notifier, logger)
+	mux := router.New(cfg, davh, authn, maintenance, logger)
 
 	srv := &Server{
 		http: &http.Server{
@@ -58,9 +73,21 @@ func NewServer(cfg *config.Config, logger zerolog.Logger) (*Server, func(), erro
 			WriteTimeout: 120 * time.Second,
 			IdleTimeout:  120 * time.Second,
 		},
-		logger: logger,
+		maintenance: maintenance,
+		logger:      logger,
+	}
+	subCtx, stopSubscriptions := context.WithCancel(context.Background())
+	if cfg.CalDAV.Subscriptions {
+		go davh.CalDAVHandlers.RunSubscriptions(subCtx)
 	}
+
 	cleanup := func() {
+		stopSubscriptions()
+		if notifier != nil {
+			drainCtx, cancel := context.WithTimeout(context.Background(), webhookDrainTimeout)
+			_ = notifier.Close(drainCtx)
+			cancel()
+		}
 		store.Close()
 		dir.Close()
 	}
@@ -72,6 +99,11 @@ func (s *Server) Start() error {
 	return s.http.ListenAndServe()
 }
 
+// ToggleMaintenance flips read-only maintenance mode and returns the new state.
+func (s *Server) ToggleMaintenance() bool {
+	return s.maintenance.Toggle()
+}
+
 func (s *Server) Shutdown(ctx context.Context) error {
 	return s.http.Shutdown(ctx)
 }
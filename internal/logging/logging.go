@@ -1,17 +1,46 @@
 package logging
 
 import (
+	"context"
 	"os"
 	"strings"
 
 	"github.com/rs/zerolog"
 )
 
+type ctxKey int
+
+const requestIDKey ctxKey = 0
+
 func New(level string) zerolog.Logger {
 	lvl, err := zerolog.ParseLevel(strings.ToLower(level))
 	if err != nil {
 		lvl = zerolog.InfoLevel
 	}
-	logger := zerolog.New(os.Stdout).With().Timestamp().Logger().Level(lvl)
+	logger := zerolog.New(os.Stdout).With().Timestamp().Logger().Level(lvl).Hook(requestIDHook{})
 	return logger
 }
+
+// WithRequestID stores the request correlation id in the context.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// RequestID returns the correlation id stored in the context, if any.
+func RequestID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// requestIDHook tags events logged with .Ctx(ctx) with the request id
+// carried by that context.
+type requestIDHook struct{}
+
+func (requestIDHook) Run(e *zerolog.Event, _ zerolog.Level, _ string) {
+	if id := RequestID(e.GetCtx()); id != "" {
+		e.Str("request_id", id)
+	}
+}
@@ -0,0 +1,116 @@
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+type capabilityLimits struct {
+	MaxICSBytes             int64 `json:"max_ics_bytes"`
+	MaxVCFBytes             int64 `json:"max_vcf_bytes"`
+	MaxReportBytes          int64 `json:"max_report_bytes"`
+	MaxPropfindBytes        int64 `json:"max_propfind_bytes"`
+	MaxProppatchBytes       int64 `json:"max_proppatch_bytes"`
+	MaxMkcolBytes           int64 `json:"max_mkcol_bytes"`
+	MaxInstances            int   `json:"max_instances"`
+	MaxAttendeesPerInstance int   `json:"max_attendees_per_instance"`
+	MaxExpandInstances      int   `json:"max_expand_instances"`
+}
+
+type capabilityCalDAV struct {
+	Components            []string `json:"components"`
+	SchedulingCollections bool     `json:"scheduling_collections"`
+	BirthdayCalendar      bool     `json:"birthday_calendar"`
+	MinDateTime           string   `json:"min_date_time"`
+	MaxDateTime           string   `json:"max_date_time"`
+}
+
+type capabilityCardDAV struct {
+	LDAPAddressbooks   int  `json:"ldap_addressbooks"`
+	RejectStaleRev     bool `json:"reject_stale_rev"`
+	ExpandGroupMembers bool `json:"expand_group_members"`
+}
+
+type capabilities struct {
+	DAV             string            `json:"dav"`
+	BasePath        string            `json:"base_path"`
+	Storage         string            `json:"storage"`
+	ReadOnly        bool              `json:"read_only"`
+	PrincipalLayout string            `json:"principal_layout"`
+	SyncTokenFormat string            `json:"sync_token_format"`
+	RequireIfMatch  bool              `json:"require_if_match"`
+	Collations      []string          `json:"collations"`
+	Limits          capabilityLimits  `json:"limits"`
+	CalDAV          capabilityCalDAV  `json:"caldav"`
+	CardDAV         capabilityCardDAV `json:"carddav"`
+}
+
+// handleCapabilities reports the effective feature set and limits as JSON,
+// so tooling can inspect a deployment without probing every path.
+func (r *Router) handleCapabilities(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	p, err := r.authenticate(req)
+	if err != nil || p == nil {
+		r.logAttempt(req, "", err)
+		w.Header().Set("WWW-Authenticate", `Basic realm="DAV", charset="UTF-8"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	cfg := r.config
+	enabledFilters := 0
+	for _, f := range cfg.LDAP.AddressbookFilters {
+		if f.Enabled {
+			enabledFilters++
+		}
+	}
+
+	caps := capabilities{
+		DAV:             r.buildDAVCapabilities(),
+		BasePath:        r.getBasePath(),
+		Storage:         cfg.Storage.Type,
+		ReadOnly:        r.maintenance.Enabled(),
+		PrincipalLayout: cfg.HTTP.PrincipalLayout,
+		SyncTokenFormat: cfg.HTTP.SyncTokenFormat,
+		RequireIfMatch:  cfg.HTTP.RequireIfMatch,
+		Collations:      []string{"i;ascii-casemap", "i;octet", "i;unicode-casemap"},
+		Limits: capabilityLimits{
+			MaxICSBytes:             cfg.HTTP.MaxICSBytes,
+			MaxVCFBytes:             cfg.HTTP.MaxVCFBytes,
+			MaxReportBytes:          cfg.HTTP.MaxReportBytes,
+			MaxPropfindBytes:        cfg.HTTP.MaxPropfindBytes,
+			MaxProppatchBytes:       cfg.HTTP.MaxProppatchBytes,
+			MaxMkcolBytes:           cfg.HTTP.MaxMkcolBytes,
+			MaxInstances:            cfg.CalDAV.MaxInstances,
+			MaxAttendeesPerInstance: cfg.CalDAV.MaxAttendees,
+			MaxExpandInstances:      cfg.CalDAV.MaxExpandInstances,
+		},
+		CalDAV: capabilityCalDAV{
+			Components:            []string{"VEVENT", "VTODO", "VJOURNAL", "VFREEBUSY"},
+			SchedulingCollections: cfg.CalDAV.SchedulingCollections,
+			BirthdayCalendar:      cfg.CalDAV.BirthdayCalendar,
+			MinDateTime:           cfg.CalDAV.MinDateTime,
+			MaxDateTime:           cfg.CalDAV.MaxDateTime,
+		},
+		CardDAV: capabilityCardDAV{
+			LDAPAddressbooks:   enabledFilters,
+			RejectStaleRev:     cfg.CardDAV.RejectStaleRev,
+			ExpandGroupMembers: cfg.CardDAV.ExpandGroupMembers,
+		},
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if req.Method == http.MethodHead {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if err := json.NewEncoder(w).Encode(caps); err != nil {
+		r.logger.Error().Ctx(req.Context()).Err(err).Msg("failed to encode capabilities")
+	}
+}
@@ -0,0 +1,48 @@
+package router
+
+import "sync"
+
+// userLimiter caps how many requests each principal may have in flight, so
+// one client opening many parallel requests cannot starve the others. A
+// nil limiter imposes no cap.
+type userLimiter struct {
+	max      int
+	mu       sync.Mutex
+	inFlight map[string]int
+}
+
+func newUserLimiter(max int) *userLimiter {
+	if max <= 0 {
+		return nil
+	}
+	return &userLimiter{max: max, inFlight: make(map[string]int)}
+}
+
+// acquire reserves a slot for uid, reporting false when uid already has
+// the maximum number of requests running.
+func (l *userLimiter) acquire(uid string) bool {
+	if l == nil {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.inFlight[uid] >= l.max {
+		return false
+	}
+	l.inFlight[uid]++
+	return true
+}
+
+// release frees a slot taken by acquire.
+func (l *userLimiter) release(uid string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.inFlight[uid] <= 1 {
+		delete(l.inFlight, uid)
+		return
+	}
+	l.inFlight[uid]--
+}
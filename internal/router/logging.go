@@ -1,11 +1,39 @@
 package router
 
 import (
-	"net"
 	"net/http"
-	"strings"
+
+	"github.com/google/uuid"
+	"github.com/sonroyaalmerol/ldap-dav/internal/logging"
 )
 
+const requestIDHeader = "X-Request-ID"
+
+// withRequestID assigns each request a correlation id, reusing a sane
+// incoming X-Request-ID, and exposes it via the context and response header.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		id := req.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = uuid.NewString()
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, req.WithContext(logging.WithRequestID(req.Context(), id)))
+	})
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
 type statusRecorder struct {
 	http.ResponseWriter
 	status      int
@@ -30,23 +58,10 @@ func (rec *statusRecorder) Write(data []byte) (int, error) {
 	return n, err
 }
 
+// realIP is the client address. Forwarded headers are only trusted once
+// withForwarded has applied them to RemoteAddr.
 func realIP(req *http.Request) string {
-	xff := req.Header.Get("X-Forwarded-For")
-	if xff != "" {
-		parts := strings.Split(xff, ",")
-		ip := strings.TrimSpace(parts[0])
-		if ip != "" {
-			return ip
-		}
-	}
-	if xr := req.Header.Get("X-Real-IP"); xr != "" {
-		return xr
-	}
-	host, _, err := net.SplitHostPort(req.RemoteAddr)
-	if err != nil {
-		return req.RemoteAddr
-	}
-	return host
+	return remoteHost(req.RemoteAddr)
 }
 
 func statusOrDefault(st int) int {
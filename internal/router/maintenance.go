@@ -0,0 +1,51 @@
+package router
+
+import (
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Maintenance is the runtime read-only switch. While enabled, mutating
+// methods are rejected with 503 and reads keep working; the DAV handlers
+// consult it too, so reads never provision anything in storage.
+type Maintenance struct {
+	enabled    atomic.Bool
+	retryAfter time.Duration
+}
+
+func NewMaintenance(enabled bool, retryAfter time.Duration) *Maintenance {
+	m := &Maintenance{retryAfter: retryAfter}
+	m.enabled.Store(enabled)
+	return m
+}
+
+func (m *Maintenance) Enabled() bool {
+	return m.enabled.Load()
+}
+
+// Toggle flips the switch and returns the new state.
+func (m *Maintenance) Toggle() bool {
+	for {
+		cur := m.enabled.Load()
+		if m.enabled.CompareAndSwap(cur, !cur) {
+			return !cur
+		}
+	}
+}
+
+func isMutatingMethod(method string) bool {
+	switch method {
+	case http.MethodPut, http.MethodDelete, http.MethodPost, "MKCOL", "MKCALENDAR", "PROPPATCH", "COPY", "MOVE":
+		return true
+	}
+	return false
+}
+
+func (m *Maintenance) serveUnavailable(w http.ResponseWriter) {
+	if m.retryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(m.retryAfter.Seconds())))
+	}
+	http.Error(w, "service in read-only maintenance mode", http.StatusServiceUnavailable)
+}
@@ -0,0 +1,86 @@
+package router
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// withForwarded applies X-Forwarded-For, X-Forwarded-Proto and
+// X-Forwarded-Host when the request comes from a trusted proxy, so that
+// handlers see the client address, scheme and host. Requests from other
+// peers are passed through untouched.
+func withForwarded(trusted []*net.IPNet, next http.Handler) http.Handler {
+	if len(trusted) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !isTrustedProxy(trusted, net.ParseIP(remoteHost(req.RemoteAddr))) {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		req = req.Clone(req.Context())
+		if ip := forwardedClientIP(trusted, req.Header.Values("X-Forwarded-For")); ip != "" {
+			req.RemoteAddr = net.JoinHostPort(ip, "0")
+		} else if xr := strings.TrimSpace(req.Header.Get("X-Real-IP")); net.ParseIP(xr) != nil {
+			req.RemoteAddr = net.JoinHostPort(xr, "0")
+		}
+		switch proto := strings.ToLower(firstForwarded(req.Header.Get("X-Forwarded-Proto"))); proto {
+		case "http", "https":
+			req.URL.Scheme = proto
+		}
+		if host := firstForwarded(req.Header.Get("X-Forwarded-Host")); host != "" {
+			req.Host = host
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// forwardedClientIP walks X-Forwarded-For from the nearest hop outwards and
+// returns the first address that is not a trusted proxy.
+func forwardedClientIP(trusted []*net.IPNet, values []string) string {
+	var hops []string
+	for _, v := range values {
+		for _, hop := range strings.Split(v, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(hops[i])
+		if ip == nil {
+			return ""
+		}
+		if !isTrustedProxy(trusted, ip) || i == 0 {
+			return ip.String()
+		}
+	}
+	return ""
+}
+
+func isTrustedProxy(trusted []*net.IPNet, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, n := range trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func firstForwarded(v string) string {
+	first, _, _ := strings.Cut(v, ",")
+	return strings.TrimSpace(first)
+}
+
+func remoteHost(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
@@ -0,0 +1,104 @@
+package router
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWithForwarded(t *testing.T) {
+	_, trustedNet, err := net.ParseCIDR("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	trusted := []*net.IPNet{trustedNet}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		xff        string
+		wantIP     string
+		wantScheme string
+		wantHost   string
+	}{
+		{
+			name:       "untrusted peer",
+			remoteAddr: "203.0.113.7:5555",
+			xff:        "198.51.100.1",
+			wantIP:     "203.0.113.7",
+			wantScheme: "",
+			wantHost:   "dav.internal",
+		},
+		{
+			name:       "trusted proxy",
+			remoteAddr: "10.0.0.2:5555",
+			xff:        "198.51.100.1",
+			wantIP:     "198.51.100.1",
+			wantScheme: "https",
+			wantHost:   "dav.example.com",
+		},
+		{
+			name:       "spoofed hop before trusted proxy",
+			remoteAddr: "10.0.0.2:5555",
+			xff:        "192.0.2.66, 198.51.100.1",
+			wantIP:     "198.51.100.1",
+			wantScheme: "https",
+			wantHost:   "dav.example.com",
+		},
+		{
+			name:       "chain of trusted proxies",
+			remoteAddr: "10.0.0.2:5555",
+			xff:        "198.51.100.1, 10.0.0.3",
+			wantIP:     "198.51.100.1",
+			wantScheme: "https",
+			wantHost:   "dav.example.com",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got *http.Request
+			h := withForwarded(trusted, http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+				got = r
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/dav/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			req.Host = "dav.internal"
+			req.Header.Set("X-Forwarded-For", tt.xff)
+			req.Header.Set("X-Forwarded-Proto", "https")
+			req.Header.Set("X-Forwarded-Host", "dav.example.com")
+			h.ServeHTTP(httptest.NewRecorder(), req)
+
+			if ip := realIP(got); ip != tt.wantIP {
+				t.Errorf("realIP = %q, want %q", ip, tt.wantIP)
+			}
+			if got.URL.Scheme != tt.wantScheme {
+				t.Errorf("scheme = %q, want %q", got.URL.Scheme, tt.wantScheme)
+			}
+			if got.Host != tt.wantHost {
+				t.Errorf("host = %q, want %q", got.Host, tt.wantHost)
+			}
+		})
+	}
+}
+
+func TestRealIPIgnoresForwardedFor(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/dav/", nil)
+	req.RemoteAddr = "203.0.113.7:5555"
+	req.Header.Set("X-Forwarded-For", "198.51.100.1")
+	req.Header.Set("X-Real-IP", "198.51.100.2")
+
+	if ip := realIP(req); ip != "203.0.113.7" {
+		t.Errorf("realIP = %q, want the peer address 203.0.113.7", ip)
+	}
+
+	// Without trusted proxies the middleware is a no-op.
+	var got *http.Request
+	withForwarded(nil, http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		got = r
+	})).ServeHTTP(httptest.NewRecorder(), req)
+	if ip := realIP(got); ip != "203.0.113.7" {
+		t.Errorf("realIP without trusted proxies = %q, want 203.0.113.7", ip)
+	}
+}
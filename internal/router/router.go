@@ -18,13 +18,15 @@ import (
 var _ DAVService = (*caldav.Handlers)(nil)
 var _ DAVService = (*carddav.Handlers)(nil)
 
-func New(cfg *config.Config, h *dav.Handlers, authn *auth.Chain, logger zerolog.Logger) http.Handler {
+func New(cfg *config.Config, h *dav.Handlers, authn *auth.Chain, maintenance *Maintenance, logger zerolog.Logger) http.Handler {
 	r := &Router{
-		config:   cfg,
-		handlers: h,
-		auth:     authn,
-		logger:   logger,
-		services: make(map[string]DAVService),
+		config:      cfg,
+		handlers:    h,
+		auth:        authn,
+		maintenance: maintenance,
+		limiter:     newUserLimiter(cfg.HTTP.MaxUserConcurrency),
+		logger:      logger,
+		services:    make(map[string]DAVService),
 	}
 
 	r.RegisterService("caldav", &h.CalDAVHandlers)
@@ -43,6 +45,7 @@ func (r *Router) setupRoutes() http.Handler {
 	r.setupWellKnownRoutes(mux)
 
 	mux.HandleFunc("/healthz", r.handleHealth)
+	mux.HandleFunc("/capabilities", r.handleCapabilities)
 
 	base := r.getBasePath()
 	mux.HandleFunc(base, r.handleDAVRequest)
@@ -52,7 +55,7 @@ func (r *Router) setupRoutes() http.Handler {
 		mux.HandleFunc(baseWithoutSlash, r.handleDAVRequest)
 	}
 
-	return mux
+	return withRequestID(withForwarded(r.config.HTTP.TrustedProxies, mux))
 }
 
 func (r *Router) setupWellKnownRoutes(mux *http.ServeMux) {
@@ -94,6 +97,18 @@ func (r *Router) handleDAVRequest(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	if !r.limiter.acquire(p.UserID) {
+		r.logger.Warn().Ctx(req.Context()).
+			Str("user", p.UserID).
+			Str("method", req.Method).
+			Str("path", req.URL.Path).
+			Msg("too many concurrent requests for user")
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "too many concurrent requests", http.StatusTooManyRequests)
+		return
+	}
+	defer r.limiter.release(p.UserID)
+
 	req = req.WithContext(auth.WithPrincipal(req.Context(), p))
 
 	r.routeDAVMethod(w, req)
@@ -132,26 +147,35 @@ func (r *Router) routeDAVMethod(w http.ResponseWriter, req *http.Request) {
 		service = r.services["caldav"]
 	}
 
-	switch req.Method {
-	case "PROPFIND":
+	switch {
+	case r.maintenance.Enabled() && isMutatingMethod(req.Method):
+		r.maintenance.serveUnavailable(rec)
+	case req.Method == "PROPFIND":
 		r.handlers.HandlePropfind(rec, req)
-	case "REPORT":
+	case req.Method == "REPORT":
 		service.HandleReport(rec, req)
-	case http.MethodGet:
+	case req.Method == http.MethodGet:
 		service.HandleGet(rec, req)
-	case http.MethodHead:
+	case req.Method == http.MethodHead:
 		service.HandleHead(rec, req)
-	case http.MethodPut:
+	case req.Method == http.MethodPut:
 		service.HandlePut(rec, req)
-	case http.MethodDelete:
+	case req.Method == http.MethodPost:
+		service.HandlePost(rec, req)
+	case req.Method == http.MethodDelete:
 		service.HandleDelete(rec, req)
-	case "MKCOL":
+	case req.Method == "MKCOL":
 		service.HandleMkcol(rec, req)
-	case "MKCALENDAR":
+	case req.Method == "MKCALENDAR":
 		service.HandleMkcalendar(rec, req)
-	case "PROPPATCH":
+	case req.Method == "PROPPATCH":
 		service.HandleProppatch(rec, req)
 	default:
+		if dav.IsNotImplementedMethod(req.Method) {
+			http.Error(rec, "not implemented", http.StatusNotImplemented)
+			break
+		}
+		rec.Header().Set("Allow", r.handlers.AllowedMethods(req.URL.Path))
 		http.Error(rec, "method not allowed", http.StatusMethodNotAllowed)
 	}
 
@@ -160,9 +184,9 @@ func (r *Router) routeDAVMethod(w http.ResponseWriter, req *http.Request) {
 	var logEvent *zerolog.Event
 	switch req.Method {
 	case "PROPFIND", "REPORT", http.MethodGet, http.MethodHead:
-		logEvent = r.logger.Debug()
+		logEvent = r.logger.Debug().Ctx(req.Context())
 	default:
-		logEvent = r.logger.Info()
+		logEvent = r.logger.Info().Ctx(req.Context())
 	}
 
 	logEntry := logEvent.
@@ -231,7 +255,7 @@ func (r *Router) logAttempt(req *http.Request, username string, authErr error) {
 		authType = strings.ToLower(authz[:i])
 	}
 
-	logEvent := r.logger.Info().
+	logEvent := r.logger.Info().Ctx(req.Context()).
 		Bool("auth_success", false).
 		Str("user", username).
 		Str("method", req.Method).
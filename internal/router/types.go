@@ -14,6 +14,7 @@ type DAVService interface {
 	HandleGet(w http.ResponseWriter, r *http.Request)
 	HandleHead(w http.ResponseWriter, r *http.Request)
 	HandlePut(w http.ResponseWriter, r *http.Request)
+	HandlePost(w http.ResponseWriter, r *http.Request)
 	HandleDelete(w http.ResponseWriter, r *http.Request)
 	HandleMkcol(w http.ResponseWriter, r *http.Request)
 	HandleMkcalendar(w http.ResponseWriter, r *http.Request)
@@ -22,10 +23,12 @@ type DAVService interface {
 }
 
 type Router struct {
-	config   *config.Config
-	handlers *dav.Handlers
-	auth     *auth.Chain
-	logger   zerolog.Logger
+	config      *config.Config
+	handlers    *dav.Handlers
+	auth        *auth.Chain
+	maintenance *Maintenance
+	limiter     *userLimiter
+	logger      zerolog.Logger
 
 	services map[string]DAVService
 }
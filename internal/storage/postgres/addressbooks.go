@@ -3,6 +3,7 @@ package postgres
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/sonroyaalmerol/ldap-dav/internal/storage"
 )
@@ -14,18 +15,30 @@ func (s *Store) CreateAddressbook(a storage.Addressbook, ownerGroup string, desc
 	if a.CTag == "" {
 		a.CTag = randID()
 	}
+	if a.CreatedAt.IsZero() {
+		a.CreatedAt = time.Now().UTC()
+	}
+	if a.UpdatedAt.IsZero() {
+		a.UpdatedAt = a.CreatedAt
+	}
 
 	_, err := s.pool.Exec(context.Background(), `
 		insert into addressbooks (
-			id, owner_user_id, owner_group, uri, display_name, description, ctag
+			id, owner_user_id, owner_group, uri, display_name, description, ctag, created_at, updated_at
 		) values (
-			$1::uuid, $2, $3, $4, $5, $6, $7
+			$1::uuid, $2, $3, $4, $5, $6, $7, $8, $9
 		)
-	`, a.ID, a.OwnerUserID, ownerGroup, a.URI, a.DisplayName, description, a.CTag)
+	`, a.ID, a.OwnerUserID, ownerGroup, a.URI, a.DisplayName, description, a.CTag, a.CreatedAt, a.UpdatedAt)
 	return err
 }
 
 func (s *Store) DeleteAddressbook(ownerUserID, abURI string) error {
+	if _, err := s.pool.Exec(context.Background(), `
+		delete from dead_properties
+		where resource_id in (select id::text from addressbooks where owner_user_id = $1 and uri = $2)
+	`, ownerUserID, abURI); err != nil {
+		return err
+	}
 	_, err := s.pool.Exec(context.Background(), `
 		delete from addressbooks where owner_user_id = $1 and uri = $2
 	`, ownerUserID, abURI)
@@ -108,17 +121,21 @@ func (s *Store) PutContact(ctx context.Context, c *storage.Contact) error {
 	if c.ETag == "" {
 		c.ETag = randID()
 	}
+	updated := c.UpdatedAt
+	if updated.IsZero() {
+		updated = time.Now().UTC()
+	}
 	_, err := s.pool.Exec(ctx, `
 		insert into contacts (
-			id, addressbook_id, uid, etag, data
+			id, addressbook_id, uid, etag, data, updated_at
 		) values (
-			$1::uuid, $2::uuid, $3, $4, $5
+			$1::uuid, $2::uuid, $3, $4, $5, $6
 		)
 		on conflict (addressbook_id, uid) do update set
 			etag = excluded.etag,
 			data = excluded.data,
 			updated_at = now()
-	`, c.ID, c.AddressbookID, c.UID, c.ETag, c.Data)
+	`, c.ID, c.AddressbookID, c.UID, c.ETag, c.Data, updated)
 	return err
 }
 
@@ -260,7 +277,8 @@ func (s *Store) RecordAddressbookChange(ctx context.Context, addressbookID, uid
 	err = tx.QueryRow(ctx, `
 		update addressbooks
 		set sync_seq = sync_seq + 1,
-		    sync_token = 'seq:' || (sync_seq + 1)
+		    sync_token = 'seq:' || (sync_seq + 1),
+		    ctag = 'seq:' || (sync_seq + 1)
 		where id::text = $1
 		returning sync_seq, sync_token
 	`, addressbookID).Scan(&newSeq, new(string)) // temporary placeholder
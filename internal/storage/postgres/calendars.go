@@ -37,7 +37,16 @@ func (s *Store) CreateCalendar(c storage.Calendar, ownerGroup string, descriptio
 	if ctag == "" {
 		ctag = uuid.New().String()
 	}
-	now := time.Now().UTC()
+	// Timestamps given by the caller are kept, so copies made by
+	// ldap-dav-migrate carry the source ones.
+	created := c.CreatedAt
+	if created.IsZero() {
+		created = time.Now().UTC()
+	}
+	updated := c.UpdatedAt
+	if updated.IsZero() {
+		updated = created
+	}
 
 	grp := ownerGroup
 	if grp == "" {
@@ -48,20 +57,37 @@ func (s *Store) CreateCalendar(c storage.Calendar, ownerGroup string, descriptio
 		desc = c.Description
 	}
 
-	_, err := s.pool.Exec(ctx, `
+	cmdTag, err := s.pool.Exec(ctx, `
         insert into calendars (
           id, owner_user_id, owner_group, uri, display_name, description, color,
+          default_alarm_vevent_datetime, default_alarm_vevent_date,
           ctag, created_at, updated_at, sync_seq, sync_token
         ) values (
           $1::uuid, $2, $3, $4, $5, $6, $7,
-          $8, $9, $9, 0, 'seq:0'
+          $8, $9,
+          $10, $11, $12, 0, 'seq:0'
         )
-    `, id, ownerUser, grp, uri, displayName, desc, color, ctag, now)
-	return err
+        on conflict (owner_user_id, uri) do nothing
+    `, id, ownerUser, grp, uri, displayName, desc, color,
+		c.DefaultAlarmVEventDateTime, c.DefaultAlarmVEventDate,
+		ctag, created, updated)
+	if err != nil {
+		return err
+	}
+	if cmdTag.RowsAffected() == 0 {
+		return storage.ErrExists
+	}
+	return nil
 }
 
 func (s *Store) DeleteCalendar(ownerUserID, calURI string) error {
 	ctx := context.Background()
+	if _, err := s.pool.Exec(ctx, `
+		delete from dead_properties
+		where resource_id in (select id::text from calendars where owner_user_id = $1 and uri = $2)
+	`, ownerUserID, calURI); err != nil {
+		return err
+	}
 	cmdTag, err := s.pool.Exec(ctx, `
 		delete from calendars
 		where owner_user_id = $1 and uri = $2
@@ -77,10 +103,10 @@ func (s *Store) DeleteCalendar(ownerUserID, calURI string) error {
 
 func (s *Store) GetCalendarByURI(ctx context.Context, uri string) (*storage.Calendar, error) {
 	row := s.pool.QueryRow(ctx, `
-        select id::text, owner_user_id, owner_group, uri, display_name, description, color, ctag, created_at, updated_at
+        select id::text, owner_user_id, owner_group, uri, display_name, description, color, default_alarm_vevent_datetime, default_alarm_vevent_date, ctag, created_at, updated_at
         from calendars where uri = $1`, uri)
 	var c storage.Calendar
-	if err := row.Scan(&c.ID, &c.OwnerUserID, &c.OwnerGroup, &c.URI, &c.DisplayName, &c.Description, &c.Color, &c.CTag, &c.CreatedAt, &c.UpdatedAt); err != nil {
+	if err := row.Scan(&c.ID, &c.OwnerUserID, &c.OwnerGroup, &c.URI, &c.DisplayName, &c.Description, &c.Color, &c.DefaultAlarmVEventDateTime, &c.DefaultAlarmVEventDate, &c.CTag, &c.CreatedAt, &c.UpdatedAt); err != nil {
 		return nil, err
 	}
 	return &c, nil
@@ -97,7 +123,7 @@ func (s *Store) UpdateCalendarDisplayName(ctx context.Context, ownerUID, calURI
 
 func (s *Store) ListCalendarsByOwnerUser(ctx context.Context, uid string) ([]*storage.Calendar, error) {
 	rows, err := s.pool.Query(ctx, `
-        select id::text, owner_user_id, owner_group, uri, display_name, description, color, ctag, created_at, updated_at
+        select id::text, owner_user_id, owner_group, uri, display_name, description, color, default_alarm_vevent_datetime, default_alarm_vevent_date, ctag, created_at, updated_at
         from calendars where owner_user_id = $1`, uid)
 	if err != nil {
 		return nil, err
@@ -106,7 +132,7 @@ func (s *Store) ListCalendarsByOwnerUser(ctx context.Context, uid string) ([]*st
 	var out []*storage.Calendar
 	for rows.Next() {
 		var c storage.Calendar
-		if err := rows.Scan(&c.ID, &c.OwnerUserID, &c.OwnerGroup, &c.URI, &c.DisplayName, &c.Description, &c.Color, &c.CTag, &c.CreatedAt, &c.UpdatedAt); err != nil {
+		if err := rows.Scan(&c.ID, &c.OwnerUserID, &c.OwnerGroup, &c.URI, &c.DisplayName, &c.Description, &c.Color, &c.DefaultAlarmVEventDateTime, &c.DefaultAlarmVEventDate, &c.CTag, &c.CreatedAt, &c.UpdatedAt); err != nil {
 			return nil, err
 		}
 		out = append(out, &c)
@@ -116,7 +142,7 @@ func (s *Store) ListCalendarsByOwnerUser(ctx context.Context, uid string) ([]*st
 
 func (s *Store) ListAllCalendars(ctx context.Context) ([]*storage.Calendar, error) {
 	rows, err := s.pool.Query(ctx, `
-        select id::text, owner_user_id, owner_group, uri, display_name, description, color, ctag, created_at, updated_at
+        select id::text, owner_user_id, owner_group, uri, display_name, description, color, default_alarm_vevent_datetime, default_alarm_vevent_date, ctag, created_at, updated_at
         from calendars`)
 	if err != nil {
 		return nil, err
@@ -125,7 +151,7 @@ func (s *Store) ListAllCalendars(ctx context.Context) ([]*storage.Calendar, erro
 	var out []*storage.Calendar
 	for rows.Next() {
 		var c storage.Calendar
-		if err := rows.Scan(&c.ID, &c.OwnerUserID, &c.OwnerGroup, &c.URI, &c.DisplayName, &c.Description, &c.Color, &c.CTag, &c.CreatedAt, &c.UpdatedAt); err != nil {
+		if err := rows.Scan(&c.ID, &c.OwnerUserID, &c.OwnerGroup, &c.URI, &c.DisplayName, &c.Description, &c.Color, &c.DefaultAlarmVEventDateTime, &c.DefaultAlarmVEventDate, &c.CTag, &c.CreatedAt, &c.UpdatedAt); err != nil {
 			return nil, err
 		}
 		out = append(out, &c)
@@ -142,12 +168,25 @@ func (s *Store) UpdateCalendarColor(ctx context.Context, ownerUID, calURI, color
 	return err
 }
 
+func (s *Store) UpdateCalendarDefaultAlarm(ctx context.Context, ownerUID, calURI string, allDay bool, alarm string) error {
+	column := "default_alarm_vevent_datetime"
+	if allDay {
+		column = "default_alarm_vevent_date"
+	}
+	_, err := s.pool.Exec(ctx, `
+        update calendars
+        set `+column+` = $1, updated_at = now()
+        where owner_user_id = $2 and uri = $3
+    `, alarm, ownerUID, calURI)
+	return err
+}
+
 func (s *Store) GetObject(ctx context.Context, calendarID, uid string) (*storage.Object, error) {
 	row := s.pool.QueryRow(ctx, `
-		select id::text, calendar_id::text, uid, etag, data, component, start_at, end_at, updated_at
+		select id::text, calendar_id::text, uid, etag, schedule_tag, data, component, start_at, end_at, has_recurrence, updated_at
 		from calendar_objects where calendar_id::text = $1 and uid = $2`, calendarID, uid)
 	var o storage.Object
-	if err := row.Scan(&o.ID, &o.CalendarID, &o.UID, &o.ETag, &o.Data, &o.Component, &o.StartAt, &o.EndAt, &o.UpdatedAt); err != nil {
+	if err := row.Scan(&o.ID, &o.CalendarID, &o.UID, &o.ETag, &o.ScheduleTag, &o.Data, &o.Component, &o.StartAt, &o.EndAt, &o.HasRecurrence, &o.UpdatedAt); err != nil {
 		return nil, err
 	}
 	return &o, nil
@@ -160,20 +199,26 @@ func (s *Store) PutObject(ctx context.Context, obj *storage.Object) error {
 	if obj.ETag == "" {
 		obj.ETag = randID()
 	}
+	updated := obj.UpdatedAt
+	if updated.IsZero() {
+		updated = time.Now().UTC()
+	}
 	_, err := s.pool.Exec(ctx, `
 		insert into calendar_objects (
-			id, calendar_id, uid, etag, data, component, start_at, end_at
+			id, calendar_id, uid, etag, schedule_tag, data, component, start_at, end_at, has_recurrence, updated_at
 		) values (
-			$1::uuid, $2::uuid, $3, $4, $5, $6, $7, $8
+			$1::uuid, $2::uuid, $3, $4, $5, $6, $7, $8, $9, $10, $11
 		)
 		on conflict (calendar_id, uid) do update set
 			etag = excluded.etag,
+			schedule_tag = excluded.schedule_tag,
 			data = excluded.data,
 			component = excluded.component,
 			start_at = excluded.start_at,
 			end_at = excluded.end_at,
+			has_recurrence = excluded.has_recurrence,
 			updated_at = now()
-	`, obj.ID, obj.CalendarID, obj.UID, obj.ETag, obj.Data, obj.Component, obj.StartAt, obj.EndAt)
+	`, obj.ID, obj.CalendarID, obj.UID, obj.ETag, obj.ScheduleTag, obj.Data, obj.Component, obj.StartAt, obj.EndAt, obj.HasRecurrence, updated)
 	return err
 }
 
@@ -192,12 +237,12 @@ func (s *Store) DeleteObject(ctx context.Context, calendarID, uid, etag string)
 
 func (s *Store) ListObjects(ctx context.Context, calendarID string, start *time.Time, end *time.Time) ([]*storage.Object, error) {
 	q := `
-		select id::text, calendar_id::text, uid, etag, data, component, start_at, end_at, updated_at
+		select id::text, calendar_id::text, uid, etag, schedule_tag, data, component, start_at, end_at, has_recurrence, updated_at
 		from calendar_objects
 		where calendar_id::text = $1`
 	args := []any{calendarID}
 	if start != nil {
-		q += " and (start_at is null or end_at >= $2)"
+		q += " and (has_recurrence or start_at is null or end_at >= $2)"
 		args = append(args, *start)
 		if end != nil {
 			q += " and (end_at is null or start_at <= $3)"
@@ -217,7 +262,7 @@ func (s *Store) ListObjects(ctx context.Context, calendarID string, start *time.
 	var out []*storage.Object
 	for rows.Next() {
 		var o storage.Object
-		if err := rows.Scan(&o.ID, &o.CalendarID, &o.UID, &o.ETag, &o.Data, &o.Component, &o.StartAt, &o.EndAt, &o.UpdatedAt); err != nil {
+		if err := rows.Scan(&o.ID, &o.CalendarID, &o.UID, &o.ETag, &o.ScheduleTag, &o.Data, &o.Component, &o.StartAt, &o.EndAt, &o.HasRecurrence, &o.UpdatedAt); err != nil {
 			return nil, err
 		}
 		out = append(out, &o)
@@ -238,8 +283,18 @@ func randID() string {
 }
 
 func (s *Store) ListObjectsByComponent(ctx context.Context, calendarID string, components []string, start *time.Time, end *time.Time) ([]*storage.Object, error) {
+	return s.listObjectsByComponent(ctx, "data", calendarID, components, start, end)
+}
+
+func (s *Store) ListObjectMetadataByComponent(ctx context.Context, calendarID string, components []string, start *time.Time, end *time.Time) ([]*storage.Object, error) {
+	return s.listObjectsByComponent(ctx, "''", calendarID, components, start, end)
+}
+
+// listObjectsByComponent selects dataCol as the object body, so metadata
+// listings can skip reading it while still reporting its size.
+func (s *Store) listObjectsByComponent(ctx context.Context, dataCol, calendarID string, components []string, start *time.Time, end *time.Time) ([]*storage.Object, error) {
 	q := `
-		select id::text, calendar_id::text, uid, etag, data, component, start_at, end_at, updated_at
+		select id::text, calendar_id::text, uid, etag, schedule_tag, ` + dataCol + `, octet_length(data), component, start_at, end_at, has_recurrence, updated_at
 		from calendar_objects
 		where calendar_id::text = $1`
 	args := []any{calendarID}
@@ -250,7 +305,7 @@ func (s *Store) ListObjectsByComponent(ctx context.Context, calendarID string, c
 	}
 	argPos := len(args) + 1
 	if start != nil {
-		q += " and (start_at is null or end_at >= $" + strconv.FormatInt(int64(argPos), 10) + ")"
+		q += " and (has_recurrence or start_at is null or end_at >= $" + strconv.FormatInt(int64(argPos), 10) + ")"
 		args = append(args, *start)
 		argPos++
 	}
@@ -268,7 +323,7 @@ func (s *Store) ListObjectsByComponent(ctx context.Context, calendarID string, c
 	var out []*storage.Object
 	for rows.Next() {
 		var o storage.Object
-		if err := rows.Scan(&o.ID, &o.CalendarID, &o.UID, &o.ETag, &o.Data, &o.Component, &o.StartAt, &o.EndAt, &o.UpdatedAt); err != nil {
+		if err := rows.Scan(&o.ID, &o.CalendarID, &o.UID, &o.ETag, &o.ScheduleTag, &o.Data, &o.Size, &o.Component, &o.StartAt, &o.EndAt, &o.HasRecurrence, &o.UpdatedAt); err != nil {
 			return nil, err
 		}
 		out = append(out, &o)
@@ -343,7 +398,8 @@ func (s *Store) RecordChange(ctx context.Context, calendarID, uid string, delete
 	err = tx.QueryRow(ctx, `
 		update calendars
 		set sync_seq = sync_seq + 1,
-		    sync_token = 'seq:' || (sync_seq + 1)
+		    sync_token = 'seq:' || (sync_seq + 1),
+		    ctag = 'seq:' || (sync_seq + 1)
 		where id::text = $1
 		returning sync_seq, sync_token
 	`, calendarID).Scan(&newSeq, new(string)) // temporary placeholder
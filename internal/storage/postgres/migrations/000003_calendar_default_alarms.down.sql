@@ -0,0 +1,2 @@
+alter table calendars drop column if exists default_alarm_vevent_date;
+alter table calendars drop column if exists default_alarm_vevent_datetime;
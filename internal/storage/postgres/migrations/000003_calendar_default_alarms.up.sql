@@ -0,0 +1,5 @@
+alter table calendars
+  add column if not exists default_alarm_vevent_datetime text not null default '';
+
+alter table calendars
+  add column if not exists default_alarm_vevent_date text not null default '';
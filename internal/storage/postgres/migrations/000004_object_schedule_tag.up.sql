@@ -0,0 +1,2 @@
+alter table calendar_objects
+  add column if not exists schedule_tag text not null default '';
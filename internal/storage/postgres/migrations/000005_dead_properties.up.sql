@@ -0,0 +1,7 @@
+create table if not exists dead_properties (
+  resource_id text not null,
+  namespace text not null,
+  name text not null,
+  value text not null,
+  primary key (resource_id, namespace, name)
+);
@@ -0,0 +1 @@
+alter table calendar_objects drop column if exists has_recurrence;
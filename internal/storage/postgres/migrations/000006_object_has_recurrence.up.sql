@@ -0,0 +1,2 @@
+alter table calendar_objects
+  add column if not exists has_recurrence boolean not null default false;
@@ -0,0 +1,85 @@
+package postgres
+
+import (
+	"context"
+
+	"github.com/sonroyaalmerol/ldap-dav/internal/storage"
+)
+
+func (s *Store) ListDeadProperties(ctx context.Context, resourceID string) ([]storage.DeadProperty, error) {
+	rows, err := s.pool.Query(ctx, `
+        select resource_id, namespace, name, value
+        from dead_properties where resource_id = $1
+        order by namespace, name`, resourceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []storage.DeadProperty
+	for rows.Next() {
+		var p storage.DeadProperty
+		if err := rows.Scan(&p.ResourceID, &p.Space, &p.Local, &p.Value); err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+func (s *Store) ListAllDeadProperties(ctx context.Context) ([]storage.DeadProperty, error) {
+	rows, err := s.pool.Query(ctx, `
+        select resource_id, namespace, name, value
+        from dead_properties
+        order by resource_id, namespace, name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []storage.DeadProperty
+	for rows.Next() {
+		var p storage.DeadProperty
+		if err := rows.Scan(&p.ResourceID, &p.Space, &p.Local, &p.Value); err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+func (s *Store) ListResourcesWithDeadProperty(ctx context.Context, space, local string) ([]string, error) {
+	rows, err := s.pool.Query(ctx, `
+        select resource_id
+        from dead_properties
+        where namespace = $1 and name = $2
+        order by resource_id`, space, local)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		out = append(out, id)
+	}
+	return out, rows.Err()
+}
+
+func (s *Store) SetDeadProperty(ctx context.Context, p storage.DeadProperty) error {
+	_, err := s.pool.Exec(ctx, `
+        insert into dead_properties (resource_id, namespace, name, value)
+        values ($1, $2, $3, $4)
+        on conflict (resource_id, namespace, name) do update set value = excluded.value
+    `, p.ResourceID, p.Space, p.Local, p.Value)
+	return err
+}
+
+func (s *Store) RemoveDeadProperty(ctx context.Context, resourceID, space, local string) error {
+	_, err := s.pool.Exec(ctx, `
+        delete from dead_properties
+        where resource_id = $1 and namespace = $2 and name = $3
+    `, resourceID, space, local)
+	return err
+}
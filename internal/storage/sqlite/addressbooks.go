@@ -3,6 +3,7 @@ package sqlite
 import (
 	"context"
 	"database/sql"
+	"time"
 
 	_ "github.com/ncruces/go-sqlite3/driver"
 	_ "github.com/ncruces/go-sqlite3/embed"
@@ -18,19 +19,31 @@ func (s *Store) CreateAddressbook(a storage.Addressbook, ownerGroup string, desc
 		if a.CTag == "" {
 			a.CTag = randID()
 		}
+		if a.CreatedAt.IsZero() {
+			a.CreatedAt = time.Now().UTC()
+		}
+		if a.UpdatedAt.IsZero() {
+			a.UpdatedAt = a.CreatedAt
+		}
 
 		_, err := tx.Exec(`
 			INSERT INTO addressbooks (
-				id, owner_user_id, owner_group, uri, display_name, description, ctag
+				id, owner_user_id, owner_group, uri, display_name, description, ctag, created_at, updated_at
 			) VALUES (
-				?, ?, ?, ?, ?, ?, ?
+				?, ?, ?, ?, ?, ?, ?, ?, ?
 			)
-		`, a.ID, a.OwnerUserID, ownerGroup, a.URI, a.DisplayName, description, a.CTag)
+		`, a.ID, a.OwnerUserID, ownerGroup, a.URI, a.DisplayName, description, a.CTag, a.CreatedAt, a.UpdatedAt)
 		return err
 	})
 }
 
 func (s *Store) DeleteAddressbook(ownerUserID, abURI string) error {
+	if _, err := s.db.ExecContext(context.Background(), `
+		DELETE FROM dead_properties
+		WHERE resource_id IN (SELECT id FROM addressbooks WHERE owner_user_id = ? AND uri = ?)
+	`, ownerUserID, abURI); err != nil {
+		return err
+	}
 	_, err := s.db.ExecContext(context.Background(), `
 		DELETE FROM addressbooks WHERE owner_user_id = ? AND uri = ?
 	`, ownerUserID, abURI)
@@ -114,17 +127,21 @@ func (s *Store) PutContact(ctx context.Context, c *storage.Contact) error {
 		if c.ETag == "" {
 			c.ETag = randID()
 		}
+		updated := c.UpdatedAt
+		if updated.IsZero() {
+			updated = time.Now().UTC()
+		}
 		_, err := tx.Exec(`
 			INSERT INTO contacts (
-				id, addressbook_id, uid, etag, data
+				id, addressbook_id, uid, etag, data, updated_at
 			) VALUES (
-				?, ?, ?, ?, ?
+				?, ?, ?, ?, ?, ?
 			)
 			ON CONFLICT (addressbook_id, uid) DO UPDATE SET
 				etag = excluded.etag,
 				data = excluded.data,
 				updated_at = datetime('now')
-		`, c.ID, c.AddressbookID, c.UID, c.ETag, c.Data)
+		`, c.ID, c.AddressbookID, c.UID, c.ETag, c.Data, updated)
 		return err
 	})
 }
@@ -255,7 +272,8 @@ func (s *Store) RecordAddressbookChange(ctx context.Context, addressbookID, uid
 		err := tx.QueryRow(`
 			UPDATE addressbooks
 			SET sync_seq = sync_seq + 1,
-				sync_token = 'seq:' || (sync_seq + 1)
+				sync_token = 'seq:' || (sync_seq + 1),
+				ctag = 'seq:' || (sync_seq + 1)
 			WHERE id = ?
 			RETURNING sync_seq, sync_token
 		`, addressbookID).Scan(&newSeq, &newToken)
@@ -37,7 +37,16 @@ func (s *Store) CreateCalendar(c storage.Calendar, ownerGroup string, descriptio
 		if ctag == "" {
 			ctag = uuid.New().String()
 		}
-		now := time.Now().UTC()
+		// Timestamps given by the caller are kept, so copies made by
+		// ldap-dav-migrate carry the source ones.
+		created := c.CreatedAt
+		if created.IsZero() {
+			created = time.Now().UTC()
+		}
+		updated := c.UpdatedAt
+		if updated.IsZero() {
+			updated = created
+		}
 
 		grp := ownerGroup
 		if grp == "" {
@@ -48,21 +57,38 @@ func (s *Store) CreateCalendar(c storage.Calendar, ownerGroup string, descriptio
 			desc = c.Description
 		}
 
-		_, err := tx.Exec(`
+		res, err := tx.Exec(`
 			INSERT INTO calendars (
 				id, owner_user_id, owner_group, uri, display_name, description, color,
+				default_alarm_vevent_datetime, default_alarm_vevent_date,
 				ctag, created_at, updated_at, sync_seq, sync_token
 			) VALUES (
 				?, ?, ?, ?, ?, ?, ?,
+				?, ?,
 				?, ?, ?, 0, 'seq:0'
 			)
-		`, id, ownerUser, grp, uri, displayName, desc, color, ctag, now, now)
-		return err
+			ON CONFLICT(owner_user_id, uri) DO NOTHING
+		`, id, ownerUser, grp, uri, displayName, desc, color,
+			c.DefaultAlarmVEventDateTime, c.DefaultAlarmVEventDate,
+			ctag, created, updated)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err == nil && n == 0 {
+			return storage.ErrExists
+		}
+		return nil
 	})
 }
 
 func (s *Store) DeleteCalendar(ownerUserID, calURI string) error {
 	ctx := context.Background()
+	if _, err := s.db.ExecContext(ctx, `
+		DELETE FROM dead_properties
+		WHERE resource_id IN (SELECT id FROM calendars WHERE owner_user_id = ? AND uri = ?)
+	`, ownerUserID, calURI); err != nil {
+		return err
+	}
 	result, err := s.db.ExecContext(ctx, `
 		DELETE FROM calendars
 		WHERE owner_user_id = ? AND uri = ?
@@ -82,10 +108,10 @@ func (s *Store) DeleteCalendar(ownerUserID, calURI string) error {
 
 func (s *Store) GetCalendarByURI(ctx context.Context, uri string) (*storage.Calendar, error) {
 	row := s.db.QueryRowContext(ctx, `
-        SELECT id, owner_user_id, owner_group, uri, display_name, description, color, ctag, created_at, updated_at
+        SELECT id, owner_user_id, owner_group, uri, display_name, description, color, default_alarm_vevent_datetime, default_alarm_vevent_date, ctag, created_at, updated_at
         FROM calendars WHERE uri = ?`, uri)
 	var c storage.Calendar
-	if err := row.Scan(&c.ID, &c.OwnerUserID, &c.OwnerGroup, &c.URI, &c.DisplayName, &c.Description, &c.Color, &c.CTag, &c.CreatedAt, &c.UpdatedAt); err != nil {
+	if err := row.Scan(&c.ID, &c.OwnerUserID, &c.OwnerGroup, &c.URI, &c.DisplayName, &c.Description, &c.Color, &c.DefaultAlarmVEventDateTime, &c.DefaultAlarmVEventDate, &c.CTag, &c.CreatedAt, &c.UpdatedAt); err != nil {
 		return nil, err
 	}
 	return &c, nil
@@ -102,7 +128,7 @@ func (s *Store) UpdateCalendarDisplayName(ctx context.Context, ownerUID, calURI
 
 func (s *Store) ListCalendarsByOwnerUser(ctx context.Context, uid string) ([]*storage.Calendar, error) {
 	rows, err := s.db.QueryContext(ctx, `
-        SELECT id, owner_user_id, owner_group, uri, display_name, description, color, ctag, created_at, updated_at
+        SELECT id, owner_user_id, owner_group, uri, display_name, description, color, default_alarm_vevent_datetime, default_alarm_vevent_date, ctag, created_at, updated_at
         FROM calendars WHERE owner_user_id = ?`, uid)
 	if err != nil {
 		return nil, err
@@ -111,7 +137,7 @@ func (s *Store) ListCalendarsByOwnerUser(ctx context.Context, uid string) ([]*st
 	var out []*storage.Calendar
 	for rows.Next() {
 		var c storage.Calendar
-		if err := rows.Scan(&c.ID, &c.OwnerUserID, &c.OwnerGroup, &c.URI, &c.DisplayName, &c.Description, &c.Color, &c.CTag, &c.CreatedAt, &c.UpdatedAt); err != nil {
+		if err := rows.Scan(&c.ID, &c.OwnerUserID, &c.OwnerGroup, &c.URI, &c.DisplayName, &c.Description, &c.Color, &c.DefaultAlarmVEventDateTime, &c.DefaultAlarmVEventDate, &c.CTag, &c.CreatedAt, &c.UpdatedAt); err != nil {
 			return nil, err
 		}
 		out = append(out, &c)
@@ -121,7 +147,7 @@ func (s *Store) ListCalendarsByOwnerUser(ctx context.Context, uid string) ([]*st
 
 func (s *Store) ListAllCalendars(ctx context.Context) ([]*storage.Calendar, error) {
 	rows, err := s.db.QueryContext(ctx, `
-        SELECT id, owner_user_id, owner_group, uri, display_name, description, color, ctag, created_at, updated_at
+        SELECT id, owner_user_id, owner_group, uri, display_name, description, color, default_alarm_vevent_datetime, default_alarm_vevent_date, ctag, created_at, updated_at
         FROM calendars`)
 	if err != nil {
 		return nil, err
@@ -130,7 +156,7 @@ func (s *Store) ListAllCalendars(ctx context.Context) ([]*storage.Calendar, erro
 	var out []*storage.Calendar
 	for rows.Next() {
 		var c storage.Calendar
-		if err := rows.Scan(&c.ID, &c.OwnerUserID, &c.OwnerGroup, &c.URI, &c.DisplayName, &c.Description, &c.Color, &c.CTag, &c.CreatedAt, &c.UpdatedAt); err != nil {
+		if err := rows.Scan(&c.ID, &c.OwnerUserID, &c.OwnerGroup, &c.URI, &c.DisplayName, &c.Description, &c.Color, &c.DefaultAlarmVEventDateTime, &c.DefaultAlarmVEventDate, &c.CTag, &c.CreatedAt, &c.UpdatedAt); err != nil {
 			return nil, err
 		}
 		out = append(out, &c)
@@ -147,12 +173,25 @@ func (s *Store) UpdateCalendarColor(ctx context.Context, ownerUID, calURI, color
 	return err
 }
 
+func (s *Store) UpdateCalendarDefaultAlarm(ctx context.Context, ownerUID, calURI string, allDay bool, alarm string) error {
+	column := "default_alarm_vevent_datetime"
+	if allDay {
+		column = "default_alarm_vevent_date"
+	}
+	_, err := s.db.ExecContext(ctx, `
+        UPDATE calendars
+        SET `+column+` = ?, updated_at = datetime('now')
+        WHERE owner_user_id = ? AND uri = ?
+    `, alarm, ownerUID, calURI)
+	return err
+}
+
 func (s *Store) GetObject(ctx context.Context, calendarID, uid string) (*storage.Object, error) {
 	row := s.db.QueryRowContext(ctx, `
-		SELECT id, calendar_id, uid, etag, data, component, start_at, end_at, updated_at
+		SELECT id, calendar_id, uid, etag, schedule_tag, data, component, start_at, end_at, has_recurrence, updated_at
 		FROM calendar_objects WHERE calendar_id = ? AND uid = ?`, calendarID, uid)
 	var o storage.Object
-	if err := row.Scan(&o.ID, &o.CalendarID, &o.UID, &o.ETag, &o.Data, &o.Component, &o.StartAt, &o.EndAt, &o.UpdatedAt); err != nil {
+	if err := row.Scan(&o.ID, &o.CalendarID, &o.UID, &o.ETag, &o.ScheduleTag, &o.Data, &o.Component, &o.StartAt, &o.EndAt, &o.HasRecurrence, &o.UpdatedAt); err != nil {
 		return nil, err
 	}
 	return &o, nil
@@ -166,20 +205,26 @@ func (s *Store) PutObject(ctx context.Context, obj *storage.Object) error {
 		if obj.ETag == "" {
 			obj.ETag = randID()
 		}
+		updated := obj.UpdatedAt
+		if updated.IsZero() {
+			updated = time.Now().UTC()
+		}
 		_, err := tx.Exec(`
 			INSERT INTO calendar_objects (
-				id, calendar_id, uid, etag, data, component, start_at, end_at
+				id, calendar_id, uid, etag, schedule_tag, data, component, start_at, end_at, has_recurrence, updated_at
 			) VALUES (
-				?, ?, ?, ?, ?, ?, ?, ?
+				?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?
 			)
 			ON CONFLICT(calendar_id, uid) DO UPDATE SET
 				etag = excluded.etag,
+				schedule_tag = excluded.schedule_tag,
 				data = excluded.data,
 				component = excluded.component,
 				start_at = excluded.start_at,
 				end_at = excluded.end_at,
+				has_recurrence = excluded.has_recurrence,
 				updated_at = datetime('now')
-		`, obj.ID, obj.CalendarID, obj.UID, obj.ETag, obj.Data, obj.Component, obj.StartAt, obj.EndAt)
+		`, obj.ID, obj.CalendarID, obj.UID, obj.ETag, obj.ScheduleTag, obj.Data, obj.Component, obj.StartAt, obj.EndAt, obj.HasRecurrence, updated)
 		return err
 	})
 }
@@ -199,12 +244,12 @@ func (s *Store) DeleteObject(ctx context.Context, calendarID, uid, etag string)
 
 func (s *Store) ListObjects(ctx context.Context, calendarID string, start *time.Time, end *time.Time) ([]*storage.Object, error) {
 	q := `
-		SELECT id, calendar_id, uid, etag, data, component, start_at, end_at, updated_at
+		SELECT id, calendar_id, uid, etag, schedule_tag, data, component, start_at, end_at, has_recurrence, updated_at
 		FROM calendar_objects
 		WHERE calendar_id = ?`
 	args := []interface{}{calendarID}
 	if start != nil {
-		q += " AND (start_at IS NULL OR end_at >= ?)"
+		q += " AND (has_recurrence = 1 OR start_at IS NULL OR end_at >= ?)"
 		args = append(args, *start)
 		if end != nil {
 			q += " AND (end_at IS NULL OR start_at <= ?)"
@@ -224,7 +269,7 @@ func (s *Store) ListObjects(ctx context.Context, calendarID string, start *time.
 	var out []*storage.Object
 	for rows.Next() {
 		var o storage.Object
-		if err := rows.Scan(&o.ID, &o.CalendarID, &o.UID, &o.ETag, &o.Data, &o.Component, &o.StartAt, &o.EndAt, &o.UpdatedAt); err != nil {
+		if err := rows.Scan(&o.ID, &o.CalendarID, &o.UID, &o.ETag, &o.ScheduleTag, &o.Data, &o.Component, &o.StartAt, &o.EndAt, &o.HasRecurrence, &o.UpdatedAt); err != nil {
 			return nil, err
 		}
 		out = append(out, &o)
@@ -249,8 +294,18 @@ func randID() string {
 }
 
 func (s *Store) ListObjectsByComponent(ctx context.Context, calendarID string, components []string, start *time.Time, end *time.Time) ([]*storage.Object, error) {
+	return s.listObjectsByComponent(ctx, "data", calendarID, components, start, end)
+}
+
+func (s *Store) ListObjectMetadataByComponent(ctx context.Context, calendarID string, components []string, start *time.Time, end *time.Time) ([]*storage.Object, error) {
+	return s.listObjectsByComponent(ctx, "''", calendarID, components, start, end)
+}
+
+// listObjectsByComponent selects dataCol as the object body, so metadata
+// listings can skip reading it while still reporting its size.
+func (s *Store) listObjectsByComponent(ctx context.Context, dataCol, calendarID string, components []string, start *time.Time, end *time.Time) ([]*storage.Object, error) {
 	q := `
-		SELECT id, calendar_id, uid, etag, data, component, start_at, end_at, updated_at
+		SELECT id, calendar_id, uid, etag, schedule_tag, ` + dataCol + `, length(CAST(data AS BLOB)), component, start_at, end_at, has_recurrence, updated_at
 		FROM calendar_objects
 		WHERE calendar_id = ?`
 	args := []interface{}{calendarID}
@@ -269,7 +324,7 @@ func (s *Store) ListObjectsByComponent(ctx context.Context, calendarID string, c
 	}
 
 	if start != nil {
-		q += " AND (start_at IS NULL OR end_at >= ?)"
+		q += " AND (has_recurrence = 1 OR start_at IS NULL OR end_at >= ?)"
 		args = append(args, *start)
 	}
 	if end != nil {
@@ -286,7 +341,7 @@ func (s *Store) ListObjectsByComponent(ctx context.Context, calendarID string, c
 	var out []*storage.Object
 	for rows.Next() {
 		var o storage.Object
-		if err := rows.Scan(&o.ID, &o.CalendarID, &o.UID, &o.ETag, &o.Data, &o.Component, &o.StartAt, &o.EndAt, &o.UpdatedAt); err != nil {
+		if err := rows.Scan(&o.ID, &o.CalendarID, &o.UID, &o.ETag, &o.ScheduleTag, &o.Data, &o.Size, &o.Component, &o.StartAt, &o.EndAt, &o.HasRecurrence, &o.UpdatedAt); err != nil {
 			return nil, err
 		}
 		out = append(out, &o)
@@ -345,7 +400,8 @@ func (s *Store) RecordChange(ctx context.Context, calendarID, uid string, delete
 		err := tx.QueryRow(`
 			UPDATE calendars
 			SET sync_seq = sync_seq + 1,
-				sync_token = 'seq:' || (sync_seq + 1)
+				sync_token = 'seq:' || (sync_seq + 1),
+				ctag = 'seq:' || (sync_seq + 1)
 			WHERE id = ?
 			RETURNING sync_seq, sync_token
 		`, calendarID).Scan(&newSeq, &newToken)
@@ -0,0 +1,2 @@
+ALTER TABLE calendars DROP COLUMN default_alarm_vevent_date;
+ALTER TABLE calendars DROP COLUMN default_alarm_vevent_datetime;
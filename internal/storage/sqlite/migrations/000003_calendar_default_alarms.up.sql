@@ -0,0 +1,3 @@
+-- Per-calendar default alarms (CS:default-alarm-vevent-datetime / -date)
+ALTER TABLE calendars ADD COLUMN default_alarm_vevent_datetime TEXT NOT NULL DEFAULT '';
+ALTER TABLE calendars ADD COLUMN default_alarm_vevent_date TEXT NOT NULL DEFAULT '';
@@ -0,0 +1 @@
+ALTER TABLE calendar_objects DROP COLUMN schedule_tag;
@@ -0,0 +1,2 @@
+-- Schedule-Tag for scheduling object resources (RFC 6638)
+ALTER TABLE calendar_objects ADD COLUMN schedule_tag TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,8 @@
+-- Dead properties set through PROPPATCH, keyed by collection ID
+CREATE TABLE IF NOT EXISTS dead_properties (
+    resource_id TEXT NOT NULL,
+    namespace TEXT NOT NULL,
+    name TEXT NOT NULL,
+    value TEXT NOT NULL,
+    PRIMARY KEY (resource_id, namespace, name)
+);
@@ -0,0 +1 @@
+ALTER TABLE calendar_objects DROP COLUMN has_recurrence;
@@ -0,0 +1,2 @@
+-- Recurring masters are returned by every time-range query and bounded by the expander
+ALTER TABLE calendar_objects ADD COLUMN has_recurrence INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,85 @@
+package sqlite
+
+import (
+	"context"
+
+	"github.com/sonroyaalmerol/ldap-dav/internal/storage"
+)
+
+func (s *Store) ListDeadProperties(ctx context.Context, resourceID string) ([]storage.DeadProperty, error) {
+	rows, err := s.db.QueryContext(ctx, `
+        SELECT resource_id, namespace, name, value
+        FROM dead_properties WHERE resource_id = ?
+        ORDER BY namespace, name`, resourceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []storage.DeadProperty
+	for rows.Next() {
+		var p storage.DeadProperty
+		if err := rows.Scan(&p.ResourceID, &p.Space, &p.Local, &p.Value); err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+func (s *Store) ListAllDeadProperties(ctx context.Context) ([]storage.DeadProperty, error) {
+	rows, err := s.db.QueryContext(ctx, `
+        SELECT resource_id, namespace, name, value
+        FROM dead_properties
+        ORDER BY resource_id, namespace, name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []storage.DeadProperty
+	for rows.Next() {
+		var p storage.DeadProperty
+		if err := rows.Scan(&p.ResourceID, &p.Space, &p.Local, &p.Value); err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+func (s *Store) ListResourcesWithDeadProperty(ctx context.Context, space, local string) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, `
+        SELECT resource_id
+        FROM dead_properties
+        WHERE namespace = ? AND name = ?
+        ORDER BY resource_id`, space, local)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		out = append(out, id)
+	}
+	return out, rows.Err()
+}
+
+func (s *Store) SetDeadProperty(ctx context.Context, p storage.DeadProperty) error {
+	_, err := s.db.ExecContext(ctx, `
+        INSERT INTO dead_properties (resource_id, namespace, name, value)
+        VALUES (?, ?, ?, ?)
+        ON CONFLICT (resource_id, namespace, name) DO UPDATE SET value = excluded.value
+    `, p.ResourceID, p.Space, p.Local, p.Value)
+	return err
+}
+
+func (s *Store) RemoveDeadProperty(ctx context.Context, resourceID, space, local string) error {
+	_, err := s.db.ExecContext(ctx, `
+        DELETE FROM dead_properties
+        WHERE resource_id = ? AND namespace = ? AND name = ?
+    `, resourceID, space, local)
+	return err
+}
@@ -2,32 +2,56 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"time"
 )
 
+// ErrExists is returned when creating a collection whose owner and URI are
+// already taken.
+var ErrExists = errors.New("already exists")
+
 type Calendar struct {
-	ID          string
-	OwnerUserID string
-	OwnerGroup  string
-	URI         string
-	DisplayName string
-	Description string
-	Color       string
-	CTag        string
-	CreatedAt   time.Time
-	UpdatedAt   time.Time
+	ID                         string
+	OwnerUserID                string
+	OwnerGroup                 string
+	URI                        string
+	DisplayName                string
+	Description                string
+	Color                      string
+	DefaultAlarmVEventDateTime string
+	DefaultAlarmVEventDate     string
+	CTag                       string
+	CreatedAt                  time.Time
+	UpdatedAt                  time.Time
 }
 
 type Object struct {
-	ID         string
-	CalendarID string
-	UID        string
-	ETag       string
-	Data       string
-	Component  string // VEVENT/VTODO
-	StartAt    *time.Time
-	EndAt      *time.Time
-	UpdatedAt  time.Time
+	ID          string
+	CalendarID  string
+	UID         string
+	ETag        string
+	ScheduleTag string // set only for scheduling objects (with ORGANIZER)
+	Data        string
+	Component   string // VEVENT/VTODO
+	StartAt     *time.Time
+	EndAt       *time.Time
+	// HasRecurrence marks recurring masters (RRULE/RDATE); time-range
+	// listings always include them since StartAt/EndAt only cover the
+	// first instance.
+	HasRecurrence bool
+	UpdatedAt     time.Time
+	// Size is the byte length of Data, reported by metadata listings that
+	// leave Data empty.
+	Size int64
+}
+
+// ContentLength is the byte length of the object body, whether or not it
+// was loaded.
+func (o *Object) ContentLength() int64 {
+	if o.Data == "" {
+		return o.Size
+	}
+	return int64(len(o.Data))
 }
 
 type Change struct {
@@ -57,6 +81,15 @@ type Addressbook struct {
 	UpdatedAt   time.Time
 }
 
+// DeadProperty is a client-defined WebDAV property stored verbatim.
+// Value holds the property element serialized as XML.
+type DeadProperty struct {
+	ResourceID string
+	Space      string
+	Local      string
+	Value      string
+}
+
 type Store interface {
 	Close()
 	// Calendars
@@ -67,6 +100,7 @@ type Store interface {
 	ListCalendarsByOwnerUser(ctx context.Context, uid string) ([]*Calendar, error)
 	ListAllCalendars(ctx context.Context) ([]*Calendar, error)
 	UpdateCalendarColor(ctx context.Context, ownerUID, calURI, color string) error
+	UpdateCalendarDefaultAlarm(ctx context.Context, ownerUID, calURI string, allDay bool, alarm string) error
 
 	// Objects
 	GetObject(ctx context.Context, calendarID, uid string) (*Object, error)
@@ -74,6 +108,9 @@ type Store interface {
 	DeleteObject(ctx context.Context, calendarID, uid string, etag string) error
 	ListObjects(ctx context.Context, calendarID string, start *time.Time, end *time.Time) ([]*Object, error)
 	ListObjectsByComponent(ctx context.Context, calendarID string, components []string, start *time.Time, end *time.Time) ([]*Object, error)
+	// ListObjectMetadataByComponent is ListObjectsByComponent without
+	// reading object bodies: Data is empty and Size carries its length.
+	ListObjectMetadataByComponent(ctx context.Context, calendarID string, components []string, start *time.Time, end *time.Time) ([]*Object, error)
 	// Sync tokens
 	NewCTag(ctx context.Context, calendarID string) (string, error)
 	GetSyncInfo(ctx context.Context, calendarID string) (token string, seq int64, err error)
@@ -99,4 +136,15 @@ type Store interface {
 	GetAddressbookSyncInfo(ctx context.Context, addressbookID string) (token string, seq int64, err error)
 	ListAddressbookChangesSince(ctx context.Context, addressbookID string, sinceSeq int64, limit int) ([]Change, int64, error)
 	RecordAddressbookChange(ctx context.Context, addressbookID, uid string, deleted bool) (newToken string, newSeq int64, err error)
+
+	// Dead properties, keyed by calendar or addressbook ID
+	ListDeadProperties(ctx context.Context, resourceID string) ([]DeadProperty, error)
+	// ListAllDeadProperties returns the dead properties of every resource,
+	// for copying a store as a whole.
+	ListAllDeadProperties(ctx context.Context) ([]DeadProperty, error)
+	// ListResourcesWithDeadProperty returns the IDs of the resources that
+	// carry the given dead property.
+	ListResourcesWithDeadProperty(ctx context.Context, space, local string) ([]string, error)
+	SetDeadProperty(ctx context.Context, p DeadProperty) error
+	RemoveDeadProperty(ctx context.Context, resourceID, space, local string) error
 }
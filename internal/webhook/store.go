@@ -0,0 +1,49 @@
+package webhook
+
+import (
+	"context"
+
+	"github.com/sonroyaalmerol/ldap-dav/internal/dav/common"
+	"github.com/sonroyaalmerol/ldap-dav/internal/storage"
+)
+
+// Store wraps a storage.Store and fires a webhook for every recorded change.
+type Store struct {
+	storage.Store
+	n      *Notifier
+	tokens *common.SyncTokens
+}
+
+// WrapStore wraps s so that every change is sent to n. Sync-tokens in the
+// payload are encoded with tokens, as the DAV responses carry them.
+func WrapStore(s storage.Store, n *Notifier, tokens *common.SyncTokens) *Store {
+	return &Store{Store: s, n: n, tokens: tokens}
+}
+
+func (s *Store) RecordChange(ctx context.Context, calendarID, uid string, deleted bool) (string, int64, error) {
+	token, seq, err := s.Store.RecordChange(ctx, calendarID, uid, deleted)
+	if err == nil {
+		s.n.Notify(ctx, Event{
+			Type:         EventCalendarChange,
+			CollectionID: calendarID,
+			UID:          uid,
+			Deleted:      deleted,
+			SyncToken:    s.tokens.Encode(calendarID, token),
+		})
+	}
+	return token, seq, err
+}
+
+func (s *Store) RecordAddressbookChange(ctx context.Context, addressbookID, uid string, deleted bool) (string, int64, error) {
+	token, seq, err := s.Store.RecordAddressbookChange(ctx, addressbookID, uid, deleted)
+	if err == nil {
+		s.n.Notify(ctx, Event{
+			Type:         EventAddressbookChange,
+			CollectionID: addressbookID,
+			UID:          uid,
+			Deleted:      deleted,
+			SyncToken:    s.tokens.Encode(addressbookID, token),
+		})
+	}
+	return token, seq, err
+}
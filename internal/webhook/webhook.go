@@ -0,0 +1,171 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/sonroyaalmerol/ldap-dav/internal/config"
+)
+
+const (
+	EventCalendarChange    = "calendar.change"
+	EventAddressbookChange = "addressbook.change"
+	// EventSchedulingDelivery is sent when the server delivers an iTIP
+	// message on a calendar user's behalf, such as an auto-decline reply or
+	// a resource booking.
+	EventSchedulingDelivery = "scheduling.delivery"
+
+	// SignatureHeader carries "sha256=<hex>" of the HMAC-SHA256 of the body
+	// keyed with the configured secret.
+	SignatureHeader = "X-LDAP-DAV-Signature"
+)
+
+// Event is the JSON body of a delivery. Method, Originator and Recipient
+// are only set on scheduling deliveries: the iTIP method and the calendar
+// addresses the message went from and to.
+type Event struct {
+	Type         string    `json:"type"`
+	CollectionID string    `json:"collection_id"`
+	UID          string    `json:"uid"`
+	Deleted      bool      `json:"deleted"`
+	SyncToken    string    `json:"sync_token,omitempty"`
+	Method       string    `json:"method,omitempty"`
+	Originator   string    `json:"originator,omitempty"`
+	Recipient    string    `json:"recipient,omitempty"`
+	Time         time.Time `json:"time"`
+}
+
+type Notifier struct {
+	urls   []string
+	secret []byte
+	client *http.Client
+	logger zerolog.Logger
+
+	queue  chan delivery
+	wg     sync.WaitGroup
+	mu     sync.RWMutex
+	closed bool
+}
+
+// delivery is one event body queued for one URL.
+type delivery struct {
+	ctx  context.Context
+	url  string
+	body []byte
+}
+
+// New returns a notifier that posts through cfg.Workers background workers
+// fed by a queue of cfg.QueueSize deliveries. Call Close to drain it.
+func New(cfg config.WebhookConfig, logger zerolog.Logger) *Notifier {
+	n := &Notifier{
+		urls:   cfg.URLs,
+		secret: []byte(cfg.Secret),
+		client: &http.Client{Timeout: cfg.Timeout},
+		logger: logger,
+		queue:  make(chan delivery, max(cfg.QueueSize, 1)),
+	}
+	if len(n.urls) > 0 {
+		for range max(cfg.Workers, 1) {
+			n.wg.Add(1)
+			go n.work()
+		}
+	}
+	return n
+}
+
+// Notify queues ev for every configured URL. Delivery is best effort:
+// failures are logged and not retried, and deliveries that find the queue
+// full or the notifier closed are dropped with a warning. A nil notifier
+// drops everything.
+func (n *Notifier) Notify(ctx context.Context, ev Event) {
+	if n == nil || len(n.urls) == 0 {
+		return
+	}
+	if ev.Time.IsZero() {
+		ev.Time = time.Now().UTC()
+	}
+	body, err := json.Marshal(ev)
+	if err != nil {
+		n.logger.Error().Ctx(ctx).Err(err).Msg("failed to encode webhook payload")
+		return
+	}
+	// keep request-scoped values (request id) but outlive the request
+	ctx = context.WithoutCancel(ctx)
+
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	for _, url := range n.urls {
+		if n.closed {
+			n.logger.Warn().Ctx(ctx).Str("url", url).Msg("webhook notifier closed, dropping delivery")
+			continue
+		}
+		select {
+		case n.queue <- delivery{ctx: ctx, url: url, body: body}:
+		default:
+			n.logger.Warn().Ctx(ctx).Str("url", url).Msg("webhook queue full, dropping delivery")
+		}
+	}
+}
+
+// Close stops accepting deliveries and waits until the queued ones have
+// been sent or ctx is done, in which case the rest are abandoned.
+func (n *Notifier) Close(ctx context.Context) error {
+	n.mu.Lock()
+	if !n.closed {
+		n.closed = true
+		close(n.queue)
+	}
+	n.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		n.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		n.logger.Warn().Int("pending", len(n.queue)).Msg("webhook queue not drained before shutdown")
+		return ctx.Err()
+	}
+}
+
+func (n *Notifier) work() {
+	defer n.wg.Done()
+	for d := range n.queue {
+		n.post(d.ctx, d.url, d.body)
+	}
+}
+
+func (n *Notifier) post(ctx context.Context, url string, body []byte) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		n.logger.Error().Ctx(ctx).Err(err).Str("url", url).Msg("failed to build webhook request")
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(n.secret) > 0 {
+		mac := hmac.New(sha256.New, n.secret)
+		mac.Write(body)
+		req.Header.Set(SignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		n.logger.Warn().Ctx(ctx).Err(err).Str("url", url).Msg("webhook delivery failed")
+		return
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		n.logger.Warn().Ctx(ctx).Int("status", resp.StatusCode).Str("url", url).Msg("webhook endpoint rejected delivery")
+	}
+}
@@ -0,0 +1,84 @@
+package webhook
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/sonroyaalmerol/ldap-dav/internal/config"
+)
+
+func TestNotifierDrainsOnClose(t *testing.T) {
+	var got, inFlight, peak atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cur := inFlight.Add(1)
+		for {
+			p := peak.Load()
+			if cur <= p || peak.CompareAndSwap(p, cur) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		inFlight.Add(-1)
+		got.Add(1)
+	}))
+	defer srv.Close()
+
+	n := New(config.WebhookConfig{
+		URLs:      []string{srv.URL},
+		Timeout:   time.Second,
+		Workers:   2,
+		QueueSize: 50,
+	}, zerolog.Nop())
+
+	for i := 0; i < 20; i++ {
+		n.Notify(context.Background(), Event{Type: EventCalendarChange, UID: "e"})
+	}
+	if err := n.Close(context.Background()); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if got.Load() != 20 {
+		t.Errorf("delivered %d events, want 20", got.Load())
+	}
+	if peak.Load() > 2 {
+		t.Errorf("%d deliveries in flight, want at most 2", peak.Load())
+	}
+
+	n.Notify(context.Background(), Event{Type: EventCalendarChange, UID: "late"})
+	if got.Load() != 20 {
+		t.Errorf("delivery after Close was sent")
+	}
+}
+
+func TestNotifierDropsWhenQueueFull(t *testing.T) {
+	release := make(chan struct{})
+	var got atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		got.Add(1)
+	}))
+	defer srv.Close()
+
+	n := New(config.WebhookConfig{
+		URLs:      []string{srv.URL},
+		Timeout:   5 * time.Second,
+		Workers:   1,
+		QueueSize: 2,
+	}, zerolog.Nop())
+
+	for i := 0; i < 10; i++ {
+		n.Notify(context.Background(), Event{Type: EventCalendarChange, UID: "e"})
+	}
+	close(release)
+	if err := n.Close(context.Background()); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	// one in flight with the worker plus a full queue of two
+	if got.Load() > 3 {
+		t.Errorf("delivered %d events, want at most 3", got.Load())
+	}
+}
@@ -0,0 +1,65 @@
+package ical
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/emersion/go-ical"
+)
+
+// FeedObject is one calendar object cut from an iCalendar stream.
+type FeedObject struct {
+	Component string
+	Data      []byte
+}
+
+// SplitCalendar breaks an iCalendar stream, such as a published feed, into
+// one calendar object per UID the way CalDAV stores them (RFC 4791 section
+// 4.1). Each object carries every VTIMEZONE of the stream; components
+// without a UID are dropped. prodID is used when the stream has none.
+func SplitCalendar(data []byte, prodID string) (map[string]FeedObject, error) {
+	cal, err := ical.NewDecoder(bytes.NewReader(data)).Decode()
+	if err != nil {
+		return nil, err
+	}
+	if p := cal.Props.Get(ical.PropProductID); p != nil && p.Value != "" {
+		prodID = p.Value
+	}
+
+	var timezones []*ical.Component
+	groups := make(map[string][]*ical.Component)
+	for _, child := range cal.Children {
+		switch child.Name {
+		case ical.CompTimezone:
+			timezones = append(timezones, child)
+		case ical.CompEvent, ical.CompToDo, ical.CompJournal:
+			uid, err := child.Props.Text(ical.PropUID)
+			if err != nil || uid == "" {
+				continue
+			}
+			groups[uid] = append(groups[uid], child)
+		}
+	}
+
+	out := make(map[string]FeedObject, len(groups))
+	for uid, comps := range groups {
+		obj := ical.NewCalendar()
+		obj.Props.SetText(ical.PropVersion, "2.0")
+		obj.Props.SetText(ical.PropProductID, prodID)
+		obj.Children = append(obj.Children, timezones...)
+		obj.Children = append(obj.Children, comps...)
+
+		var buf bytes.Buffer
+		if err := ical.NewEncoder(&buf).Encode(obj); err != nil {
+			return nil, err
+		}
+		out[uid] = FeedObject{Component: comps[0].Name, Data: buf.Bytes()}
+	}
+	return out, nil
+}
+
+// ParseDuration parses an iCalendar or ISO 8601 duration such as PT15M or
+// P1D.
+func ParseDuration(s string) (time.Duration, error) {
+	return parseDuration(s)
+}
@@ -0,0 +1,136 @@
+package ical
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/emersion/go-ical"
+)
+
+// PropFilter is a CalDAV prop-filter (RFC 4791 section 9.7.2) expressed
+// independently of its XML form.
+type PropFilter struct {
+	Name         string
+	IsNotDefined bool
+	TextMatch    *TextMatch
+	ParamFilters []ParamFilter
+}
+
+// ParamFilter tests a parameter of the property a PropFilter selects. All
+// param-filters of a prop-filter must hold on the same property instance.
+type ParamFilter struct {
+	Name         string
+	IsNotDefined bool
+	TextMatch    *TextMatch
+}
+
+// TextMatch is a substring match against a property value. Matching is
+// case-insensitive unless CaseSensitive is set (i;octet collation).
+type TextMatch struct {
+	Text          string
+	CaseSensitive bool
+	Negate        bool
+}
+
+// MatchCalendarProps evaluates prop-filters against the top-level VCALENDAR
+// properties (METHOD, CALSCALE, PRODID, ...) of an iCalendar object.
+func MatchCalendarProps(data []byte, filters []PropFilter) (bool, error) {
+	cal, err := ical.NewDecoder(bytes.NewReader(data)).Decode()
+	if err != nil {
+		return false, err
+	}
+	return matchProps(cal.Props, filters), nil
+}
+
+// MatchComponentProps evaluates prop-filters against the top-level
+// components named comp (VEVENT, VTODO, ...) and reports whether any of
+// them satisfies all filters.
+func MatchComponentProps(data []byte, comp string, filters []PropFilter) (bool, error) {
+	cal, err := ical.NewDecoder(bytes.NewReader(data)).Decode()
+	if err != nil {
+		return false, err
+	}
+	for _, child := range cal.Children {
+		if strings.EqualFold(child.Name, comp) && matchProps(child.Props, filters) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func matchProps(props ical.Props, filters []PropFilter) bool {
+	for _, f := range filters {
+		if !matchProp(props[strings.ToUpper(f.Name)], f) {
+			return false
+		}
+	}
+	return true
+}
+
+func matchProp(values []ical.Prop, f PropFilter) bool {
+	if f.IsNotDefined {
+		return len(values) == 0
+	}
+	if len(values) == 0 {
+		return false
+	}
+	if len(f.ParamFilters) > 0 && !matchAnyParams(values, f.ParamFilters) {
+		return false
+	}
+	if f.TextMatch == nil {
+		return true
+	}
+	found := false
+	for _, v := range values {
+		if matchText(v.Value, f.TextMatch) {
+			found = true
+			break
+		}
+	}
+	return found != f.TextMatch.Negate
+}
+
+// matchAnyParams reports whether some property instance satisfies every
+// param-filter.
+func matchAnyParams(values []ical.Prop, filters []ParamFilter) bool {
+	for _, v := range values {
+		ok := true
+		for _, pf := range filters {
+			if !matchParam(v.Params.Values(pf.Name), pf) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+func matchParam(values []string, f ParamFilter) bool {
+	if f.IsNotDefined {
+		return len(values) == 0
+	}
+	if len(values) == 0 {
+		return false
+	}
+	if f.TextMatch == nil {
+		return true
+	}
+	found := false
+	for _, v := range values {
+		if matchText(v, f.TextMatch) {
+			found = true
+			break
+		}
+	}
+	return found != f.TextMatch.Negate
+}
+
+func matchText(value string, tm *TextMatch) bool {
+	if tm.CaseSensitive {
+		return strings.Contains(value, tm.Text)
+	}
+	return strings.Contains(strings.ToLower(value), strings.ToLower(tm.Text))
+}
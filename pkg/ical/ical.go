@@ -10,6 +10,10 @@ import (
 
 type Interval struct{ S, E time.Time }
 
+// ErrUnsupportedComponent is returned by DetectICSComponent for a valid
+// iCalendar stream holding no VEVENT, VTODO, VJOURNAL or VFREEBUSY.
+var ErrUnsupportedComponent = errors.New("unsupported component")
+
 func NormalizeICS(data []byte) ([]byte, error) {
 	// Optionally parse and re-serialize to ensure validity and consistent formatting
 	cal, err := ical.NewDecoder(bytes.NewReader(data)).Decode()
@@ -35,12 +39,31 @@ func DetectICSComponent(data []byte) (string, error) {
 	for _, child := range cal.Children {
 		if child.Name == ical.CompEvent ||
 			child.Name == ical.CompToDo ||
-			child.Name == ical.CompJournal {
+			child.Name == ical.CompJournal ||
+			child.Name == ical.CompFreeBusy {
 			return child.Name, nil
 		}
 	}
 
-	return "", errors.New("unsupported component")
+	return "", ErrUnsupportedComponent
+}
+
+// ObjectUID returns the UID of the first VEVENT, VTODO or VJOURNAL in data,
+// or "" when there is none.
+func ObjectUID(data []byte) string {
+	cal, err := ical.NewDecoder(bytes.NewReader(data)).Decode()
+	if err != nil {
+		return ""
+	}
+	for _, child := range cal.Children {
+		switch child.Name {
+		case ical.CompEvent, ical.CompToDo, ical.CompJournal:
+			if uid, err := child.Props.Text(ical.PropUID); err == nil && uid != "" {
+				return uid
+			}
+		}
+	}
+	return ""
 }
 
 func EnsureDTStamp(data []byte) ([]byte, bool) {
@@ -54,7 +77,7 @@ func EnsureDTStamp(data []byte) ([]byte, bool) {
 
 	// Process all components in the calendar
 	for _, child := range cal.Children {
-		if child.Name == ical.CompEvent {
+		if child.Name == ical.CompEvent || child.Name == ical.CompFreeBusy {
 			// Check if DTSTAMP already exists
 			if child.Props.Get(ical.PropDateTimeStamp) == nil {
 				// Add DTSTAMP property
@@ -80,3 +103,58 @@ func EnsureDTStamp(data []byte) ([]byte, bool) {
 
 	return buf.Bytes(), true
 }
+
+// StampCalendar sets the VCALENDAR's PRODID to prodID and, when
+// enforceVersion is set, its VERSION to 2.0. With replaceProdID false an
+// existing PRODID is left alone and only a missing one is added; an empty
+// prodID leaves PRODID untouched. It reports whether data was changed.
+func StampCalendar(data []byte, prodID string, replaceProdID, enforceVersion bool) ([]byte, bool) {
+	cal, err := ical.NewDecoder(bytes.NewReader(data)).Decode()
+	if err != nil {
+		return data, false
+	}
+
+	modified := false
+	if cur, _ := cal.Props.Text(ical.PropProductID); prodID != "" && cur != prodID && (replaceProdID || cur == "") {
+		cal.Props.SetText(ical.PropProductID, prodID)
+		modified = true
+	}
+	if cur, _ := cal.Props.Text(ical.PropVersion); enforceVersion && cur != "2.0" {
+		cal.Props.SetText(ical.PropVersion, "2.0")
+		modified = true
+	}
+	if !modified {
+		return data, false
+	}
+
+	var buf bytes.Buffer
+	if err := ical.NewEncoder(&buf).Encode(cal); err != nil {
+		return data, false
+	}
+	return buf.Bytes(), true
+}
+
+// BuildAnnualEvent renders a yearly-recurring all-day VEVENT starting on day.
+func BuildAnnualEvent(uid, summary string, day, stamp time.Time, prodID string) ([]byte, error) {
+	cal := ical.NewCalendar()
+	cal.Props.SetText(ical.PropVersion, "2.0")
+	cal.Props.SetText(ical.PropProductID, prodID)
+
+	ev := ical.NewEvent()
+	ev.Props.SetText(ical.PropUID, uid)
+	ev.Props.SetDateTime(ical.PropDateTimeStamp, stamp.UTC())
+	ev.Props.SetDate(ical.PropDateTimeStart, day)
+	ev.Props.SetDate(ical.PropDateTimeEnd, day.AddDate(0, 0, 1))
+	rrule := ical.NewProp(ical.PropRecurrenceRule)
+	rrule.Value = "FREQ=YEARLY"
+	ev.Props.Set(rrule)
+	ev.Props.SetText(ical.PropSummary, summary)
+	ev.Props.SetText(ical.PropTransparency, "TRANSPARENT")
+	cal.Children = append(cal.Children, ev.Component)
+
+	var buf bytes.Buffer
+	if err := ical.NewEncoder(&buf).Encode(cal); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
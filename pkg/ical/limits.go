@@ -0,0 +1,117 @@
+package ical
+
+import (
+	"bytes"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-ical"
+	"github.com/teambition/rrule-go"
+)
+
+// MaxAttendees returns the largest number of ATTENDEE properties carried by
+// a single component. Overridden instances are components of their own, so
+// this is the per-instance count of RFC 4791 section 5.2.9.
+func MaxAttendees(data []byte) int {
+	cal, err := ical.NewDecoder(bytes.NewReader(data)).Decode()
+	if err != nil {
+		return 0
+	}
+	most := 0
+	for _, child := range cal.Children {
+		most = max(most, len(child.Props[ical.PropAttendee]))
+	}
+	return most
+}
+
+// LongestEvent returns the longest duration of the VEVENTs of an object,
+// overridden instances included.
+func LongestEvent(data []byte) time.Duration {
+	events, err := ParseCalendar(data)
+	if err != nil {
+		return 0
+	}
+	var longest time.Duration
+	for _, ev := range events {
+		longest = max(longest, ev.Duration)
+	}
+	return longest
+}
+
+// MissingCategories reports whether a VEVENT, VTODO or VJOURNAL of an object
+// carries no non-empty CATEGORIES property.
+func MissingCategories(data []byte) bool {
+	cal, err := ical.NewDecoder(bytes.NewReader(data)).Decode()
+	if err != nil {
+		return false
+	}
+	for _, child := range cal.Children {
+		switch child.Name {
+		case ical.CompEvent, ical.CompToDo, ical.CompJournal:
+		default:
+			continue
+		}
+		found := false
+		for _, p := range child.Props.Values(ical.PropCategories) {
+			if strings.Trim(p.Value, " ,") != "" {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return true
+		}
+	}
+	return false
+}
+
+// CountInstances counts the instances the VEVENTs of an object produce,
+// stopping once limit is exceeded. Rules without COUNT or UNTIL recur
+// forever and cannot be held to a limit, so only their RDATEs are counted.
+// Overridden instances do not add to the total.
+func CountInstances(data []byte, limit int) int {
+	events, err := ParseCalendar(data)
+	if err != nil {
+		return 0
+	}
+	total := 0
+	for _, ev := range events {
+		if ev.RecurrenceID != nil {
+			continue
+		}
+		if !ev.IsRecurring {
+			total++
+			continue
+		}
+		total += len(ev.RDates)
+		if ev.RRule != "" {
+			total += countRule(ev, limit-total+1)
+		} else {
+			total++
+		}
+		if total > limit {
+			break
+		}
+	}
+	return total
+}
+
+// countRule counts the occurrences of a bounded RRULE, up to limit.
+func countRule(ev *Event, limit int) int {
+	rule, err := rrule.StrToRRule("DTSTART:" + ev.Start.Format("20060102T150405Z") + "\nRRULE:" + ev.RRule)
+	if err != nil {
+		return 0
+	}
+	if rule.OrigOptions.Count == 0 && rule.OrigOptions.Until.IsZero() {
+		return 0
+	}
+	n := 0
+	next := rule.Iterator()
+	for n < limit {
+		if _, ok := next(); !ok {
+			break
+		}
+		n++
+	}
+	return n
+}
@@ -0,0 +1,70 @@
+package ical
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/emersion/go-ical"
+)
+
+// CompSelection is a CALDAV:comp element of a calendar-data request
+// (RFC 4791 section 9.6.1), naming which properties and subcomponents of a
+// component to return.
+type CompSelection struct {
+	Name     string
+	AllProps bool
+	Props    []string
+	AllComps bool
+	Comps    []CompSelection
+}
+
+// SelectComponents trims a calendar object down to the properties and
+// subcomponents sel asks for. The data is returned unchanged when it cannot
+// be parsed.
+func SelectComponents(data []byte, sel CompSelection) []byte {
+	cal, err := ical.NewDecoder(bytes.NewReader(data)).Decode()
+	if err != nil || !strings.EqualFold(sel.Name, cal.Name) {
+		return data
+	}
+	selectComponent(cal.Component, sel)
+
+	var buf bytes.Buffer
+	if err := ical.NewEncoder(&buf).Encode(cal); err != nil {
+		return data
+	}
+	return buf.Bytes()
+}
+
+func selectComponent(comp *ical.Component, sel CompSelection) {
+	if !sel.AllProps {
+		keep := make(map[string]bool, len(sel.Props))
+		for _, p := range sel.Props {
+			keep[strings.ToUpper(p)] = true
+		}
+		// VERSION and PRODID keep the result valid iCalendar.
+		if comp.Name == ical.CompCalendar {
+			keep[ical.PropVersion] = true
+			keep[ical.PropProductID] = true
+		}
+		for name := range comp.Props {
+			if !keep[name] {
+				delete(comp.Props, name)
+			}
+		}
+	}
+
+	if sel.AllComps {
+		return
+	}
+	children := comp.Children[:0]
+	for _, child := range comp.Children {
+		for _, cs := range sel.Comps {
+			if strings.EqualFold(cs.Name, child.Name) {
+				selectComponent(child, cs)
+				children = append(children, child)
+				break
+			}
+		}
+	}
+	comp.Children = children
+}
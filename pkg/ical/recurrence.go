@@ -2,6 +2,7 @@ package ical
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"sort"
 	"time"
@@ -21,13 +22,16 @@ type Event struct {
 	IsRecurring  bool
 	RRule        string
 	RDates       []time.Time
+	ExRules      []string
 	ExDates      []time.Time
 	RecurrenceID *time.Time
 	RawData      []byte
 }
 
 type RecurrenceExpander struct {
-	timeZone *time.Location
+	timeZone     *time.Location
+	maxInstances int
+	onTruncate   func(ctx context.Context, uid string, limit int)
 }
 
 func NewRecurrenceExpander(tz *time.Location) *RecurrenceExpander {
@@ -37,7 +41,24 @@ func NewRecurrenceExpander(tz *time.Location) *RecurrenceExpander {
 	return &RecurrenceExpander{timeZone: tz}
 }
 
+// WithLimit returns a copy of the expander that yields at most limit instances
+// per event, calling onTruncate (if set) whenever an expansion is cut short.
+// A limit of zero or less disables the cap.
+func (re *RecurrenceExpander) WithLimit(limit int, onTruncate func(ctx context.Context, uid string, limit int)) *RecurrenceExpander {
+	c := *re
+	c.maxInstances = limit
+	c.onTruncate = onTruncate
+	return &c
+}
+
 func ParseCalendar(data []byte) ([]*Event, error) {
+	return ParseCalendarMatching(data, nil)
+}
+
+// ParseCalendarMatching parses the VEVENTs of data whose properties satisfy
+// every prop-filter, so a time-range can then be tested against the same
+// components (RFC 4791 section 9.7.1).
+func ParseCalendarMatching(data []byte, filters []PropFilter) ([]*Event, error) {
 	cal, err := ical.NewDecoder(bytes.NewReader(data)).Decode()
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse calendar: %w", err)
@@ -46,7 +67,7 @@ func ParseCalendar(data []byte) ([]*Event, error) {
 	var events []*Event
 
 	for _, comp := range cal.Children {
-		if comp.Name != ical.CompEvent {
+		if comp.Name != ical.CompEvent || !matchProps(comp.Props, filters) {
 			continue
 		}
 
@@ -60,6 +81,31 @@ func ParseCalendar(data []byte) ([]*Event, error) {
 	return events, nil
 }
 
+// EventWindow returns the span covered by the VEVENTs of an object, for
+// indexing. recurring reports an RRULE or RDATE, in which case the span only
+// covers the explicit instances and the object may recur past end. Objects
+// without events yield nil bounds.
+func EventWindow(data []byte) (start, end *time.Time, recurring bool) {
+	events, err := ParseCalendar(data)
+	if err != nil {
+		return nil, nil, false
+	}
+	for _, ev := range events {
+		if ev.IsRecurring {
+			recurring = true
+		}
+		if start == nil || ev.Start.Before(*start) {
+			s := ev.Start
+			start = &s
+		}
+		if end == nil || ev.End.After(*end) {
+			e := ev.End
+			end = &e
+		}
+	}
+	return start, end, recurring
+}
+
 func SerializeEvent(event *Event) ([]byte, error) {
 	if event.RawData != nil {
 		if event.RecurrenceID != nil {
@@ -71,7 +117,7 @@ func SerializeEvent(event *Event) ([]byte, error) {
 	return createEventData(event)
 }
 
-func (re *RecurrenceExpander) ExpandRecurrences(events []*Event, rangeStart, rangeEnd time.Time) ([]*Event, error) {
+func (re *RecurrenceExpander) ExpandRecurrences(ctx context.Context, events []*Event, rangeStart, rangeEnd time.Time) ([]*Event, error) {
 	var expandedEvents []*Event
 
 	for _, event := range events {
@@ -82,7 +128,7 @@ func (re *RecurrenceExpander) ExpandRecurrences(events []*Event, rangeStart, ran
 			continue
 		}
 
-		instances, err := re.expandEvent(event, rangeStart, rangeEnd)
+		instances, err := re.expandEvent(ctx, event, rangeStart, rangeEnd)
 		if err != nil {
 			continue // Skip events that fail to expand
 		}
@@ -162,6 +208,11 @@ func parseEvent(comp *ical.Component, originalData []byte) (*Event, error) {
 		event.IsRecurring = true
 	}
 
+	// EXRULE is deprecated by RFC 5545 but still found in older calendars.
+	for _, exrule := range comp.Props.Values("EXRULE") {
+		event.ExRules = append(event.ExRules, exrule.Value)
+	}
+
 	exdateProps := comp.Props.Values(ical.PropExceptionDates)
 	for _, exdateProp := range exdateProps {
 		dates, err := parseMultipleDates(exdateProp.Value)
@@ -183,24 +234,37 @@ func parseEvent(comp *ical.Component, originalData []byte) (*Event, error) {
 	return event, nil
 }
 
-func (re *RecurrenceExpander) expandEvent(event *Event, rangeStart, rangeEnd time.Time) ([]*Event, error) {
+func (re *RecurrenceExpander) expandEvent(ctx context.Context, event *Event, rangeStart, rangeEnd time.Time) ([]*Event, error) {
 	var instances []time.Time
+	truncated := false
+
+	windowStart := rangeStart.Add(-event.Duration)
+	windowEnd := rangeEnd.Add(event.Duration)
 
 	if event.RRule != "" {
-		rruleStr := "DTSTART:" + event.Start.Format("20060102T150405Z") + "\nRRULE:" + event.RRule
-		rule, err := rrule.StrToRRule(rruleStr)
+		occurrences, capped, err := ruleOccurrences(event.Start, event.RRule, windowStart, windowEnd, re.maxInstances)
 		if err != nil {
 			return nil, fmt.Errorf("invalid RRULE: %w", err)
 		}
-
-		extendedEnd := rangeEnd.Add(event.Duration)
-		occurrences := rule.Between(rangeStart.Add(-event.Duration), extendedEnd, true)
+		truncated = capped
 		instances = append(instances, occurrences...)
+	} else {
+		// Without an RRULE the set starts from DTSTART itself, with the
+		// RDATEs adding to it (RFC 5545 section 3.8.5.2).
+		instances = append(instances, event.Start)
 	}
 
 	instances = append(instances, event.RDates...)
 
-	instances = filterExcludedDates(instances, event.ExDates)
+	exdates := event.ExDates
+	for _, exrule := range event.ExRules {
+		occurrences, _, err := ruleOccurrences(event.Start, exrule, windowStart, windowEnd, re.maxInstances)
+		if err != nil {
+			continue
+		}
+		exdates = append(exdates, occurrences...)
+	}
+	instances = filterExcludedDates(instances, exdates)
 
 	var filteredInstances []time.Time
 	for _, instance := range instances {
@@ -214,6 +278,14 @@ func (re *RecurrenceExpander) expandEvent(event *Event, rangeStart, rangeEnd tim
 		return filteredInstances[i].Before(filteredInstances[j])
 	})
 
+	if re.maxInstances > 0 && len(filteredInstances) > re.maxInstances {
+		filteredInstances = filteredInstances[:re.maxInstances]
+		truncated = true
+	}
+	if truncated && re.onTruncate != nil {
+		re.onTruncate(ctx, event.UID, re.maxInstances)
+	}
+
 	var expandedEvents []*Event
 	for i, instanceTime := range filteredInstances {
 		instanceEvent := &Event{
@@ -234,6 +306,39 @@ func (re *RecurrenceExpander) expandEvent(event *Event, rangeStart, rangeEnd tim
 	return expandedEvents, nil
 }
 
+// maxRuleIterations bounds how many occurrences of a rule are generated,
+// including those skipped before the requested range, so a fine-grained
+// rule anchored far in the past cannot keep a request busy.
+const maxRuleIterations = 100000
+
+// ruleOccurrences returns the occurrences of a recurrence rule anchored at
+// dtstart that fall within [from, to]. When limit is positive, collection
+// stops one past limit so the caller can tell the expansion was truncated
+// without materializing the whole set. It reports whether generation
+// stopped at maxRuleIterations before reaching the end of the range.
+func ruleOccurrences(dtstart time.Time, rule string, from, to time.Time, limit int) ([]time.Time, bool, error) {
+	r, err := rrule.StrToRRule("DTSTART:" + dtstart.Format("20060102T150405Z") + "\nRRULE:" + rule)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var out []time.Time
+	next := r.Iterator()
+	for i := 0; limit <= 0 || len(out) <= limit; i++ {
+		if i == maxRuleIterations {
+			return out, true, nil
+		}
+		t, ok := next()
+		if !ok || t.After(to) {
+			break
+		}
+		if !t.Before(from) {
+			out = append(out, t)
+		}
+	}
+	return out, false, nil
+}
+
 func (re *RecurrenceExpander) eventOverlapsRange(event *Event, rangeStart, rangeEnd time.Time) bool {
 	return re.timeRangeOverlaps(event.Start, event.End, rangeStart, rangeEnd)
 }
@@ -0,0 +1,138 @@
+package ical
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+)
+
+// contentLine matches the start of an iCalendar content line: a property
+// name followed by its parameters or value.
+var contentLine = regexp.MustCompile(`^[A-Za-z0-9-]+[;:]`)
+
+// RepairICS fixes the recoverable defects some clients produce: bare LF
+// line endings, folded lines missing their leading whitespace, and a
+// VCALENDAR without VERSION or PRODID. prodID fills in a missing PRODID.
+// It reports whether anything was changed.
+func RepairICS(data []byte, prodID string) ([]byte, bool) {
+	text := strings.ReplaceAll(string(data), "\r\n", "\n")
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		switch {
+		case line == "":
+			continue
+		case line[0] == ' ' || line[0] == '\t':
+			lines = append(lines, line)
+		case len(lines) > 0 && !contentLine.MatchString(line):
+			// A fold that lost its leading whitespace.
+			lines[len(lines)-1] += line
+		default:
+			lines = append(lines, line)
+		}
+	}
+
+	lines = ensureCalendarProps(lines, prodID)
+
+	repaired := []byte(strings.Join(lines, "\r\n") + "\r\n")
+	if bytes.Equal(repaired, data) {
+		return data, false
+	}
+	return repaired, true
+}
+
+// ensureCalendarProps adds VERSION and PRODID to the VCALENDAR when it
+// lacks them.
+func ensureCalendarProps(lines []string, prodID string) []string {
+	start := -1
+	depth := 0
+	hasVersion, hasProdID := false, false
+	for i, line := range lines {
+		upper := strings.ToUpper(line)
+		switch {
+		case strings.HasPrefix(upper, "BEGIN:"):
+			if depth == 0 && strings.TrimSpace(upper[6:]) == "VCALENDAR" {
+				start = i
+			}
+			depth++
+		case strings.HasPrefix(upper, "END:"):
+			depth--
+		case depth == 1 && (strings.HasPrefix(upper, "VERSION:") || strings.HasPrefix(upper, "VERSION;")):
+			hasVersion = true
+		case depth == 1 && (strings.HasPrefix(upper, "PRODID:") || strings.HasPrefix(upper, "PRODID;")):
+			hasProdID = true
+		}
+	}
+	if start < 0 || (hasVersion && hasProdID) {
+		return lines
+	}
+
+	var missing []string
+	if !hasVersion {
+		missing = append(missing, "VERSION:2.0")
+	}
+	if !hasProdID {
+		missing = append(missing, "PRODID:"+prodID)
+	}
+	out := make([]string, 0, len(lines)+len(missing))
+	out = append(out, lines[:start+1]...)
+	out = append(out, missing...)
+	return append(out, lines[start+1:]...)
+}
+
+// SingleCalendar returns data as exactly one VCALENDAR. Components outside
+// any VCALENDAR are wrapped in one carrying VERSION and prodID, and the
+// components of further VCALENDAR blocks are merged into the first, whose
+// calendar properties are kept. Data that already is a single VCALENDAR is
+// returned unchanged.
+func SingleCalendar(data []byte, prodID string) []byte {
+	text := strings.ReplaceAll(string(data), "\r\n", "\n")
+	var out []string
+	depth, calendars := 0, 0
+	skipping := false
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if line == "" {
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			if !skipping {
+				out = append(out, line)
+			}
+			continue
+		}
+		skipping = false
+		upper := strings.ToUpper(line)
+		switch {
+		case strings.HasPrefix(upper, "BEGIN:"):
+			depth++
+			if depth == 1 && strings.TrimSpace(upper[6:]) == "VCALENDAR" {
+				calendars++
+				if calendars > 1 {
+					skipping = true
+					continue
+				}
+			}
+		case strings.HasPrefix(upper, "END:"):
+			depth--
+			if depth == 0 && strings.TrimSpace(upper[4:]) == "VCALENDAR" {
+				skipping = true
+				continue
+			}
+		case depth == 1 && calendars > 1:
+			// A calendar property of a merged VCALENDAR.
+			skipping = true
+			continue
+		}
+		out = append(out, line)
+	}
+
+	switch calendars {
+	case 1:
+		return data
+	case 0:
+		out = append([]string{"BEGIN:VCALENDAR", "VERSION:2.0", "PRODID:" + prodID}, out...)
+	}
+	out = append(out, "END:VCALENDAR")
+	return []byte(strings.Join(out, "\r\n") + "\r\n")
+}
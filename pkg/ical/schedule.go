@@ -0,0 +1,280 @@
+package ical
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-ical"
+)
+
+// IsSchedulingObject reports whether the calendar object carries an
+// ORGANIZER, making it a scheduling object resource (RFC 6638 section 3.1).
+func IsSchedulingObject(data []byte) bool {
+	cal, err := ical.NewDecoder(bytes.NewReader(data)).Decode()
+	if err != nil {
+		return false
+	}
+	for _, child := range cal.Children {
+		if child.Props.Get(ical.PropOrganizer) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Attendee is an ATTENDEE of a calendar object with the RFC 5545 defaults
+// filled in for parameters it leaves out.
+type Attendee struct {
+	Address  string // calendar address without the mailto: scheme
+	CUType   string // INDIVIDUAL, GROUP, RESOURCE, ROOM or UNKNOWN
+	PartStat string
+}
+
+// IsResource reports whether the attendee is a room or a piece of
+// equipment rather than a person.
+func (a Attendee) IsResource() bool {
+	return strings.EqualFold(a.CUType, "ROOM") || strings.EqualFold(a.CUType, "RESOURCE")
+}
+
+// CalendarAddress strips the mailto: scheme from a calendar user address
+// and lowercases it, so addresses can be compared.
+func CalendarAddress(v string) string {
+	v = strings.TrimSpace(v)
+	if len(v) >= 7 && strings.EqualFold(v[:7], "mailto:") {
+		v = v[7:]
+	}
+	return strings.ToLower(v)
+}
+
+// Organizer returns the ORGANIZER address of the first component that has
+// one, or "" when data is not a scheduling object.
+func Organizer(data []byte) string {
+	cal, err := ical.NewDecoder(bytes.NewReader(data)).Decode()
+	if err != nil {
+		return ""
+	}
+	for _, child := range cal.Children {
+		if p := child.Props.Get(ical.PropOrganizer); p != nil {
+			return CalendarAddress(p.Value)
+		}
+	}
+	return ""
+}
+
+// Attendees lists the attendees of every component in data, each address
+// once. CUTYPE defaults to INDIVIDUAL and PARTSTAT to NEEDS-ACTION.
+func Attendees(data []byte) ([]Attendee, error) {
+	cal, err := ical.NewDecoder(bytes.NewReader(data)).Decode()
+	if err != nil {
+		return nil, err
+	}
+	var out []Attendee
+	seen := map[string]bool{}
+	for _, child := range cal.Children {
+		for _, p := range child.Props[ical.PropAttendee] {
+			addr := CalendarAddress(p.Value)
+			if addr == "" || seen[addr] {
+				continue
+			}
+			seen[addr] = true
+			out = append(out, Attendee{
+				Address:  addr,
+				CUType:   paramOr(p.Params, ical.ParamCalendarUserType, "INDIVIDUAL"),
+				PartStat: paramOr(p.Params, ical.ParamParticipationStatus, "NEEDS-ACTION"),
+			})
+		}
+	}
+	return out, nil
+}
+
+func paramOr(params ical.Params, name, def string) string {
+	if v := strings.ToUpper(strings.TrimSpace(params.Get(name))); v != "" {
+		return v
+	}
+	return def
+}
+
+// SetAttendeePartStat sets PARTSTAT on the attendee with the given address
+// in every component and clears its RSVP request. It reports whether
+// anything changed.
+func SetAttendeePartStat(data []byte, addr, partStat string) ([]byte, bool, error) {
+	cal, err := ical.NewDecoder(bytes.NewReader(data)).Decode()
+	if err != nil {
+		return data, false, err
+	}
+	changed := false
+	for _, child := range cal.Children {
+		attendees := child.Props[ical.PropAttendee]
+		for i := range attendees {
+			if CalendarAddress(attendees[i].Value) != addr {
+				continue
+			}
+			if attendees[i].Params == nil {
+				attendees[i].Params = make(ical.Params)
+			}
+			if attendees[i].Params.Get(ical.ParamParticipationStatus) == partStat && attendees[i].Params.Get(ical.ParamRSVP) == "" {
+				continue
+			}
+			attendees[i].Params.Set(ical.ParamParticipationStatus, partStat)
+			attendees[i].Params.Del(ical.ParamRSVP)
+			changed = true
+		}
+	}
+	if !changed {
+		return data, false, nil
+	}
+	var buf bytes.Buffer
+	if err := ical.NewEncoder(&buf).Encode(cal); err != nil {
+		return data, false, err
+	}
+	return buf.Bytes(), true, nil
+}
+
+// DeclineAttendee sets PARTSTAT=DECLINED on the attendees accepted by isSelf
+// whose participation is still pending (NEEDS-ACTION, or no PARTSTAT at
+// all). It reports whether anything changed.
+func DeclineAttendee(data []byte, isSelf func(addr string) bool) ([]byte, bool, error) {
+	cal, err := ical.NewDecoder(bytes.NewReader(data)).Decode()
+	if err != nil {
+		return data, false, err
+	}
+	changed := false
+	for _, child := range cal.Children {
+		attendees := child.Props[ical.PropAttendee]
+		for i := range attendees {
+			if !isSelf(attendees[i].Value) {
+				continue
+			}
+			ps := strings.ToUpper(attendees[i].Params.Get(ical.ParamParticipationStatus))
+			if ps != "" && ps != "NEEDS-ACTION" {
+				continue
+			}
+			if attendees[i].Params == nil {
+				attendees[i].Params = make(ical.Params)
+			}
+			attendees[i].Params.Set(ical.ParamParticipationStatus, "DECLINED")
+			attendees[i].Params.Del(ical.ParamRSVP)
+			changed = true
+		}
+	}
+	if !changed {
+		return data, false, nil
+	}
+	var buf bytes.Buffer
+	if err := ical.NewEncoder(&buf).Encode(cal); err != nil {
+		return data, false, err
+	}
+	return buf.Bytes(), true, nil
+}
+
+// BusyEvents parses the VEVENTs of data that block time for the calendar
+// user accepted by isSelf. Transparent and cancelled events are left out, as
+// are those the user declined (RFC 4791 section 7.10). An overridden
+// instance that is left out is also excluded from its master's recurrence.
+func BusyEvents(data []byte, isSelf func(addr string) bool) ([]*Event, error) {
+	cal, err := ical.NewDecoder(bytes.NewReader(data)).Decode()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse calendar: %w", err)
+	}
+
+	var events []*Event
+	var free []time.Time
+	for _, comp := range cal.Children {
+		if comp.Name != ical.CompEvent {
+			continue
+		}
+		event, err := parseEvent(comp, data)
+		if err != nil {
+			continue
+		}
+		if blocksTime(comp, isSelf) {
+			events = append(events, event)
+		} else if event.RecurrenceID != nil {
+			free = append(free, *event.RecurrenceID)
+		}
+	}
+	for _, ev := range events {
+		if ev.IsRecurring && ev.RecurrenceID == nil {
+			ev.ExDates = append(ev.ExDates, free...)
+		}
+	}
+	return events, nil
+}
+
+func blocksTime(comp *ical.Component, isSelf func(addr string) bool) bool {
+	if p := comp.Props.Get(ical.PropTransparency); p != nil && strings.EqualFold(p.Value, "TRANSPARENT") {
+		return false
+	}
+	if p := comp.Props.Get(ical.PropStatus); p != nil && strings.EqualFold(p.Value, "CANCELLED") {
+		return false
+	}
+	for _, a := range comp.Props[ical.PropAttendee] {
+		if isSelf(a.Value) && strings.EqualFold(a.Params.Get(ical.ParamParticipationStatus), "DECLINED") {
+			return false
+		}
+	}
+	return true
+}
+
+// SchedulingSignature flattens the parts of a calendar object that matter
+// to the organizer into a comparable string. Attendee participation status,
+// alarms, timestamps and X- properties are left out so that two objects
+// differing only in those yield the same signature (RFC 6638 section 3.2.10).
+func SchedulingSignature(data []byte) (string, error) {
+	cal, err := ical.NewDecoder(bytes.NewReader(data)).Decode()
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	for _, child := range cal.Children {
+		writeSignature(&b, child)
+	}
+	return b.String(), nil
+}
+
+func writeSignature(b *strings.Builder, comp *ical.Component) {
+	if comp.Name == ical.CompAlarm {
+		return
+	}
+	b.WriteString("BEGIN:" + comp.Name + "\n")
+
+	names := make([]string, 0, len(comp.Props))
+	for name := range comp.Props {
+		switch {
+		case strings.HasPrefix(name, "X-"),
+			name == ical.PropDateTimeStamp,
+			name == ical.PropLastModified:
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		lines := make([]string, 0, len(comp.Props[name]))
+		for _, prop := range comp.Props[name] {
+			var params []string
+			for pname, values := range prop.Params {
+				if name == ical.PropAttendee &&
+					(pname == ical.ParamParticipationStatus || pname == ical.ParamRSVP) {
+					continue
+				}
+				params = append(params, pname+"="+strings.Join(values, ","))
+			}
+			sort.Strings(params)
+			lines = append(lines, name+";"+strings.Join(params, ";")+":"+prop.Value)
+		}
+		sort.Strings(lines)
+		for _, l := range lines {
+			b.WriteString(l + "\n")
+		}
+	}
+
+	for _, child := range comp.Children {
+		writeSignature(b, child)
+	}
+	b.WriteString("END:" + comp.Name + "\n")
+}
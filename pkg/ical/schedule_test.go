@@ -0,0 +1,22 @@
+package ical
+
+import "testing"
+
+func TestAttendeeIsResource(t *testing.T) {
+	tests := []struct {
+		cuType string
+		want   bool
+	}{
+		{"ROOM", true},
+		{"room", true},
+		{"Resource", true},
+		{"INDIVIDUAL", false},
+		{"group", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := (Attendee{CUType: tt.cuType}).IsResource(); got != tt.want {
+			t.Errorf("IsResource() with CUTYPE %q = %v, want %v", tt.cuType, got, tt.want)
+		}
+	}
+}
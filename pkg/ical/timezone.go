@@ -0,0 +1,116 @@
+package ical
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/emersion/go-ical"
+)
+
+// localTimeLayout formats the local DATE-TIME values of VTIMEZONE
+// observances.
+const localTimeLayout = "20060102T150405"
+
+// BuildTimezone returns a VCALENDAR holding the VTIMEZONE of the IANA zone
+// tzid, the form CALDAV:calendar-timezone takes (RFC 4791 section 5.2.2).
+// The observances describe the zone's rules in year; a zone without
+// offset changes gets a single STANDARD observance.
+func BuildTimezone(tzid, prodID string, year int) ([]byte, error) {
+	loc, err := time.LoadLocation(tzid)
+	if err != nil {
+		return nil, err
+	}
+
+	tz := ical.NewComponent(ical.CompTimezone)
+	tz.Props.SetText(ical.PropTimezoneID, tzid)
+
+	start := time.Date(year, time.January, 1, 0, 0, 0, 0, loc)
+	end := time.Date(year+1, time.January, 1, 0, 0, 0, 0, loc)
+	var changes []time.Time
+	for t := start; ; {
+		_, next := t.ZoneBounds()
+		if next.IsZero() || !next.Before(end) {
+			break
+		}
+		changes = append(changes, next)
+		t = next
+	}
+
+	if len(changes) == 0 {
+		name, offset := start.Zone()
+		onset := time.Date(1970, time.January, 1, 0, 0, 0, 0, time.UTC)
+		tz.Children = append(tz.Children, observance(ical.CompTimezoneStandard, name, offset, offset, onset, ""))
+	}
+	for _, change := range changes {
+		_, from := change.Add(-time.Second).Zone()
+		name, to := change.Zone()
+		kind := ical.CompTimezoneStandard
+		if change.IsDST() {
+			kind = ical.CompTimezoneDaylight
+		}
+		// DTSTART is the onset in the local time in force before it.
+		onset := change.UTC().Add(time.Duration(from) * time.Second)
+		rule := ""
+		if len(changes) == 2 {
+			rule = yearlyRule(onset)
+		}
+		tz.Children = append(tz.Children, observance(kind, name, from, to, onset, rule))
+	}
+
+	cal := ical.NewCalendar()
+	cal.Props.SetText(ical.PropVersion, "2.0")
+	cal.Props.SetText(ical.PropProductID, prodID)
+	cal.Children = append(cal.Children, tz)
+
+	var buf bytes.Buffer
+	if err := ical.NewEncoder(&buf).Encode(cal); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func observance(kind, name string, from, to int, onset time.Time, rule string) *ical.Component {
+	obs := ical.NewComponent(kind)
+	dtstart := ical.NewProp(ical.PropDateTimeStart)
+	dtstart.Value = onset.Format(localTimeLayout)
+	obs.Props.Set(dtstart)
+	obs.Props.SetText(ical.PropTimezoneOffsetFrom, utcOffset(from))
+	obs.Props.SetText(ical.PropTimezoneOffsetTo, utcOffset(to))
+	if name != "" {
+		obs.Props.SetText(ical.PropTimezoneName, name)
+	}
+	if rule != "" {
+		rrule := ical.NewProp(ical.PropRecurrenceRule)
+		rrule.Value = rule
+		obs.Props.Set(rrule)
+	}
+	return obs
+}
+
+// yearlyRule describes a change falling on the same weekday of the same
+// week of its month every year, counting from the end of the month for the
+// last week.
+func yearlyRule(onset time.Time) string {
+	week := (onset.Day()-1)/7 + 1
+	if onset.AddDate(0, 0, 7).Month() != onset.Month() {
+		week = -1
+	}
+	day := [...]string{"SU", "MO", "TU", "WE", "TH", "FR", "SA"}[onset.Weekday()]
+	return fmt.Sprintf("FREQ=YEARLY;BYMONTH=%d;BYDAY=%d%s", int(onset.Month()), week, day)
+}
+
+// utcOffset formats seconds east of UTC as a UTC-OFFSET value (RFC 5545
+// section 3.3.14).
+func utcOffset(seconds int) string {
+	sign := '+'
+	if seconds < 0 {
+		sign = '-'
+		seconds = -seconds
+	}
+	s := fmt.Sprintf("%c%02d%02d", sign, seconds/3600, seconds/60%60)
+	if seconds%60 != 0 {
+		s += fmt.Sprintf("%02d", seconds%60)
+	}
+	return s
+}
@@ -49,6 +49,12 @@ func parseMultipleDates(dateStr string) ([]time.Time, error) {
 			continue
 		}
 
+		// A PERIOD value is start/end or start/duration; only the start
+		// matters for an instance.
+		if start, _, ok := strings.Cut(part, "/"); ok {
+			part = start
+		}
+
 		date, _, err := parseDateTime(part)
 		if err != nil {
 			continue
@@ -112,19 +118,18 @@ func parseDuration(durStr string) (time.Duration, error) {
 }
 
 func filterExcludedDates(instances, exdates []time.Time) []time.Time {
-	if len(exdates) == 0 {
-		return instances
-	}
-
 	excludeMap := make(map[string]bool)
 	for _, exdate := range exdates {
-		excludeMap[exdate.Format("20060102T150405Z")] = true
+		excludeMap[exdate.UTC().Format("20060102T150405Z")] = true
 	}
 
 	var filtered []time.Time
 	for _, instance := range instances {
-		key := instance.Format("20060102T150405Z")
+		key := instance.UTC().Format("20060102T150405Z")
 		if !excludeMap[key] {
+			// Mark it seen so an RDATE repeating an RRULE occurrence
+			// yields a single instance.
+			excludeMap[key] = true
 			filtered = append(filtered, instance)
 		}
 	}
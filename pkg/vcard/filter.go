@@ -0,0 +1,258 @@
+package vcard
+
+import (
+	"strings"
+
+	govcard "github.com/emersion/go-vcard"
+)
+
+// Filter is a CardDAV filter (RFC 6352 section 10.5) expressed
+// independently of its XML form. A card matches when any of the
+// prop-filters holds, or every one of them with AllOf.
+type Filter struct {
+	AllOf       bool
+	PropFilters []PropFilter
+}
+
+// PropFilter is a CardDAV prop-filter (RFC 6352 section 10.5.1). Name may
+// carry a vCard group, as in item1.EMAIL, to match only the properties of
+// that group, and may address one component of a structured property as
+// NAME/component, such as ADR/locality; "/" cannot occur in a property or
+// group name. A property that is defined matches when any of its
+// text-matches and param-filters holds, or every one of them with AllOf;
+// with none it matches on being defined.
+type PropFilter struct {
+	Name         string
+	AllOf        bool
+	IsNotDefined bool
+	TextMatches  []TextMatch
+	ParamFilters []ParamFilter
+}
+
+// ParamFilter is a CardDAV param-filter (RFC 6352 section 10.5.2). It holds
+// when some instance of the property has the parameter, or lacks it with
+// IsNotDefined, and the value satisfies TextMatch if one is given.
+type ParamFilter struct {
+	Name         string
+	IsNotDefined bool
+	TextMatch    *TextMatch
+}
+
+// TextMatch is a text-match against a property value. Matching is
+// case-insensitive unless CaseSensitive is set (i;octet collation).
+// MatchType is one of equals, contains, starts-with or ends-with; empty
+// means contains.
+type TextMatch struct {
+	Text          string
+	MatchType     string
+	CaseSensitive bool
+	Negate        bool
+}
+
+// MatchFilter reports whether the vCard satisfies the filter. A filter
+// without prop-filters matches every card.
+func MatchFilter(raw []byte, f Filter) (bool, error) {
+	cards, err := parseAll(raw)
+	if err != nil {
+		return false, err
+	}
+	if len(cards) == 0 {
+		return false, nil
+	}
+	if len(f.PropFilters) == 0 {
+		return true, nil
+	}
+	card := cards[0]
+	for _, pf := range f.PropFilters {
+		group, name, component := splitPropFilterName(pf.Name)
+		ok := matchProp(inGroup(card[name], group), name, component, pf)
+		if ok != f.AllOf {
+			return ok, nil
+		}
+	}
+	return f.AllOf, nil
+}
+
+// ComponentSeparator separates a property name from the structured
+// component a prop-filter addresses.
+const ComponentSeparator = "/"
+
+// splitPropFilterName splits a prop-filter name into its optional group,
+// the upper-cased property name and the optional lower-cased component.
+func splitPropFilterName(filterName string) (group, name, component string) {
+	name, component, _ = strings.Cut(filterName, ComponentSeparator)
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		group, name = name[:i], name[i+1:]
+	}
+	return group, strings.ToUpper(name), strings.ToLower(component)
+}
+
+// PropFilterProperty returns the property name a prop-filter name
+// addresses, without group or component.
+func PropFilterProperty(filterName string) string {
+	_, name, _ := splitPropFilterName(filterName)
+	return name
+}
+
+// inGroup keeps the fields of group, or all of them when group is empty.
+func inGroup(fields []*govcard.Field, group string) []*govcard.Field {
+	if group == "" {
+		return fields
+	}
+	var out []*govcard.Field
+	for _, f := range fields {
+		if strings.EqualFold(f.Group, group) {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// structuredComponents names, in order, the components of the structured
+// properties a prop-filter can address one at a time.
+var structuredComponents = map[string][]string{
+	govcard.FieldAddress:     {"pobox", "extended", "street", "locality", "region", "postal-code", "country"},
+	govcard.FieldGeolocation: {"latitude", "longitude"},
+}
+
+func matchProp(fields []*govcard.Field, name, component string, f PropFilter) bool {
+	if f.IsNotDefined {
+		return len(fields) == 0
+	}
+	if len(fields) == 0 {
+		return false
+	}
+	if len(f.TextMatches) == 0 && len(f.ParamFilters) == 0 {
+		return true
+	}
+	for i := range f.TextMatches {
+		if ok := matchTextMatch(fields, name, component, &f.TextMatches[i]); ok != f.AllOf {
+			return ok
+		}
+	}
+	for _, pf := range f.ParamFilters {
+		if ok := matchParam(fields, pf); ok != f.AllOf {
+			return ok
+		}
+	}
+	return f.AllOf
+}
+
+func matchTextMatch(fields []*govcard.Field, name, component string, tm *TextMatch) bool {
+	found := false
+	for _, field := range fields {
+		if matchValues(propValues(name, component, field.Value), tm) {
+			found = true
+			break
+		}
+	}
+	return found != tm.Negate
+}
+
+// matchParam reports whether some instance of the property satisfies the
+// param-filter. Parameters may hold comma-separated lists, such as TYPE.
+func matchParam(fields []*govcard.Field, pf ParamFilter) bool {
+	for _, field := range fields {
+		var values []string
+		for _, v := range field.Params[strings.ToUpper(pf.Name)] {
+			values = append(values, splitList(v)...)
+		}
+		switch {
+		case pf.IsNotDefined:
+			if len(values) == 0 {
+				return true
+			}
+		case len(values) == 0:
+		case pf.TextMatch == nil:
+			return true
+		case matchValues(values, pf.TextMatch) != pf.TextMatch.Negate:
+			return true
+		}
+	}
+	return false
+}
+
+// propValues lists the values of a property that a text-match is tried
+// against. CATEGORIES holds a comma-separated list. ADR and GEO are matched
+// as a whole and component by component, or only on the named component.
+func propValues(name, component, value string) []string {
+	switch name {
+	case govcard.FieldCategories:
+		return splitList(value)
+	case govcard.FieldAddress:
+		return structuredValues(name, component, value, strings.Split(value, ";"))
+	case govcard.FieldGeolocation:
+		lat, lon := parseGeo(value)
+		return structuredValues(name, component, lat+","+lon, []string{lat, lon})
+	}
+	if component != "" {
+		return nil
+	}
+	return []string{value}
+}
+
+// structuredValues picks the values to match from a structured property
+// whose components are parts. Components may hold comma-separated lists.
+func structuredValues(name, component, whole string, parts []string) []string {
+	if component == "" {
+		values := []string{whole}
+		for _, p := range parts {
+			values = append(values, splitList(p)...)
+		}
+		return values
+	}
+	for i, c := range structuredComponents[name] {
+		if c == component && i < len(parts) {
+			return splitList(parts[i])
+		}
+	}
+	return nil
+}
+
+// parseGeo reads a GEO value in either the vCard 4 geo: URI form
+// (geo:52.52,13.40) or the vCard 3 form (52.52;13.40).
+func parseGeo(value string) (lat, lon string) {
+	value = strings.TrimSpace(value)
+	sep := ";"
+	if len(value) >= 4 && strings.EqualFold(value[:4], "geo:") {
+		value, _, _ = strings.Cut(value[4:], ";")
+		sep = ","
+	}
+	lat, lon, _ = strings.Cut(value, sep)
+	return strings.TrimSpace(lat), strings.TrimSpace(lon)
+}
+
+func splitList(value string) []string {
+	parts := strings.Split(value, ",")
+	for i, p := range parts {
+		parts[i] = strings.TrimSpace(p)
+	}
+	return parts
+}
+
+func matchValues(values []string, tm *TextMatch) bool {
+	for _, v := range values {
+		if matchText(v, tm) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchText(value string, tm *TextMatch) bool {
+	text := tm.Text
+	if !tm.CaseSensitive {
+		value = strings.ToLower(value)
+		text = strings.ToLower(text)
+	}
+	switch tm.MatchType {
+	case "equals":
+		return value == text
+	case "starts-with":
+		return strings.HasPrefix(value, text)
+	case "ends-with":
+		return strings.HasSuffix(value, text)
+	default:
+		return strings.Contains(value, text)
+	}
+}
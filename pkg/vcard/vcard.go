@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"strings"
+	"time"
 
 	govcard "github.com/emersion/go-vcard"
 	"github.com/google/uuid"
@@ -56,11 +57,13 @@ func NormalizeVCard(raw []byte, targetVersion string) ([]byte, error) {
 	}
 
 	for _, c := range cards {
-		// Set version first, before other processing
+		// Settle the version first, before other processing
 		switch targetVersion {
 		case "4.0":
+			// Convert before stamping the version: a card already
+			// marked 4.0 is left as is.
+			toV4(c)
 			c.SetValue(govcard.FieldVersion, "4.0")
-			govcard.ToV4(c)
 		case "3.0":
 			c.SetValue(govcard.FieldVersion, "3.0")
 			// Add v4-only field removal logic if needed
@@ -72,6 +75,8 @@ func NormalizeVCard(raw []byte, targetVersion string) ([]byte, error) {
 			return nil, errors.New("unsupported target vcard version")
 		}
 
+		normalizeTypes(c)
+
 		// Generate FN if missing
 		if c.Value(govcard.FieldFormattedName) == "" {
 			if name := c.Name(); name != nil {
@@ -104,6 +109,226 @@ func NormalizeVCard(raw []byte, targetVersion string) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
+// normalizeTypes lowercases the TYPE tokens of every property and drops
+// duplicates, splitting comma-joined values so each token stands alone.
+func normalizeTypes(c govcard.Card) {
+	for _, fields := range c {
+		for _, f := range fields {
+			types, ok := f.Params[govcard.ParamType]
+			if !ok {
+				continue
+			}
+			var out []string
+			seen := make(map[string]bool)
+			for _, v := range types {
+				for _, t := range strings.Split(v, ",") {
+					t = strings.ToLower(strings.TrimSpace(t))
+					if t == "" || seen[t] {
+						continue
+					}
+					seen[t] = true
+					out = append(out, t)
+				}
+			}
+			if len(out) == 0 {
+				delete(f.Params, govcard.ParamType)
+				continue
+			}
+			f.Params[govcard.ParamType] = out
+		}
+	}
+}
+
+// toV4 turns the 3.0 TYPE=pref marker into the 4.0 PREF=1 parameter
+// (RFC 6350 section 5.3).
+func toV4(c govcard.Card) {
+	if strings.HasPrefix(c.Value(govcard.FieldVersion), "4.") {
+		return
+	}
+	for name, fields := range c {
+		if name == govcard.FieldVersion {
+			continue
+		}
+		for _, f := range fields {
+			types, ok := f.Params[govcard.ParamType]
+			if !ok {
+				continue
+			}
+			var rest []string
+			pref := false
+			for _, v := range types {
+				for _, t := range strings.Split(v, ",") {
+					if strings.EqualFold(strings.TrimSpace(t), "pref") {
+						pref = true
+						continue
+					}
+					rest = append(rest, t)
+				}
+			}
+			if !pref {
+				continue
+			}
+			if len(rest) == 0 {
+				delete(f.Params, govcard.ParamType)
+			} else {
+				f.Params[govcard.ParamType] = rest
+			}
+			if f.Params.Get(govcard.ParamPreferred) == "" {
+				f.Params.Set(govcard.ParamPreferred, "1")
+			}
+		}
+	}
+}
+
+// EnsureUID sets UID on every card that lacks one. It returns the original
+// data and false when nothing was added or the data cannot be parsed.
+func EnsureUID(raw []byte, uid string) ([]byte, bool) {
+	cards, err := parseAll(raw)
+	if err != nil || len(cards) == 0 {
+		return raw, false
+	}
+
+	modified := false
+	for _, c := range cards {
+		if c.Value(govcard.FieldUID) == "" {
+			c.SetValue(govcard.FieldUID, uid)
+			modified = true
+		}
+	}
+	if !modified {
+		return raw, false
+	}
+
+	var buf bytes.Buffer
+	enc := govcard.NewEncoder(&buf)
+	for _, c := range cards {
+		if err := enc.Encode(c); err != nil {
+			return raw, false
+		}
+	}
+	return buf.Bytes(), true
+}
+
+// UID returns the UID of the first card in raw, or "" when it has none.
+func UID(raw []byte) string {
+	cards, err := parseAll(raw)
+	if err != nil || len(cards) == 0 {
+		return ""
+	}
+	return strings.TrimSpace(cards[0].Value(govcard.FieldUID))
+}
+
+// GroupMembers returns the member UIDs of the first card in raw when it is a
+// group, read from MEMBER or Apple's X-ADDRESSBOOKSERVER-MEMBER with any
+// urn:uuid: prefix stripped. It returns nil for individual cards.
+func GroupMembers(raw []byte) []string {
+	cards, err := parseAll(raw)
+	if err != nil || len(cards) == 0 {
+		return nil
+	}
+	c := cards[0]
+	kind := c.Value(govcard.FieldKind)
+	if kind == "" {
+		kind = c.Value("X-ADDRESSBOOKSERVER-KIND")
+	}
+	if !strings.EqualFold(strings.TrimSpace(kind), "group") {
+		return nil
+	}
+	var out []string
+	for _, name := range []string{govcard.FieldMember, "X-ADDRESSBOOKSERVER-MEMBER"} {
+		for _, v := range c.Values(name) {
+			v = strings.TrimSpace(v)
+			if len(v) > len("urn:uuid:") && strings.EqualFold(v[:len("urn:uuid:")], "urn:uuid:") {
+				v = v[len("urn:uuid:"):]
+			}
+			if v != "" {
+				out = append(out, v)
+			}
+		}
+	}
+	return out
+}
+
+var revLayouts = []string{
+	"20060102T150405Z",
+	"20060102T150405Z0700",
+	"2006-01-02T15:04:05Z07:00",
+	"20060102T150405",
+	"2006-01-02T15:04:05",
+	"20060102",
+	"2006-01-02",
+}
+
+// Revision returns the REV timestamp of the first card in raw.
+func Revision(raw []byte) (time.Time, bool) {
+	cards, err := parseAll(raw)
+	if err != nil || len(cards) == 0 {
+		return time.Time{}, false
+	}
+	rev := strings.TrimSpace(cards[0].Value(govcard.FieldRevision))
+	if rev == "" {
+		return time.Time{}, false
+	}
+	for _, layout := range revLayouts {
+		if t, err := time.Parse(layout, rev); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// dateLayouts covers the date forms BDAY and ANNIVERSARY take in vCard 3
+// and 4, including the year-less --MMDD form.
+var dateLayouts = []string{
+	"20060102",
+	"2006-01-02",
+	"--0102",
+	"--01-02",
+}
+
+// SpecialDates returns the FN of the first card in raw together with its
+// BDAY and ANNIVERSARY (or vCard 3 X-ANNIVERSARY) dates, keyed by
+// FieldBirthday and FieldAnniversary. Year-less dates are placed in 1972,
+// a leap year, so 29 February survives.
+func SpecialDates(raw []byte) (string, map[string]time.Time) {
+	cards, err := parseAll(raw)
+	if err != nil || len(cards) == 0 {
+		return "", nil
+	}
+	c := cards[0]
+	dates := make(map[string]time.Time)
+	if t, ok := parseDate(c.Value(govcard.FieldBirthday)); ok {
+		dates[govcard.FieldBirthday] = t
+	}
+	anniversary := c.Value(govcard.FieldAnniversary)
+	if anniversary == "" {
+		anniversary = c.Value("X-ANNIVERSARY")
+	}
+	if t, ok := parseDate(anniversary); ok {
+		dates[govcard.FieldAnniversary] = t
+	}
+	return c.Value(govcard.FieldFormattedName), dates
+}
+
+func parseDate(v string) (time.Time, bool) {
+	v = strings.TrimSpace(v)
+	if i := strings.IndexByte(v, 'T'); i > 0 {
+		v = v[:i]
+	}
+	if v == "" {
+		return time.Time{}, false
+	}
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, v); err == nil {
+			if strings.HasPrefix(v, "--") {
+				t = time.Date(1972, t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+			}
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
 func parseAll(b []byte) ([]govcard.Card, error) {
 	// Normalize line endings to CRLF as required by RFC 6350
 	content := strings.ReplaceAll(string(b), "\n", "\r\n")
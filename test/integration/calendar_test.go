@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/base64"
+	"encoding/xml"
 	"fmt"
 	"io"
 	"net"
@@ -234,6 +235,27 @@ func testCalendarHomeListing(t *testing.T, client *http.Client, baseURL, basePat
 	if _, err := parseMultiStatus(b); err != nil {
 		t.Fatalf("parse home multistatus: %v", err)
 	}
+	// Namespaces are bound to prefixes once on the root instead of being
+	// re-declared as the default namespace on nested elements.
+	if strings.Contains(string(b), `xmlns="`) {
+		t.Errorf("home multistatus re-declares a default namespace:\n%s", string(b))
+	}
+	spaces := map[string]bool{}
+	dec := xml.NewDecoder(bytes.NewReader(b))
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			break
+		}
+		if se, ok := tok.(xml.StartElement); ok {
+			spaces[se.Name.Space] = true
+		}
+	}
+	for _, ns := range []string{"DAV:", "urn:ietf:params:xml:ns:caldav"} {
+		if !spaces[ns] {
+			t.Errorf("home multistatus has no element in %s", ns)
+		}
+	}
 }
 
 func testBasicEventOperations(t *testing.T, client *http.Client, baseURL, basePath, authz string) {
@@ -525,6 +547,67 @@ func testEventManagement(t *testing.T, client *http.Client, baseURL, basePath, a
 		deleteAndValidate(t, client, url, authz)
 	})
 
+	// Test POST with a server-chosen member name (RFC 5995)
+	t.Run("PostToCollection", func(t *testing.T) {
+		for _, uid := range []string{"evt-post-test", "evt post/test?#1"} {
+			ics := "BEGIN:VCALENDAR\r\n" +
+				"VERSION:2.0\r\n" +
+				"PRODID:-//ldap-dav//test//EN\r\n" +
+				"BEGIN:VEVENT\r\n" +
+				"UID:" + uid + "\r\n" +
+				"DTSTAMP:20250101T090000Z\r\n" +
+				"DTSTART:20250101T160000Z\r\n" +
+				"DTEND:20250101T170000Z\r\n" +
+				"SUMMARY:Posted Event\r\n" +
+				"END:VEVENT\r\n" +
+				"END:VCALENDAR\r\n"
+
+			req, _ := http.NewRequest("POST", baseCalendarURL, bytes.NewBufferString(ics))
+			req.Header.Set("Authorization", authz)
+			req.Header.Set("Content-Type", "text/calendar; charset=utf-8")
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatalf("post event %q: %v", uid, err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusCreated {
+				t.Fatalf("post event %q status: %d", uid, resp.StatusCode)
+			}
+			loc := resp.Header.Get("Location")
+			if loc == "" {
+				t.Fatalf("post event %q: missing Location", uid)
+			}
+			u, err := url.Parse(loc)
+			if err != nil {
+				t.Fatalf("post event %q: unparsable Location %q: %v", uid, loc, err)
+			}
+			if !strings.HasPrefix(u.Path, basePath+"/calendars/alice/shared/team/") || strings.Count(strings.TrimPrefix(u.Path, basePath+"/calendars/alice/shared/team/"), "/") != 0 {
+				t.Fatalf("post event %q: Location %q is not a member of the calendar", uid, loc)
+			}
+			if uid == "evt-post-test" && !strings.HasSuffix(u.Path, "/evt-post-test.ics") {
+				t.Fatalf("post event %q: Location %q does not use the UID", uid, loc)
+			}
+
+			memberURL := baseURL + u.EscapedPath()
+			req, _ = http.NewRequest("GET", memberURL, nil)
+			req.Header.Set("Authorization", authz)
+			resp, err = client.Do(req)
+			if err != nil {
+				t.Fatalf("get posted event %q: %v", uid, err)
+			}
+			b, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("get posted event %q status: %d", uid, resp.StatusCode)
+			}
+			if !bytes.Contains(b, []byte("UID:"+uid)) {
+				t.Fatalf("posted event %q not returned at Location: %s", uid, string(b))
+			}
+
+			deleteAndValidate(t, client, memberURL, authz)
+		}
+	})
+
 	// Test recurring events
 	t.Run("RecurringEvents", func(t *testing.T) {
 		recurringIcs := "BEGIN:VCALENDAR\r\n" +
@@ -581,9 +664,120 @@ func testEventManagement(t *testing.T, client *http.Client, baseURL, basePath, a
 			t.Fatalf("query recurring events status: %d", resp.StatusCode)
 		}
 
+		// The same query on the event's own URL expands that event alone
+		req, _ = http.NewRequest("REPORT", url, bytes.NewBufferString(body))
+		req.Header.Set("Authorization", authz)
+		req.Header.Set("Content-Type", "application/xml")
+		req.Header.Set("Depth", "0")
+		objResp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("query recurring event by URL: %v", err)
+		}
+		objBody, _ := io.ReadAll(objResp.Body)
+		objResp.Body.Close()
+		if objResp.StatusCode != 207 {
+			t.Fatalf("query recurring event by URL status: %d", objResp.StatusCode)
+		}
+		if n := strings.Count(string(objBody), "BEGIN:VEVENT"); n != 5 {
+			t.Fatalf("expected 5 expanded instances from the event URL, got %d: %s", n, objBody)
+		}
+
 		deleteAndValidate(t, client, url, authz)
 	})
 
+	// Test RDATE periods and EXRULE in expansion
+	t.Run("RDatePeriodsAndExRule", func(t *testing.T) {
+		cases := []struct {
+			uid      string
+			rules    string
+			expected int
+			instance string
+		}{
+			{
+				// Feb 1-5 daily, minus every other day from Feb 1, plus Feb 10
+				uid: "rdate-exrule-evt",
+				rules: "RRULE:FREQ=DAILY;COUNT=5\r\n" +
+					"EXRULE:FREQ=DAILY;INTERVAL=2;COUNT=3\r\n" +
+					"RDATE;VALUE=PERIOD:20250210T100000Z/20250210T110000Z\r\n",
+				expected: 3,
+				instance: "20250210T100000Z",
+			},
+			{
+				// DTSTART plus an RDATE period, without an RRULE
+				uid:      "rdate-only-evt",
+				rules:    "RDATE;VALUE=PERIOD:20250212T100000Z/PT1H\r\n",
+				expected: 2,
+				instance: "20250212T100000Z",
+			},
+		}
+
+		body := `<?xml version="1.0" encoding="utf-8" ?>
+<C:calendar-query xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav">
+ <D:prop>
+  <C:calendar-data>
+   <C:expand start="20250201T000000Z" end="20250215T000000Z"/>
+  </C:calendar-data>
+ </D:prop>
+ <C:filter>
+  <C:comp-filter name="VCALENDAR">
+   <C:comp-filter name="VEVENT">
+    <C:time-range start="20250201T000000Z" end="20250215T000000Z"/>
+   </C:comp-filter>
+  </C:comp-filter>
+ </C:filter>
+</C:calendar-query>`
+
+		for _, tc := range cases {
+			ics := "BEGIN:VCALENDAR\r\n" +
+				"VERSION:2.0\r\n" +
+				"PRODID:-//ldap-dav//test//EN\r\n" +
+				"BEGIN:VEVENT\r\n" +
+				"UID:" + tc.uid + "\r\n" +
+				"DTSTAMP:20250101T090000Z\r\n" +
+				"DTSTART:20250201T100000Z\r\n" +
+				"DTEND:20250201T110000Z\r\n" +
+				tc.rules +
+				"SUMMARY:Expansion Rules Event\r\n" +
+				"END:VEVENT\r\n" +
+				"END:VCALENDAR\r\n"
+
+			url := baseCalendarURL + tc.uid + ".ics"
+			req, _ := http.NewRequest("PUT", url, bytes.NewBufferString(ics))
+			req.Header.Set("Authorization", authz)
+			req.Header.Set("Content-Type", "text/calendar; charset=utf-8")
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatalf("create %s: %v", tc.uid, err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusNoContent {
+				t.Fatalf("create %s status: %d", tc.uid, resp.StatusCode)
+			}
+
+			req, _ = http.NewRequest("REPORT", url, bytes.NewBufferString(body))
+			req.Header.Set("Authorization", authz)
+			req.Header.Set("Content-Type", "application/xml")
+			req.Header.Set("Depth", "0")
+			resp, err = client.Do(req)
+			if err != nil {
+				t.Fatalf("expand %s: %v", tc.uid, err)
+			}
+			b, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode != 207 {
+				t.Fatalf("expand %s status: %d", tc.uid, resp.StatusCode)
+			}
+			if n := strings.Count(string(b), "BEGIN:VEVENT"); n != tc.expected {
+				t.Fatalf("expected %d expanded instances of %s, got %d: %s", tc.expected, tc.uid, n, b)
+			}
+			if !strings.Contains(string(b), tc.instance) {
+				t.Fatalf("expanded %s missing the RDATE instance %s: %s", tc.uid, tc.instance, b)
+			}
+
+			deleteAndValidate(t, client, url, authz)
+		}
+	})
+
 	// Test VTODO support
 	t.Run("TodoSupport", func(t *testing.T) {
 		todoIcs := "BEGIN:VCALENDAR\r\n" +
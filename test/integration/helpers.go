@@ -126,18 +126,24 @@ func validETag(s string) bool {
 
 // Extract inner text of <tag>..</tag> (single, naive)
 func innerText(xmlStr string, local string) string {
-	open := "<" + local
-	i := strings.Index(xmlStr, open)
-	if i == -1 {
+	// the element may carry a namespace prefix, e.g. <cal:calendar-data>
+	open := regexp.MustCompile(`<([A-Za-z][\w.-]*:)?` + regexp.QuoteMeta(local) + `[\s>]`)
+	m := open.FindStringSubmatchIndex(xmlStr)
+	if m == nil {
 		return ""
 	}
+	i := m[0]
+	prefix := ""
+	if m[2] >= 0 {
+		prefix = xmlStr[m[2]:m[3]]
+	}
 	// move to '>' of open tag
 	j := strings.Index(xmlStr[i:], ">")
 	if j == -1 {
 		return ""
 	}
 	start := i + j + 1
-	closeTag := "</" + local + ">"
+	closeTag := "</" + prefix + local + ">"
 	k := strings.Index(xmlStr[start:], closeTag)
 	if k == -1 {
 		return ""